@@ -97,6 +97,10 @@ func InitEnv() {
 	GlobalWebRateLimitNum = GetEnvOrDefault("GLOBAL_WEB_RATE_LIMIT", 60)
 	GlobalWebRateLimitDuration = int64(GetEnvOrDefault("GLOBAL_WEB_RATE_LIMIT_DURATION", 180))
 
+	TracingEnabled = GetEnvOrDefaultBool("TRACING_ENABLED", false)
+	MetricsEnabled = GetEnvOrDefaultBool("METRICS_ENABLED", false)
+	MetricsToken = GetEnvOrDefaultString("METRICS_TOKEN", "")
+
 	initConstantEnv()
 }
 
@@ -104,6 +108,9 @@ func initConstantEnv() {
 	constant.StreamingTimeout = GetEnvOrDefault("STREAMING_TIMEOUT", 120)
 	constant.DifyDebug = GetEnvOrDefaultBool("DIFY_DEBUG", true)
 	constant.MaxFileDownloadMB = GetEnvOrDefault("MAX_FILE_DOWNLOAD_MB", 20)
+	// 请求转换时并发下载图片的数量及单个文件的下载超时
+	constant.FileDownloadConcurrency = GetEnvOrDefault("FILE_DOWNLOAD_CONCURRENCY", 4)
+	constant.FileDownloadTimeoutSeconds = GetEnvOrDefault("FILE_DOWNLOAD_TIMEOUT", 30)
 	// ForceStreamOption 覆盖请求参数，强制返回usage信息
 	constant.ForceStreamOption = GetEnvOrDefaultBool("FORCE_STREAM_OPTION", true)
 	constant.GetMediaToken = GetEnvOrDefaultBool("GET_MEDIA_TOKEN", true)
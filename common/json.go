@@ -20,3 +20,56 @@ func DecodeJson(reader *bytes.Reader, v any) error {
 func Marshal(v any) ([]byte, error) {
 	return json.Marshal(v)
 }
+
+// MarshalOptions 序列化选项，零值时与 Marshal 一致
+type MarshalOptions struct {
+	DisableHTMLEscape bool `json:"disable_html_escape,omitempty"` // 字符串中的 < > & 原样输出，不转义为 \u003c 等
+	OmitNull          bool `json:"omit_null,omitempty"`           // 删除对象中值为 null 的字段
+}
+
+// MarshalWithOptions 按选项序列化，结果不带末尾换行
+func MarshalWithOptions(v any, opts MarshalOptions) ([]byte, error) {
+	if !opts.DisableHTMLEscape && !opts.OmitNull {
+		return json.Marshal(v)
+	}
+	if opts.OmitNull {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		// 保留数字的原始写法，避免大整数精度丢失
+		decoder.UseNumber()
+		var value any
+		if err = decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+		v = removeJsonNulls(value)
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(!opts.DisableHTMLEscape)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func removeJsonNulls(value any) any {
+	switch node := value.(type) {
+	case map[string]any:
+		for key, item := range node {
+			if item == nil {
+				delete(node, key)
+				continue
+			}
+			node[key] = removeJsonNulls(item)
+		}
+	case []any:
+		// 数组中的 null 有位置含义，保留
+		for i, item := range node {
+			node[i] = removeJsonNulls(item)
+		}
+	}
+	return value
+}
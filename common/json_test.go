@@ -0,0 +1,32 @@
+package common
+
+import "testing"
+
+func TestMarshalWithOptions(t *testing.T) {
+	value := map[string]any{
+		"text":   "<tag> & more",
+		"id":     int64(9007199254740993),
+		"note":   nil,
+		"nested": map[string]any{"empty": nil, "keep": 1},
+		"list":   []any{nil, "a"},
+	}
+	tests := []struct {
+		name string
+		opts MarshalOptions
+		want string
+	}{
+		{"defaults match Marshal", MarshalOptions{}, `{"id":9007199254740993,"list":[null,"a"],"nested":{"empty":null,"keep":1},"note":null,"text":"\u003ctag\u003e \u0026 more"}`},
+		{"html escaping disabled", MarshalOptions{DisableHTMLEscape: true}, `{"id":9007199254740993,"list":[null,"a"],"nested":{"empty":null,"keep":1},"note":null,"text":"<tag> & more"}`},
+		// 删除对象中的 null，数组中的 null 与大整数保持原样
+		{"null fields omitted", MarshalOptions{OmitNull: true}, `{"id":9007199254740993,"list":[null,"a"],"nested":{"keep":1},"text":"\u003ctag\u003e \u0026 more"}`},
+	}
+	for _, tt := range tests {
+		got, err := MarshalWithOptions(value, tt.opts)
+		if err != nil {
+			t.Fatalf("%s: MarshalWithOptions: %v", tt.name, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
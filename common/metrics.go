@@ -0,0 +1,73 @@
+package common
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricsEnabled 是否启用 Prometheus 指标，启用后通过 /metrics 暴露
+var MetricsEnabled = false
+
+// MetricsToken 访问 /metrics 使用的 Bearer token，未设置时仅管理员可以访问
+var MetricsToken = ""
+
+const metricsNamespace = "new_api"
+
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	relayPromptTokens = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "relay_prompt_tokens",
+		Help:      "Prompt tokens per relayed request.",
+		Buckets:   prometheus.ExponentialBuckets(16, 4, 9),
+	}, []string{"model", "channel"})
+	relayCompletionTokens = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "relay_completion_tokens",
+		Help:      "Completion tokens per relayed request.",
+		Buckets:   prometheus.ExponentialBuckets(16, 4, 8),
+	}, []string{"model", "channel"})
+	relayUpstreamLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "relay_upstream_latency_seconds",
+		Help:      "Time until the upstream returned response headers.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 11),
+	}, []string{"model", "channel"})
+	relayPromptTokenEstimateRatio = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "relay_prompt_token_estimate_ratio",
+		Help:      "Upstream-reported prompt tokens divided by the local estimate.",
+		Buckets:   prometheus.LinearBuckets(0.5, 0.05, 21),
+	}, []string{"model"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(relayPromptTokens, relayCompletionTokens, relayUpstreamLatency, relayPromptTokenEstimateRatio)
+}
+
+// MetricsGatherer 返回指标注册表，供 /metrics 接口输出
+func MetricsGatherer() prometheus.Gatherer {
+	return metricsRegistry
+}
+
+// ObserveRelayMetrics 记录一次转发请求的 token 数与上游耗时，未启用指标时不做处理
+func ObserveRelayMetrics(model string, channelId int, promptTokens int, completionTokens int, upstreamLatency time.Duration) {
+	if !MetricsEnabled {
+		return
+	}
+	channel := strconv.Itoa(channelId)
+	relayPromptTokens.WithLabelValues(model, channel).Observe(float64(promptTokens))
+	relayCompletionTokens.WithLabelValues(model, channel).Observe(float64(completionTokens))
+	relayUpstreamLatency.WithLabelValues(model, channel).Observe(upstreamLatency.Seconds())
+}
+
+// ObservePromptTokenEstimate 记录上游返回的输入 token 与本地预估值的比值，用于校准 tokenizer
+func ObservePromptTokenEstimate(model string, estimated int, actual int) {
+	if !MetricsEnabled || estimated <= 0 {
+		return
+	}
+	relayPromptTokenEstimateRatio.WithLabelValues(model).Observe(float64(actual) / float64(estimated))
+}
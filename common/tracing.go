@@ -0,0 +1,65 @@
+package common
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// TracingEnabled 是否启用 OpenTelemetry 链路追踪，导出地址等通过标准 OTEL_EXPORTER_OTLP_* 环境变量配置
+var TracingEnabled = false
+
+const tracerName = "one-api"
+
+var noopSpan = noop.Span{}
+
+// InitTracing 初始化 OTLP 导出器，返回的函数用于退出时刷新并关闭
+func InitTracing() (func(context.Context) error, error) {
+	if !TracingEnabled {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(
+			semconv.ServiceName("new-api"),
+			semconv.ServiceVersion(Version),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	SysLog("opentelemetry tracing enabled")
+	return provider.Shutdown, nil
+}
+
+// StartSpan 以当前请求的 span 为父节点创建子 span，未启用追踪时返回空操作的 span
+func StartSpan(c *gin.Context, name string, attrs ...attribute.KeyValue) trace.Span {
+	if !TracingEnabled {
+		return noopSpan
+	}
+	_, span := otel.Tracer(tracerName).Start(c.Request.Context(), name, trace.WithAttributes(attrs...))
+	return span
+}
+
+// EndSpan 结束 span，err 不为空时记录错误
+func EndSpan(span trace.Span, err error) {
+	if !TracingEnabled {
+		return
+	}
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
@@ -5,6 +5,7 @@ type ContextKey string
 const (
 	ContextKeyOriginalModel    ContextKey = "original_model"
 	ContextKeyRequestStartTime ContextKey = "request_start_time"
+	ContextKeyModelAlias       ContextKey = "model_alias"
 
 	/* token related keys */
 	ContextKeyTokenUnlimited         ContextKey = "token_unlimited_quota"
@@ -41,4 +42,16 @@ const (
 	ContextKeyUserGroup   ContextKey = "user_group"
 	ContextKeyUsingGroup  ContextKey = "group"
 	ContextKeyUserName    ContextKey = "username"
+
+	/* relay related keys */
+	ContextKeyResponseBlocked          ContextKey = "response_blocked"
+	ContextKeyClientDisconnected       ContextKey = "client_disconnected"
+	ContextKeyResponseToolUse          ContextKey = "response_tool_use"
+	ContextKeyClaudeJsonMode           ContextKey = "claude_json_mode"
+	ContextKeyClaudeMaxTokensTruncated ContextKey = "claude_max_tokens_truncated" // 响应因达到 max_tokens 被截断
+	ContextKeySLABreached              ContextKey = "sla_breached"
+	ContextKeyPromptTokensDelta        ContextKey = "prompt_tokens_delta"
+	ContextKeyModelVersion             ContextKey = "model_version"
+	ContextKeyTraceHeader              ContextKey = "trace_header" // 提供请求 id 的客户端追踪请求头
+	ContextKeyModelRoute               ContextKey = "model_route"  // 按路由规则切换模型前客户端请求的模型
 )
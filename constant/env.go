@@ -3,6 +3,8 @@ package constant
 var StreamingTimeout int
 var DifyDebug bool
 var MaxFileDownloadMB int
+var FileDownloadConcurrency int
+var FileDownloadTimeoutSeconds int
 var ForceStreamOption bool
 var GetMediaToken bool
 var GetMediaTokenNotStream bool
@@ -3,10 +3,11 @@ package constant
 type TaskPlatform string
 
 const (
-	TaskPlatformSuno       TaskPlatform = "suno"
-	TaskPlatformMidjourney              = "mj"
-	TaskPlatformKling      TaskPlatform = "kling"
-	TaskPlatformJimeng     TaskPlatform = "jimeng"
+	TaskPlatformSuno        TaskPlatform = "suno"
+	TaskPlatformMidjourney               = "mj"
+	TaskPlatformKling       TaskPlatform = "kling"
+	TaskPlatformJimeng      TaskPlatform = "jimeng"
+	TaskPlatformVertexBatch TaskPlatform = "vertex_batch"
 )
 
 const (
@@ -15,6 +16,7 @@ const (
 
 	TaskActionGenerate     = "generate"
 	TaskActionTextGenerate = "textGenerate"
+	TaskActionBatchPredict = "batchPredict"
 )
 
 var SunoModel2Action = map[string]string{
@@ -7,6 +7,9 @@ import (
 	"one-api/common"
 	"one-api/constant"
 	"one-api/model"
+	"one-api/relay"
+	"one-api/relay/channel/vertex"
+	"one-api/service"
 	"strconv"
 	"strings"
 
@@ -350,6 +353,85 @@ func GetChannel(c *gin.Context) {
 	return
 }
 
+// GetChannelErrorStats 获取各渠道按分类统计的错误次数
+func GetChannelErrorStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    service.GetChannelErrorStats(),
+	})
+}
+
+// GetVertexModelRegions 获取模型在 Vertex AI 上可用的区域
+func GetVertexModelRegions(c *gin.Context) {
+	modelName := c.Query("model")
+	if modelName == "" {
+		common.ApiErrorMsg(c, "model 不能为空")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    vertex.GetAvailableRegions(modelName),
+	})
+}
+
+// GetChannelAnthropicBeta 查询模型在渠道上实际发送的 anthropic-beta，client_beta 为模拟的客户端请求头
+func GetChannelAnthropicBeta(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	modelName := c.Query("model")
+	if modelName == "" {
+		common.ApiErrorMsg(c, "model 不能为空")
+		return
+	}
+	channel, err := model.GetChannelById(id, false)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	switch channel.Type {
+	case constant.ChannelTypeAnthropic, constant.ChannelTypeAws:
+	case constant.ChannelTypeVertexAi:
+		// Vertex 仅 Claude 模型发送 beta 请求头，按映射后的上游模型判断
+		upstreamModel := modelName
+		modelMapping := map[string]string{}
+		if err := json.Unmarshal([]byte(channel.GetModelMapping()), &modelMapping); err == nil && modelMapping[modelName] != "" {
+			upstreamModel = modelMapping[modelName]
+		}
+		if !strings.HasPrefix(upstreamModel, "claude") {
+			common.ApiErrorMsg(c, "该模型在 Vertex 渠道上不使用 anthropic-beta")
+			return
+		}
+	default:
+		common.ApiErrorMsg(c, "该渠道类型不支持 anthropic-beta")
+		return
+	}
+	betaHeaders, apiErr := relay.ResolveChannelAnthropicBeta(channel, modelName, c.Query("client_beta"))
+	if apiErr != nil {
+		common.ApiError(c, apiErr)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    betaHeaders,
+	})
+}
+
+// ResetChannelErrorStats 清空渠道错误统计，不指定 id 时清空全部
+func ResetChannelErrorStats(c *gin.Context) {
+	channelId, _ := strconv.Atoi(c.Query("id"))
+	service.ResetChannelErrorStats(channelId)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
 // validateChannel 通用的渠道校验函数
 func validateChannel(channel *model.Channel, isAdd bool) error {
 	// 校验 channel settings
@@ -385,6 +467,14 @@ func validateChannel(channel *model.Channel, isAdd bool) error {
 		if regionMap["default"] == nil {
 			return fmt.Errorf("部署地区必须包含default字段")
 		}
+
+		modelMapping := make(map[string]string)
+		if channel.GetModelMapping() != "" {
+			_ = common.Unmarshal([]byte(channel.GetModelMapping()), &modelMapping)
+		}
+		if err := vertex.ValidateModelRegions(channel.Other, channel.GetModels(), modelMapping); err != nil {
+			return fmt.Errorf("部署地区配置错误：%s", err.Error())
+		}
 	}
 
 	return nil
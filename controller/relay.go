@@ -107,6 +107,7 @@ func Relay(c *gin.Context) {
 		//	common.LogError(c, fmt.Sprintf("origin 429 error: %s", newAPIError.Error()))
 		//	newAPIError.SetMessage("当前分组上游负载已饱和，请稍后再试")
 		//}
+		newAPIError.RequestId = requestId
 		newAPIError.SetMessage(common.MessageWithRequestId(newAPIError.Error(), requestId))
 		c.JSON(newAPIError.StatusCode, gin.H{
 			"error": newAPIError.ToOpenAIError(),
@@ -169,6 +170,7 @@ func WssRelay(c *gin.Context) {
 		//if newAPIError.StatusCode == http.StatusTooManyRequests {
 		//	newAPIError.SetMessage("当前分组上游负载已饱和，请稍后再试")
 		//}
+		newAPIError.RequestId = requestId
 		newAPIError.SetMessage(common.MessageWithRequestId(newAPIError.Error(), requestId))
 		helper.WssError(c, ws, newAPIError.ToOpenAIError())
 	}
@@ -208,6 +210,7 @@ func RelayClaude(c *gin.Context) {
 	}
 
 	if newAPIError != nil {
+		newAPIError.RequestId = requestId
 		newAPIError.SetMessage(common.MessageWithRequestId(newAPIError.Error(), requestId))
 		c.JSON(newAPIError.StatusCode, gin.H{
 			"type":  "error",
@@ -216,6 +219,20 @@ func RelayClaude(c *gin.Context) {
 	}
 }
 
+// EstimateClaude 预估 Claude 请求的费用，不请求上游也不扣费
+func EstimateClaude(c *gin.Context) {
+	estimate, newAPIError := relay.ClaudeEstimateHelper(c)
+	if newAPIError != nil {
+		newAPIError.SetMessage(common.MessageWithRequestId(newAPIError.Error(), c.GetString(common.RequestIdKey)))
+		c.JSON(newAPIError.StatusCode, gin.H{
+			"type":  "error",
+			"error": newAPIError.ToClaudeError(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, estimate)
+}
+
 func relayRequest(c *gin.Context, relayMode int, channel *model.Channel) *types.NewAPIError {
 	addUsedChannel(c, channel.Id)
 	requestBody, _ := common.GetRequestBody(c)
@@ -321,6 +338,7 @@ func processChannelError(c *gin.Context, channelError types.ChannelError, err *t
 	// 不要使用context获取渠道信息，异步处理时可能会出现渠道信息不一致的情况
 	// do not use context to get channel info, there may be inconsistent channel info when processing asynchronously
 	common.LogError(c, fmt.Sprintf("relay error (channel #%d, status code: %d): %s", channelError.ChannelId, err.StatusCode, err.Error()))
+	service.RecordChannelError(channelError.ChannelId, err)
 	if service.ShouldDisableChannel(channelError.ChannelId, err) && channelError.AutoBan {
 		service.DisableChannel(channelError, err.Error())
 	}
@@ -428,7 +446,8 @@ func RelayTask(c *gin.Context) {
 func taskRelayHandler(c *gin.Context, relayMode int) *dto.TaskError {
 	var err *dto.TaskError
 	switch relayMode {
-	case relayconstant.RelayModeSunoFetch, relayconstant.RelayModeSunoFetchByID, relayconstant.RelayModeKlingFetchByID:
+	case relayconstant.RelayModeSunoFetch, relayconstant.RelayModeSunoFetchByID, relayconstant.RelayModeKlingFetchByID,
+		relayconstant.RelayModeVertexBatchFetchByID:
 		err = relay.RelayTaskFetch(c, relayMode)
 	default:
 		err = relay.RelayTaskSubmit(c, relayMode)
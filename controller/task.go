@@ -77,6 +77,8 @@ func UpdateTaskByPlatform(platform constant.TaskPlatform, taskChannelM map[int][
 		_ = UpdateSunoTaskAll(context.Background(), taskChannelM, taskM)
 	case constant.TaskPlatformKling, constant.TaskPlatformJimeng:
 		_ = UpdateVideoTaskAll(context.Background(), platform, taskChannelM, taskM)
+	case constant.TaskPlatformVertexBatch:
+		_ = UpdateVertexBatchTaskAll(context.Background(), taskChannelM, taskM)
 	default:
 		common.SysLog("未知平台")
 	}
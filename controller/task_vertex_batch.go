@@ -0,0 +1,211 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"one-api/model"
+	"one-api/relay"
+	"one-api/relay/channel"
+	relaycommon "one-api/relay/common"
+	"one-api/service"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func UpdateVertexBatchTaskAll(ctx context.Context, taskChannelM map[int][]string, taskM map[string]*model.Task) error {
+	adaptor := relay.GetTaskAdaptor(constant.TaskPlatformVertexBatch)
+	if adaptor == nil {
+		return fmt.Errorf("vertex batch adaptor not found")
+	}
+	for channelId, taskIds := range taskChannelM {
+		cacheGetChannel, err := model.CacheGetChannel(channelId)
+		if err != nil {
+			common.LogError(ctx, fmt.Sprintf("Channel #%d failed to update vertex batch tasks: %s", channelId, err.Error()))
+			continue
+		}
+		for _, taskId := range taskIds {
+			if err := updateVertexBatchSingleTask(ctx, adaptor, cacheGetChannel, taskM[taskId]); err != nil {
+				common.LogError(ctx, fmt.Sprintf("Failed to update vertex batch task %s: %s", taskId, err.Error()))
+			}
+		}
+	}
+	return nil
+}
+
+type vertexBatchTaskData struct {
+	Name string `json:"name"`
+}
+
+func updateVertexBatchSingleTask(ctx context.Context, adaptor channel.TaskAdaptor, channel *model.Channel, task *model.Task) error {
+	if task == nil {
+		return fmt.Errorf("task not found")
+	}
+	// 任务数据中保存的是提交时返回的任务详情，轮询后会被最新详情覆盖，name 字段保持不变
+	var data vertexBatchTaskData
+	if err := task.GetData(&data); err != nil || data.Name == "" {
+		return fmt.Errorf("batch prediction job name not found in task data")
+	}
+	fetchBody := map[string]any{
+		"name":            data.Name,
+		"channel_id":      channel.Id,
+		"channel_setting": channel.GetSetting(),
+	}
+	resp, err := adaptor.FetchTask("", channel.Key, fetchBody)
+	if err != nil {
+		return fmt.Errorf("fetchTask failed: %w", err)
+	}
+	defer resp.Body.Close()
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("readAll failed: %w", err)
+	}
+	taskResult, err := adaptor.ParseTaskResult(responseBody)
+	if err != nil {
+		return fmt.Errorf("parseTaskResult failed: %w", err)
+	}
+
+	now := time.Now().Unix()
+	task.Status = model.TaskStatus(taskResult.Status)
+	switch taskResult.Status {
+	case model.TaskStatusQueued:
+		task.Progress = "20%"
+	case model.TaskStatusInProgress:
+		task.Progress = "30%"
+		if task.StartTime == 0 {
+			task.StartTime = now
+		}
+	case model.TaskStatusSuccess:
+		fetcher, ok := adaptor.(batchUsageFetcher)
+		if !ok {
+			return fmt.Errorf("adaptor does not support fetching batch usage")
+		}
+		fetchBody["output_directory"] = taskResult.Url
+		usage, succeeded, err := fetcher.FetchBatchUsage(channel.Key, fetchBody)
+		if err != nil {
+			// 未读取到用量时不更新任务状态，下次轮询时重试结算
+			return fmt.Errorf("fetch batch usage failed: %w", err)
+		}
+		task.Progress = "100%"
+		task.FinishTime = now
+		task.FailReason = taskResult.Url
+		settleVertexBatchTask(ctx, task, usage, succeeded)
+	case model.TaskStatusFailure:
+		task.Progress = "100%"
+		task.FinishTime = now
+		task.FailReason = taskResult.Reason
+		common.LogInfo(ctx, fmt.Sprintf("Vertex batch task %s failed: %s", task.TaskID, task.FailReason))
+		// 失败的任务退还提交时预扣的额度
+		if task.Quota != 0 {
+			if err := service.PostConsumeQuota(batchTaskRelayInfo(task), -task.Quota, 0, false); err != nil {
+				common.LogError(ctx, "Failed to refund vertex batch task quota: "+err.Error())
+			}
+			model.RecordLog(task.UserId, model.LogTypeSystem, fmt.Sprintf("Vertex batch task %s failed, refund %s", task.TaskID, common.LogQuota(task.Quota)))
+			task.Quota = 0
+		}
+	default:
+		return fmt.Errorf("unknown task status %s for task %s", taskResult.Status, task.TaskID)
+	}
+
+	task.Data = responseBody
+	if err := task.Update(); err != nil {
+		common.SysError("UpdateVertexBatchTask task error: " + err.Error())
+	}
+	return nil
+}
+
+// batchUsageFetcher 读取已完成批量任务的实际用量
+type batchUsageFetcher interface {
+	FetchBatchUsage(key string, body map[string]any) (*dto.Usage, int, error)
+}
+
+// batchTaskRelayInfo 构造结算所需的 RelayInfo，令牌已删除时只调整用户额度
+func batchTaskRelayInfo(task *model.Task) *relaycommon.RelayInfo {
+	info := &relaycommon.RelayInfo{UserId: task.UserId, ChannelId: task.ChannelId, IsPlayground: true}
+	if billing := task.Properties.Billing; billing != nil && billing.TokenId != 0 {
+		if token, err := model.GetTokenById(billing.TokenId); err == nil {
+			info.TokenId = token.Id
+			info.TokenKey = token.Key
+			info.TokenUnlimited = token.UnlimitedQuota
+			info.IsPlayground = false
+		}
+	}
+	return info
+}
+
+// settleVertexBatchTask 按任务的实际用量结算，多退少补提交时预扣的额度，task.Quota 更新为实际扣除的总额度
+// 补扣时不超过用户的剩余额度，避免余额变为负数
+func settleVertexBatchTask(ctx context.Context, task *model.Task, usage *dto.Usage, succeeded int) {
+	billing := task.Properties.Billing
+	if billing == nil {
+		common.LogError(ctx, fmt.Sprintf("Vertex batch task %s has no billing info, keep pre-consumed quota %s", task.TaskID, common.LogQuota(task.Quota)))
+		return
+	}
+	preConsumedQuota := task.Quota
+	quota := billing.Quota(usage.PromptTokens, usage.CompletionTokens, succeeded)
+	delta := quota - preConsumedQuota
+	if delta > 0 {
+		userQuota, err := model.GetUserQuota(task.UserId, false)
+		if err != nil {
+			common.LogError(ctx, "Failed to get user quota: "+err.Error())
+			userQuota = 0
+		}
+		if userQuota < delta {
+			common.LogWarn(ctx, fmt.Sprintf("Vertex batch task %s needs %s more than pre-consumed, user %d only has %s, charge capped",
+				task.TaskID, common.LogQuota(delta), task.UserId, common.LogQuota(userQuota)))
+			delta = max(userQuota, 0)
+			quota = preConsumedQuota + delta
+		}
+	}
+	relayInfo := batchTaskRelayInfo(task)
+	if delta != 0 {
+		if err := service.PostConsumeQuota(relayInfo, delta, preConsumedQuota, false); err != nil {
+			common.LogError(ctx, "Failed to settle vertex batch task quota: "+err.Error())
+			return
+		}
+	}
+	task.Quota = quota
+	if quota > 0 {
+		model.UpdateUserUsedQuotaAndRequestCount(task.UserId, quota)
+		model.UpdateChannelUsedQuota(task.ChannelId, quota)
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/vertex/batches", nil)
+	if username, err := model.GetUsernameById(task.UserId, false); err == nil {
+		c.Set("username", username)
+	}
+	tokenName := ""
+	if !relayInfo.IsPlayground {
+		if token, err := model.GetTokenById(relayInfo.TokenId); err == nil {
+			tokenName = token.Name
+		}
+	}
+	other := map[string]interface{}{
+		"model_ratio":      billing.ModelRatio,
+		"completion_ratio": billing.CompletionRatio,
+		"group_ratio":      billing.GroupRatio,
+		"model_price":      billing.ModelPrice,
+		"batch_task_id":    task.TaskID,
+		"batch_succeeded":  succeeded,
+		"pre_consumed":     preConsumedQuota,
+	}
+	model.RecordConsumeLog(c, task.UserId, model.RecordConsumeLogParams{
+		ChannelId:        task.ChannelId,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		ModelName:        billing.ModelName,
+		TokenName:        tokenName,
+		Quota:            quota,
+		Content:          fmt.Sprintf("Vertex batch task %s completed, %d requests succeeded", task.TaskID, succeeded),
+		TokenId:          relayInfo.TokenId,
+		Group:            billing.Group,
+		Other:            other,
+	})
+}
@@ -1,7 +1,307 @@
 package dto
 
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
 type ChannelSettings struct {
 	ForceFormat       bool   `json:"force_format,omitempty"`
 	ThinkingToContent bool   `json:"thinking_to_content,omitempty"`
 	Proxy             string `json:"proxy"`
+	// 上游连接池设置，均为 0 时使用全局默认客户端
+	MaxIdleConnsPerHost int `json:"max_idle_conns_per_host,omitempty"`
+	IdleConnTimeout     int `json:"idle_conn_timeout,omitempty"` // 秒
+	KeepAlive           int `json:"keep_alive,omitempty"`        // 秒
+	// 转发给上游的客户端请求头白名单，以及需要额外剔除的请求头
+	ForwardHeaders []string `json:"forward_headers,omitempty"`
+	StripHeaders   []string `json:"strip_headers,omitempty"`
+	// 请求体大小与消息数上限，为 0 时使用默认值，小于 0 表示不限制
+	MaxRequestBodyBytes int `json:"max_request_body_bytes,omitempty"`
+	MaxMessages         int `json:"max_messages,omitempty"`
+	// Vertex 请求的配额与计费归属项目（X-Goog-User-Project）
+	VertexQuotaProject string `json:"vertex_quota_project,omitempty"`
+	// Vertex 优先使用 global 端点，模型不支持时回退到区域端点
+	PreferGlobalRegion bool `json:"prefer_global_region,omitempty"`
+	// Vertex Claude 请求使用的 anthropic_version，按模型配置，支持 default
+	VertexAnthropicVersions map[string]string `json:"vertex_anthropic_versions,omitempty"`
+	// 主模型不可用或过载时切换的备用模型，如 {"claude-opus-4-20250514": "claude-sonnet-4-20250514"}
+	FallbackModels map[string]string `json:"fallback_models,omitempty"`
+	// Gemini 原生格式请求原样转发给上游，响应也原样返回，不做任何转换
+	GeminiPassThrough bool `json:"gemini_pass_through,omitempty"`
+	// 思考预算上限，为 0 时使用全局配置
+	MaxThinkingBudgetTokens int `json:"max_thinking_budget_tokens,omitempty"`
+	// Vertex API 域名，用于 Private Service Connect 等私有端点，支持 {region} 占位符，如 {region}-aiplatform.example.internal
+	VertexApiHost string `json:"vertex_api_host,omitempty"`
+	// 渠道默认系统提示词及注入方式（prepend/append/replace_if_absent），模式为空时默认 prepend
+	DefaultSystemPrompt     string `json:"default_system_prompt,omitempty"`
+	DefaultSystemPromptMode string `json:"default_system_prompt_mode,omitempty"`
+	// 向上游额外发送的静态请求头，值支持 {project_id} 占位符（Vertex 凭证中的项目），不能覆盖鉴权请求头
+	UpstreamHeaders map[string]string `json:"upstream_headers,omitempty"`
+	// 参数策略，不论客户端如何传参都按渠道规则限制或覆盖
+	ParameterPolicy *ParameterPolicy `json:"parameter_policy,omitempty"`
+	// 上游错误状态码到返回给客户端的错误码和错误信息的映射，如 {"403": {"code": "model_not_available_in_region"}}
+	// 与状态码映射相互独立，不改变返回的状态码
+	ErrorMappings map[string]ErrorMapping `json:"error_mappings,omitempty"`
+	// 按顺序执行的请求体转换器名称，转换器需在代码中注册
+	RequestTransformers []string `json:"request_transformers,omitempty"`
+	// 出站连接的 TLS 设置（自定义 CA、客户端证书），上游请求与 Vertex 令牌请求均生效
+	TLS *ChannelTLSConfig `json:"tls,omitempty"`
+	// 按请求特征选择上游模型，键为客户端请求的模型（如 claude-auto），规则按顺序匹配，均不满足时使用请求的模型
+	ModelRoutes map[string][]ModelRouteRule `json:"model_routes,omitempty"`
+	// 响应内容过滤规则，按顺序执行；流式响应保留末尾 ResponseFilterWindow 字节暂不输出，以识别跨块的匹配，为 0 时使用默认值
+	ResponseFilters      []ResponseFilterRule `json:"response_filters,omitempty"`
+	ResponseFilterWindow int                  `json:"response_filter_window,omitempty"`
+	// 发送给上游的请求体序列化选项，部分上游不接受 null 字段或转义后的 HTML 字符
+	RequestMarshalOptions *common.MarshalOptions `json:"request_marshal_options,omitempty"`
+}
+
+// ErrorMapping 替换后的错误码和错误信息，为空的字段保留上游原值
+type ErrorMapping struct {
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// ParameterPolicy 各请求参数的限制规则，未配置的参数不做处理
+type ParameterPolicy struct {
+	Temperature          *ParameterRule `json:"temperature,omitempty"`
+	TopP                 *ParameterRule `json:"top_p,omitempty"`
+	MaxTokens            *ParameterRule `json:"max_tokens,omitempty"`
+	ThinkingBudgetTokens *ParameterRule `json:"thinking_budget_tokens,omitempty"`
+}
+
+// ParameterRule 单个参数的规则，设置 Force 时直接覆盖，否则限制在 [Min, Max] 内
+type ParameterRule struct {
+	Min   *float64 `json:"min,omitempty"`
+	Max   *float64 `json:"max,omitempty"`
+	Force *float64 `json:"force,omitempty"`
+}
+
+// Apply 按规则计算参数取值
+func (r *ParameterRule) Apply(value float64) float64 {
+	if r.Force != nil {
+		return *r.Force
+	}
+	if r.Min != nil && value < *r.Min {
+		value = *r.Min
+	}
+	if r.Max != nil && value > *r.Max {
+		value = *r.Max
+	}
+	return value
+}
+
+func (r *ParameterRule) validate(name string) error {
+	if r != nil && r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+		return fmt.Errorf("invalid parameter policy for %s: min %v is greater than max %v", name, *r.Min, *r.Max)
+	}
+	return nil
+}
+
+const (
+	DefaultMaxRequestBodyBytes = 32 << 20
+	DefaultMaxMessages         = 10000
+
+	DefaultVertexAnthropicVersion = "vertex-2023-10-16"
+
+	DefaultResponseFilterWindow      = 256
+	DefaultResponseFilterReplacement = "[REDACTED]"
+)
+
+// 响应过滤命中后的处理方式
+const (
+	ResponseFilterActionRedact = "redact" // 替换命中的内容
+	ResponseFilterActionBlock  = "block"  // 拦截整个响应并返回错误
+)
+
+// 默认系统提示词的注入方式
+const (
+	SystemPromptModePrepend         = "prepend"           // 插入到客户端系统提示词之前
+	SystemPromptModeAppend          = "append"            // 追加到客户端系统提示词之后
+	SystemPromptModeReplaceIfAbsent = "replace_if_absent" // 仅在客户端未提供系统提示词时使用
+)
+
+// vertexAnthropicVersionRegex Vertex anthropic_version 的格式，如 vertex-2023-10-16
+var vertexAnthropicVersionRegex = regexp.MustCompile(`^vertex-\d{4}-\d{2}-\d{2}$`)
+
+// IsValidVertexAnthropicVersion 校验 anthropic_version 的格式，新版本无需修改代码即可配置
+func IsValidVertexAnthropicVersion(version string) bool {
+	return vertexAnthropicVersionRegex.MatchString(version)
+}
+
+// ProtectedUpstreamHeaders 由渠道鉴权设置的请求头，不允许通过 UpstreamHeaders 覆盖
+var ProtectedUpstreamHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"X-Api-Key":           true,
+	"X-Goog-Api-Key":      true,
+}
+
+// vertexApiHostRegex 域名（可带端口），占位符替换后校验
+var vertexApiHostRegex = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?\.)*[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(:[0-9]{1,5})?$`)
+
+// Validate 校验渠道设置中的取值
+func (s *ChannelSettings) Validate() error {
+	for model, version := range s.VertexAnthropicVersions {
+		if !IsValidVertexAnthropicVersion(version) {
+			return fmt.Errorf("invalid vertex anthropic version %q for model %s, expected vertex-YYYY-MM-DD", version, model)
+		}
+	}
+	if s.VertexApiHost != "" && !vertexApiHostRegex.MatchString(strings.ReplaceAll(s.VertexApiHost, "{region}", "us-central1")) {
+		return fmt.Errorf("invalid vertex api host %q, expected a host name without scheme or path", s.VertexApiHost)
+	}
+	for key := range s.UpstreamHeaders {
+		if ProtectedUpstreamHeaders[http.CanonicalHeaderKey(key)] {
+			return fmt.Errorf("upstream header %s is reserved for channel authentication", key)
+		}
+	}
+	if policy := s.ParameterPolicy; policy != nil {
+		for name, rule := range map[string]*ParameterRule{
+			"temperature":            policy.Temperature,
+			"top_p":                  policy.TopP,
+			"max_tokens":             policy.MaxTokens,
+			"thinking_budget_tokens": policy.ThinkingBudgetTokens,
+		} {
+			if err := rule.validate(name); err != nil {
+				return err
+			}
+		}
+	}
+	for status := range s.ErrorMappings {
+		if code, err := strconv.Atoi(status); err != nil || code < 400 || code > 599 {
+			return fmt.Errorf("invalid error mapping status %q, expected an http error status code", status)
+		}
+	}
+	if s.TLS != nil {
+		if _, err := s.TLS.BuildTLSConfig(); err != nil {
+			return err
+		}
+	}
+	for model, rules := range s.ModelRoutes {
+		for i, rule := range rules {
+			if rule.Model == "" {
+				return fmt.Errorf("model route %d of %s has no target model", i, model)
+			}
+		}
+	}
+	for i, rule := range s.ResponseFilters {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("invalid response filter %d: %w", i, err)
+		}
+	}
+	switch s.DefaultSystemPromptMode {
+	case "", SystemPromptModePrepend, SystemPromptModeAppend, SystemPromptModeReplaceIfAbsent:
+	default:
+		return fmt.Errorf("unsupported default system prompt mode %q", s.DefaultSystemPromptMode)
+	}
+	return nil
+}
+
+// GetDefaultSystemPromptMode 获取默认系统提示词的注入方式
+func (s *ChannelSettings) GetDefaultSystemPromptMode() string {
+	if s.DefaultSystemPromptMode == "" {
+		return SystemPromptModePrepend
+	}
+	return s.DefaultSystemPromptMode
+}
+
+// GetVertexAnthropicVersion 获取模型使用的 anthropic_version
+func (s *ChannelSettings) GetVertexAnthropicVersion(model string) string {
+	if version, ok := s.VertexAnthropicVersions[model]; ok && version != "" {
+		return version
+	}
+	if version, ok := s.VertexAnthropicVersions["default"]; ok && version != "" {
+		return version
+	}
+	return DefaultVertexAnthropicVersion
+}
+
+// HasConnectionPool 是否配置了渠道级连接池
+func (s *ChannelSettings) HasConnectionPool() bool {
+	return s.MaxIdleConnsPerHost > 0 || s.IdleConnTimeout > 0 || s.KeepAlive > 0
+}
+
+// GetMaxRequestBodyBytes 获取请求体大小上限，0 表示不限制
+func (s *ChannelSettings) GetMaxRequestBodyBytes() int {
+	if s.MaxRequestBodyBytes == 0 {
+		return DefaultMaxRequestBodyBytes
+	}
+	return max(s.MaxRequestBodyBytes, 0)
+}
+
+// GetMaxMessages 获取消息数上限，0 表示不限制
+func (s *ChannelSettings) GetMaxMessages() int {
+	if s.MaxMessages == 0 {
+		return DefaultMaxMessages
+	}
+	return max(s.MaxMessages, 0)
+}
+
+// ModelRouteRule 模型路由规则，所有已配置的条件均满足时使用 Model
+type ModelRouteRule struct {
+	Model                   string `json:"model"`
+	MinPromptTokens         int    `json:"min_prompt_tokens,omitempty"`
+	MaxPromptTokens         int    `json:"max_prompt_tokens,omitempty"`
+	HasImages               *bool  `json:"has_images,omitempty"`
+	Thinking                *bool  `json:"thinking,omitempty"`                   // 是否开启扩展思考
+	MinThinkingBudgetTokens int    `json:"min_thinking_budget_tokens,omitempty"` // 思考预算下限，用于区分推理强度
+}
+
+// Match 判断请求特征是否满足规则
+func (r *ModelRouteRule) Match(promptTokens int, hasImages bool, thinking bool, thinkingBudget int) bool {
+	if r.MinPromptTokens > 0 && promptTokens < r.MinPromptTokens {
+		return false
+	}
+	if r.MaxPromptTokens > 0 && promptTokens > r.MaxPromptTokens {
+		return false
+	}
+	if r.HasImages != nil && *r.HasImages != hasImages {
+		return false
+	}
+	if r.Thinking != nil && *r.Thinking != thinking {
+		return false
+	}
+	return thinkingBudget >= r.MinThinkingBudgetTokens
+}
+
+// ResponseFilterRule 响应内容过滤规则，Pattern 为正则表达式，Matcher 为代码中注册的匹配器名称，二者选其一
+type ResponseFilterRule struct {
+	Pattern         string `json:"pattern,omitempty"`
+	Matcher         string `json:"matcher,omitempty"`
+	Action          string `json:"action"`
+	Replacement     string `json:"replacement,omitempty"`       // 替换文本，为空时使用 [REDACTED]
+	ErrorMessage    string `json:"error_message,omitempty"`     // 拦截时返回的错误信息
+	ErrorStatusCode int    `json:"error_status_code,omitempty"` // 拦截时返回的状态码，为 0 时使用 400
+}
+
+func (r *ResponseFilterRule) validate() error {
+	if (r.Pattern == "") == (r.Matcher == "") {
+		return fmt.Errorf("exactly one of pattern and matcher is required")
+	}
+	if r.Pattern != "" {
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return err
+		}
+	}
+	switch r.Action {
+	case ResponseFilterActionRedact, ResponseFilterActionBlock:
+	default:
+		return fmt.Errorf("unsupported action %q", r.Action)
+	}
+	if r.ErrorStatusCode != 0 && (r.ErrorStatusCode < 400 || r.ErrorStatusCode > 599) {
+		return fmt.Errorf("invalid error status code %d", r.ErrorStatusCode)
+	}
+	return nil
+}
+
+// GetResponseFilterWindow 获取流式响应过滤保留的字节数
+func (s *ChannelSettings) GetResponseFilterWindow() int {
+	if s.ResponseFilterWindow > 0 {
+		return s.ResponseFilterWindow
+	}
+	return DefaultResponseFilterWindow
 }
@@ -0,0 +1,103 @@
+package dto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestValidateVertexAnthropicVersions(t *testing.T) {
+	tests := []struct {
+		version string
+		valid   bool
+	}{
+		{DefaultVertexAnthropicVersion, true},
+		{"vertex-2025-06-01", true},
+		{"2023-06-01", false},
+		{"vertex-latest", false},
+	}
+	for _, tt := range tests {
+		s := ChannelSettings{VertexAnthropicVersions: map[string]string{"claude-sonnet-4-20250514": tt.version}}
+		if err := s.Validate(); (err == nil) != tt.valid {
+			t.Errorf("Validate(%q) error = %v, want valid %v", tt.version, err, tt.valid)
+		}
+	}
+}
+
+func TestValidateVertexApiHost(t *testing.T) {
+	tests := []struct {
+		host  string
+		valid bool
+	}{
+		{"", true},
+		{"aiplatform-psc.p.googleapis.com", true},
+		{"{region}-aiplatform.example.internal", true},
+		{"vertex.example.internal:8443", true},
+		{"https://aiplatform.example.internal", false},
+		{"aiplatform.example.internal/v1", false},
+		{"-bad.example.internal", false},
+	}
+	for _, tt := range tests {
+		s := ChannelSettings{VertexApiHost: tt.host}
+		if err := s.Validate(); (err == nil) != tt.valid {
+			t.Errorf("Validate(%q) error = %v, want valid %v", tt.host, err, tt.valid)
+		}
+	}
+}
+
+// newTestCertificatePEM 生成自签名证书与私钥，notAfter 控制证书过期时间
+func newTestCertificatePEM(t *testing.T, notAfter time.Time) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test-client"},
+		NotBefore:    notAfter.Add(-48 * time.Hour),
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}))
+}
+
+func TestValidateChannelTLS(t *testing.T) {
+	cert, key := newTestCertificatePEM(t, time.Now().Add(24*time.Hour))
+	otherCert, _ := newTestCertificatePEM(t, time.Now().Add(24*time.Hour))
+	expiredCert, expiredKey := newTestCertificatePEM(t, time.Now().Add(-time.Hour))
+	tests := []struct {
+		name  string
+		tls   ChannelTLSConfig
+		valid bool
+	}{
+		{"ca bundle", ChannelTLSConfig{CACert: cert}, true},
+		{"client key pair", ChannelTLSConfig{ClientCert: cert, ClientKey: key}, true},
+		{"insecure skip verify", ChannelTLSConfig{InsecureSkipVerify: true}, true},
+		{"malformed ca bundle", ChannelTLSConfig{CACert: "not a certificate"}, false},
+		{"client cert without key", ChannelTLSConfig{ClientCert: cert}, false},
+		{"mismatched key pair", ChannelTLSConfig{ClientCert: otherCert, ClientKey: key}, false},
+		{"expired client cert", ChannelTLSConfig{ClientCert: expiredCert, ClientKey: expiredKey}, false},
+	}
+	for _, tt := range tests {
+		s := ChannelSettings{TLS: &tt.tls}
+		if err := s.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%s: Validate error = %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package dto
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ChannelTLSConfig 渠道出站连接的 TLS 设置，用于 Private Service Connect、mTLS 等私有端点
+// 证书与私钥均为 PEM 格式内容
+type ChannelTLSConfig struct {
+	CACert             string `json:"ca_cert,omitempty"`              // 额外信任的 CA 证书，在系统根证书基础上追加
+	ClientCert         string `json:"client_cert,omitempty"`          // mTLS 客户端证书，需与 client_key 同时配置
+	ClientKey          string `json:"client_key,omitempty"`           // mTLS 客户端私钥
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"` // 不校验服务端证书，仅用于测试
+}
+
+// BuildTLSConfig 解析证书生成 tls.Config，同时用于保存渠道时的校验
+func (t *ChannelTLSConfig) BuildTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if t.CACert != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(t.CACert)) {
+			return nil, errors.New("invalid tls ca_cert, no PEM certificate found")
+		}
+		config.RootCAs = pool
+	}
+	if (t.ClientCert == "") != (t.ClientKey == "") {
+		return nil, errors.New("tls client_cert and client_key must be configured together")
+	}
+	if t.ClientCert != "" {
+		cert, err := tls.X509KeyPair([]byte(t.ClientCert), []byte(t.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid tls client certificate: %w", err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid tls client certificate: %w", err)
+		}
+		if time.Now().After(leaf.NotAfter) {
+			return nil, fmt.Errorf("tls client certificate expired at %s", leaf.NotAfter.Format(time.RFC3339))
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// Fingerprint 区分不同 TLS 设置的摘要，用于复用 HTTP 客户端，不暴露证书与私钥内容
+func (t *ChannelTLSConfig) Fingerprint() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%s\x00%v", t.CACert, t.ClientCert, t.ClientKey, t.InsecureSkipVerify)))
+	return hex.EncodeToString(sum[:])
+}
@@ -17,6 +17,7 @@ type ClaudeMediaMessage struct {
 	Source       *ClaudeMessageSource `json:"source,omitempty"`
 	Usage        *ClaudeUsage         `json:"usage,omitempty"`
 	StopReason   *string              `json:"stop_reason,omitempty"`
+	StopSequence *string              `json:"stop_sequence,omitempty"`
 	PartialJson  *string              `json:"partial_json,omitempty"`
 	Role         string               `json:"role,omitempty"`
 	Thinking     string               `json:"thinking,omitempty"`
@@ -191,11 +192,15 @@ type ClaudeRequest struct {
 	Temperature       *float64        `json:"temperature,omitempty"`
 	TopP              float64         `json:"top_p,omitempty"`
 	TopK              int             `json:"top_k,omitempty"`
-	//ClaudeMetadata    `json:"metadata,omitempty"`
-	Stream     bool      `json:"stream,omitempty"`
-	Tools      any       `json:"tools,omitempty"`
-	ToolChoice any       `json:"tool_choice,omitempty"`
-	Thinking   *Thinking `json:"thinking,omitempty"`
+	Metadata          *ClaudeMetadata `json:"metadata,omitempty"`
+	Stream            bool            `json:"stream,omitempty"`
+	Tools             any             `json:"tools,omitempty"`
+	ToolChoice        any             `json:"tool_choice,omitempty"`
+	Thinking          *Thinking       `json:"thinking,omitempty"`
+	// 代码执行等服务端工具复用的容器
+	Container any `json:"container,omitempty"`
+	// 网关扩展字段，强制关闭思考，不转发给上游
+	DisableThinking bool `json:"disable_thinking,omitempty"`
 }
 
 // AddTool 添加工具到请求中
@@ -302,6 +307,7 @@ type ClaudeResponse struct {
 	Content      []ClaudeMediaMessage `json:"content,omitempty"`
 	Completion   string               `json:"completion,omitempty"`
 	StopReason   string               `json:"stop_reason,omitempty"`
+	StopSequence *string              `json:"stop_sequence,omitempty"`
 	Model        string               `json:"model,omitempty"`
 	Error        *types.ClaudeError   `json:"error,omitempty"`
 	Usage        *ClaudeUsage         `json:"usage,omitempty"`
@@ -335,3 +341,15 @@ type ClaudeUsage struct {
 type ClaudeServerToolUse struct {
 	WebSearchRequests int `json:"web_search_requests"`
 }
+
+// ClaudeCostEstimate Claude 请求的费用预估，quota 均为上限估算
+type ClaudeCostEstimate struct {
+	Model                string `json:"model"`
+	PromptTokens         int    `json:"prompt_tokens"`
+	MaxTokens            int    `json:"max_tokens"`
+	ThinkingBudgetTokens int    `json:"thinking_budget_tokens"`
+	UsePrice             bool   `json:"use_price"`
+	PromptQuota          int    `json:"prompt_quota"`           // 仅输入部分的费用
+	QuotaWithoutThinking int    `json:"quota_without_thinking"` // 输出不含思考预算时的最大费用
+	QuotaWithThinking    int    `json:"quota_with_thinking"`    // 输出用满 max_tokens 时的最大费用
+}
@@ -42,7 +42,7 @@ type GeneralOpenAIRequest struct {
 	PresencePenalty     float64           `json:"presence_penalty,omitempty"`
 	ResponseFormat      *ResponseFormat   `json:"response_format,omitempty"`
 	EncodingFormat      json.RawMessage   `json:"encoding_format,omitempty"`
-	Seed                float64           `json:"seed,omitempty"`
+	Seed                *float64          `json:"seed,omitempty"`
 	ParallelTooCalls    *bool             `json:"parallel_tool_calls,omitempty"`
 	Tools               []ToolCallRequest `json:"tools,omitempty"`
 	ToolChoice          any               `json:"tool_choice,omitempty"`
@@ -120,6 +120,7 @@ type Message struct {
 	Reasoning        string          `json:"reasoning,omitempty"`
 	ToolCalls        json.RawMessage `json:"tool_calls,omitempty"`
 	ToolCallId       string          `json:"tool_call_id,omitempty"`
+	Annotations      []Annotation    `json:"annotations,omitempty"`
 	parsedContent    []MediaContent
 	//parsedStringContent *string
 }
@@ -142,9 +143,26 @@ func (m *MediaContent) GetImageMedia() *MessageImageUrl {
 		}
 		if itemMap, ok := m.ImageUrl.(map[string]any); ok {
 			out := &MessageImageUrl{
-				Url:      common.Interface2String(itemMap["url"]),
-				Detail:   common.Interface2String(itemMap["detail"]),
-				MimeType: common.Interface2String(itemMap["mime_type"]),
+				Url:           common.Interface2String(itemMap["url"]),
+				Detail:        common.Interface2String(itemMap["detail"]),
+				MimeType:      common.Interface2String(itemMap["mime_type"]),
+				VideoMetadata: parseVideoMetadata(itemMap["video_metadata"]),
+			}
+			return out
+		}
+	}
+	return nil
+}
+
+func (m *MediaContent) GetVideoUrl() *MessageVideoUrl {
+	if m.VideoUrl != nil {
+		if _, ok := m.VideoUrl.(*MessageVideoUrl); ok {
+			return m.VideoUrl.(*MessageVideoUrl)
+		}
+		if itemMap, ok := m.VideoUrl.(map[string]any); ok {
+			out := &MessageVideoUrl{
+				Url:           common.Interface2String(itemMap["url"]),
+				VideoMetadata: parseVideoMetadata(itemMap["video_metadata"]),
 			}
 			return out
 		}
@@ -186,9 +204,10 @@ func (m *MediaContent) GetFile() *MessageFile {
 }
 
 type MessageImageUrl struct {
-	Url      string `json:"url"`
-	Detail   string `json:"detail"`
-	MimeType string
+	Url           string `json:"url"`
+	Detail        string `json:"detail"`
+	MimeType      string
+	VideoMetadata *VideoMetadata `json:"video_metadata,omitempty"`
 }
 
 func (m *MessageImageUrl) IsRemoteImage() bool {
@@ -207,7 +226,30 @@ type MessageFile struct {
 }
 
 type MessageVideoUrl struct {
-	Url string `json:"url"`
+	Url           string         `json:"url"`
+	VideoMetadata *VideoMetadata `json:"video_metadata,omitempty"`
+}
+
+// VideoMetadata 视频输入的采样帧率与截取区间，偏移量为时长字符串，如 "10s"、"1m30s"
+type VideoMetadata struct {
+	Fps         float64 `json:"fps,omitempty"`
+	StartOffset string  `json:"start_offset,omitempty"`
+	EndOffset   string  `json:"end_offset,omitempty"`
+}
+
+func parseVideoMetadata(v any) *VideoMetadata {
+	itemMap, ok := v.(map[string]any)
+	if !ok {
+		return nil
+	}
+	// 未提供的偏移量保持为空字符串
+	metadata := &VideoMetadata{}
+	metadata.StartOffset, _ = itemMap["start_offset"].(string)
+	metadata.EndOffset, _ = itemMap["end_offset"].(string)
+	if fps, ok := itemMap["fps"].(float64); ok {
+		metadata.Fps = fps
+	}
+	return metadata
 }
 
 const (
@@ -361,6 +403,7 @@ func (m *Message) ParseContent() []MediaContent {
 				if ok1 {
 					temp.Url = url
 				}
+				temp.VideoMetadata = parseVideoMetadata(v["video_metadata"])
 			}
 			contentList = append(contentList, MediaContent{
 				Type:     ContentTypeImageURL,
@@ -407,13 +450,24 @@ func (m *Message) ParseContent() []MediaContent {
 				}
 			}
 		case ContentTypeVideoUrl:
-			if videoUrl, ok := contentItem["video_url"].(string); ok {
+			switch v := contentItem["video_url"].(type) {
+			case string:
 				contentList = append(contentList, MediaContent{
 					Type: ContentTypeVideoUrl,
 					VideoUrl: &MessageVideoUrl{
-						Url: videoUrl,
+						Url: v,
 					},
 				})
+			case map[string]interface{}:
+				if url, ok := v["url"].(string); ok {
+					contentList = append(contentList, MediaContent{
+						Type: ContentTypeVideoUrl,
+						VideoUrl: &MessageVideoUrl{
+							Url:           url,
+							VideoMetadata: parseVideoMetadata(v["video_metadata"]),
+						},
+					})
+				}
 			}
 		}
 	}
@@ -22,7 +22,27 @@ type TextResponse struct {
 type OpenAITextResponseChoice struct {
 	Index        int `json:"index"`
 	Message      `json:"message"`
-	FinishReason string `json:"finish_reason"`
+	FinishReason string          `json:"finish_reason"`
+	Logprobs     *ChoiceLogprobs `json:"logprobs,omitempty"`
+	StopSequence *string         `json:"stop_sequence,omitempty"` // 触发结束的停止序列（Claude stop_sequence），按配置返回
+}
+
+// ChoiceLogprobs 输出 token 的对数概率
+type ChoiceLogprobs struct {
+	Content []TokenLogprob `json:"content"`
+}
+
+type TokenLogprob struct {
+	Token       string       `json:"token"`
+	Logprob     float64      `json:"logprob"`
+	Bytes       []int        `json:"bytes"`
+	TopLogprobs []TopLogprob `json:"top_logprobs"`
+}
+
+type TopLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+	Bytes   []int   `json:"bytes"`
 }
 
 type OpenAITextResponse struct {
@@ -66,6 +86,7 @@ type ChatCompletionsStreamResponseChoice struct {
 	Logprobs     *any                                     `json:"logprobs"`
 	FinishReason *string                                  `json:"finish_reason"`
 	Index        int                                      `json:"index"`
+	StopSequence *string                                  `json:"stop_sequence,omitempty"`
 }
 
 type ChatCompletionsStreamResponseChoiceDelta struct {
@@ -74,6 +95,20 @@ type ChatCompletionsStreamResponseChoiceDelta struct {
 	Reasoning        *string            `json:"reasoning,omitempty"`
 	Role             string             `json:"role,omitempty"`
 	ToolCalls        []ToolCallResponse `json:"tool_calls,omitempty"`
+	Annotations      []Annotation       `json:"annotations,omitempty"`
+}
+
+// Annotation 回复内容的引用来源，目前仅支持 url_citation
+type Annotation struct {
+	Type        string       `json:"type"`
+	UrlCitation *UrlCitation `json:"url_citation,omitempty"`
+}
+
+type UrlCitation struct {
+	Url        string `json:"url"`
+	Title      string `json:"title,omitempty"`
+	StartIndex int    `json:"start_index"`
+	EndIndex   int    `json:"end_index"`
 }
 
 func (c *ChatCompletionsStreamResponseChoiceDelta) SetContentString(s string) {
@@ -196,6 +231,8 @@ type Usage struct {
 	InputTokensDetails     *InputTokenDetails `json:"input_tokens_details"`
 	// OpenRouter Params
 	Cost any `json:"cost,omitempty"`
+	// 上游返回的原始用量（如 Anthropic 的 usage、Gemini 的 usageMetadata），仅在客户端要求时返回
+	UpstreamUsage any `json:"x_upstream_usage,omitempty"`
 }
 
 type InputTokenDetails struct {
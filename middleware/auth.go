@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"net/http"
 	"one-api/common"
@@ -167,6 +168,22 @@ func RootAuth() func(c *gin.Context) {
 	}
 }
 
+// MetricsAuth 校验 /metrics 的访问权限，配置了 METRICS_TOKEN 时校验 Bearer token，否则要求管理员登录
+func MetricsAuth() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if common.MetricsToken == "" {
+			authHelper(c, common.RoleAdminUser)
+			return
+		}
+		token := strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(common.MetricsToken)) != 1 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Next()
+	}
+}
+
 func WssAuth(c *gin.Context) {
 
 }
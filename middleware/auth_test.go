@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"testing"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+)
+
+func newMetricsTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(sessions.Sessions("session", cookie.NewStore([]byte("test-secret"))))
+	router.GET("/metrics", MetricsAuth(), func(c *gin.Context) {
+		c.String(http.StatusOK, "new_api_relay_prompt_tokens_count 1")
+	})
+	return router
+}
+
+func TestMetricsAuth(t *testing.T) {
+	originalToken := common.MetricsToken
+	defer func() { common.MetricsToken = originalToken }()
+
+	tests := []struct {
+		name          string
+		metricsToken  string
+		authorization string
+		wantExposed   bool
+	}{
+		{"no credentials without token", "", "", false},
+		{"missing token", "scrape-secret", "", false},
+		{"wrong token", "scrape-secret", "Bearer wrong", false},
+		{"valid token", "scrape-secret", "Bearer scrape-secret", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			common.MetricsToken = tt.metricsToken
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			newMetricsTestRouter().ServeHTTP(recorder, req)
+			exposed := recorder.Code == http.StatusOK && recorder.Body.String() == "new_api_relay_prompt_tokens_count 1"
+			if exposed != tt.wantExposed {
+				t.Errorf("metrics exposed = %v (status %d), want %v", exposed, recorder.Code, tt.wantExposed)
+			}
+		})
+	}
+}
@@ -11,6 +11,7 @@ import (
 	relayconstant "one-api/relay/constant"
 	"one-api/service"
 	"one-api/setting"
+	"one-api/setting/model_setting"
 	"one-api/setting/ratio_setting"
 	"one-api/types"
 	"strconv"
@@ -60,6 +61,18 @@ func Distribute() func(c *gin.Context) {
 			userGroup = tokenGroup
 		}
 		common.SetContextKey(c, constant.ContextKeyUsingGroup, userGroup)
+		// 大小写或首尾空白不同的模型名统一为渠道中配置的规范模型名
+		if canonical, ok := model.GetCanonicalModelName(modelRequest.Model); ok {
+			common.LogInfo(c, fmt.Sprintf("model name normalized: %q -> %s", modelRequest.Model, canonical))
+			modelRequest.Model = canonical
+		}
+		// 模型别名在选择渠道前解析，渠道与计费均使用实际模型
+		modelAlias := ""
+		if resolved, isAlias := model_setting.GetModelAliasSettings().ResolveModelAlias(userGroup, modelRequest.Model); isAlias {
+			modelAlias = modelRequest.Model
+			modelRequest.Model = resolved
+			common.SetContextKey(c, constant.ContextKeyModelAlias, modelAlias)
+		}
 		if ok {
 			id, err := strconv.Atoi(channelId.(string))
 			if err != nil {
@@ -88,7 +101,7 @@ func Distribute() func(c *gin.Context) {
 					tokenModelLimit = map[string]bool{}
 				}
 				if tokenModelLimit != nil {
-					if _, ok := tokenModelLimit[modelRequest.Model]; !ok {
+					if !model.IsModelAllowedByLimits(tokenModelLimit, modelRequest.Model) && (modelAlias == "" || !model.IsModelAllowedByLimits(tokenModelLimit, modelAlias)) {
 						abortWithOpenAiMessage(c, http.StatusForbidden, "该令牌无权访问模型 "+modelRequest.Model)
 						return
 					}
@@ -172,6 +185,15 @@ func getModelRequest(c *gin.Context) (*ModelRequest, bool, error) {
 		}
 		c.Set("platform", string(constant.TaskPlatformSuno))
 		c.Set("relay_mode", relayMode)
+	} else if strings.Contains(c.Request.URL.Path, "/v1/vertex/batches") {
+		relayMode := relayconstant.Path2RelayVertexBatch(c.Request.Method, c.Request.URL.Path)
+		if relayMode == relayconstant.RelayModeVertexBatchFetchByID {
+			shouldSelectChannel = false
+		} else {
+			err = common.UnmarshalBodyReusable(c, &modelRequest)
+		}
+		c.Set("platform", string(constant.TaskPlatformVertexBatch))
+		c.Set("relay_mode", relayMode)
 	} else if strings.Contains(c.Request.URL.Path, "/v1/video/generations") {
 		err = common.UnmarshalBodyReusable(c, &modelRequest)
 		var platform string
@@ -3,16 +3,59 @@ package middleware
 import (
 	"context"
 	"github.com/gin-gonic/gin"
+	"net/http"
 	"one-api/common"
+	"one-api/constant"
+	"one-api/setting/operation_setting"
+	"regexp"
+	"strings"
 )
 
+// RequestIdHeader 通用的请求 id 请求头，客户端传入合法值时沿用，便于跨服务关联日志
+const RequestIdHeader = "X-Request-Id"
+
+// TraceparentHeader W3C Trace Context 请求头，取其中的 trace-id 作为请求 id
+const TraceparentHeader = "Traceparent"
+
+var requestIdRegex = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// traceparentRegex version-traceid-parentid-flags，trace-id 不能全为 0
+var traceparentRegex = regexp.MustCompile(`^[0-9a-f]{2}-([0-9a-f]{32})-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+// getClientTraceId 按配置顺序读取客户端传入的追踪 id，返回提供 id 的请求头
+func getClientTraceId(c *gin.Context) (string, string) {
+	for _, header := range operation_setting.GetGeneralSetting().TraceHeaders {
+		header = http.CanonicalHeaderKey(header)
+		value := strings.TrimSpace(c.Request.Header.Get(header))
+		if value == "" {
+			continue
+		}
+		if header == TraceparentHeader {
+			if match := traceparentRegex.FindStringSubmatch(value); match != nil && strings.Trim(match[1], "0") != "" {
+				return match[1], header
+			}
+			continue
+		}
+		if requestIdRegex.MatchString(value) {
+			return value, header
+		}
+	}
+	return "", ""
+}
+
 func RequestId() func(c *gin.Context) {
 	return func(c *gin.Context) {
-		id := common.GetTimeString() + common.GetRandomString(8)
+		id, traceHeader := getClientTraceId(c)
+		if id == "" {
+			id = common.GetTimeString() + common.GetRandomString(8)
+		} else {
+			common.SetContextKey(c, constant.ContextKeyTraceHeader, traceHeader)
+		}
 		c.Set(common.RequestIdKey, id)
 		ctx := context.WithValue(c.Request.Context(), common.RequestIdKey, id)
 		c.Request = c.Request.WithContext(ctx)
 		c.Header(common.RequestIdKey, id)
+		c.Header(RequestIdHeader, id)
 		c.Next()
 	}
 }
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serveRequestId 经过 RequestId 中间件处理请求，返回上下文中的请求 id、响应头与日志
+func serveRequestId(t *testing.T, header map[string]string) (string, string, string) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	var logs bytes.Buffer
+	originalWriter := gin.DefaultWriter
+	gin.DefaultWriter = &logs
+	defer func() { gin.DefaultWriter = originalWriter }()
+
+	var requestId string
+	router := gin.New()
+	router.Use(RequestId())
+	router.POST("/v1/messages", func(c *gin.Context) {
+		requestId = c.GetString(common.RequestIdKey)
+		common.LogInfo(c, "[CLAUDE] Request started")
+		common.LogInfo(c.Request.Context(), "[CLAUDE] Upstream request")
+		common.LogInfo(c, "[CLAUDE] Request completed")
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return requestId, recorder.Header().Get(RequestIdHeader), logs.String()
+}
+
+func TestRequestIdReusesIncomingTraceparent(t *testing.T) {
+	const traceId = "4bf92f3577b34da6a3ce929d0e0e4736"
+	requestId, responseId, logs := serveRequestId(t, map[string]string{"traceparent": "00-" + traceId + "-00f067aa0ba902b7-01"})
+	if requestId != traceId || responseId != traceId {
+		t.Fatalf("request id = %q, response %s = %q, want the traceparent trace-id %q", requestId, RequestIdHeader, responseId, traceId)
+	}
+	lines := strings.Split(strings.TrimSpace(logs), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("want 3 log lines, got:\n%s", logs)
+	}
+	for _, line := range lines {
+		if !strings.Contains(line, "| "+traceId+" |") {
+			t.Errorf("log line does not carry the reused id: %s", line)
+		}
+	}
+}
+
+func TestRequestIdValidatesIncomingIds(t *testing.T) {
+	tests := []struct {
+		name   string
+		header map[string]string
+		want   string
+	}{
+		{"x-request-id before traceparent", map[string]string{"X-Request-Id": "client-req.42", "traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}, "client-req.42"},
+		{"invalid x-request-id falls back to traceparent", map[string]string{"X-Request-Id": "bad id\n", "traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}, "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{"all-zero trace-id is generated", map[string]string{"traceparent": "00-00000000000000000000000000000000-00f067aa0ba902b7-01"}, ""},
+		{"malformed traceparent is generated", map[string]string{"traceparent": "00-4bf92f35-01"}, ""},
+		{"no trace headers is generated", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requestId, responseId, _ := serveRequestId(t, tt.header)
+			if requestId == "" || responseId != requestId {
+				t.Fatalf("request id = %q, response header = %q", requestId, responseId)
+			}
+			if tt.want != "" && requestId != tt.want {
+				t.Errorf("request id = %q, want %q", requestId, tt.want)
+			}
+			if tt.want == "" && (strings.Contains(requestId, "0000000000") || strings.Contains(requestId, "4bf92f35")) {
+				t.Errorf("request id = %q, want a freshly generated id", requestId)
+			}
+		})
+	}
+}
@@ -16,6 +16,7 @@ import (
 
 var group2model2channels map[string]map[string][]int // enabled channel
 var channelsIDM map[int]*Channel                     // all channels include disabled
+var normalizedModelNames map[string]string           // normalized model name -> canonical name, "" if ambiguous
 var channelSyncLock sync.RWMutex
 
 func InitChannelCache() {
@@ -64,8 +65,21 @@ func InitChannelCache() {
 		}
 	}
 
+	newNormalizedModelNames := make(map[string]string)
+	for _, model2channels := range newGroup2model2channels {
+		for model := range model2channels {
+			key := NormalizeModelName(model)
+			if canonical, ok := newNormalizedModelNames[key]; ok && canonical != model {
+				newNormalizedModelNames[key] = ""
+				continue
+			}
+			newNormalizedModelNames[key] = model
+		}
+	}
+
 	channelSyncLock.Lock()
 	group2model2channels = newGroup2model2channels
+	normalizedModelNames = newNormalizedModelNames
 	channelsIDM = newChannelId2channel
 	channelSyncLock.Unlock()
 	common.SysLog("channels synced from database")
@@ -0,0 +1,39 @@
+package model
+
+import (
+	"one-api/common"
+	"strings"
+)
+
+// NormalizeModelName 模型名的比较形式，去除首尾空白并转为小写
+func NormalizeModelName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// GetCanonicalModelName 将大小写或首尾空白不同的模型名解析为已启用渠道中的规范模型名
+// 模型名已与规范名一致、无匹配或匹配到多个规范名时返回 false
+func GetCanonicalModelName(name string) (string, bool) {
+	key := NormalizeModelName(name)
+	if key == "" {
+		return "", false
+	}
+	if common.MemoryCacheEnabled {
+		channelSyncLock.RLock()
+		canonical := normalizedModelNames[key]
+		channelSyncLock.RUnlock()
+		if canonical == "" || canonical == name {
+			return "", false
+		}
+		return canonical, true
+	}
+	// 未启用内存缓存时，仅在模型名不是比较形式时查询数据库，避免每个请求都增加一次查询
+	if key == name {
+		return "", false
+	}
+	var models []string
+	DB.Table("abilities").Where("enabled = ? AND LOWER(model) = ?", true, key).Distinct("model").Pluck("model", &models)
+	if len(models) != 1 || models[0] == name {
+		return "", false
+	}
+	return models[0], true
+}
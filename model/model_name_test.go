@@ -0,0 +1,60 @@
+package model
+
+import (
+	"one-api/common"
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestGetCanonicalModelName(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	if err := db.AutoMigrate(&Channel{}, &Ability{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	originalDB, originalMemoryCache := DB, common.MemoryCacheEnabled
+	DB = db
+	defer func() { DB, common.MemoryCacheEnabled = originalDB, originalMemoryCache }()
+	channels := []*Channel{
+		{Id: 1, Type: 1, Key: "sk-1", Status: common.ChannelStatusEnabled, Group: "default", Models: "claude-3-5-sonnet-20241022,Gemini-2.5-Pro"},
+		// 仅大小写不同的两个模型名无法确定规范名
+		{Id: 2, Type: 1, Key: "sk-2", Status: common.ChannelStatusEnabled, Group: "default", Models: "GPT-4o,gpt-4o"},
+	}
+	for _, channel := range channels {
+		db.Create(channel)
+		if err := channel.AddAbilities(); err != nil {
+			t.Fatalf("add abilities: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		model  string
+		want   string
+		wantOk bool
+	}{
+		{"mixed case with trailing space", "Claude-3-5-Sonnet-20241022 ", "claude-3-5-sonnet-20241022", true},
+		{"leading whitespace", "\tclaude-3-5-sonnet-20241022", "claude-3-5-sonnet-20241022", true},
+		{"upper case", "CLAUDE-3-5-SONNET-20241022", "claude-3-5-sonnet-20241022", true},
+		{"mixed case canonical name", " GEMINI-2.5-pro", "Gemini-2.5-Pro", true},
+		{"already canonical", "claude-3-5-sonnet-20241022", "", false},
+		{"ambiguous", "Gpt-4O", "", false},
+		{"unknown model", "Claude-Unknown", "", false},
+		{"blank", "  ", "", false},
+	}
+	for _, memoryCache := range []bool{false, true} {
+		common.MemoryCacheEnabled = memoryCache
+		InitChannelCache()
+		for _, tt := range tests {
+			got, ok := GetCanonicalModelName(tt.model)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("memory cache %v, %s: GetCanonicalModelName(%q) = %q, %v, want %q, %v", memoryCache, tt.name, tt.model, got, ok, tt.want, tt.wantOk)
+			}
+		}
+	}
+}
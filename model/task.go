@@ -3,6 +3,8 @@ package model
 import (
 	"database/sql/driver"
 	"encoding/json"
+	"math"
+	"one-api/common"
 	"one-api/constant"
 	commonRelay "one-api/relay/common"
 	"time"
@@ -52,7 +54,28 @@ func (t *Task) GetData(v any) error {
 }
 
 type Properties struct {
-	Input string `json:"input"`
+	Input   string       `json:"input"`
+	Billing *TaskBilling `json:"billing,omitempty"`
+}
+
+// TaskBilling 按用量结算的任务在提交时记录的计费信息，任务完成时据此结算
+type TaskBilling struct {
+	TokenId         int     `json:"token_id"`
+	ModelName       string  `json:"model_name"`
+	Group           string  `json:"group"`
+	ModelRatio      float64 `json:"model_ratio"`
+	CompletionRatio float64 `json:"completion_ratio"`
+	GroupRatio      float64 `json:"group_ratio"`
+	ModelPrice      float64 `json:"model_price"`
+	UsePrice        bool    `json:"use_price"`
+}
+
+// Quota 按实际用量计算额度，按次计费的模型按成功的请求数计算
+func (b *TaskBilling) Quota(promptTokens int, completionTokens int, requestCount int) int {
+	if b.UsePrice {
+		return int(math.Round(b.ModelPrice * common.QuotaPerUnit * b.GroupRatio * float64(requestCount)))
+	}
+	return int(math.Round((float64(promptTokens) + float64(completionTokens)*b.CompletionRatio) * b.ModelRatio * b.GroupRatio))
 }
 
 func (m *Properties) Scan(val interface{}) error {
@@ -0,0 +1,25 @@
+package model
+
+import (
+	"one-api/common"
+	"testing"
+)
+
+func TestTaskBillingQuota(t *testing.T) {
+	tests := []struct {
+		name    string
+		billing TaskBilling
+		want    int
+	}{
+		{"ratio", TaskBilling{ModelRatio: 0.5, CompletionRatio: 4, GroupRatio: 1}, int((1000 + 200*4) * 0.5)},
+		{"group ratio", TaskBilling{ModelRatio: 0.5, CompletionRatio: 4, GroupRatio: 2}, int((1000 + 200*4) * 0.5 * 2)},
+		{"price per request", TaskBilling{UsePrice: true, ModelPrice: 0.01, GroupRatio: 1}, int(0.01 * common.QuotaPerUnit * 3)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.billing.Quota(1000, 200, 3); got != tt.want {
+				t.Errorf("Quota() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -238,6 +238,19 @@ func (token *Token) GetModelLimitsMap() map[string]bool {
 	return limitsMap
 }
 
+// IsModelAllowedByLimits 判断模型是否在令牌的模型限制内，支持以 * 结尾的通配前缀（如 claude-*）
+func IsModelAllowedByLimits(limits map[string]bool, modelName string) bool {
+	if limits[modelName] {
+		return true
+	}
+	for limit := range limits {
+		if prefix, ok := strings.CutSuffix(limit, "*"); ok && strings.HasPrefix(modelName, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 func DisableModelLimits(tokenId int) error {
 	token, err := GetTokenById(tokenId)
 	if err != nil {
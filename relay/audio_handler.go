@@ -106,6 +106,10 @@ func AudioHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 
 	resp, err := adaptor.DoRequest(c, relayInfo, ioReader)
 	if err != nil {
+		var apiErr *types.NewAPIError
+		if errors.As(err, &apiErr) {
+			return apiErr
+		}
 		return types.NewError(err, types.ErrorCodeDoRequestFailed)
 	}
 	statusCodeMappingStr := c.GetString("status_code_mapping")
@@ -6,12 +6,15 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	common2 "one-api/common"
+	"one-api/dto"
 	"one-api/relay/common"
 	"one-api/relay/constant"
 	"one-api/relay/helper"
 	"one-api/service"
 	"one-api/setting/operation_setting"
+	"strings"
 	"sync"
 	"time"
 
@@ -32,6 +35,95 @@ func SetupApiRequestHeader(info *common.RelayInfo, c *gin.Context, req *http.Hea
 			req.Set("Accept", "text/event-stream")
 		}
 	}
+	forwardTraceHeaders(c, req)
+}
+
+// forwardTraceHeaders 按配置将客户端传入的追踪请求头原样转发给上游，便于端到端关联
+func forwardTraceHeaders(c *gin.Context, req *http.Header) {
+	generalSetting := operation_setting.GetGeneralSetting()
+	if !generalSetting.ForwardTraceHeaders {
+		return
+	}
+	for _, header := range generalSetting.TraceHeaders {
+		if value := c.Request.Header.Get(header); value != "" {
+			req.Set(header, value)
+		}
+	}
+}
+
+// defaultStripHeaders 无论如何配置都不会转发给上游的客户端请求头
+var defaultStripHeaders = []string{"Authorization", "Cookie", "Host", "X-Api-Key", "Proxy-Authorization"}
+
+func getStripHeaders(setting dto.ChannelSettings) map[string]bool {
+	stripHeaders := make(map[string]bool, len(defaultStripHeaders)+len(setting.StripHeaders))
+	for _, header := range defaultStripHeaders {
+		stripHeaders[http.CanonicalHeaderKey(header)] = true
+	}
+	for _, header := range setting.StripHeaders {
+		stripHeaders[http.CanonicalHeaderKey(header)] = true
+	}
+	return stripHeaders
+}
+
+// ClientHeaderStripped 判断客户端请求头是否被默认或渠道设置的黑名单剔除
+func ClientHeaderStripped(setting dto.ChannelSettings, header string) bool {
+	return getStripHeaders(setting)[http.CanonicalHeaderKey(header)]
+}
+
+// ForwardClientHeaders 按渠道设置的白名单转发客户端请求头，并剔除黑名单中的请求头
+func ForwardClientHeaders(c *gin.Context, req *http.Header, setting dto.ChannelSettings) {
+	stripHeaders := getStripHeaders(setting)
+	for _, header := range setting.ForwardHeaders {
+		key := http.CanonicalHeaderKey(header)
+		if stripHeaders[key] {
+			continue
+		}
+		if values := c.Request.Header.Values(key); len(values) > 0 {
+			req.Del(key)
+			for _, value := range values {
+				req.Add(key, value)
+			}
+		}
+	}
+	for _, header := range setting.StripHeaders {
+		req.Del(header)
+	}
+}
+
+// SetUpstreamHeaders 设置渠道配置的静态请求头，vars 为占位符（不含花括号）到取值的映射
+func SetUpstreamHeaders(req *http.Header, setting dto.ChannelSettings, vars map[string]string) {
+	for key, value := range setting.UpstreamHeaders {
+		if dto.ProtectedUpstreamHeaders[http.CanonicalHeaderKey(key)] {
+			continue
+		}
+		for name, v := range vars {
+			value = strings.ReplaceAll(value, "{"+name+"}", v)
+		}
+		req.Set(key, value)
+	}
+}
+
+// UpstreamURLHeader 调试模式下返回实际请求的上游地址，用于确认区域与端点选择
+const UpstreamURLHeader = "X-Upstream-URL"
+
+// 上游地址中可能携带密钥的查询参数
+var secretQueryParams = []string{"key", "api_key", "apikey", "access_token", "token", "signature"}
+
+// redactRequestURL 去除上游地址中的用户信息与密钥参数
+func redactRequestURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	u.User = nil
+	query := u.Query()
+	for _, param := range secretQueryParams {
+		if query.Has(param) {
+			query.Set(param, "redacted")
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
 }
 
 func DoApiRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, requestBody io.Reader) (*http.Response, error) {
@@ -41,6 +133,7 @@ func DoApiRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, requestBody
 	}
 	if common2.DebugEnabled {
 		println("fullRequestURL:", fullRequestURL)
+		c.Header(UpstreamURLHeader, redactRequestURL(fullRequestURL))
 	}
 	req, err := http.NewRequest(c.Request.Method, fullRequestURL, requestBody)
 	if err != nil {
@@ -64,6 +157,7 @@ func DoFormRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, requestBod
 	}
 	if common2.DebugEnabled {
 		println("fullRequestURL:", fullRequestURL)
+		c.Header(UpstreamURLHeader, redactRequestURL(fullRequestURL))
 	}
 	req, err := http.NewRequest(c.Request.Method, fullRequestURL, requestBody)
 	if err != nil {
@@ -213,15 +307,9 @@ func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 		req.Method, req.URL.String(), 
 		func() string { if info.ChannelSetting.Proxy != "" { return "enabled" } else { return "none" } }()))
 	
-	var client *http.Client
-	var err error
-	if info.ChannelSetting.Proxy != "" {
-		client, err = service.NewProxyHttpClient(info.ChannelSetting.Proxy)
-		if err != nil {
-			return nil, fmt.Errorf("new proxy http client failed: %w", err)
-		}
-	} else {
-		client = service.GetHttpClient()
+	client, err := service.GetChannelHttpClient(info.ChannelId, info.ChannelSetting)
+	if err != nil {
+		return nil, fmt.Errorf("new proxy http client failed: %w", err)
 	}
 
 	var stopPinger context.CancelFunc
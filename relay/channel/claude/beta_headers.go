@@ -0,0 +1,21 @@
+package claude
+
+import (
+	"net/http"
+	"one-api/setting/model_setting"
+)
+
+// AnthropicBetaHeaders 模型在渠道上发送给上游的 beta 特性及模型配置注入的请求头
+type AnthropicBetaHeaders struct {
+	AnthropicBeta       []string            `json:"anthropic_beta"`        // 最终发送的 beta 特性
+	ClientBetaForwarded bool                `json:"client_beta_forwarded"` // 渠道是否转发客户端传入的 anthropic-beta
+	InjectedHeaders     map[string][]string `json:"injected_headers"`      // 模型配置注入的请求头，同名时覆盖客户端传入的值
+}
+
+// SetAnthropicBetaHeaders 转发客户端传入的 anthropic-beta，再写入模型配置的请求头
+func SetAnthropicBetaHeaders(req *http.Header, model string, clientBeta string) {
+	if clientBeta != "" {
+		req.Set("anthropic-beta", clientBeta)
+	}
+	model_setting.GetClaudeSettings().WriteHeaders(model, req)
+}
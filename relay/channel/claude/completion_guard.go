@@ -0,0 +1,86 @@
+package claude
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/relay/helper"
+	"one-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getCompletionTokenCap 流式输出的 token 上限，即最终发送给上游的 max_tokens
+func getCompletionTokenCap(info *relaycommon.RelayInfo) int {
+	if !model_setting.GetClaudeSettings().CompletionTokenGuardEnabled {
+		return 0
+	}
+	return info.MaxCompletionTokens
+}
+
+// completionCapExceeded 上游返回的累计输出 token（usage.output_tokens）超过上限时返回 true，由调用方结束流
+func completionCapExceeded(claudeInfo *ClaudeResponseInfo, claudeResponse *dto.ClaudeResponse) bool {
+	switch claudeResponse.Type {
+	case "content_block_start":
+		claudeInfo.ContentBlockOpen = true
+	case "content_block_stop":
+		claudeInfo.ContentBlockOpen = false
+	}
+	if claudeInfo.MaxCompletionTokens <= 0 || claudeResponse.Usage == nil || claudeResponse.Usage.OutputTokens <= claudeInfo.MaxCompletionTokens {
+		return false
+	}
+	claudeInfo.CompletionCapped = true
+	claudeInfo.StopReason = "max_tokens"
+	claudeInfo.Usage.CompletionTokens = claudeResponse.Usage.OutputTokens
+	return true
+}
+
+// finishCappedStream 提前结束流时补发结束事件，客户端按 max_tokens 截断处理
+// OpenAI 格式的用量与 [DONE] 由 HandleStreamFinalResponse 发送
+func finishCappedStream(c *gin.Context, info *relaycommon.RelayInfo, claudeInfo *ClaudeResponseInfo) {
+	var err error
+	switch info.RelayFormat {
+	case relaycommon.RelayFormatClaude:
+		if claudeInfo.ContentBlockOpen && len(claudeInfo.ContentBlocks) > 0 {
+			err = helper.ClaudeData(c, dto.ClaudeResponse{
+				Type:  "content_block_stop",
+				Index: common.GetPointer(len(claudeInfo.ContentBlocks) - 1),
+			})
+		}
+		if err == nil {
+			err = helper.ClaudeData(c, dto.ClaudeResponse{
+				Type:  "message_delta",
+				Delta: &dto.ClaudeMediaMessage{StopReason: common.GetPointer(claudeInfo.StopReason)},
+				Usage: &dto.ClaudeUsage{
+					InputTokens:              claudeInfo.Usage.PromptTokens,
+					CacheCreationInputTokens: claudeInfo.Usage.PromptTokensDetails.CachedCreationTokens,
+					CacheReadInputTokens:     claudeInfo.Usage.PromptTokensDetails.CachedTokens,
+					OutputTokens:             claudeInfo.MaxCompletionTokens,
+				},
+			})
+		}
+		if err == nil {
+			err = helper.ClaudeData(c, dto.ClaudeResponse{Type: "message_stop"})
+		}
+	case relaycommon.RelayFormatOpenAI:
+		err = helper.ObjectData(c, helper.GenerateStopResponse(claudeInfo.ResponseId, claudeInfo.Created, info.ResponseModel(info.UpstreamModelName), "length"))
+	}
+	if err != nil {
+		common.LogError(c, fmt.Sprintf("[CLAUDE] Send capped stream end failed | Error:%s", err.Error()))
+	}
+}
+
+// capCompletionUsage 输出 token 按上限结算，上游返回的用量超过上限时同样截断
+func capCompletionUsage(c *gin.Context, claudeInfo *ClaudeResponseInfo) {
+	limit := claudeInfo.MaxCompletionTokens
+	if limit <= 0 || (!claudeInfo.CompletionCapped && claudeInfo.Usage.CompletionTokens <= limit) {
+		return
+	}
+	if !claudeInfo.CompletionCapped {
+		common.LogWarn(c, fmt.Sprintf("[CLAUDE] Upstream completion tokens exceed max_tokens, billed at cap | MaxTokens:%d | Reported:%d",
+			limit, claudeInfo.Usage.CompletionTokens))
+	}
+	claudeInfo.Usage.CompletionTokens = limit
+	claudeInfo.Usage.TotalTokens = claudeInfo.Usage.PromptTokens + limit
+}
@@ -0,0 +1,93 @@
+package claude
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/constant"
+	relaycommon "one-api/relay/common"
+	"one-api/setting/model_setting"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// overrunStream 上游在流中途报告的输出用量已超过 max_tokens，之后仍继续输出
+var overrunStream = []string{
+	`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-20250514","content":[],"usage":{"input_tokens":3,"output_tokens":1}}}`,
+	`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+	`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"within cap"}}`,
+	`{"type":"message_delta","delta":{},"usage":{"output_tokens":50}}`,
+	`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"OVERRUN"}}`,
+	`{"type":"content_block_stop","index":0}`,
+	`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":80}}`,
+	`{"type":"message_stop"}`,
+}
+
+func runOverrunStream(t *testing.T, guardEnabled bool) (string, int) {
+	t.Helper()
+	constant.StreamingTimeout = 60
+	claudeSettings := model_setting.GetClaudeSettings()
+	original := claudeSettings.CompletionTokenGuardEnabled
+	claudeSettings.CompletionTokenGuardEnabled = guardEnabled
+	defer func() { claudeSettings.CompletionTokenGuardEnabled = original }()
+
+	var body strings.Builder
+	for _, event := range overrunStream {
+		body.WriteString("data: " + event + "\n\n")
+	}
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	info := &relaycommon.RelayInfo{
+		RelayFormat:         relaycommon.RelayFormatClaude,
+		IsStream:            true,
+		OriginModelName:     "claude-sonnet-4-20250514",
+		UpstreamModelName:   "claude-sonnet-4-20250514",
+		MaxCompletionTokens: 10,
+	}
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       io.NopCloser(strings.NewReader(body.String())),
+	}
+	apiErr, usage := ClaudeStreamHandler(c, resp, info, RequestModeMessage)
+	if apiErr != nil {
+		t.Fatalf("ClaudeStreamHandler: %v", apiErr)
+	}
+	return recorder.Body.String(), usage.CompletionTokens
+}
+
+func TestCompletionGuardStopsStreamOnUpstreamOverrun(t *testing.T) {
+	output, completionTokens := runOverrunStream(t, true)
+	if strings.Contains(output, "OVERRUN") {
+		t.Errorf("content after the cap was forwarded: %s", output)
+	}
+	if !strings.Contains(output, "within cap") {
+		t.Errorf("content before the cap is missing: %s", output)
+	}
+	if strings.Count(output, `"type":"content_block_stop"`) != 1 || strings.Count(output, `"type":"message_stop"`) != 1 {
+		t.Errorf("stream should end with exactly one content_block_stop and message_stop: %s", output)
+	}
+	if !strings.Contains(output, `"stop_reason":"max_tokens"`) || strings.Contains(output, `"output_tokens":50`) {
+		t.Errorf("capped stream should report max_tokens at the cap: %s", output)
+	}
+	if completionTokens != 10 {
+		t.Errorf("billed completion tokens = %d, want 10", completionTokens)
+	}
+}
+
+func TestCompletionGuardDisabledByDefault(t *testing.T) {
+	if model_setting.GetClaudeSettings().CompletionTokenGuardEnabled {
+		t.Fatal("completion token guard should be disabled by default")
+	}
+	output, completionTokens := runOverrunStream(t, false)
+	if !strings.Contains(output, "OVERRUN") {
+		t.Errorf("stream should pass through when the guard is disabled: %s", output)
+	}
+	if completionTokens != 80 {
+		t.Errorf("billed completion tokens = %d, want upstream usage 80", completionTokens)
+	}
+}
@@ -0,0 +1,79 @@
+package claude
+
+import (
+	"encoding/json"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+
+	"github.com/gin-gonic/gin"
+)
+
+// jsonModeToolName 模拟 JSON 模式时强制调用的工具名
+const jsonModeToolName = "json_response"
+
+// applyJsonMode Claude 没有原生 JSON 模式，response_format 为 json_object 时通过强制调用单个工具输出 JSON
+// 客户端自带工具或开启思考（不支持强制工具调用）时不做处理
+func applyJsonMode(c *gin.Context, textRequest dto.GeneralOpenAIRequest, claudeRequest *dto.ClaudeRequest) {
+	if textRequest.ResponseFormat == nil || textRequest.ResponseFormat.Type != "json_object" {
+		return
+	}
+	if len(textRequest.Tools) > 0 || textRequest.WebSearchOptions != nil || claudeRequest.Thinking != nil {
+		return
+	}
+	claudeRequest.Tools = []any{
+		&dto.Tool{
+			Name:        jsonModeToolName,
+			Description: "Respond with a JSON object.",
+			InputSchema: map[string]interface{}{"type": "object"},
+		},
+	}
+	claudeRequest.ToolChoice = &dto.ClaudeToolChoice{
+		Type: "tool",
+		Name: jsonModeToolName,
+	}
+	common.SetContextKey(c, constant.ContextKeyClaudeJsonMode, true)
+	common.LogInfo(c, "[CLAUDE] JSON mode emulated with forced tool call")
+}
+
+func isJsonMode(c *gin.Context) bool {
+	return common.GetContextKeyBool(c, constant.ContextKeyClaudeJsonMode)
+}
+
+// unwrapJsonModeStreamEvent 将 JSON 模式工具调用的流式事件改写为文本事件
+func unwrapJsonModeStreamEvent(claudeResponse *dto.ClaudeResponse) {
+	switch claudeResponse.Type {
+	case "content_block_start":
+		if block := claudeResponse.ContentBlock; block != nil && block.Type == "tool_use" && block.Name == jsonModeToolName {
+			textBlock := &dto.ClaudeMediaMessage{Type: "text"}
+			textBlock.SetText("")
+			claudeResponse.ContentBlock = textBlock
+		}
+	case "content_block_delta":
+		if delta := claudeResponse.Delta; delta != nil && delta.Type == "input_json_delta" && delta.PartialJson != nil {
+			delta.Type = "text_delta"
+			delta.SetText(*delta.PartialJson)
+			delta.PartialJson = nil
+		}
+	case "message_delta":
+		if delta := claudeResponse.Delta; delta != nil && delta.StopReason != nil && *delta.StopReason == "tool_use" {
+			delta.StopReason = common.GetPointer("end_turn")
+		}
+	}
+}
+
+// unwrapJsonModeResponse 将 JSON 模式工具调用的参数改写为文本内容
+func unwrapJsonModeResponse(claudeResponse *dto.ClaudeResponse) {
+	for i, block := range claudeResponse.Content {
+		if block.Type != "tool_use" || block.Name != jsonModeToolName {
+			continue
+		}
+		input, _ := json.Marshal(block.Input)
+		textBlock := dto.ClaudeMediaMessage{Type: "text"}
+		textBlock.SetText(string(input))
+		claudeResponse.Content[i] = textBlock
+	}
+	if claudeResponse.StopReason == "tool_use" {
+		claudeResponse.StopReason = "end_turn"
+	}
+}
@@ -0,0 +1,130 @@
+package claude
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/service"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 强制调用 JSON 模式工具时上游返回的响应
+const jsonModeToolUseResponse = `{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-20250514","content":[{"type":"tool_use","id":"toolu_1","name":"json_response","input":{"answer":42}}],"stop_reason":"tool_use","usage":{"input_tokens":12,"output_tokens":8}}`
+
+var jsonModeToolUseStream = []string{
+	`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-20250514","content":[],"usage":{"input_tokens":12,"output_tokens":1}}}`,
+	`{"type":"content_block_start","index":0,"content_block":{"type":"tool_use","id":"toolu_1","name":"json_response","input":{}}}`,
+	`{"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"{\"answer\":"}}`,
+	`{"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"42}"}}`,
+	`{"type":"content_block_stop","index":0}`,
+	`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":8}}`,
+	`{"type":"message_stop"}`,
+}
+
+// newJsonModeContext 转换 json_object 请求并返回已标记 JSON 模式的上下文
+func newJsonModeContext(t *testing.T, stream bool) (*gin.Context, *httptest.ResponseRecorder, *relaycommon.RelayInfo) {
+	t.Helper()
+	var request dto.GeneralOpenAIRequest
+	if err := common.UnmarshalJsonStr(`{"model":"claude-sonnet-4-20250514","response_format":{"type":"json_object"},"messages":[{"role":"user","content":"answer in json"}]}`, &request); err != nil {
+		t.Fatalf("unmarshal request: %v", err)
+	}
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	claudeRequest, err := RequestOpenAI2ClaudeMessage(c, request)
+	if err != nil {
+		t.Fatalf("RequestOpenAI2ClaudeMessage: %v", err)
+	}
+	body, _ := common.Marshal(claudeRequest)
+	if !strings.Contains(string(body), `"tool_choice":{"type":"tool","name":"json_response"}`) || !strings.Contains(string(body), `"name":"json_response"`) {
+		t.Fatalf("claude request %s does not force the json_response tool", body)
+	}
+	info := &relaycommon.RelayInfo{
+		RelayFormat:       relaycommon.RelayFormatOpenAI,
+		IsStream:          stream,
+		OriginModelName:   "claude-sonnet-4-20250514",
+		UpstreamModelName: "claude-sonnet-4-20250514",
+		StartTime:         time.Now(),
+	}
+	return c, recorder, info
+}
+
+func TestJsonModeRoundTripReturnsJsonContent(t *testing.T) {
+	c, recorder, info := newJsonModeContext(t, false)
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(jsonModeToolUseResponse)),
+	}
+	if apiErr, _ := ClaudeHandler(c, resp, RequestModeMessage, info); apiErr != nil {
+		t.Fatalf("ClaudeHandler: %v", apiErr)
+	}
+	var response dto.OpenAITextResponse
+	if err := common.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("unmarshal %s: %v", recorder.Body.String(), err)
+	}
+	choice := response.Choices[0]
+	if content := choice.Message.StringContent(); content != `{"answer":42}` {
+		t.Errorf("content = %q, want the tool input as JSON text", content)
+	}
+	if len(choice.Message.ParseToolCalls()) != 0 {
+		t.Errorf("tool calls must be unwrapped, got %s", recorder.Body.String())
+	}
+	if choice.FinishReason != constant.FinishReasonStop {
+		t.Errorf("finish_reason = %q, want stop", choice.FinishReason)
+	}
+}
+
+func TestJsonModeStreamReturnsJsonContent(t *testing.T) {
+	constant.StreamingTimeout = 60
+	service.InitTokenEncoders()
+	c, recorder, info := newJsonModeContext(t, true)
+	var body strings.Builder
+	for _, event := range jsonModeToolUseStream {
+		body.WriteString("data: " + event + "\n\n")
+	}
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       io.NopCloser(strings.NewReader(body.String())),
+	}
+	if apiErr, _ := ClaudeStreamHandler(c, resp, info, RequestModeMessage); apiErr != nil {
+		t.Fatalf("ClaudeStreamHandler: %v", apiErr)
+	}
+	var content strings.Builder
+	var finishReason string
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var response dto.ChatCompletionsStreamResponse
+		if err := common.UnmarshalJsonStr(data, &response); err != nil {
+			t.Fatalf("unmarshal %s: %v", data, err)
+		}
+		for _, choice := range response.Choices {
+			if len(choice.Delta.ToolCalls) > 0 {
+				t.Errorf("tool call delta must be unwrapped: %s", data)
+			}
+			content.WriteString(choice.Delta.GetContentString())
+			if choice.FinishReason != nil {
+				finishReason = *choice.FinishReason
+			}
+		}
+	}
+	if content.String() != `{"answer":42}` {
+		t.Errorf("streamed content = %q, want the tool input as JSON text", content.String())
+	}
+	if finishReason != constant.FinishReasonStop {
+		t.Errorf("finish_reason = %q, want stop", finishReason)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/http"
 	"one-api/common"
+	"one-api/constant"
 	"one-api/dto"
 	"one-api/relay/channel/openrouter"
 	relaycommon "one-api/relay/common"
@@ -18,6 +19,15 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// claudeSupportedImageMimeTypes Claude 支持的图片类型
+var claudeSupportedImageMimeTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/jpg":  true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
 const (
 	WebSearchMaxUsesLow    = 1
 	WebSearchMaxUsesMedium = 5
@@ -104,6 +114,11 @@ func RequestOpenAI2ClaudeMessage(c *gin.Context, textRequest dto.GeneralOpenAIRe
 		common.LogInfo(c, fmt.Sprintf("[CLAUDE] Tools conversion completed | Count:%d", len(claudeTools)))
 	}
 
+	// Claude 不支持 seed，直接忽略
+	if textRequest.Seed != nil && common.DebugEnabled {
+		common.LogInfo(c, fmt.Sprintf("[CLAUDE] Seed is not supported by Claude, ignored | Seed:%v", *textRequest.Seed))
+	}
+
 	// Web search tool
 	// https://docs.anthropic.com/en/docs/agents-and-tools/tool-use/web-search-tool
 	if textRequest.WebSearchOptions != nil {
@@ -191,14 +206,15 @@ func RequestOpenAI2ClaudeMessage(c *gin.Context, textRequest dto.GeneralOpenAIRe
 			claudeRequest.MaxTokens = 1280
 		}
 
-		// BudgetTokens 为 max_tokens 的 80%
+		// BudgetTokens 按模型配置的比例计算，默认为 max_tokens 的 80%
 		claudeRequest.Thinking = &dto.Thinking{
 			Type:         "enabled",
-			BudgetTokens: common.GetPointer[int](int(float64(claudeRequest.MaxTokens) * model_setting.GetClaudeSettings().ThinkingAdapterBudgetTokensPercentage)),
+			BudgetTokens: common.GetPointer[int](model_setting.GetClaudeSettings().GetThinkingBudgetTokens(strings.TrimSuffix(textRequest.Model, "-thinking"), int(claudeRequest.MaxTokens))),
 		}
 		// TODO: 临时处理
 		// https://docs.anthropic.com/en/docs/build-with-claude/extended-thinking#important-considerations-when-using-extended-thinking
 		claudeRequest.TopP = 0
+		claudeRequest.TopK = 0
 		claudeRequest.Temperature = common.GetPointer[float64](1.0)
 		claudeRequest.Model = strings.TrimSuffix(textRequest.Model, "-thinking")
 		common.LogInfo(c, fmt.Sprintf("[CLAUDE] Thinking mode configured | BudgetTokens:%d | Model:%s",
@@ -207,24 +223,22 @@ func RequestOpenAI2ClaudeMessage(c *gin.Context, textRequest dto.GeneralOpenAIRe
 
 	if textRequest.ReasoningEffort != "" {
 		common.LogInfo(c, fmt.Sprintf("[CLAUDE] ReasoningEffort detected | Level:%s", textRequest.ReasoningEffort))
-		switch textRequest.ReasoningEffort {
-		case "low":
-			claudeRequest.Thinking = &dto.Thinking{
-				Type:         "enabled",
-				BudgetTokens: common.GetPointer[int](1280),
+		budgetTokens, ok := model_setting.GetClaudeSettings().GetReasoningEffortBudgetTokens(strings.TrimSuffix(textRequest.Model, "-thinking"), textRequest.ReasoningEffort)
+		if ok {
+			// 思考预算必须小于 max_tokens，不足最小预算时与 -thinking 适配一样提高 max_tokens
+			budgetTokens = min(budgetTokens, int(claudeRequest.MaxTokens)-1)
+			if budgetTokens < 1024 {
+				budgetTokens = 1024
+				claudeRequest.MaxTokens = max(claudeRequest.MaxTokens, 1280)
 			}
-		case "medium":
 			claudeRequest.Thinking = &dto.Thinking{
 				Type:         "enabled",
-				BudgetTokens: common.GetPointer[int](2048),
-			}
-		case "high":
-			claudeRequest.Thinking = &dto.Thinking{
-				Type:         "enabled",
-				BudgetTokens: common.GetPointer[int](4096),
+				BudgetTokens: common.GetPointer[int](budgetTokens),
 			}
+			common.LogInfo(c, fmt.Sprintf("[CLAUDE] ReasoningEffort configured | BudgetTokens:%d", budgetTokens))
+		} else {
+			common.LogWarn(c, fmt.Sprintf("[CLAUDE] Unknown reasoning effort ignored | Level:%s", textRequest.ReasoningEffort))
 		}
-		common.LogInfo(c, fmt.Sprintf("[CLAUDE] ReasoningEffort configured | BudgetTokens:%d", *claudeRequest.Thinking.BudgetTokens))
 	}
 
 	// 指定了 reasoning 参数,覆盖 budgetTokens
@@ -292,6 +306,13 @@ func RequestOpenAI2ClaudeMessage(c *gin.Context, textRequest dto.GeneralOpenAIRe
 
 	claudeMessages := make([]dto.ClaudeMessage, 0)
 	isFirstMessage := true
+	// 整个请求内图片的序号，用于在错误信息中定位有问题的附件
+	attachmentIndex := 0
+	// 图片地址提前并发下载，转换时按地址取用
+	imageFiles, err := service.GetFilesBase64FromUrls(service.CollectMessageImageUrls(formatMessages))
+	if err != nil {
+		return nil, err
+	}
 	for _, message := range formatMessages {
 		if message.Role == "system" {
 			if message.IsStringContent() {
@@ -366,23 +387,30 @@ func RequestOpenAI2ClaudeMessage(c *gin.Context, textRequest dto.GeneralOpenAIRe
 						claudeMediaMessage.Text = common.GetPointer[string](mediaMessage.Text)
 					} else {
 						imageUrl := mediaMessage.GetImageMedia()
+						attachmentIndex++
 						claudeMediaMessage.Type = "image"
 						claudeMediaMessage.Source = &dto.ClaudeMessageSource{
 							Type: "base64",
 						}
 						// 判断是否是url
 						if strings.HasPrefix(imageUrl.Url, "http") {
-							// 是url，获取图片的类型和base64编码的数据
-							fileData, err := service.GetFileBase64FromUrl(imageUrl.Url)
-							if err != nil {
-								return nil, fmt.Errorf("get file base64 from url failed: %s", err.Error())
-							}
+							// 是url，使用已下载的图片类型和base64编码的数据
+							fileData := imageFiles[imageUrl.Url]
 							claudeMediaMessage.Source.MediaType = fileData.MimeType
 							claudeMediaMessage.Source.Data = fileData.Base64Data
 						} else {
+							if strings.HasPrefix(imageUrl.Url, "data:") {
+								mimeType, _, err := service.ParseBase64DataURI(imageUrl.Url)
+								if err != nil {
+									return nil, types.NewErrorWithStatusCode(fmt.Errorf("invalid image_url at attachment index %d: %s", attachmentIndex, err.Error()), types.ErrorCodeInvalidRequest, http.StatusBadRequest)
+								}
+								if !claudeSupportedImageMimeTypes[mimeType] {
+									return nil, types.NewErrorWithStatusCode(fmt.Errorf("invalid image_url at attachment index %d: mime type '%s' is not supported by Claude, supported types are: image/jpeg, image/png, image/gif, image/webp", attachmentIndex, mimeType), types.ErrorCodeInvalidRequest, http.StatusBadRequest)
+								}
+							}
 							_, format, base64String, err := service.DecodeBase64ImageData(imageUrl.Url)
 							if err != nil {
-								return nil, err
+								return nil, types.NewErrorWithStatusCode(fmt.Errorf("invalid image_url at attachment index %d: %s", attachmentIndex, err.Error()), types.ErrorCodeInvalidRequest, http.StatusBadRequest)
 							}
 							claudeMediaMessage.Source.MediaType = "image/" + format
 							claudeMediaMessage.Source.Data = base64String
@@ -425,6 +453,8 @@ func RequestOpenAI2ClaudeMessage(c *gin.Context, textRequest dto.GeneralOpenAIRe
 		}(),
 		claudeRequest.Thinking != nil))
 
+	applyJsonMode(c, textRequest, &claudeRequest)
+
 	return &claudeRequest, nil
 }
 
@@ -498,6 +528,10 @@ func StreamResponseClaude2OpenAI(reqMode int, claudeResponse *dto.ClaudeResponse
 			if finishReason != "null" {
 				choice.FinishReason = &finishReason
 			}
+			// 停止序列随最后的 message_delta 返回
+			if model_setting.GetClaudeSettings().ReturnStopSequence {
+				choice.StopSequence = claudeResponse.Delta.StopSequence
+			}
 			//claudeUsage = &claudeResponse.Usage
 		} else if claudeResponse.Type == "message_stop" {
 			return nil
@@ -570,6 +604,9 @@ func ResponseClaude2OpenAI(reqMode int, claudeResponse *dto.ClaudeResponse) *dto
 		},
 		FinishReason: stopReasonClaude2OpenAI(claudeResponse.StopReason),
 	}
+	if model_setting.GetClaudeSettings().ReturnStopSequence {
+		choice.StopSequence = claudeResponse.StopSequence
+	}
 	choice.SetStringContent(responseText)
 	if len(responseThinking) > 0 {
 		choice.ReasoningContent = responseThinking
@@ -598,7 +635,37 @@ type ClaudeResponseInfo struct {
 	Role         string
 	ContentBlocks []dto.ClaudeMediaMessage
 	StopReason   string
+	StopSequence *string
 	CompleteUsage *dto.ClaudeUsage
+
+	// 客户端通过请求头关闭思考过程的流式输出
+	StripThinking bool
+
+	// 流式推送累计用量的间隔，以及已估算和已推送的输出 token
+	UsageInterval             int
+	EstimatedCompletionTokens int
+	ReportedCompletionTokens  int
+
+	// 输出 token 上限，上游返回的输出用量超出后提前结束流并按上限结算，0 表示不限制
+	MaxCompletionTokens int
+	CompletionCapped    bool
+	// 是否有已开始但尚未结束的内容块，提前结束流时需要补发 content_block_stop
+	ContentBlockOpen bool
+
+	// 渠道配置的响应内容过滤器，以及流式响应中各内容块尚未输出的文本
+	ResponseFilter        *service.ResponseFilter
+	ResponseFilterStreams map[int]*service.ResponseFilterStream
+}
+
+// ClaudeStripThinkingHeader 客户端设置为 true 时，不再向其转发 thinking_delta 与 signature_delta
+const ClaudeStripThinkingHeader = "X-Strip-Thinking"
+
+// isThinkingDelta 判断事件是否为思考过程的增量数据
+func isThinkingDelta(claudeResponse *dto.ClaudeResponse) bool {
+	if claudeResponse.Type != "content_block_delta" || claudeResponse.Delta == nil {
+		return false
+	}
+	return claudeResponse.Delta.Type == "thinking_delta" || claudeResponse.Delta.Type == "signature_delta"
 }
 
 // updateCompleteResponseData 更新完整响应数据，用于重组流式响应
@@ -643,6 +710,10 @@ func updateCompleteResponseData(claudeInfo *ClaudeResponseInfo, claudeResponse *
 						newText := currentText + *claudeResponse.Delta.Text
 						claudeInfo.ContentBlocks[index].SetText(newText)
 					}
+				case "thinking_delta":
+					claudeInfo.ContentBlocks[index].Thinking += claudeResponse.Delta.Thinking
+				case "signature_delta":
+					claudeInfo.ContentBlocks[index].Signature += claudeResponse.Delta.Signature
 				case "input_json_delta":
 					if claudeResponse.Delta.PartialJson != nil {
 						// 对于tool_use类型的内容块，累积JSON数据
@@ -672,10 +743,11 @@ func updateCompleteResponseData(claudeInfo *ClaudeResponseInfo, claudeResponse *
 			if claudeResponse.Delta.StopReason != nil {
 				claudeInfo.StopReason = *claudeResponse.Delta.StopReason
 			}
+			claudeInfo.StopSequence = claudeResponse.Delta.StopSequence
 		}
-		// 更新最终的usage信息
+		// 更新最终的usage信息，message_delta 中未返回的输入用量保留 message_start 中的值
 		if claudeResponse.Usage != nil {
-			claudeInfo.CompleteUsage = claudeResponse.Usage
+			claudeInfo.CompleteUsage = mergeClaudeUsage(claudeInfo.CompleteUsage, claudeResponse.Usage)
 		}
 	case "message_stop":
 		claudeInfo.Done = true
@@ -684,16 +756,38 @@ func updateCompleteResponseData(claudeInfo *ClaudeResponseInfo, claudeResponse *
 	}
 }
 
+func mergeClaudeUsage(base *dto.ClaudeUsage, delta *dto.ClaudeUsage) *dto.ClaudeUsage {
+	if base == nil {
+		return delta
+	}
+	merged := *base
+	merged.OutputTokens = delta.OutputTokens
+	if delta.InputTokens > 0 {
+		merged.InputTokens = delta.InputTokens
+	}
+	if delta.CacheCreationInputTokens > 0 {
+		merged.CacheCreationInputTokens = delta.CacheCreationInputTokens
+	}
+	if delta.CacheReadInputTokens > 0 {
+		merged.CacheReadInputTokens = delta.CacheReadInputTokens
+	}
+	if delta.ServerToolUse != nil {
+		merged.ServerToolUse = delta.ServerToolUse
+	}
+	return &merged
+}
+
 // buildCompleteResponse 构建完整的Claude响应对象
 func buildCompleteResponse(claudeInfo *ClaudeResponseInfo) *dto.ClaudeResponse {
 	response := &dto.ClaudeResponse{
-		Id:         claudeInfo.MessageId,
-		Type:       "message",
-		Role:       claudeInfo.Role,
-		Model:      claudeInfo.Model,
-		Content:    claudeInfo.ContentBlocks,
-		StopReason: claudeInfo.StopReason,
-		Usage:      claudeInfo.CompleteUsage,
+		Id:           claudeInfo.MessageId,
+		Type:         "message",
+		Role:         claudeInfo.Role,
+		Model:        claudeInfo.Model,
+		Content:      claudeInfo.ContentBlocks,
+		StopReason:   claudeInfo.StopReason,
+		StopSequence: claudeInfo.StopSequence,
+		Usage:        claudeInfo.CompleteUsage,
 	}
 	return response
 }
@@ -769,10 +863,33 @@ func HandleStreamResponseData(c *gin.Context, info *relaycommon.RelayInfo, claud
 			claudeResponse.Error.Type, claudeResponse.Error.Message))
 		return types.WithClaudeError(*claudeResponse.Error, http.StatusInternalServerError)
 	}
+	if isJsonMode(c) {
+		unwrapJsonModeStreamEvent(&claudeResponse)
+	}
 	
 	// [CLAUDE] 重组完整响应数据
 	updateCompleteResponseData(claudeInfo, &claudeResponse)
-	
+	// 超出输出上限的事件不再转发，由 ClaudeStreamHandler 补发结束事件
+	if completionCapExceeded(claudeInfo, &claudeResponse) {
+		return nil
+	}
+	estimateStreamCompletionTokens(info, claudeInfo, &claudeResponse)
+	// 用量在本次内容转发之后推送
+	defer reportStreamUsage(c, info, claudeInfo, &claudeResponse)
+
+	// 思考增量仍计入用量统计，但不再转发给客户端
+	stripThinking := claudeInfo.StripThinking && isThinkingDelta(&claudeResponse)
+	filtered := false
+	if claudeInfo.ResponseFilter != nil && requestMode != RequestModeCompletion {
+		skip, changed, filterErr := filterClaudeStreamEvent(c, info, claudeInfo, &claudeResponse, requestMode)
+		if filterErr != nil {
+			return filterErr
+		}
+		if skip {
+			return nil
+		}
+		filtered = changed
+	}
 	if info.RelayFormat == relaycommon.RelayFormatClaude {
 		FormatClaudeResponseInfo(requestMode, &claudeResponse, nil, claudeInfo)
 
@@ -781,10 +898,20 @@ func HandleStreamResponseData(c *gin.Context, info *relaycommon.RelayInfo, claud
 			if claudeResponse.Type == "message_start" {
 				// message_start, 获取usage
 				info.UpstreamModelName = claudeResponse.Message.Model
+				if model := info.ResponseModel(claudeResponse.Message.Model); model != claudeResponse.Message.Model {
+					claudeResponse.Message.Model = model
+					filtered = true
+				}
 			} else if claudeResponse.Type == "content_block_delta" {
 			} else if claudeResponse.Type == "message_delta" {
 			}
 		}
+		if stripThinking {
+			return nil
+		}
+		if filtered {
+			return sendClaudeEvent(c, claudeResponse)
+		}
 		helper.ClaudeChunkData(c, claudeResponse, data)
 	} else if info.RelayFormat == relaycommon.RelayFormatOpenAI {
 		response := StreamResponseClaude2OpenAI(requestMode, &claudeResponse)
@@ -792,6 +919,10 @@ func HandleStreamResponseData(c *gin.Context, info *relaycommon.RelayInfo, claud
 		if !FormatClaudeResponseInfo(requestMode, &claudeResponse, response, claudeInfo) {
 			return nil
 		}
+		response.Model = info.ResponseModel(response.Model)
+		if stripThinking {
+			return nil
+		}
 
 		err = helper.ObjectData(c, response)
 		if err != nil {
@@ -801,6 +932,32 @@ func HandleStreamResponseData(c *gin.Context, info *relaycommon.RelayInfo, claud
 	return nil
 }
 
+// markRefusal 记录被上游安全策略拒绝的响应，计费时按配置退还
+func markRefusal(c *gin.Context, stopReason string) {
+	if stopReason != "refusal" {
+		return
+	}
+	common.SetContextKey(c, constant.ContextKeyResponseBlocked, true)
+	common.LogWarn(c, "[CLAUDE] Response refused by upstream safety policy")
+}
+
+// markToolUse 记录以工具调用结束的响应，此类响应即使没有文本输出也按正常响应计费
+func markToolUse(c *gin.Context, stopReason string) {
+	if stopReason == "tool_use" {
+		common.SetContextKey(c, constant.ContextKeyResponseToolUse, true)
+	}
+}
+
+// markMaxTokensTruncated 记录因达到 max_tokens 而被截断的响应，便于按模型统计截断率
+func markMaxTokensTruncated(c *gin.Context, info *relaycommon.RelayInfo, stopReason string) {
+	if stopReason != "max_tokens" || !model_setting.GetClaudeSettings().MaxTokensTruncationLogEnabled {
+		return
+	}
+	common.SetContextKey(c, constant.ContextKeyClaudeMaxTokensTruncated, true)
+	common.LogWarn(c, fmt.Sprintf("[CLAUDE] Response truncated by max_tokens | Model:%s | OriginModel:%s",
+		info.UpstreamModelName, info.OriginModelName))
+}
+
 func HandleStreamFinalResponse(c *gin.Context, info *relaycommon.RelayInfo, claudeInfo *ClaudeResponseInfo, requestMode int) {
 
 	if requestMode == RequestModeCompletion {
@@ -809,7 +966,9 @@ func HandleStreamFinalResponse(c *gin.Context, info *relaycommon.RelayInfo, clau
 		if claudeInfo.Usage.PromptTokens == 0 {
 			//上游出错
 		}
-		if claudeInfo.Usage.CompletionTokens == 0 || !claudeInfo.Done {
+		if claudeInfo.CompletionCapped {
+			// 超出 max_tokens 被提前结束时上游未返回最终用量，输出由 capCompletionUsage 按上限结算
+		} else if claudeInfo.Usage.CompletionTokens == 0 || !claudeInfo.Done {
 			// [CLAUDE] 检测到上游响应不完整的错误
 			common.LogWarn(c, fmt.Sprintf("[CLAUDE] Incomplete upstream response detected | CompletionTokens:%d | Done:%v | ResponseText:%s",
 				claudeInfo.Usage.CompletionTokens, claudeInfo.Done,
@@ -822,14 +981,19 @@ func HandleStreamFinalResponse(c *gin.Context, info *relaycommon.RelayInfo, clau
 				}()))
 			claudeInfo.Usage = service.ResponseText2Usage(claudeInfo.ResponseText.String(), info.UpstreamModelName, claudeInfo.Usage.PromptTokens)
 		}
+		capCompletionUsage(c, claudeInfo)
 	}
 
 	if info.RelayFormat == relaycommon.RelayFormatClaude {
 		//
 	} else if info.RelayFormat == relaycommon.RelayFormatOpenAI {
 
-		if info.ShouldIncludeUsage {
-			response := helper.GenerateFinalUsageResponse(claudeInfo.ResponseId, claudeInfo.Created, info.UpstreamModelName, *claudeInfo.Usage)
+		if info.ShouldIncludeUsage || claudeInfo.UsageInterval > 0 {
+			usage := *claudeInfo.Usage
+			if info.IncludeUpstreamUsage && claudeInfo.CompleteUsage != nil {
+				usage.UpstreamUsage = claudeInfo.CompleteUsage
+			}
+			response := helper.GenerateFinalUsageResponse(claudeInfo.ResponseId, claudeInfo.Created, info.ResponseModel(info.UpstreamModelName), usage)
 			err := helper.ObjectData(c, response)
 			if err != nil {
 				common.SysError("send final response failed: " + err.Error())
@@ -851,19 +1015,46 @@ func ClaudeStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.
 		ResponseText: strings.Builder{},
 		RawResponse:  strings.Builder{},
 		Usage:        &dto.Usage{},
+
+		StripThinking: strings.EqualFold(c.GetHeader(ClaudeStripThinkingHeader), "true"),
+		UsageInterval: getStreamUsageInterval(c),
+
+		MaxCompletionTokens: getCompletionTokenCap(info),
+
+		ResponseFilterStreams: map[int]*service.ResponseFilterStream{},
 	}
 	var err *types.NewAPIError
+	if claudeInfo.ResponseFilter, err = newClaudeResponseFilter(info); err != nil {
+		return err, nil
+	}
 	var chunkCount int
 	helper.StreamScannerHandler(c, resp, info, func(data string) bool {
+		// 客户端已断开，后续内容无法送达，不再处理也不计费
+		if c.Request.Context().Err() != nil {
+			return false
+		}
 		chunkCount++
 		err = HandleStreamResponseData(c, info, claudeInfo, data, requestMode)
 		if err != nil {
 			common.LogError(c, fmt.Sprintf("[CLAUDE] Stream chunk processing failed | ChunkNum:%d | Error:%s", chunkCount, err.Error()))
 			return false
 		}
+		if claudeInfo.CompletionCapped {
+			common.LogWarn(c, fmt.Sprintf("[CLAUDE] Completion token cap exceeded, stream stopped | MaxTokens:%d | Reported:%d | ChunkNum:%d",
+				claudeInfo.MaxCompletionTokens, claudeInfo.Usage.CompletionTokens, chunkCount))
+			finishCappedStream(c, info, claudeInfo)
+			return false
+		}
 		return true
 	})
 	if err != nil {
+		// 出错前已向客户端输出内容时，按配置补发错误事件，并按已输出的内容结算
+		if chunkCount > 1 && model_setting.GetGlobalSettings().StreamPartialContentOnError {
+			common.LogWarn(c, fmt.Sprintf("[CLAUDE] Stream failed midway, keep partial content | ChunkNum:%d | Error:%s", chunkCount, err.Error()))
+			helper.StreamErrorData(c, info, err)
+			HandleStreamFinalResponse(c, info, claudeInfo, requestMode)
+			return nil, claudeInfo.Usage
+		}
 		return err, nil
 	}
 
@@ -892,6 +1083,14 @@ func ClaudeStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.
 		common.LogWarn(c, "[CLAUDE] Stream not completed, cannot reconstruct complete response")
 	}
 
+	if common.GetContextKeyBool(c, constant.ContextKeyClientDisconnected) {
+		// 未收到完整响应时 HandleStreamFinalResponse 会按已送达的内容重新计算用量
+		common.LogWarn(c, fmt.Sprintf("[CLAUDE] Client disconnected, stream cancelled early | DeliveredChunks:%d | Done:%v",
+			chunkCount, claudeInfo.Done))
+	}
+	markMaxTokensTruncated(c, info, claudeInfo.StopReason)
+	markRefusal(c, claudeInfo.StopReason)
+	markToolUse(c, claudeInfo.StopReason)
 	HandleStreamFinalResponse(c, info, claudeInfo, requestMode)
 	return nil, claudeInfo.Usage
 }
@@ -910,6 +1109,16 @@ func HandleClaudeResponseData(c *gin.Context, info *relaycommon.RelayInfo, claud
 			claudeResponse.Error.Type, claudeResponse.Error.Message))
 		return types.WithClaudeError(*claudeResponse.Error, http.StatusInternalServerError)
 	}
+	if isJsonMode(c) {
+		unwrapJsonModeResponse(&claudeResponse)
+	}
+	filtered := false
+	if claudeInfo.ResponseFilter != nil {
+		var filterErr *types.NewAPIError
+		if filtered, filterErr = filterClaudeResponse(c, claudeInfo.ResponseFilter, &claudeResponse); filterErr != nil {
+			return filterErr
+		}
+	}
 	if requestMode == RequestModeCompletion {
 		completionTokens := service.CountTextToken(claudeResponse.Completion, info.OriginModelName)
 		claudeInfo.Usage.PromptTokens = info.PromptTokens
@@ -926,15 +1135,32 @@ func HandleClaudeResponseData(c *gin.Context, info *relaycommon.RelayInfo, claud
 	switch info.RelayFormat {
 	case relaycommon.RelayFormatOpenAI:
 		openaiResponse := ResponseClaude2OpenAI(requestMode, &claudeResponse)
+		openaiResponse.Model = info.ResponseModel(openaiResponse.Model)
 		openaiResponse.Usage = *claudeInfo.Usage
+		if info.IncludeUpstreamUsage && claudeResponse.Usage != nil {
+			openaiResponse.Usage.UpstreamUsage = claudeResponse.Usage
+		}
 		responseData, err = json.Marshal(openaiResponse)
 		if err != nil {
 			return types.NewError(err, types.ErrorCodeBadResponseBody)
 		}
 	case relaycommon.RelayFormatClaude:
 		responseData = data
+		if model := info.ResponseModel(claudeResponse.Model); model != claudeResponse.Model {
+			claudeResponse.Model = model
+			filtered = true
+		}
+		if filtered {
+			if responseData, err = json.Marshal(claudeResponse); err != nil {
+				return types.NewError(err, types.ErrorCodeBadResponseBody)
+			}
+		}
 	}
 
+	markMaxTokensTruncated(c, info, claudeResponse.StopReason)
+	markRefusal(c, claudeResponse.StopReason)
+	markToolUse(c, claudeResponse.StopReason)
+
 	if claudeResponse.Usage.ServerToolUse != nil && claudeResponse.Usage.ServerToolUse.WebSearchRequests > 0 {
 		c.Set("claude_web_search_requests", claudeResponse.Usage.ServerToolUse.WebSearchRequests)
 	}
@@ -958,6 +1184,11 @@ func ClaudeHandler(c *gin.Context, resp *http.Response, requestMode int, info *r
 		RawResponse:  strings.Builder{},
 		Usage:        &dto.Usage{},
 	}
+	filter, filterErr := newClaudeResponseFilter(info)
+	if filterErr != nil {
+		return filterErr, nil
+	}
+	claudeInfo.ResponseFilter = filter
 	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
 		common.LogError(c, fmt.Sprintf("[CLAUDE] Failed to read response body | Error:%s", err.Error()))
@@ -0,0 +1,695 @@
+package claude
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/service"
+	"one-api/setting/model_setting"
+	"one-api/types"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxTokensTruncatedResponse 因达到 max_tokens 而被截断的响应
+const maxTokensTruncatedResponse = `{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-20250514","content":[{"type":"text","text":"The answer is"}],"stop_reason":"max_tokens","usage":{"input_tokens":12,"output_tokens":16}}`
+
+var maxTokensTruncatedStream = []string{
+	`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-20250514","content":[],"usage":{"input_tokens":12,"output_tokens":1}}}`,
+	`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+	`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"The answer is"}}`,
+	`{"type":"content_block_stop","index":0}`,
+	`{"type":"message_delta","delta":{"stop_reason":"max_tokens"},"usage":{"output_tokens":16}}`,
+	`{"type":"message_stop"}`,
+}
+
+func TestClaudeHandlerMarksMaxTokensTruncation(t *testing.T) {
+	constant.StreamingTimeout = 60
+	claudeSettings := model_setting.GetClaudeSettings()
+	original := claudeSettings.MaxTokensTruncationLogEnabled
+	defer func() { claudeSettings.MaxTokensTruncationLogEnabled = original }()
+
+	for _, enabled := range []bool{true, false} {
+		for _, stream := range []bool{false, true} {
+			name := "non-stream"
+			if stream {
+				name = "stream"
+			}
+			if !enabled {
+				name += " disabled"
+			}
+			t.Run(name, func(t *testing.T) {
+				claudeSettings.MaxTokensTruncationLogEnabled = enabled
+				gin.SetMode(gin.TestMode)
+				c, _ := gin.CreateTestContext(httptest.NewRecorder())
+				c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+				info := &relaycommon.RelayInfo{
+					RelayFormat:       relaycommon.RelayFormatClaude,
+					IsStream:          stream,
+					OriginModelName:   "claude-sonnet-4-20250514",
+					UpstreamModelName: "claude-sonnet-4-20250514",
+					StartTime:         time.Now(),
+				}
+				body, contentType := maxTokensTruncatedResponse, "application/json"
+				if stream {
+					var sb strings.Builder
+					for _, event := range maxTokensTruncatedStream {
+						sb.WriteString("data: " + event + "\n\n")
+					}
+					body, contentType = sb.String(), "text/event-stream"
+				}
+				resp := &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{"Content-Type": []string{contentType}},
+					Body:       io.NopCloser(strings.NewReader(body)),
+				}
+				var apiErr *types.NewAPIError
+				if stream {
+					apiErr, _ = ClaudeStreamHandler(c, resp, info, RequestModeMessage)
+				} else {
+					apiErr, _ = ClaudeHandler(c, resp, RequestModeMessage, info)
+				}
+				if apiErr != nil {
+					t.Fatalf("handler: %v", apiErr)
+				}
+				if got := common.GetContextKeyBool(c, constant.ContextKeyClaudeMaxTokensTruncated); got != enabled {
+					t.Errorf("truncated mark = %v, want %v", got, enabled)
+				}
+				other := service.GenerateClaudeOtherInfo(c, info, 1, 1, 1, 0, 0, 0, 0, 0, -1)
+				if _, ok := other["stop_reason"]; ok != enabled {
+					t.Errorf("log other info = %v, want stop_reason annotated: %v", other, enabled)
+				}
+			})
+		}
+	}
+}
+
+var thinkingStream = []string{
+	`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-20250514","content":[],"usage":{"input_tokens":12,"output_tokens":1}}}`,
+	`{"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":""}}`,
+	`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"Let me think"}}`,
+	`{"type":"content_block_delta","index":0,"delta":{"type":"signature_delta","signature":"sig-abc"}}`,
+	`{"type":"content_block_stop","index":0}`,
+	`{"type":"content_block_start","index":1,"content_block":{"type":"text","text":""}}`,
+	`{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"The answer"}}`,
+	`{"type":"content_block_stop","index":1}`,
+	`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":20}}`,
+	`{"type":"message_stop"}`,
+}
+
+func TestClaudeStreamHandlerThinkingDeltas(t *testing.T) {
+	constant.StreamingTimeout = 60
+	tests := []struct {
+		name         string
+		stripHeader  string
+		wantThinking bool
+	}{
+		{"forwarded", "", true},
+		{"stripped", "true", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body strings.Builder
+			for _, event := range thinkingStream {
+				body.WriteString("data: " + event + "\n\n")
+			}
+			gin.SetMode(gin.TestMode)
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+			if tt.stripHeader != "" {
+				c.Request.Header.Set(ClaudeStripThinkingHeader, tt.stripHeader)
+			}
+			info := &relaycommon.RelayInfo{
+				RelayFormat:       relaycommon.RelayFormatClaude,
+				IsStream:          true,
+				OriginModelName:   "claude-sonnet-4-20250514",
+				UpstreamModelName: "claude-sonnet-4-20250514",
+				StartTime:         time.Now(),
+			}
+			resp := &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+				Body:       io.NopCloser(strings.NewReader(body.String())),
+			}
+			apiErr, usage := ClaudeStreamHandler(c, resp, info, RequestModeMessage)
+			if apiErr != nil {
+				t.Fatalf("ClaudeStreamHandler: %v", apiErr)
+			}
+			output := recorder.Body.String()
+			for _, deltaType := range []string{"thinking_delta", "signature_delta"} {
+				if got := strings.Contains(output, `"type":"`+deltaType+`"`); got != tt.wantThinking {
+					t.Errorf("%s forwarded = %v, want %v\n%s", deltaType, got, tt.wantThinking, output)
+				}
+			}
+			if !strings.Contains(output, `"text":"The answer"`) {
+				t.Errorf("text delta missing from stream:\n%s", output)
+			}
+			// 思考增量被剔除时仍计入输出用量
+			if usage.CompletionTokens != 20 {
+				t.Errorf("completion tokens = %d, want 20", usage.CompletionTokens)
+			}
+		})
+	}
+}
+
+// disconnectingRecorder 在写出包含 marker 的数据后模拟客户端断开连接
+type disconnectingRecorder struct {
+	*httptest.ResponseRecorder
+	marker string
+	cancel context.CancelFunc
+}
+
+func (r *disconnectingRecorder) Write(data []byte) (int, error) {
+	n, err := r.ResponseRecorder.Write(data)
+	if strings.Contains(string(data), r.marker) {
+		r.cancel()
+	}
+	return n, err
+}
+
+func TestClaudeStreamHandlerStopsWhenClientDisconnects(t *testing.T) {
+	constant.StreamingTimeout = 60
+	service.InitTokenEncoders()
+	events := []string{
+		`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-20250514","content":[],"usage":{"input_tokens":12,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"delivered"}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"` + strings.Repeat("undelivered ", 200) + `"}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":500}}`,
+		`{"type":"message_stop"}`,
+	}
+	upstream, upstreamWriter := io.Pipe()
+	writeErr := make(chan error, 1)
+	go func() {
+		for _, event := range events {
+			if _, err := upstreamWriter.Write([]byte("data: " + event + "\n\n")); err != nil {
+				writeErr <- err
+				return
+			}
+		}
+		writeErr <- upstreamWriter.Close()
+	}()
+
+	gin.SetMode(gin.TestMode)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	recorder := &disconnectingRecorder{ResponseRecorder: httptest.NewRecorder(), marker: "delivered", cancel: cancel}
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil).WithContext(ctx)
+	info := &relaycommon.RelayInfo{
+		RelayFormat:       relaycommon.RelayFormatClaude,
+		IsStream:          true,
+		OriginModelName:   "claude-sonnet-4-20250514",
+		UpstreamModelName: "claude-sonnet-4-20250514",
+		StartTime:         time.Now(),
+	}
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       upstream,
+	}
+	apiErr, usage := ClaudeStreamHandler(c, resp, info, RequestModeMessage)
+	if apiErr != nil {
+		t.Fatalf("ClaudeStreamHandler: %v", apiErr)
+	}
+	if !common.GetContextKeyBool(c, constant.ContextKeyClientDisconnected) {
+		t.Error("client disconnection was not recorded")
+	}
+	if strings.Contains(recorder.Body.String(), "undelivered") {
+		t.Error("content after the disconnect must not be forwarded")
+	}
+	// 只按已送达的内容计费，而不是上游最终报告的 500 个输出 token
+	if usage.CompletionTokens <= 0 || usage.CompletionTokens >= 100 {
+		t.Errorf("completion tokens = %d, want only the delivered delta", usage.CompletionTokens)
+	}
+	select {
+	case err := <-writeErr:
+		if err == nil {
+			t.Error("the upstream body should be closed before the stream finished")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("upstream writer still blocked, the upstream read was not aborted")
+	}
+}
+
+func TestRequestOpenAI2ClaudeMessageRejectsMalformedDataURI(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"missing base64 marker", "data:image/png,iVBORw0KGgo=", "missing ';base64' marker"},
+		{"unsupported mime", "data:image/bmp;base64,Qk0=", "mime type 'image/bmp' is not supported"},
+		{"missing mime", "data:;base64,iVBORw0KGgo=", "missing mime type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var request dto.GeneralOpenAIRequest
+			if err := common.UnmarshalJsonStr(`{"model":"claude-sonnet-4-20250514","messages":[{"role":"user","content":[
+				{"type":"text","text":"compare"},
+				{"type":"image_url","image_url":{"url":"`+tt.url+`"}}]}]}`, &request); err != nil {
+				t.Fatalf("unmarshal request: %v", err)
+			}
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			_, err := RequestOpenAI2ClaudeMessage(c, request)
+			apiErr, ok := err.(*types.NewAPIError)
+			if !ok {
+				t.Fatalf("err = %v, want a NewAPIError", err)
+			}
+			if apiErr.GetErrorCode() != types.ErrorCodeInvalidRequest || apiErr.StatusCode != http.StatusBadRequest {
+				t.Errorf("error = %s/%d, want %s/400", apiErr.GetErrorCode(), apiErr.StatusCode, types.ErrorCodeInvalidRequest)
+			}
+			if !strings.Contains(err.Error(), "attachment index 1") || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want attachment index 1 and %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestOpenAI2ClaudeMessageKeepsImageOrder(t *testing.T) {
+	constant.MaxFileDownloadMB = 20
+	constant.FileDownloadConcurrency = 4
+	constant.FileDownloadTimeoutSeconds = 10
+	// 先请求的图片下载最慢，转换结果仍按消息中的顺序排列
+	delays := map[string]time.Duration{"/first.png": 200 * time.Millisecond, "/second.png": 100 * time.Millisecond}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delays[r.URL.Path])
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+
+	var request dto.GeneralOpenAIRequest
+	if err := common.UnmarshalJsonStr(`{"model":"claude-sonnet-4-20250514","messages":[{"role":"user","content":[
+		{"type":"image_url","image_url":{"url":"`+server.URL+`/first.png"}},
+		{"type":"text","text":"and"},
+		{"type":"image_url","image_url":{"url":"`+server.URL+`/second.png"}},
+		{"type":"image_url","image_url":{"url":"`+server.URL+`/third.png"}}]}]}`, &request); err != nil {
+		t.Fatalf("unmarshal request: %v", err)
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	start := time.Now()
+	claudeRequest, err := RequestOpenAI2ClaudeMessage(c, request)
+	if err != nil {
+		t.Fatalf("RequestOpenAI2ClaudeMessage: %v", err)
+	}
+	// 并发下载时总耗时接近最慢的一张
+	if elapsed := time.Since(start); elapsed >= 300*time.Millisecond {
+		t.Errorf("conversion took %v, want the downloads to run in parallel", elapsed)
+	}
+	body, _ := common.Marshal(claudeRequest)
+	last := -1
+	for _, path := range []string{"/first.png", "/second.png", "/third.png"} {
+		index := strings.Index(string(body), base64.StdEncoding.EncodeToString([]byte(path)))
+		if index <= last {
+			t.Fatalf("image %s out of order in %s", path, body)
+		}
+		last = index
+	}
+}
+
+func TestRequestOpenAI2ClaudeMessageThinkingBudgetPerModel(t *testing.T) {
+	settings := model_setting.GetClaudeSettings()
+	originalEnabled, originalPercentages := settings.ThinkingAdapterEnabled, settings.ThinkingBudgetTokensPercentages
+	settings.ThinkingAdapterEnabled = true
+	settings.ThinkingBudgetTokensPercentages = map[string]float64{
+		"claude-opus-4-20250514":   0.5,
+		"claude-sonnet-4-20250514": 0.25,
+	}
+	defer func() {
+		settings.ThinkingAdapterEnabled, settings.ThinkingBudgetTokensPercentages = originalEnabled, originalPercentages
+	}()
+
+	tests := []struct {
+		name       string
+		model      string
+		maxTokens  uint
+		wantBudget int
+	}{
+		{"opus uses its own percentage", "claude-opus-4-20250514", 20000, 10000},
+		{"opus clamped to its thinking maximum", "claude-opus-4-20250514", 80000, 32000},
+		{"sonnet uses its own percentage", "claude-sonnet-4-20250514", 8000, 2000},
+		{"unconfigured model falls back to the global percentage", "claude-3-7-sonnet-20250219", 10000, 8000},
+		{"budget never below the minimum", "claude-sonnet-4-20250514", 2000, 1024},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			request := dto.GeneralOpenAIRequest{
+				Model:     tt.model + "-thinking",
+				MaxTokens: tt.maxTokens,
+				Messages:  []dto.Message{{Role: "user", Content: "hello"}},
+			}
+			claudeRequest, err := RequestOpenAI2ClaudeMessage(c, request)
+			if err != nil {
+				t.Fatalf("RequestOpenAI2ClaudeMessage: %v", err)
+			}
+			if claudeRequest.Thinking == nil || claudeRequest.Thinking.BudgetTokens == nil {
+				t.Fatalf("thinking = %+v, want enabled", claudeRequest.Thinking)
+			}
+			if got := *claudeRequest.Thinking.BudgetTokens; got != tt.wantBudget {
+				t.Errorf("budget_tokens = %d, want %d", got, tt.wantBudget)
+			}
+		})
+	}
+}
+
+func TestClaudeStreamHandlerKeepsPartialContentOnError(t *testing.T) {
+	constant.StreamingTimeout = 60
+	service.InitTokenEncoders()
+	globalSettings := model_setting.GetGlobalSettings()
+	original := globalSettings.StreamPartialContentOnError
+	defer func() { globalSettings.StreamPartialContentOnError = original }()
+
+	// 输出部分内容后上游返回错误事件
+	events := []string{
+		`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-20250514","content":[],"usage":{"input_tokens":12,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"The answer"}}`,
+		`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+	}
+	tests := []struct {
+		name    string
+		enabled bool
+	}{
+		{"enabled", true},
+		{"disabled", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			globalSettings.StreamPartialContentOnError = tt.enabled
+			var body strings.Builder
+			for _, event := range events {
+				body.WriteString("data: " + event + "\n\n")
+			}
+			gin.SetMode(gin.TestMode)
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+			info := &relaycommon.RelayInfo{
+				RelayFormat:       relaycommon.RelayFormatClaude,
+				IsStream:          true,
+				OriginModelName:   "claude-sonnet-4-20250514",
+				UpstreamModelName: "claude-sonnet-4-20250514",
+				StartTime:         time.Now(),
+			}
+			resp := &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+				Body:       io.NopCloser(strings.NewReader(body.String())),
+			}
+			apiErr, usage := ClaudeStreamHandler(c, resp, info, RequestModeMessage)
+			output := recorder.Body.String()
+			if !tt.enabled {
+				// 默认行为不变：整体返回错误，由上层处理
+				if apiErr == nil {
+					t.Fatalf("expected an error with the option disabled, got usage %+v", usage)
+				}
+				if strings.Contains(output, `"type":"error"`) {
+					t.Errorf("error event must not be written by the handler when disabled:\n%s", output)
+				}
+				return
+			}
+			if apiErr != nil {
+				t.Fatalf("ClaudeStreamHandler: %v", apiErr)
+			}
+			textAt := strings.Index(output, `"text":"The answer"`)
+			errorAt := strings.Index(output, `"type":"error"`)
+			if textAt < 0 || errorAt < 0 || errorAt < textAt {
+				t.Fatalf("want the partial content followed by an error event:\n%s", output)
+			}
+			if !strings.Contains(output[errorAt:], "Overloaded") {
+				t.Errorf("error event does not carry the upstream message:\n%s", output[errorAt:])
+			}
+			// 按已输出的内容结算
+			if usage == nil || usage.PromptTokens != 12 || usage.CompletionTokens == 0 {
+				t.Errorf("usage = %+v, want prompt 12 and non-zero completion", usage)
+			}
+		})
+	}
+}
+
+func TestRequestOpenAI2ClaudeMessageIgnoresSeed(t *testing.T) {
+	originalDebug, originalWriter := common.DebugEnabled, gin.DefaultWriter
+	var logs strings.Builder
+	common.DebugEnabled, gin.DefaultWriter = true, &logs
+	defer func() { common.DebugEnabled, gin.DefaultWriter = originalDebug, originalWriter }()
+
+	var request dto.GeneralOpenAIRequest
+	if err := common.UnmarshalJsonStr(`{"model":"claude-sonnet-4-20250514","seed":42,"messages":[{"role":"user","content":"hi"}]}`, &request); err != nil {
+		t.Fatalf("unmarshal request: %v", err)
+	}
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	claudeRequest, err := RequestOpenAI2ClaudeMessage(c, request)
+	if err != nil {
+		t.Fatalf("RequestOpenAI2ClaudeMessage: %v", err)
+	}
+	body, _ := common.Marshal(claudeRequest)
+	if strings.Contains(string(body), "seed") {
+		t.Errorf("claude request %s must not carry a seed", body)
+	}
+	if !strings.Contains(logs.String(), "Seed is not supported by Claude, ignored | Seed:42") {
+		t.Errorf("missing debug note for the ignored seed:\n%s", logs.String())
+	}
+}
+
+func TestRequestOpenAI2ClaudeMessageMapsReasoningEffort(t *testing.T) {
+	settings := model_setting.GetClaudeSettings()
+	originalBudget := settings.ReasoningEffortBudget
+	settings.ReasoningEffortBudget = map[string]int{"low": 1500, "medium": 3000, "high": 6000}
+	defer func() { settings.ReasoningEffortBudget = originalBudget }()
+
+	tests := []struct {
+		name       string
+		body       string
+		wantBudget int // 为 0 表示不开启思考
+	}{
+		{"low", `"reasoning_effort":"low","max_tokens":8192`, 1500},
+		{"medium", `"reasoning_effort":"medium","max_tokens":8192`, 3000},
+		{"high", `"reasoning_effort":"high","max_tokens":8192`, 6000},
+		{"kept below max_tokens", `"reasoning_effort":"high","max_tokens":4000`, 3999},
+		{"explicit reasoning budget wins", `"reasoning_effort":"high","max_tokens":8192,"reasoning":{"max_tokens":2500}`, 2500},
+		{"unknown effort ignored", `"reasoning_effort":"extreme","max_tokens":8192`, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			var request dto.GeneralOpenAIRequest
+			if err := common.UnmarshalJsonStr(`{"model":"claude-sonnet-4-20250514",`+tt.body+`,"messages":[{"role":"user","content":"hello"}]}`, &request); err != nil {
+				t.Fatalf("unmarshal request: %v", err)
+			}
+			claudeRequest, err := RequestOpenAI2ClaudeMessage(c, request)
+			if err != nil {
+				t.Fatalf("RequestOpenAI2ClaudeMessage: %v", err)
+			}
+			budget := 0
+			if claudeRequest.Thinking != nil && claudeRequest.Thinking.BudgetTokens != nil {
+				budget = *claudeRequest.Thinking.BudgetTokens
+			}
+			if budget != tt.wantBudget {
+				t.Errorf("budget_tokens = %d, want %d", budget, tt.wantBudget)
+			}
+		})
+	}
+}
+
+// 命中提示词缓存的响应，message_delta 中不再返回输入用量
+const cachedUsageResponse = `{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-20250514","content":[{"type":"text","text":"hi"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"cache_creation_input_tokens":2048,"cache_read_input_tokens":4096,"output_tokens":5}}`
+
+var cachedUsageStream = []string{
+	`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-20250514","content":[],"usage":{"input_tokens":12,"cache_creation_input_tokens":2048,"cache_read_input_tokens":4096,"output_tokens":1}}}`,
+	`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+	`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"hi"}}`,
+	`{"type":"content_block_stop","index":0}`,
+	`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":5}}`,
+	`{"type":"message_stop"}`,
+}
+
+func TestClaudeHandlerIncludesUpstreamUsage(t *testing.T) {
+	constant.StreamingTimeout = 60
+	service.InitTokenEncoders()
+	for _, stream := range []bool{false, true} {
+		for _, include := range []bool{true, false} {
+			t.Run(fmt.Sprintf("stream=%v include=%v", stream, include), func(t *testing.T) {
+				gin.SetMode(gin.TestMode)
+				recorder := httptest.NewRecorder()
+				c, _ := gin.CreateTestContext(recorder)
+				c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+				info := &relaycommon.RelayInfo{
+					RelayFormat:          relaycommon.RelayFormatOpenAI,
+					IsStream:             stream,
+					ShouldIncludeUsage:   true,
+					IncludeUpstreamUsage: include,
+					OriginModelName:      "claude-sonnet-4-20250514",
+					UpstreamModelName:    "claude-sonnet-4-20250514",
+					StartTime:            time.Now(),
+				}
+				body, contentType := cachedUsageResponse, "application/json"
+				if stream {
+					var sb strings.Builder
+					for _, event := range cachedUsageStream {
+						sb.WriteString("data: " + event + "\n\n")
+					}
+					body, contentType = sb.String(), "text/event-stream"
+				}
+				resp := &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{"Content-Type": []string{contentType}},
+					Body:       io.NopCloser(strings.NewReader(body)),
+				}
+				var apiErr *types.NewAPIError
+				if stream {
+					apiErr, _ = ClaudeStreamHandler(c, resp, info, RequestModeMessage)
+				} else {
+					apiErr, _ = ClaudeHandler(c, resp, RequestModeMessage, info)
+				}
+				if apiErr != nil {
+					t.Fatalf("handler: %v", apiErr)
+				}
+
+				var usage *dto.Usage
+				payloads := []string{recorder.Body.String()}
+				if stream {
+					payloads = nil
+					for _, line := range strings.Split(recorder.Body.String(), "\n") {
+						if data, ok := strings.CutPrefix(line, "data: "); ok && data != "[DONE]" {
+							payloads = append(payloads, data)
+						}
+					}
+				}
+				for _, payload := range payloads {
+					var response struct {
+						Usage *dto.Usage `json:"usage"`
+					}
+					if err := common.UnmarshalJsonStr(payload, &response); err != nil {
+						t.Fatalf("unmarshal %s: %v", payload, err)
+					}
+					if response.Usage != nil {
+						usage = response.Usage
+					}
+				}
+				if usage == nil {
+					t.Fatalf("no usage in response:\n%s", recorder.Body.String())
+				}
+				if !include {
+					if usage.UpstreamUsage != nil {
+						t.Errorf("x_upstream_usage = %v, want it omitted unless requested", usage.UpstreamUsage)
+					}
+					return
+				}
+				// 流式响应合并 message_start 与 message_delta 中的用量
+				raw, _ := usage.UpstreamUsage.(map[string]any)
+				if raw["cache_creation_input_tokens"] != float64(2048) || raw["cache_read_input_tokens"] != float64(4096) || raw["output_tokens"] != float64(5) {
+					t.Errorf("x_upstream_usage = %v, want the native Anthropic usage", usage.UpstreamUsage)
+				}
+			})
+		}
+	}
+}
+
+// stopSequenceResponse 因自定义停止序列结束的响应
+const stopSequenceResponse = `{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-20250514","content":[{"type":"text","text":"Step one"}],"stop_reason":"stop_sequence","stop_sequence":"###END","usage":{"input_tokens":12,"output_tokens":3}}`
+
+var stopSequenceStream = []string{
+	`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-20250514","content":[],"usage":{"input_tokens":12,"output_tokens":1}}}`,
+	`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+	`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Step one"}}`,
+	`{"type":"content_block_stop","index":0}`,
+	`{"type":"message_delta","delta":{"stop_reason":"stop_sequence","stop_sequence":"###END"},"usage":{"output_tokens":3}}`,
+	`{"type":"message_stop"}`,
+}
+
+func TestClaudeHandlerReturnsStopSequence(t *testing.T) {
+	constant.StreamingTimeout = 60
+	claudeSettings := model_setting.GetClaudeSettings()
+	original := claudeSettings.ReturnStopSequence
+	defer func() { claudeSettings.ReturnStopSequence = original }()
+
+	for _, enabled := range []bool{true, false} {
+		for _, stream := range []bool{false, true} {
+			name := fmt.Sprintf("stream %v enabled %v", stream, enabled)
+			t.Run(name, func(t *testing.T) {
+				claudeSettings.ReturnStopSequence = enabled
+				gin.SetMode(gin.TestMode)
+				recorder := httptest.NewRecorder()
+				c, _ := gin.CreateTestContext(recorder)
+				c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+				info := &relaycommon.RelayInfo{
+					RelayFormat:       relaycommon.RelayFormatOpenAI,
+					IsStream:          stream,
+					OriginModelName:   "claude-sonnet-4-20250514",
+					UpstreamModelName: "claude-sonnet-4-20250514",
+					StartTime:         time.Now(),
+				}
+				body, contentType := stopSequenceResponse, "application/json"
+				if stream {
+					var sb strings.Builder
+					for _, event := range stopSequenceStream {
+						sb.WriteString("data: " + event + "\n\n")
+					}
+					body, contentType = sb.String(), "text/event-stream"
+				}
+				resp := &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{"Content-Type": []string{contentType}},
+					Body:       io.NopCloser(strings.NewReader(body)),
+				}
+				var apiErr *types.NewAPIError
+				if stream {
+					apiErr, _ = ClaudeStreamHandler(c, resp, info, RequestModeMessage)
+				} else {
+					apiErr, _ = ClaudeHandler(c, resp, RequestModeMessage, info)
+				}
+				if apiErr != nil {
+					t.Fatalf("handler: %v", apiErr)
+				}
+
+				var stopSequence *string
+				if stream {
+					// 停止序列随携带结束原因的最后一个分片返回
+					for _, line := range strings.Split(recorder.Body.String(), "\n") {
+						data, ok := strings.CutPrefix(line, "data: ")
+						if !ok || data == "[DONE]" {
+							continue
+						}
+						var chunk dto.ChatCompletionsStreamResponse
+						if err := common.UnmarshalJsonStr(data, &chunk); err != nil {
+							t.Fatalf("unmarshal %s: %v", data, err)
+						}
+						for _, choice := range chunk.Choices {
+							if choice.StopSequence != nil {
+								stopSequence = choice.StopSequence
+							}
+						}
+					}
+				} else {
+					var response dto.OpenAITextResponse
+					if err := common.UnmarshalJsonStr(recorder.Body.String(), &response); err != nil {
+						t.Fatalf("unmarshal %s: %v", recorder.Body.String(), err)
+					}
+					stopSequence = response.Choices[0].StopSequence
+				}
+				if enabled && (stopSequence == nil || *stopSequence != "###END") {
+					t.Errorf("stop_sequence = %v, want ###END\n%s", stopSequence, recorder.Body.String())
+				}
+				if !enabled && stopSequence != nil {
+					t.Errorf("stop_sequence = %q, want it omitted when disabled", *stopSequence)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package claude
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/relay/helper"
+	"one-api/service"
+	"one-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newClaudeResponseFilter 创建渠道配置的响应过滤器，匹配器未注册时拒绝返回响应
+func newClaudeResponseFilter(info *relaycommon.RelayInfo) (*service.ResponseFilter, *types.NewAPIError) {
+	filter, err := service.NewResponseFilter(info.ChannelSetting)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeResponseFiltered)
+	}
+	return filter, nil
+}
+
+// filterClaudeResponse 过滤非流式响应中的文本内容，返回内容是否被替换
+func filterClaudeResponse(c *gin.Context, filter *service.ResponseFilter, claudeResponse *dto.ClaudeResponse) (bool, *types.NewAPIError) {
+	changed := false
+	if claudeResponse.Completion != "" {
+		text, textChanged, err := filter.Apply(claudeResponse.Completion)
+		if err != nil {
+			common.LogWarn(c, fmt.Sprintf("[CLAUDE] Response blocked by content filter | Error:%s", err.Error()))
+			return false, err
+		}
+		claudeResponse.Completion = text
+		changed = changed || textChanged
+	}
+	for i := range claudeResponse.Content {
+		block := &claudeResponse.Content[i]
+		if block.Type != "text" || block.Text == nil {
+			continue
+		}
+		text, textChanged, err := filter.Apply(*block.Text)
+		if err != nil {
+			common.LogWarn(c, fmt.Sprintf("[CLAUDE] Response blocked by content filter | Error:%s", err.Error()))
+			return false, err
+		}
+		block.SetText(text)
+		changed = changed || textChanged
+	}
+	if changed {
+		common.LogInfo(c, "[CLAUDE] Response content redacted by content filter")
+	}
+	return changed, nil
+}
+
+// filterClaudeStreamEvent 过滤流式文本增量，每个内容块末尾的文本缓存到块结束时再输出
+// skip 表示本次增量全部被缓存，无需转发；changed 表示事件内容被修改，需要重新序列化
+func filterClaudeStreamEvent(c *gin.Context, info *relaycommon.RelayInfo, claudeInfo *ClaudeResponseInfo, claudeResponse *dto.ClaudeResponse, requestMode int) (skip bool, changed bool, err *types.NewAPIError) {
+	switch claudeResponse.Type {
+	case "content_block_delta":
+		delta := claudeResponse.Delta
+		if delta == nil || delta.Type != "text_delta" || delta.Text == nil {
+			return false, false, nil
+		}
+		index := claudeResponse.GetIndex()
+		stream, ok := claudeInfo.ResponseFilterStreams[index]
+		if !ok {
+			stream = claudeInfo.ResponseFilter.NewStream()
+			claudeInfo.ResponseFilterStreams[index] = stream
+		}
+		text, err := stream.Write(*delta.Text)
+		if err != nil {
+			common.LogWarn(c, fmt.Sprintf("[CLAUDE] Stream blocked by content filter | Index:%d | Error:%s", index, err.Error()))
+			return false, false, err
+		}
+		if text == "" {
+			return true, false, nil
+		}
+		if text == *delta.Text {
+			return false, false, nil
+		}
+		delta.SetText(text)
+		return false, true, nil
+	case "content_block_stop":
+		return false, false, flushClaudeFilterStream(c, info, claudeInfo, claudeResponse.GetIndex(), requestMode)
+	case "message_delta", "message_stop":
+		// 上游未发送 content_block_stop 时，在消息结束前输出剩余内容
+		for index := range claudeInfo.ResponseFilterStreams {
+			if err := flushClaudeFilterStream(c, info, claudeInfo, index, requestMode); err != nil {
+				return false, false, err
+			}
+		}
+	}
+	return false, false, nil
+}
+
+func flushClaudeFilterStream(c *gin.Context, info *relaycommon.RelayInfo, claudeInfo *ClaudeResponseInfo, index int, requestMode int) *types.NewAPIError {
+	stream, ok := claudeInfo.ResponseFilterStreams[index]
+	if !ok {
+		return nil
+	}
+	delete(claudeInfo.ResponseFilterStreams, index)
+	text, err := stream.Flush()
+	if err != nil {
+		common.LogWarn(c, fmt.Sprintf("[CLAUDE] Stream blocked by content filter | Index:%d | Error:%s", index, err.Error()))
+		return err
+	}
+	if text == "" {
+		return nil
+	}
+	event := dto.ClaudeResponse{
+		Type:  "content_block_delta",
+		Delta: &dto.ClaudeMediaMessage{Type: "text_delta"},
+	}
+	event.SetIndex(index)
+	event.Delta.SetText(text)
+	if info.RelayFormat == relaycommon.RelayFormatClaude {
+		return sendClaudeEvent(c, event)
+	}
+	response := StreamResponseClaude2OpenAI(requestMode, &event)
+	response.Id = claudeInfo.ResponseId
+	response.Created = claudeInfo.Created
+	response.Model = info.ResponseModel(claudeInfo.Model)
+	if err := helper.ObjectData(c, response); err != nil {
+		common.LogError(c, "send_stream_response_failed: "+err.Error())
+	}
+	return nil
+}
+
+// sendClaudeEvent 重新序列化被修改的事件后转发
+func sendClaudeEvent(c *gin.Context, event dto.ClaudeResponse) *types.NewAPIError {
+	data, err := common.Marshal(event)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeBadResponseBody)
+	}
+	helper.ClaudeChunkData(c, event, string(data))
+	return nil
+}
@@ -0,0 +1,149 @@
+package claude
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/types"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newResponseFilterStream 构造文本增量被拆分到多个事件的流式响应
+func newResponseFilterStream(deltas ...string) string {
+	events := []string{
+		`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-20250514","content":[],"usage":{"input_tokens":12,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+	}
+	for _, delta := range deltas {
+		data, _ := common.Marshal(delta)
+		events = append(events, `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":`+string(data)+`}}`)
+	}
+	events = append(events,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":20}}`,
+		`{"type":"message_stop"}`,
+	)
+	var sb strings.Builder
+	for _, event := range events {
+		sb.WriteString("data: " + event + "\n\n")
+	}
+	return sb.String()
+}
+
+func runResponseFilter(t *testing.T, setting dto.ChannelSettings, stream bool, deltas ...string) (string, *types.NewAPIError) {
+	t.Helper()
+	constant.StreamingTimeout = 60
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	info := &relaycommon.RelayInfo{
+		RelayFormat:       relaycommon.RelayFormatClaude,
+		IsStream:          stream,
+		OriginModelName:   "claude-sonnet-4-20250514",
+		UpstreamModelName: "claude-sonnet-4-20250514",
+		StartTime:         time.Now(),
+		ChannelSetting:    setting,
+	}
+	body, contentType := newResponseFilterStream(deltas...), "text/event-stream"
+	if !stream {
+		text, _ := common.Marshal(strings.Join(deltas, ""))
+		body = `{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-20250514","content":[{"type":"text","text":` + string(text) + `}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":20}}`
+		contentType = "application/json"
+	}
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{contentType}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+	var apiErr *types.NewAPIError
+	if stream {
+		apiErr, _ = ClaudeStreamHandler(c, resp, info, RequestModeMessage)
+	} else {
+		apiErr, _ = ClaudeHandler(c, resp, RequestModeMessage, info)
+	}
+	return recorder.Body.String(), apiErr
+}
+
+// responseFilterOutputText 拼接客户端收到的文本内容
+func responseFilterOutputText(t *testing.T, output string, stream bool) string {
+	t.Helper()
+	if !stream {
+		var response dto.ClaudeResponse
+		if err := common.Unmarshal([]byte(output), &response); err != nil {
+			t.Fatalf("unmarshal response %s: %v", output, err)
+		}
+		return response.Content[0].GetText()
+	}
+	var sb strings.Builder
+	for _, line := range strings.Split(output, "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		var event dto.ClaudeResponse
+		if err := common.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatalf("unmarshal event %s: %v", data, err)
+		}
+		if event.Type == "content_block_delta" && event.Delta != nil && event.Delta.Text != nil {
+			sb.WriteString(*event.Delta.Text)
+		}
+	}
+	return sb.String()
+}
+
+func TestClaudeResponseFilterRedactsMatches(t *testing.T) {
+	setting := dto.ChannelSettings{
+		ResponseFilters:      []dto.ResponseFilterRule{{Pattern: `sk-[a-z0-9]{6,}`, Action: dto.ResponseFilterActionRedact}},
+		ResponseFilterWindow: 8,
+	}
+	// 密钥被拆分到多个增量中，滑动窗口需要在跨块时识别出来
+	deltas := []string{"Your key is sk-abc", "123def and ", "the token is sk-zz", "9999x."}
+	want := "Your key is [REDACTED] and the token is [REDACTED]."
+	for _, stream := range []bool{false, true} {
+		output, apiErr := runResponseFilter(t, setting, stream, deltas...)
+		if apiErr != nil {
+			t.Fatalf("stream %v: handler: %v", stream, apiErr)
+		}
+		if got := responseFilterOutputText(t, output, stream); got != want {
+			t.Errorf("stream %v: text = %q, want %q", stream, got, want)
+		}
+		if strings.Contains(output, "abc") || strings.Contains(output, "zz9999x") {
+			t.Errorf("stream %v: secret leaked:\n%s", stream, output)
+		}
+	}
+}
+
+func TestClaudeResponseFilterBlocksResponse(t *testing.T) {
+	setting := dto.ChannelSettings{
+		ResponseFilters: []dto.ResponseFilterRule{{
+			Pattern:         `BEGIN PRIVATE KEY`,
+			Action:          dto.ResponseFilterActionBlock,
+			ErrorMessage:    "response contains a private key",
+			ErrorStatusCode: http.StatusUnavailableForLegalReasons,
+		}},
+		// 窗口需覆盖最长的命中，才能在命中的开头输出前识别跨块的匹配
+		ResponseFilterWindow: 32,
+	}
+	deltas := []string{"Here it is: -----BEGIN PRIV", "ATE KEY-----\nMIIEv"}
+	for _, stream := range []bool{false, true} {
+		output, apiErr := runResponseFilter(t, setting, stream, deltas...)
+		if apiErr == nil || apiErr.GetErrorCode() != types.ErrorCodeResponseFiltered || apiErr.StatusCode != http.StatusUnavailableForLegalReasons {
+			t.Fatalf("stream %v: error = %v, want response_filtered/451", stream, apiErr)
+		}
+		if apiErr.Error() != "response contains a private key" {
+			t.Errorf("stream %v: error message = %q", stream, apiErr.Error())
+		}
+		if strings.Contains(output, "BEGIN") || strings.Contains(output, "MIIEv") {
+			t.Errorf("stream %v: blocked content leaked:\n%s", stream, output)
+		}
+	}
+}
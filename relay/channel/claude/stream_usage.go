@@ -0,0 +1,78 @@
+package claude
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/relay/helper"
+	"one-api/service"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClaudeStreamUsageIntervalHeader 客户端设置为正整数时，流式输出每新增约该数量的 token 推送一次累计用量
+// 默认不推送，避免不识别中间用量事件的客户端出错
+const ClaudeStreamUsageIntervalHeader = "X-Stream-Usage-Interval"
+
+func getStreamUsageInterval(c *gin.Context) int {
+	interval, err := strconv.Atoi(c.GetHeader(ClaudeStreamUsageIntervalHeader))
+	if err != nil || interval <= 0 {
+		return 0
+	}
+	return interval
+}
+
+// estimateStreamCompletionTokens 按输出增量估算累计输出 token，仅在需要推送用量时计算
+func estimateStreamCompletionTokens(info *relaycommon.RelayInfo, claudeInfo *ClaudeResponseInfo, claudeResponse *dto.ClaudeResponse) {
+	if claudeInfo.UsageInterval <= 0 || claudeResponse.Type != "content_block_delta" || claudeResponse.Delta == nil {
+		return
+	}
+	delta := claudeResponse.Delta
+	text := delta.GetText() + delta.Thinking
+	if delta.PartialJson != nil {
+		text += *delta.PartialJson
+	}
+	if text == "" {
+		return
+	}
+	claudeInfo.EstimatedCompletionTokens += service.CountTextToken(text, info.UpstreamModelName)
+}
+
+// reportStreamUsage 累计输出 token 每达到一个间隔向客户端推送一次用量
+// Claude 格式以 message_delta 事件推送，OpenAI 格式以不含 choices 的 usage 分块推送
+func reportStreamUsage(c *gin.Context, info *relaycommon.RelayInfo, claudeInfo *ClaudeResponseInfo, claudeResponse *dto.ClaudeResponse) {
+	if claudeInfo.UsageInterval <= 0 || claudeResponse.Type != "content_block_delta" {
+		return
+	}
+	if claudeInfo.EstimatedCompletionTokens-claudeInfo.ReportedCompletionTokens < claudeInfo.UsageInterval {
+		return
+	}
+	claudeInfo.ReportedCompletionTokens = claudeInfo.EstimatedCompletionTokens
+
+	var err error
+	switch info.RelayFormat {
+	case relaycommon.RelayFormatClaude:
+		err = helper.ClaudeData(c, dto.ClaudeResponse{
+			Type:  "message_delta",
+			Delta: &dto.ClaudeMediaMessage{},
+			Usage: &dto.ClaudeUsage{
+				InputTokens:              claudeInfo.Usage.PromptTokens,
+				CacheCreationInputTokens: claudeInfo.Usage.PromptTokensDetails.CachedCreationTokens,
+				CacheReadInputTokens:     claudeInfo.Usage.PromptTokensDetails.CachedTokens,
+				OutputTokens:             claudeInfo.ReportedCompletionTokens,
+			},
+		})
+	case relaycommon.RelayFormatOpenAI:
+		usage := dto.Usage{
+			PromptTokens:     claudeInfo.Usage.PromptTokens,
+			CompletionTokens: claudeInfo.ReportedCompletionTokens,
+			TotalTokens:      claudeInfo.Usage.PromptTokens + claudeInfo.ReportedCompletionTokens,
+		}
+		err = helper.ObjectData(c, helper.GenerateFinalUsageResponse(claudeInfo.ResponseId, claudeInfo.Created, info.ResponseModel(info.UpstreamModelName), usage))
+	}
+	if err != nil {
+		common.LogError(c, fmt.Sprintf("[CLAUDE] Send stream usage failed | Error:%s", err.Error()))
+	}
+}
@@ -0,0 +1,100 @@
+package claude
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/service"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// streamUsageEvents 按请求头流式处理多段文本输出，返回客户端收到的用量
+func streamUsageEvents(t *testing.T, interval string) []dto.Usage {
+	t.Helper()
+	events := []string{
+		`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-20250514","content":[],"usage":{"input_tokens":12,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+	}
+	for i := 0; i < 6; i++ {
+		events = append(events, `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"hello world number `+strconv.Itoa(i)+` "}}`)
+	}
+	events = append(events,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":40}}`,
+		`{"type":"message_stop"}`,
+	)
+	var body strings.Builder
+	for _, event := range events {
+		body.WriteString("data: " + event + "\n\n")
+	}
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	if interval != "" {
+		c.Request.Header.Set(ClaudeStreamUsageIntervalHeader, interval)
+	}
+	info := &relaycommon.RelayInfo{
+		RelayFormat:       relaycommon.RelayFormatOpenAI,
+		IsStream:          true,
+		OriginModelName:   "claude-sonnet-4-20250514",
+		UpstreamModelName: "claude-sonnet-4-20250514",
+		StartTime:         time.Now(),
+	}
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       io.NopCloser(strings.NewReader(body.String())),
+	}
+	if apiErr, _ := ClaudeStreamHandler(c, resp, info, RequestModeMessage); apiErr != nil {
+		t.Fatalf("ClaudeStreamHandler: %v", apiErr)
+	}
+	var usages []dto.Usage
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var response dto.ChatCompletionsStreamResponse
+		if err := common.UnmarshalJsonStr(data, &response); err != nil {
+			t.Fatalf("unmarshal %s: %v", data, err)
+		}
+		if response.Usage != nil {
+			usages = append(usages, *response.Usage)
+		}
+	}
+	return usages
+}
+
+func TestClaudeStreamUsageDeltas(t *testing.T) {
+	constant.StreamingTimeout = 60
+	service.InitTokenEncoders()
+
+	usages := streamUsageEvents(t, "4")
+	if len(usages) < 3 {
+		t.Fatalf("got %d usage events, want intermediate updates and the final usage", len(usages))
+	}
+	for i := 1; i < len(usages); i++ {
+		if usages[i].CompletionTokens <= usages[i-1].CompletionTokens {
+			t.Errorf("usage %d completion tokens %d, want more than %d", i, usages[i].CompletionTokens, usages[i-1].CompletionTokens)
+		}
+	}
+	final := usages[len(usages)-1]
+	if final.PromptTokens != 12 || final.CompletionTokens != 40 {
+		t.Errorf("final usage = %d/%d, want 12/40 from upstream", final.PromptTokens, final.CompletionTokens)
+	}
+
+	// 未开启时不推送任何用量
+	if usages := streamUsageEvents(t, ""); len(usages) != 0 {
+		t.Errorf("got %d usage events without opting in, want 0", len(usages))
+	}
+}
@@ -51,6 +51,18 @@ func (g *GeminiInlineData) UnmarshalJSON(data []byte) error {
 type FunctionCall struct {
 	FunctionName string `json:"name"`
 	Arguments    any    `json:"args"`
+	// 流式返回参数时，参数按 JSON 路径分片在 partialArgs 中返回，willContinue 表示后续分片仍属于该调用
+	PartialArgs  []FunctionCallPartialArg `json:"partialArgs,omitempty"`
+	WillContinue bool                     `json:"willContinue,omitempty"`
+}
+
+type FunctionCallPartialArg struct {
+	JsonPath     string   `json:"jsonPath"`
+	StringValue  *string  `json:"stringValue,omitempty"`
+	NumberValue  *float64 `json:"numberValue,omitempty"`
+	BoolValue    *bool    `json:"boolValue,omitempty"`
+	NullValue    any      `json:"nullValue,omitempty"`
+	WillContinue bool     `json:"willContinue,omitempty"`
 }
 
 type FunctionResponse struct {
@@ -82,6 +94,13 @@ type GeminiPart struct {
 	FileData            *GeminiFileData                `json:"fileData,omitempty"`
 	ExecutableCode      *GeminiPartExecutableCode      `json:"executableCode,omitempty"`
 	CodeExecutionResult *GeminiPartCodeExecutionResult `json:"codeExecutionResult,omitempty"`
+	VideoMetadata       *GeminiVideoMetadata           `json:"videoMetadata,omitempty"`
+}
+
+type GeminiVideoMetadata struct {
+	StartOffset string  `json:"startOffset,omitempty"`
+	EndOffset   string  `json:"endOffset,omitempty"`
+	Fps         float64 `json:"fps,omitempty"`
 }
 
 // UnmarshalJSON custom unmarshaler for GeminiPart to support snake_case and camelCase for InlineData
@@ -135,10 +154,14 @@ type GeminiChatGenerationConfig struct {
 	MaxOutputTokens    uint                  `json:"maxOutputTokens,omitempty"`
 	CandidateCount     int                   `json:"candidateCount,omitempty"`
 	StopSequences      []string              `json:"stopSequences,omitempty"`
+	PresencePenalty    *float64              `json:"presencePenalty,omitempty"`
+	FrequencyPenalty   *float64              `json:"frequencyPenalty,omitempty"`
 	ResponseMimeType   string                `json:"responseMimeType,omitempty"`
 	ResponseSchema     any                   `json:"responseSchema,omitempty"`
-	Seed               int64                 `json:"seed,omitempty"`
+	Seed               *int64                `json:"seed,omitempty"`
 	ResponseModalities []string              `json:"responseModalities,omitempty"`
+	ResponseLogprobs   bool                  `json:"responseLogprobs,omitempty"`
+	Logprobs           *int                  `json:"logprobs,omitempty"`
 	ThinkingConfig     *GeminiThinkingConfig `json:"thinkingConfig,omitempty"`
 	SpeechConfig       json.RawMessage       `json:"speechConfig,omitempty"` // RawMessage to allow flexible speech config
 }
@@ -148,21 +171,75 @@ type GeminiChatCandidate struct {
 	FinishReason  *string                  `json:"finishReason"`
 	Index         int64                    `json:"index"`
 	SafetyRatings []GeminiChatSafetyRating `json:"safetyRatings"`
+	// Google Search 搜索增强返回的引用来源
+	GroundingMetadata *GeminiGroundingMetadata `json:"groundingMetadata,omitempty"`
+	AvgLogprobs       float64                  `json:"avgLogprobs,omitempty"`
+	LogprobsResult    *GeminiLogprobsResult    `json:"logprobsResult,omitempty"`
+}
+
+type GeminiLogprobsResult struct {
+	TopCandidates    []GeminiLogprobsTopCandidates `json:"topCandidates,omitempty"`
+	ChosenCandidates []GeminiLogprobsCandidate     `json:"chosenCandidates,omitempty"`
+}
+
+type GeminiLogprobsTopCandidates struct {
+	Candidates []GeminiLogprobsCandidate `json:"candidates,omitempty"`
+}
+
+type GeminiLogprobsCandidate struct {
+	Token          string  `json:"token"`
+	TokenId        int     `json:"tokenId,omitempty"`
+	LogProbability float64 `json:"logProbability"`
+}
+
+type GeminiGroundingMetadata struct {
+	WebSearchQueries  []string                 `json:"webSearchQueries,omitempty"`
+	GroundingChunks   []GeminiGroundingChunk   `json:"groundingChunks,omitempty"`
+	GroundingSupports []GeminiGroundingSupport `json:"groundingSupports,omitempty"`
+}
+
+type GeminiGroundingChunk struct {
+	Web *struct {
+		Uri   string `json:"uri"`
+		Title string `json:"title"`
+	} `json:"web,omitempty"`
+}
+
+type GeminiGroundingSupport struct {
+	Segment struct {
+		StartIndex int    `json:"startIndex"`
+		EndIndex   int    `json:"endIndex"`
+		Text       string `json:"text"`
+	} `json:"segment"`
+	GroundingChunkIndices []int `json:"groundingChunkIndices"`
 }
 
 type GeminiChatSafetyRating struct {
 	Category    string `json:"category"`
 	Probability string `json:"probability"`
+	Blocked     bool   `json:"blocked,omitempty"`
 }
 
 type GeminiChatPromptFeedback struct {
-	SafetyRatings []GeminiChatSafetyRating `json:"safetyRatings"`
+	SafetyRatings      []GeminiChatSafetyRating `json:"safetyRatings"`
+	BlockReason        *string                  `json:"blockReason,omitempty"`
+	BlockReasonMessage string                   `json:"blockReasonMessage,omitempty"`
 }
 
 type GeminiChatResponse struct {
 	Candidates     []GeminiChatCandidate    `json:"candidates"`
 	PromptFeedback GeminiChatPromptFeedback `json:"promptFeedback"`
 	UsageMetadata  GeminiUsageMetadata      `json:"usageMetadata"`
+	// 实际提供服务的模型版本，请求别名时可能与请求的模型不同
+	ModelVersion string `json:"modelVersion,omitempty"`
+	// 流式响应中途出错时上游返回的错误
+	Error *GeminiResponseError `json:"error,omitempty"`
+}
+
+type GeminiResponseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
 }
 
 type GeminiUsageMetadata struct {
@@ -0,0 +1,135 @@
+package gemini
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/relay/channel"
+	relaycommon "one-api/relay/common"
+	"one-api/setting/model_setting"
+	"one-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const geminiFinishReasonMalformedFunctionCall = "MALFORMED_FUNCTION_CALL"
+
+// geminiMalformedFunctionCallRepairPrompt 重试时追加到对话末尾的修复提示
+const geminiMalformedFunctionCallRepairPrompt = "Your previous function call was malformed and could not be parsed. " +
+	"Call the function again with arguments that are valid JSON and match the declared parameter schema."
+
+// isGeminiMalformedFunctionCall 所有候选都没有内容且因函数调用格式错误结束
+func isGeminiMalformedFunctionCall(response *GeminiChatResponse) bool {
+	if len(response.Candidates) == 0 {
+		return false
+	}
+	for _, candidate := range response.Candidates {
+		if len(candidate.Content.Parts) > 0 || candidate.FinishReason == nil || *candidate.FinishReason != geminiFinishReasonMalformedFunctionCall {
+			return false
+		}
+	}
+	return true
+}
+
+func newGeminiMalformedFunctionCallError() *types.NewAPIError {
+	return types.NewErrorWithStatusCode(errors.New("Gemini generated a malformed function call (finishReason MALFORMED_FUNCTION_CALL), please retry the request or simplify the tool schema"),
+		types.ErrorCodeMalformedFunctionCall, http.StatusBadGateway)
+}
+
+// shouldRetryGeminiMalformedFunctionCall 按配置对函数调用格式错误重试一次，重试后再次出错时直接返回错误
+func shouldRetryGeminiMalformedFunctionCall(c *gin.Context) bool {
+	return model_setting.GetGeminiSettings().MalformedFunctionCallRetry && !c.GetBool("gemini_malformed_function_call_retried")
+}
+
+// retryGeminiMalformedFunctionCall 在原请求的对话末尾追加修复提示后重新请求上游
+// 原请求体通过 GetBody 取回，地址与请求头（含鉴权）保持不变
+func retryGeminiMalformedFunctionCall(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Response) (*http.Response, error) {
+	c.Set("gemini_malformed_function_call_retried", true)
+	if resp.Request == nil || resp.Request.GetBody == nil {
+		return nil, errors.New("original request body is not available")
+	}
+	bodyReader, err := resp.Request.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	// 按原始 JSON 修改，避免往返序列化丢失请求中的其他字段
+	var request map[string]json.RawMessage
+	if err = common.Unmarshal(body, &request); err != nil {
+		return nil, err
+	}
+	var contents []json.RawMessage
+	if err = common.Unmarshal(request["contents"], &contents); err != nil {
+		return nil, err
+	}
+	repair, err := common.Marshal(GeminiChatContent{
+		Role:  "user",
+		Parts: []GeminiPart{{Text: geminiMalformedFunctionCallRepairPrompt}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if request["contents"], err = common.Marshal(append(contents, repair)); err != nil {
+		return nil, err
+	}
+	if body, err = common.Marshal(request); err != nil {
+		return nil, err
+	}
+
+	req := resp.Request.Clone(c.Request.Context())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	common.LogWarn(c, fmt.Sprintf("[GEMINI] Malformed function call, retrying with repair instruction | Model:%s", info.UpstreamModelName))
+	retryResp, err := channel.DoRequest(c, req, info)
+	if err != nil {
+		return nil, err
+	}
+	if retryResp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(retryResp.Body)
+		common.CloseResponseBodyGracefully(retryResp)
+		return nil, fmt.Errorf("status code %d: %s", retryResp.StatusCode, respBody)
+	}
+	return retryResp, nil
+}
+
+// addGeminiAttemptUsage 将格式错误那次请求的用量计入重试后的用量，两次请求均由上游计费
+func addGeminiAttemptUsage(usage *dto.Usage, attempt GeminiUsageMetadata) {
+	usage.PromptTokens += attempt.PromptTokenCount
+	if usage.PromptTokensDetails.TextTokens > 0 {
+		usage.PromptTokensDetails.TextTokens += attempt.PromptTokenCount
+	}
+	usage.CompletionTokens += attempt.TotalTokenCount - attempt.PromptTokenCount
+	usage.CompletionTokenDetails.ReasoningTokens += attempt.ThoughtsTokenCount
+	usage.TotalTokens += attempt.TotalTokenCount
+}
+
+// handleGeminiMalformedFunctionCall 未配置重试或重试失败时返回明确的错误，否则由 handler 处理重试后的响应
+func handleGeminiMalformedFunctionCall(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Response, attempt GeminiUsageMetadata,
+	handler func(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Response) (*dto.Usage, *types.NewAPIError)) (*dto.Usage, *types.NewAPIError) {
+	if !shouldRetryGeminiMalformedFunctionCall(c) {
+		common.LogWarn(c, fmt.Sprintf("[GEMINI] Malformed function call returned | Model:%s", info.UpstreamModelName))
+		return nil, newGeminiMalformedFunctionCallError()
+	}
+	retryResp, err := retryGeminiMalformedFunctionCall(c, info, resp)
+	if err != nil {
+		common.LogError(c, fmt.Sprintf("[GEMINI] Malformed function call retry failed | Error:%s", err.Error()))
+		return nil, newGeminiMalformedFunctionCallError()
+	}
+	usage, apiErr := handler(c, info, retryResp)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	addGeminiAttemptUsage(usage, attempt)
+	return usage, nil
+}
@@ -0,0 +1,119 @@
+package gemini
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/constant"
+	relaycommon "one-api/relay/common"
+	"one-api/service"
+	"one-api/setting/model_setting"
+	"one-api/types"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	geminiMalformedFunctionCallFixture = `{"candidates":[{"content":{"role":"model"},"finishReason":"MALFORMED_FUNCTION_CALL"}],"usageMetadata":{"promptTokenCount":8,"totalTokenCount":11}}`
+	geminiRepairedFunctionCallFixture  = `{"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"get_weather","args":{"city":"Paris"}}}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":20,"candidatesTokenCount":5,"totalTokenCount":25}}`
+)
+
+// newMalformedFunctionCallResponse 构造格式错误的上游响应，原请求指向 upstreamURL 以便重试时取回请求体
+func newMalformedFunctionCallResponse(t *testing.T, upstreamURL string, stream bool) *http.Response {
+	requestBody := `{"contents":[{"role":"user","parts":[{"text":"weather in Paris?"}]}],"tools":[{"functionDeclarations":[{"name":"get_weather"}]}]}`
+	req, err := http.NewRequest(http.MethodPost, upstreamURL, bytes.NewReader([]byte(requestBody)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, contentType := geminiMalformedFunctionCallFixture, "application/json"
+	if stream {
+		body, contentType = "data: "+body+"\n\n", "text/event-stream"
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{contentType}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}
+}
+
+func TestGeminiMalformedFunctionCall(t *testing.T) {
+	constant.StreamingTimeout = 60
+	service.InitHttpClient()
+	settings := model_setting.GetGeminiSettings()
+	original := settings.MalformedFunctionCallRetry
+	defer func() { settings.MalformedFunctionCallRetry = original }()
+
+	for _, retry := range []bool{false, true} {
+		for _, stream := range []bool{false, true} {
+			name := "error"
+			if retry {
+				name = "retry"
+			}
+			if stream {
+				name += " stream"
+			}
+			t.Run(name, func(t *testing.T) {
+				settings.MalformedFunctionCallRetry = retry
+				var calls int32
+				var retryBody []byte
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					atomic.AddInt32(&calls, 1)
+					retryBody, _ = io.ReadAll(r.Body)
+					if stream {
+						w.Header().Set("Content-Type", "text/event-stream")
+						w.Write([]byte("data: " + geminiRepairedFunctionCallFixture + "\n\n"))
+						return
+					}
+					w.Header().Set("Content-Type", "application/json")
+					w.Write([]byte(geminiRepairedFunctionCallFixture))
+				}))
+				defer server.Close()
+
+				gin.SetMode(gin.TestMode)
+				c, _ := gin.CreateTestContext(httptest.NewRecorder())
+				c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+				info := &relaycommon.RelayInfo{
+					RelayFormat:       relaycommon.RelayFormatOpenAI,
+					IsStream:          stream,
+					OriginModelName:   "gemini-2.5-flash",
+					UpstreamModelName: "gemini-2.5-flash",
+				}
+				resp := newMalformedFunctionCallResponse(t, server.URL, stream)
+				handler := GeminiChatHandler
+				if stream {
+					handler = GeminiChatStreamHandler
+				}
+				usage, apiErr := handler(c, info, resp)
+
+				if !retry {
+					if apiErr == nil || apiErr.GetErrorCode() != types.ErrorCodeMalformedFunctionCall || apiErr.StatusCode != http.StatusBadGateway {
+						t.Fatalf("error = %v, want malformed_function_call/502", apiErr)
+					}
+					if got := atomic.LoadInt32(&calls); got != 0 {
+						t.Errorf("upstream calls = %d, want no retry", got)
+					}
+					return
+				}
+				if apiErr != nil {
+					t.Fatalf("handler: %v", apiErr)
+				}
+				if got := atomic.LoadInt32(&calls); got != 1 {
+					t.Fatalf("upstream calls = %d, want exactly one retry", got)
+				}
+				// 重试请求保留原有字段，并在对话末尾追加修复提示
+				if !bytes.Contains(retryBody, []byte(`"functionDeclarations"`)) || !bytes.Contains(retryBody, []byte(geminiMalformedFunctionCallRepairPrompt)) {
+					t.Errorf("retry body = %s, want the original request with the repair instruction", retryBody)
+				}
+				// 两次请求的用量都计入
+				if usage == nil || usage.PromptTokens != 28 || usage.CompletionTokens != 8 || usage.TotalTokens != 36 {
+					t.Errorf("usage = %+v, want prompt 28, completion 8, total 36", usage)
+				}
+			})
+		}
+	}
+}
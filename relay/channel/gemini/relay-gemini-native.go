@@ -33,6 +33,9 @@ func GeminiTextGenerationHandler(c *gin.Context, info *relaycommon.RelayInfo, re
 	if err != nil {
 		return nil, types.NewError(err, types.ErrorCodeBadResponseBody)
 	}
+	recordGeminiModelVersion(c, info, geminiResponse.ModelVersion)
+	// 原生格式直接透传拦截结果，标记后由计费逻辑处理
+	markGeminiResponse(c, &geminiResponse)
 
 	// 计算使用量（基于 UsageMetadata）
 	usage := dto.Usage{
@@ -51,10 +54,13 @@ func GeminiTextGenerationHandler(c *gin.Context, info *relaycommon.RelayInfo, re
 		}
 	}
 
-	// 直接返回 Gemini 原生格式的 JSON 响应
-	jsonResponse, err := common.Marshal(geminiResponse)
-	if err != nil {
-		return nil, types.NewError(err, types.ErrorCodeBadResponseBody)
+	// 直接返回 Gemini 原生格式的 JSON 响应，透传模式下返回上游原始响应
+	jsonResponse := responseBody
+	if !info.ChannelSetting.GeminiPassThrough {
+		jsonResponse, err = common.Marshal(geminiResponse)
+		if err != nil {
+			return nil, types.NewError(err, types.ErrorCodeBadResponseBody)
+		}
 	}
 
 	common.IOCopyBytesGracefully(c, resp, jsonResponse)
@@ -65,6 +71,7 @@ func GeminiTextGenerationHandler(c *gin.Context, info *relaycommon.RelayInfo, re
 func GeminiTextGenerationStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Response) (*dto.Usage, *types.NewAPIError) {
 	var usage = &dto.Usage{}
 	var imageCount int
+	modelVersion := ""
 
 	helper.SetEventStreamHeaders(c)
 
@@ -78,6 +85,11 @@ func GeminiTextGenerationStreamHandler(c *gin.Context, info *relaycommon.RelayIn
 			return false
 		}
 
+		if geminiResponse.ModelVersion != "" {
+			modelVersion = geminiResponse.ModelVersion
+		}
+		markGeminiResponse(c, &geminiResponse)
+
 		// 统计图片数量
 		for _, candidate := range geminiResponse.Candidates {
 			for _, part := range candidate.Content.Parts {
@@ -113,6 +125,7 @@ func GeminiTextGenerationStreamHandler(c *gin.Context, info *relaycommon.RelayIn
 
 		return true
 	})
+	recordGeminiModelVersion(c, info, modelVersion)
 
 	if imageCount != 0 {
 		if usage.CompletionTokens == 0 {
@@ -0,0 +1,205 @@
+package gemini
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/types"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultTTSVoice      = "Kore"
+	defaultTTSFormat     = "wav"
+	defaultTTSSampleRate = 24000
+)
+
+// geminiTTSVoices Gemini TTS 支持的预置音色
+var geminiTTSVoices = []string{
+	"Zephyr", "Puck", "Charon", "Kore", "Fenrir", "Leda", "Orus", "Aoede", "Callirrhoe", "Autonoe",
+	"Enceladus", "Iapetus", "Umbriel", "Algieba", "Despina", "Erinome", "Algenib", "Rasalgethi", "Laomedeia", "Achernar",
+	"Alnilam", "Schedar", "Gacrux", "Pulcherrima", "Achird", "Zubenelgenubi", "Vindemiatrix", "Sadachbia", "Sadaltager", "Sulafat",
+}
+
+// geminiTTSContentTypes Gemini 返回 PCM 音频，仅支持无需转码的输出格式
+var geminiTTSContentTypes = map[string]string{
+	"wav": "audio/wav",
+	"pcm": "audio/pcm",
+}
+
+type GeminiSpeechConfig struct {
+	VoiceConfig GeminiVoiceConfig `json:"voiceConfig"`
+}
+
+type GeminiVoiceConfig struct {
+	PrebuiltVoiceConfig GeminiPrebuiltVoiceConfig `json:"prebuiltVoiceConfig"`
+}
+
+type GeminiPrebuiltVoiceConfig struct {
+	VoiceName string `json:"voiceName"`
+}
+
+func getGeminiTTSVoice(voice string) (string, error) {
+	if voice == "" {
+		return defaultTTSVoice, nil
+	}
+	for _, v := range geminiTTSVoices {
+		if strings.EqualFold(v, voice) {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("voice '%s' is not supported by Gemini TTS, supported voices are: %s", voice, strings.Join(geminiTTSVoices, ", "))
+}
+
+// ConvertAudioRequest2Gemini 将 OpenAI audio/speech 请求转换为 Gemini TTS 请求
+func ConvertAudioRequest2Gemini(info *relaycommon.RelayInfo, request dto.AudioRequest) (*GeminiChatRequest, error) {
+	if request.Input == "" {
+		return nil, errors.New("input is required")
+	}
+	voice, err := getGeminiTTSVoice(request.Voice)
+	if err != nil {
+		return nil, err
+	}
+	format := strings.ToLower(request.ResponseFormat)
+	if format == "" {
+		format = defaultTTSFormat
+	}
+	if _, ok := geminiTTSContentTypes[format]; !ok {
+		return nil, fmt.Errorf("response_format '%s' is not supported by Gemini TTS, supported formats are: wav, pcm", request.ResponseFormat)
+	}
+	info.OutputAudioFormat = format
+
+	speechConfig, err := common.Marshal(GeminiSpeechConfig{
+		VoiceConfig: GeminiVoiceConfig{
+			PrebuiltVoiceConfig: GeminiPrebuiltVoiceConfig{
+				VoiceName: voice,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &GeminiChatRequest{
+		Contents: []GeminiChatContent{
+			{
+				Role: "user",
+				Parts: []GeminiPart{
+					{
+						Text: request.Input,
+					},
+				},
+			},
+		},
+		GenerationConfig: GeminiChatGenerationConfig{
+			ResponseModalities: []string{"AUDIO"},
+			SpeechConfig:       speechConfig,
+		},
+	}, nil
+}
+
+// getPCMSampleRate 从 mimeType（如 audio/L16;codec=pcm;rate=24000）中解析采样率
+func getPCMSampleRate(mimeType string) int {
+	for _, param := range strings.Split(mimeType, ";") {
+		param = strings.TrimSpace(param)
+		if strings.HasPrefix(param, "rate=") {
+			if rate, err := strconv.Atoi(strings.TrimPrefix(param, "rate=")); err == nil && rate > 0 {
+				return rate
+			}
+		}
+	}
+	return defaultTTSSampleRate
+}
+
+// pcmToWav 为 16bit 单声道 PCM 数据添加 WAV 文件头
+func pcmToWav(pcm []byte, sampleRate int) []byte {
+	const channels = 1
+	const bitsPerSample = 16
+	byteRate := sampleRate * channels * bitsPerSample / 8
+	blockAlign := channels * bitsPerSample / 8
+
+	buf := bytes.NewBuffer(make([]byte, 0, 44+len(pcm)))
+	buf.WriteString("RIFF")
+	_ = binary.Write(buf, binary.LittleEndian, uint32(36+len(pcm)))
+	buf.WriteString("WAVE")
+	buf.WriteString("fmt ")
+	_ = binary.Write(buf, binary.LittleEndian, uint32(16))
+	_ = binary.Write(buf, binary.LittleEndian, uint16(1))
+	_ = binary.Write(buf, binary.LittleEndian, uint16(channels))
+	_ = binary.Write(buf, binary.LittleEndian, uint32(sampleRate))
+	_ = binary.Write(buf, binary.LittleEndian, uint32(byteRate))
+	_ = binary.Write(buf, binary.LittleEndian, uint16(blockAlign))
+	_ = binary.Write(buf, binary.LittleEndian, uint16(bitsPerSample))
+	buf.WriteString("data")
+	_ = binary.Write(buf, binary.LittleEndian, uint32(len(pcm)))
+	buf.Write(pcm)
+	return buf.Bytes()
+}
+
+// GeminiTTSHandler 解析 Gemini TTS 响应并按请求的格式返回音频
+func GeminiTTSHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Response) (*dto.Usage, *types.NewAPIError) {
+	defer common.CloseResponseBodyGracefully(resp)
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeBadResponseBody)
+	}
+	var geminiResponse GeminiChatResponse
+	if err := common.Unmarshal(responseBody, &geminiResponse); err != nil {
+		return nil, types.NewError(err, types.ErrorCodeBadResponseBody)
+	}
+	if blockedErr := getGeminiBlockedError(&geminiResponse); blockedErr != nil {
+		return nil, blockedErr
+	}
+
+	var audioData *GeminiInlineData
+	for _, candidate := range geminiResponse.Candidates {
+		for _, part := range candidate.Content.Parts {
+			if part.InlineData != nil && strings.HasPrefix(part.InlineData.MimeType, "audio/") {
+				audioData = part.InlineData
+				break
+			}
+		}
+		if audioData != nil {
+			break
+		}
+	}
+	if audioData == nil {
+		return nil, types.NewError(errors.New("no audio returned"), types.ErrorCodeBadResponseBody)
+	}
+	pcm, err := base64.StdEncoding.DecodeString(audioData.Data)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeBadResponseBody)
+	}
+
+	format := info.OutputAudioFormat
+	if _, ok := geminiTTSContentTypes[format]; !ok {
+		format = defaultTTSFormat
+	}
+	audio := pcm
+	if format == "wav" {
+		audio = pcmToWav(pcm, getPCMSampleRate(audioData.MimeType))
+	}
+	c.Data(http.StatusOK, geminiTTSContentTypes[format], audio)
+
+	usage := &dto.Usage{
+		PromptTokens:     geminiResponse.UsageMetadata.PromptTokenCount,
+		CompletionTokens: geminiResponse.UsageMetadata.CandidatesTokenCount,
+		TotalTokens:      geminiResponse.UsageMetadata.TotalTokenCount,
+	}
+	if usage.PromptTokens == 0 {
+		usage.PromptTokens = info.PromptTokens
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+	usage.CompletionTokenDetails.AudioTokens = usage.CompletionTokens
+	return usage, nil
+}
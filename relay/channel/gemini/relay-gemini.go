@@ -14,8 +14,10 @@ import (
 	"one-api/service"
 	"one-api/setting/model_setting"
 	"one-api/types"
+	"path"
 	"strconv"
 	"strings"
+	"time"
 	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
@@ -28,6 +30,9 @@ var geminiSupportedMimeTypes = map[string]bool{
 	"audio/wav":       true,
 	"image/png":       true,
 	"image/jpeg":      true,
+	"image/webp":      true,
+	"image/heic":      true,
+	"image/heif":      true,
 	"text/plain":      true,
 	"video/mov":       true,
 	"video/mpeg":      true,
@@ -39,6 +44,9 @@ var geminiSupportedMimeTypes = map[string]bool{
 	"video/flv":       true,
 }
 
+// Gemini 单次请求允许的最大候选数
+const geminiMaxCandidateCount = 8
+
 // Gemini 允许的思考预算范围
 const (
 	pro25MinBudget       = 128
@@ -80,6 +88,16 @@ func clampThinkingBudget(modelName string, budget int) int {
 	return budget
 }
 
+// capThinkingBudget 将思考预算限制在渠道或全局配置的上限内
+func capThinkingBudget(info *relaycommon.RelayInfo, budget int) int {
+	maxBudget := info.GetMaxThinkingBudgetTokens()
+	if maxBudget <= 0 || budget <= maxBudget {
+		return budget
+	}
+	common.SysLog(fmt.Sprintf("gemini thinking budget clamped for channel #%d, model %s: %d -> %d", info.ChannelId, info.UpstreamModelName, budget, maxBudget))
+	return maxBudget
+}
+
 func ThinkingAdaptor(geminiRequest *GeminiChatRequest, info *relaycommon.RelayInfo) {
 	if model_setting.GetGeminiSettings().ThinkingAdapterEnabled {
 		modelName := info.UpstreamModelName
@@ -91,7 +109,7 @@ func ThinkingAdaptor(geminiRequest *GeminiChatRequest, info *relaycommon.RelayIn
 			parts := strings.SplitN(modelName, "-thinking-", 2)
 			if len(parts) == 2 && parts[1] != "" {
 				if budgetTokens, err := strconv.Atoi(parts[1]); err == nil {
-					clampedBudget := clampThinkingBudget(modelName, budgetTokens)
+					clampedBudget := capThinkingBudget(info, clampThinkingBudget(modelName, budgetTokens))
 					geminiRequest.GenerationConfig.ThinkingConfig = &GeminiThinkingConfig{
 						ThinkingBudget:  common.GetPointer(clampedBudget),
 						IncludeThoughts: true,
@@ -121,7 +139,7 @@ func ThinkingAdaptor(geminiRequest *GeminiChatRequest, info *relaycommon.RelayIn
 				}
 				if geminiRequest.GenerationConfig.MaxOutputTokens > 0 {
 					budgetTokens := model_setting.GetGeminiSettings().ThinkingAdapterBudgetTokensPercentage * float64(geminiRequest.GenerationConfig.MaxOutputTokens)
-					clampedBudget := clampThinkingBudget(modelName, int(budgetTokens))
+					clampedBudget := capThinkingBudget(info, clampThinkingBudget(modelName, int(budgetTokens)))
 					geminiRequest.GenerationConfig.ThinkingConfig.ThinkingBudget = common.GetPointer(clampedBudget)
 				}
 			}
@@ -135,8 +153,74 @@ func ThinkingAdaptor(geminiRequest *GeminiChatRequest, info *relaycommon.RelayIn
 	}
 }
 
+// applyReasoningEffort 将 OpenAI 的 reasoning_effort 转换为 thinkingBudget
+// 模型名后缀（-thinking-<budget>、-nothinking）与 extra_body 中显式指定的预算优先
+func applyReasoningEffort(geminiRequest *GeminiChatRequest, info *relaycommon.RelayInfo, effort string) {
+	if effort == "" || strings.Contains(info.UpstreamModelName, "-thinking-") || strings.HasSuffix(info.UpstreamModelName, "-nothinking") {
+		return
+	}
+	budget, ok := model_setting.GetGeminiSettings().GetReasoningEffortBudget(effort)
+	if !ok {
+		common.SysLog(fmt.Sprintf("gemini reasoning effort %q ignored for channel #%d: not configured", effort, info.ChannelId))
+		return
+	}
+	thinkingConfig := geminiRequest.GenerationConfig.ThinkingConfig
+	if thinkingConfig == nil {
+		thinkingConfig = &GeminiThinkingConfig{}
+	}
+	thinkingConfig.SetThinkingBudget(capThinkingBudget(info, clampThinkingBudget(info.UpstreamModelName, budget)))
+	geminiRequest.GenerationConfig.ThinkingConfig = thinkingConfig
+}
+
+// geminiExtraBody 兼容 Gemini OpenAI 接口的 extra_body.google.thinking_config
+type geminiExtraBody struct {
+	Google *struct {
+		ThinkingConfig *struct {
+			IncludeThoughts *bool `json:"include_thoughts,omitempty"`
+			ThinkingBudget  *int  `json:"thinking_budget,omitempty"`
+		} `json:"thinking_config,omitempty"`
+	} `json:"google,omitempty"`
+}
+
+// applyExtraBodyThinkingConfig 客户端显式指定的 thinking_config 优先于 -thinking 后缀推断的配置，未指定时不返回思考内容
+func applyExtraBodyThinkingConfig(geminiRequest *GeminiChatRequest, extraBody json.RawMessage, modelName string) error {
+	if len(extraBody) == 0 {
+		return nil
+	}
+	var body geminiExtraBody
+	if err := common.Unmarshal(extraBody, &body); err != nil {
+		return fmt.Errorf("invalid extra_body: %w", err)
+	}
+	if body.Google == nil || body.Google.ThinkingConfig == nil {
+		return nil
+	}
+	thinkingConfig := geminiRequest.GenerationConfig.ThinkingConfig
+	if thinkingConfig == nil {
+		thinkingConfig = &GeminiThinkingConfig{}
+	}
+	if body.Google.ThinkingConfig.IncludeThoughts != nil {
+		thinkingConfig.IncludeThoughts = *body.Google.ThinkingConfig.IncludeThoughts
+	}
+	if budget := body.Google.ThinkingConfig.ThinkingBudget; budget != nil {
+		// -1 表示由模型动态决定预算，不做范围限制
+		if *budget < 0 {
+			thinkingConfig.SetThinkingBudget(-1)
+		} else {
+			thinkingConfig.SetThinkingBudget(clampThinkingBudget(modelName, *budget))
+		}
+	}
+	geminiRequest.GenerationConfig.ThinkingConfig = thinkingConfig
+	return nil
+}
+
 // Setting safety to the lowest possible values since Gemini is already powerless enough
 func CovertGemini2OpenAI(textRequest dto.GeneralOpenAIRequest, info *relaycommon.RelayInfo) (*GeminiChatRequest, error) {
+	if textRequest.N > 1 && info.IsStream {
+		return nil, errors.New("n > 1 is not supported for gemini stream requests")
+	}
+	if textRequest.N > geminiMaxCandidateCount {
+		return nil, fmt.Errorf("n must be less than or equal to %d for gemini", geminiMaxCandidateCount)
+	}
 
 	geminiRequest := GeminiChatRequest{
 		Contents: make([]GeminiChatContent, 0, len(textRequest.Messages)),
@@ -144,9 +228,46 @@ func CovertGemini2OpenAI(textRequest dto.GeneralOpenAIRequest, info *relaycommon
 			Temperature:     textRequest.Temperature,
 			TopP:            textRequest.TopP,
 			MaxOutputTokens: textRequest.MaxTokens,
-			Seed:            int64(textRequest.Seed),
 		},
 	}
+	if textRequest.N > 1 {
+		geminiRequest.GenerationConfig.CandidateCount = textRequest.N
+	}
+	// seed 为 0 也是有效取值，仅在客户端未传时省略
+	if textRequest.Seed != nil {
+		geminiRequest.GenerationConfig.Seed = common.GetPointer(int64(*textRequest.Seed))
+	}
+	if geminiRequest.GenerationConfig.MaxOutputTokens == 0 {
+		geminiRequest.GenerationConfig.MaxOutputTokens = textRequest.MaxCompletionTokens
+	}
+	if textRequest.PresencePenalty != 0 {
+		geminiRequest.GenerationConfig.PresencePenalty = common.GetPointer(textRequest.PresencePenalty)
+	}
+	if textRequest.FrequencyPenalty != 0 {
+		geminiRequest.GenerationConfig.FrequencyPenalty = common.GetPointer(textRequest.FrequencyPenalty)
+	}
+	if textRequest.Stop != nil {
+		// stop 可能是字符串或字符串数组
+		switch stop := textRequest.Stop.(type) {
+		case string:
+			geminiRequest.GenerationConfig.StopSequences = []string{stop}
+		case []interface{}:
+			stopSequences := make([]string, 0, len(stop))
+			for _, s := range stop {
+				if str, ok := s.(string); ok {
+					stopSequences = append(stopSequences, str)
+				}
+			}
+			geminiRequest.GenerationConfig.StopSequences = stopSequences
+		}
+	}
+
+	if textRequest.LogProbs {
+		geminiRequest.GenerationConfig.ResponseLogprobs = true
+		if textRequest.TopLogProbs > 0 {
+			geminiRequest.GenerationConfig.Logprobs = common.GetPointer(textRequest.TopLogProbs)
+		}
+	}
 
 	if model_setting.IsGeminiModelSupportImagine(info.UpstreamModelName) {
 		geminiRequest.GenerationConfig.ResponseModalities = []string{
@@ -154,8 +275,22 @@ func CovertGemini2OpenAI(textRequest dto.GeneralOpenAIRequest, info *relaycommon
 			"IMAGE",
 		}
 	}
+	// 客户端通过 modalities 指定输出模态时优先使用，如 ["text", "image"]
+	if len(textRequest.Modalities) > 0 {
+		modalities, err := parseResponseModalities(textRequest.Modalities)
+		if err != nil {
+			return nil, err
+		}
+		if len(modalities) > 0 {
+			geminiRequest.GenerationConfig.ResponseModalities = modalities
+		}
+	}
 
 	ThinkingAdaptor(&geminiRequest, info)
+	applyReasoningEffort(&geminiRequest, info, textRequest.ReasoningEffort)
+	if err := applyExtraBodyThinkingConfig(&geminiRequest, textRequest.ExtraBody, info.UpstreamModelName); err != nil {
+		return nil, err
+	}
 
 	safetySettings := make([]GeminiChatSafetySettings, 0, len(SafetySettingList))
 	for _, category := range SafetySettingList {
@@ -226,10 +361,20 @@ func CovertGemini2OpenAI(textRequest dto.GeneralOpenAIRequest, info *relaycommon
 	}
 	tool_call_ids := make(map[string]string)
 	var system_content []string
+	// 整个请求内图片的序号，用于在错误信息中定位有问题的附件
+	attachmentIndex := 0
+	// 图片地址提前并发下载，转换时按地址取用
+	imageFiles, err := service.GetFilesBase64FromUrls(service.CollectMessageImageUrls(textRequest.Messages))
+	if err != nil {
+		return nil, err
+	}
 	//shouldAddDummyModelMessage := false
 	for _, message := range textRequest.Messages {
-		if message.Role == "system" {
-			system_content = append(system_content, message.StringContent())
+		// system 与 developer 消息统一合并到 systemInstruction
+		if message.Role == "system" || message.Role == "developer" {
+			if text := message.StringContent(); text != "" {
+				system_content = append(system_content, text)
+			}
 			continue
 		} else if message.Role == "tool" || message.Role == "function" {
 			if len(geminiRequest.Contents) == 0 || geminiRequest.Contents[len(geminiRequest.Contents)-1].Role == "model" {
@@ -308,17 +453,30 @@ func CovertGemini2OpenAI(textRequest dto.GeneralOpenAIRequest, info *relaycommon
 				})
 			} else if part.Type == dto.ContentTypeImageURL {
 				imageNum += 1
+				attachmentIndex += 1
 
 				if constant.GeminiVisionMaxImageNum != -1 && imageNum > constant.GeminiVisionMaxImageNum {
 					return nil, fmt.Errorf("too many images in the message, max allowed is %d", constant.GeminiVisionMaxImageNum)
 				}
-				// 判断是否是url
-				if strings.HasPrefix(part.GetImageMedia().Url, "http") {
-					// 是url，获取文件的类型和base64编码的数据
-					fileData, err := service.GetFileBase64FromUrl(part.GetImageMedia().Url)
-					if err != nil {
-						return nil, fmt.Errorf("get file base64 from url '%s' failed: %w", part.GetImageMedia().Url, err)
+				// Vertex 可直接读取 GCS 上的文件，无需下载并转为 base64
+				if info.ChannelType == constant.ChannelTypeVertexAi && strings.HasPrefix(part.GetImageMedia().Url, "gs://") {
+					fileUri := part.GetImageMedia().Url
+					mimeType := part.GetImageMedia().MimeType
+					if mimeType == "" {
+						mimeType = service.GetMimeTypeByExtension(strings.TrimPrefix(path.Ext(fileUri), "."))
 					}
+					if _, ok := geminiSupportedMimeTypes[strings.ToLower(mimeType)]; !ok {
+						return nil, fmt.Errorf("mime type is not supported by Gemini: '%s', url: '%s', supported types are: %v", mimeType, fileUri, getSupportedMimeTypesList())
+					}
+					parts = append(parts, GeminiPart{
+						FileData: &GeminiFileData{
+							MimeType: mimeType,
+							FileUri:  fileUri,
+						},
+					})
+				} else if strings.HasPrefix(part.GetImageMedia().Url, "http") {
+					// 是url，使用已下载的文件类型和base64编码的数据
+					fileData := imageFiles[part.GetImageMedia().Url]
 
 					// 校验 MimeType 是否在 Gemini 支持的白名单中
 					if _, ok := geminiSupportedMimeTypes[strings.ToLower(fileData.MimeType)]; !ok {
@@ -332,6 +490,20 @@ func CovertGemini2OpenAI(textRequest dto.GeneralOpenAIRequest, info *relaycommon
 							Data:     fileData.Base64Data,
 						},
 					})
+				} else if strings.HasPrefix(part.GetImageMedia().Url, "data:") {
+					mimeType, base64String, err := service.ParseBase64DataURI(part.GetImageMedia().Url)
+					if err != nil {
+						return nil, types.NewErrorWithStatusCode(fmt.Errorf("invalid image_url at attachment index %d: %s", attachmentIndex, err.Error()), types.ErrorCodeInvalidRequest, http.StatusBadRequest)
+					}
+					if _, ok := geminiSupportedMimeTypes[mimeType]; !ok {
+						return nil, types.NewErrorWithStatusCode(fmt.Errorf("invalid image_url at attachment index %d: mime type '%s' is not supported by Gemini, supported types are: %v", attachmentIndex, mimeType, getSupportedMimeTypesList()), types.ErrorCodeInvalidRequest, http.StatusBadRequest)
+					}
+					parts = append(parts, GeminiPart{
+						InlineData: &GeminiInlineData{
+							MimeType: mimeType,
+							Data:     base64String,
+						},
+					})
 				} else {
 					format, base64String, err := service.DecodeBase64FileData(part.GetImageMedia().Url)
 					if err != nil {
@@ -344,6 +516,38 @@ func CovertGemini2OpenAI(textRequest dto.GeneralOpenAIRequest, info *relaycommon
 						},
 					})
 				}
+				if metadata := part.GetImageMedia().VideoMetadata; metadata != nil {
+					videoMetadata, err := convertVideoMetadata(metadata)
+					if err != nil {
+						return nil, types.NewErrorWithStatusCode(fmt.Errorf("invalid video_metadata at attachment index %d: %s", attachmentIndex, err.Error()), types.ErrorCodeInvalidRequest, http.StatusBadRequest)
+					}
+					parts[len(parts)-1].VideoMetadata = videoMetadata
+				}
+			} else if part.Type == dto.ContentTypeVideoUrl {
+				attachmentIndex += 1
+				videoUrl := part.GetVideoUrl()
+				if videoUrl == nil || videoUrl.Url == "" {
+					return nil, types.NewErrorWithStatusCode(fmt.Errorf("invalid video_url at attachment index %d: url is required", attachmentIndex), types.ErrorCodeInvalidRequest, http.StatusBadRequest)
+				}
+				// 视频以文件引用的方式传递，支持 gs:// 与 http(s) 地址
+				mimeType := service.GetMimeTypeByExtension(strings.TrimPrefix(path.Ext(videoUrl.Url), "."))
+				if !strings.HasPrefix(mimeType, "video/") {
+					mimeType = "video/mp4"
+				}
+				videoPart := GeminiPart{
+					FileData: &GeminiFileData{
+						MimeType: mimeType,
+						FileUri:  videoUrl.Url,
+					},
+				}
+				if videoUrl.VideoMetadata != nil {
+					videoMetadata, err := convertVideoMetadata(videoUrl.VideoMetadata)
+					if err != nil {
+						return nil, types.NewErrorWithStatusCode(fmt.Errorf("invalid video_metadata at attachment index %d: %s", attachmentIndex, err.Error()), types.ErrorCodeInvalidRequest, http.StatusBadRequest)
+					}
+					videoPart.VideoMetadata = videoMetadata
+				}
+				parts = append(parts, videoPart)
 			} else if part.Type == dto.ContentTypeFile {
 				if part.GetFile().FileId != "" {
 					return nil, fmt.Errorf("only base64 file is supported in gemini")
@@ -382,7 +586,12 @@ func CovertGemini2OpenAI(textRequest dto.GeneralOpenAIRequest, info *relaycommon
 			content.Role = "model"
 		}
 		if len(content.Parts) > 0 {
-			geminiRequest.Contents = append(geminiRequest.Contents, content)
+			// Gemini 不接受连续的同角色消息，合并到上一条消息中，parts 保持原有顺序
+			if last := len(geminiRequest.Contents) - 1; last >= 0 && geminiRequest.Contents[last].Role == content.Role {
+				geminiRequest.Contents[last].Parts = append(geminiRequest.Contents[last].Parts, content.Parts...)
+			} else {
+				geminiRequest.Contents = append(geminiRequest.Contents, content)
+			}
 		}
 	}
 
@@ -660,8 +869,8 @@ func responseGeminiChat2OpenAI(c *gin.Context, response *GeminiChatResponse) *dt
 		Created: common.GetTimestamp(),
 		Choices: make([]dto.OpenAITextResponseChoice, 0, len(response.Candidates)),
 	}
-	isToolCall := false
 	for _, candidate := range response.Candidates {
+		isToolCall := false
 		choice := dto.OpenAITextResponseChoice{
 			Index: int(candidate.Index),
 			Message: dto.Message{
@@ -679,8 +888,10 @@ func responseGeminiChat2OpenAI(c *gin.Context, response *GeminiChatResponse) *dt
 					if call := getResponseToolCall(&part); call != nil {
 						toolCalls = append(toolCalls, *call)
 					}
+				} else if part.InlineData != nil && strings.HasPrefix(part.InlineData.MimeType, "image") {
+					texts = append(texts, "![image](data:"+part.InlineData.MimeType+";base64,"+part.InlineData.Data+")")
 				} else if part.Thought {
-					choice.Message.ReasoningContent = part.Text
+					choice.Message.ReasoningContent += part.Text
 				} else {
 					if part.ExecutableCode != nil {
 						texts = append(texts, "```"+part.ExecutableCode.Language+"\n"+part.ExecutableCode.Code+"\n```")
@@ -701,15 +912,10 @@ func responseGeminiChat2OpenAI(c *gin.Context, response *GeminiChatResponse) *dt
 			choice.Message.SetStringContent(strings.Join(texts, "\n"))
 
 		}
+		choice.Message.Annotations = groundingToAnnotations(candidate.GroundingMetadata)
+		choice.Logprobs = convertGeminiLogprobs(candidate.LogprobsResult)
 		if candidate.FinishReason != nil {
-			switch *candidate.FinishReason {
-			case "STOP":
-				choice.FinishReason = constant.FinishReasonStop
-			case "MAX_TOKENS":
-				choice.FinishReason = constant.FinishReasonLength
-			default:
-				choice.FinishReason = constant.FinishReasonContentFilter
-			}
+			choice.FinishReason = geminiFinishReason2OpenAI(*candidate.FinishReason)
 		}
 		if isToolCall {
 			choice.FinishReason = constant.FinishReasonToolCalls
@@ -720,7 +926,143 @@ func responseGeminiChat2OpenAI(c *gin.Context, response *GeminiChatResponse) *dt
 	return &fullTextResponse
 }
 
-func streamResponseGeminiChat2OpenAI(geminiResponse *GeminiChatResponse) (*dto.ChatCompletionsStreamResponse, bool, bool) {
+// convertGeminiLogprobs 将 Gemini 的 logprobsResult 转换为 OpenAI 格式的 logprobs
+func convertGeminiLogprobs(result *GeminiLogprobsResult) *dto.ChoiceLogprobs {
+	if result == nil || len(result.ChosenCandidates) == 0 {
+		return nil
+	}
+	logprobs := &dto.ChoiceLogprobs{
+		Content: make([]dto.TokenLogprob, 0, len(result.ChosenCandidates)),
+	}
+	for i, chosen := range result.ChosenCandidates {
+		tokenLogprob := dto.TokenLogprob{
+			Token:       chosen.Token,
+			Logprob:     chosen.LogProbability,
+			Bytes:       tokenBytes(chosen.Token),
+			TopLogprobs: []dto.TopLogprob{},
+		}
+		// topCandidates 与 chosenCandidates 按位置一一对应
+		if i < len(result.TopCandidates) {
+			for _, top := range result.TopCandidates[i].Candidates {
+				tokenLogprob.TopLogprobs = append(tokenLogprob.TopLogprobs, dto.TopLogprob{
+					Token:   top.Token,
+					Logprob: top.LogProbability,
+					Bytes:   tokenBytes(top.Token),
+				})
+			}
+		}
+		logprobs.Content = append(logprobs.Content, tokenLogprob)
+	}
+	return logprobs
+}
+
+func tokenBytes(token string) []int {
+	result := make([]int, len(token))
+	for i := 0; i < len(token); i++ {
+		result[i] = int(token[i])
+	}
+	return result
+}
+
+// geminiResponseModalities Gemini 支持的输出模态
+var geminiResponseModalities = map[string]bool{
+	"TEXT":  true,
+	"IMAGE": true,
+	"AUDIO": true,
+}
+
+// parseResponseModalities 将 OpenAI 格式的 modalities 转换为 Gemini 的 responseModalities
+func parseResponseModalities(raw json.RawMessage) ([]string, error) {
+	var modalities []string
+	if err := common.Unmarshal(raw, &modalities); err != nil {
+		return nil, fmt.Errorf("invalid modalities: %w", err)
+	}
+	result := make([]string, 0, len(modalities))
+	for _, modality := range modalities {
+		upper := strings.ToUpper(modality)
+		if !geminiResponseModalities[upper] {
+			return nil, fmt.Errorf("unsupported modality '%s'", modality)
+		}
+		result = append(result, upper)
+	}
+	return result, nil
+}
+
+// geminiMaxVideoFps Gemini 支持的最大视频采样帧率
+const geminiMaxVideoFps = 24
+
+// convertVideoMetadata 校验视频截取区间与帧率，并转换为 Gemini 的时长格式
+func convertVideoMetadata(metadata *dto.VideoMetadata) (*GeminiVideoMetadata, error) {
+	if metadata.Fps < 0 || metadata.Fps > geminiMaxVideoFps {
+		return nil, fmt.Errorf("fps must be between 0 and %d", geminiMaxVideoFps)
+	}
+	videoMetadata := &GeminiVideoMetadata{Fps: metadata.Fps}
+	var start, end time.Duration
+	var err error
+	if metadata.StartOffset != "" {
+		if start, err = time.ParseDuration(metadata.StartOffset); err != nil || start < 0 {
+			return nil, fmt.Errorf("invalid start_offset '%s'", metadata.StartOffset)
+		}
+		videoMetadata.StartOffset = formatGeminiDuration(start)
+	}
+	if metadata.EndOffset != "" {
+		if end, err = time.ParseDuration(metadata.EndOffset); err != nil || end <= 0 {
+			return nil, fmt.Errorf("invalid end_offset '%s'", metadata.EndOffset)
+		}
+		if end <= start {
+			return nil, fmt.Errorf("end_offset must be greater than start_offset")
+		}
+		videoMetadata.EndOffset = formatGeminiDuration(end)
+	}
+	return videoMetadata, nil
+}
+
+func formatGeminiDuration(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "s"
+}
+
+// groundingToAnnotations 将搜索增强的引用来源转换为 url_citation 注释
+func groundingToAnnotations(metadata *GeminiGroundingMetadata) []dto.Annotation {
+	if metadata == nil || len(metadata.GroundingChunks) == 0 {
+		return nil
+	}
+	annotations := make([]dto.Annotation, 0, len(metadata.GroundingChunks))
+	cited := make(map[int]bool)
+	for _, support := range metadata.GroundingSupports {
+		for _, index := range support.GroundingChunkIndices {
+			if index < 0 || index >= len(metadata.GroundingChunks) || metadata.GroundingChunks[index].Web == nil {
+				continue
+			}
+			web := metadata.GroundingChunks[index].Web
+			annotations = append(annotations, dto.Annotation{
+				Type: "url_citation",
+				UrlCitation: &dto.UrlCitation{
+					Url:        web.Uri,
+					Title:      web.Title,
+					StartIndex: support.Segment.StartIndex,
+					EndIndex:   support.Segment.EndIndex,
+				},
+			})
+			cited[index] = true
+		}
+	}
+	// 没有对应文本片段的来源也一并返回
+	for i, chunk := range metadata.GroundingChunks {
+		if cited[i] || chunk.Web == nil {
+			continue
+		}
+		annotations = append(annotations, dto.Annotation{
+			Type: "url_citation",
+			UrlCitation: &dto.UrlCitation{
+				Url:   chunk.Web.Uri,
+				Title: chunk.Web.Title,
+			},
+		})
+	}
+	return annotations
+}
+
+func streamResponseGeminiChat2OpenAI(geminiResponse *GeminiChatResponse, toolCallStream *geminiToolCallStream) (*dto.ChatCompletionsStreamResponse, bool, bool) {
 	choices := make([]dto.ChatCompletionsStreamResponseChoice, 0, len(geminiResponse.Candidates))
 	isStop := false
 	hasImage := false
@@ -739,15 +1081,7 @@ func streamResponseGeminiChat2OpenAI(geminiResponse *GeminiChatResponse) (*dto.C
 		isTools := false
 		isThought := false
 		if candidate.FinishReason != nil {
-			// p := GeminiConvertFinishReason(*candidate.FinishReason)
-			switch *candidate.FinishReason {
-			case "STOP":
-				choice.FinishReason = &constant.FinishReasonStop
-			case "MAX_TOKENS":
-				choice.FinishReason = &constant.FinishReasonLength
-			default:
-				choice.FinishReason = &constant.FinishReasonContentFilter
-			}
+			choice.FinishReason = common.GetPointer(geminiFinishReason2OpenAI(*candidate.FinishReason))
 		}
 		for _, part := range candidate.Content.Parts {
 			if part.InlineData != nil {
@@ -757,10 +1091,11 @@ func streamResponseGeminiChat2OpenAI(geminiResponse *GeminiChatResponse) (*dto.C
 					hasImage = true
 				}
 			} else if part.FunctionCall != nil {
-				isTools = true
-				if call := getResponseToolCall(&part); call != nil {
-					call.SetIndex(len(choice.Delta.ToolCalls))
-					choice.Delta.ToolCalls = append(choice.Delta.ToolCalls, *call)
+				deltas, done := toolCallStream.toolCallDeltas(&part)
+				choice.Delta.ToolCalls = append(choice.Delta.ToolCalls, deltas...)
+				// 参数仍在分片返回时不结束
+				if done {
+					isTools = true
 				}
 			} else if part.Thought {
 				isThought = true
@@ -785,6 +1120,11 @@ func streamResponseGeminiChat2OpenAI(geminiResponse *GeminiChatResponse) (*dto.C
 		if isTools {
 			choice.FinishReason = &constant.FinishReasonToolCalls
 		}
+		// 流式响应中每个分片只包含本分片 token 的对数概率
+		if logprobs := convertGeminiLogprobs(candidate.LogprobsResult); logprobs != nil {
+			var value any = logprobs
+			choice.Logprobs = &value
+		}
 		choices = append(choices, choice)
 	}
 
@@ -794,12 +1134,109 @@ func streamResponseGeminiChat2OpenAI(geminiResponse *GeminiChatResponse) (*dto.C
 	return &response, isStop, hasImage
 }
 
+// geminiBlockedFinishReasons 候选内容被拦截时的 finishReason
+var geminiBlockedFinishReasons = map[string]bool{
+	"SAFETY":                   true,
+	"RECITATION":               true,
+	"BLOCKLIST":                true,
+	"PROHIBITED_CONTENT":       true,
+	"SPII":                     true,
+	"IMAGE_SAFETY":             true,
+	"IMAGE_PROHIBITED_CONTENT": true,
+	"IMAGE_RECITATION":         true,
+}
+
+// geminiFinishReason2OpenAI 将 Gemini 的 finishReason 转换为 OpenAI 的 finish_reason，未知原因按 stop 处理
+func geminiFinishReason2OpenAI(reason string) string {
+	switch {
+	case reason == "STOP":
+		return constant.FinishReasonStop
+	case reason == "MAX_TOKENS":
+		return constant.FinishReasonLength
+	case reason == "TOOL_CALLS":
+		return constant.FinishReasonToolCalls
+	case geminiBlockedFinishReasons[reason]:
+		return constant.FinishReasonContentFilter
+	}
+	if common.DebugEnabled {
+		common.SysLog(fmt.Sprintf("[GEMINI] Unknown finish reason mapped to stop | FinishReason:%s", reason))
+	}
+	return constant.FinishReasonStop
+}
+
+// getGeminiBlockedError 检查 prompt 或候选内容是否被 Gemini 拦截，被拦截时返回带原因的错误
+func getGeminiBlockedError(response *GeminiChatResponse) *types.NewAPIError {
+	if response.PromptFeedback.BlockReason != nil && *response.PromptFeedback.BlockReason != "" {
+		return newGeminiBlockedError("prompt", types.ErrorCodePromptBlocked, *response.PromptFeedback.BlockReason, response.PromptFeedback.SafetyRatings)
+	}
+	if len(response.Candidates) == 0 {
+		return nil
+	}
+	// 所有候选都没有内容且因拦截结束时才视为错误
+	for _, candidate := range response.Candidates {
+		if len(candidate.Content.Parts) > 0 || candidate.FinishReason == nil || !geminiBlockedFinishReasons[*candidate.FinishReason] {
+			return nil
+		}
+	}
+	candidate := response.Candidates[0]
+	return newGeminiBlockedError("response", types.ErrorCodeResponseBlocked, *candidate.FinishReason, candidate.SafetyRatings)
+}
+
+// markGeminiResponse 记录响应是否被拦截或以工具调用结束，计费时按空响应策略处理
+func markGeminiResponse(c *gin.Context, response *GeminiChatResponse) {
+	if getGeminiBlockedError(response) != nil {
+		common.SetContextKey(c, constant.ContextKeyResponseBlocked, true)
+	}
+	for _, candidate := range response.Candidates {
+		for _, part := range candidate.Content.Parts {
+			if part.FunctionCall != nil {
+				common.SetContextKey(c, constant.ContextKeyResponseToolUse, true)
+				return
+			}
+		}
+	}
+}
+
+func newGeminiBlockedError(target string, code types.ErrorCode, reason string, ratings []GeminiChatSafetyRating) *types.NewAPIError {
+	categories := make([]string, 0, len(ratings))
+	for _, rating := range ratings {
+		if rating.Blocked {
+			categories = append(categories, rating.Category)
+		}
+	}
+	message := fmt.Sprintf("%s blocked by Gemini, reason: %s", target, reason)
+	if len(categories) > 0 {
+		message += ", categories: " + strings.Join(categories, ",")
+	}
+	return types.NewErrorWithStatusCode(errors.New(message), code, http.StatusBadRequest)
+}
+
+// recordGeminiModelVersion 记录上游实际提供服务的模型版本，写入日志并随消费记录保存，便于复现
+func recordGeminiModelVersion(c *gin.Context, info *relaycommon.RelayInfo, modelVersion string) {
+	if modelVersion == "" {
+		return
+	}
+	common.SetContextKey(c, constant.ContextKeyModelVersion, modelVersion)
+	common.LogInfo(c, fmt.Sprintf("[GEMINI] Served model version | Model:%s | ModelVersion:%s", info.UpstreamModelName, modelVersion))
+}
+
 func GeminiChatStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Response) (*dto.Usage, *types.NewAPIError) {
 	// responseText := ""
 	id := helper.GetResponseID(c)
 	createAt := common.GetTimestamp()
 	var usage = &dto.Usage{}
 	var imageCount int
+	var sentCount int
+	var blockedErr *types.NewAPIError
+	var streamErr *types.NewAPIError
+	// 流式响应中的引用来源可能分散在多个分片，累积后随结束分片一并返回
+	var annotations []dto.Annotation
+	annotationSeen := make(map[dto.UrlCitation]bool)
+	stopSent := false
+	toolCallStream := &geminiToolCallStream{}
+	modelVersion := ""
+	var upstreamUsage *GeminiUsageMetadata
+	var malformedUsage *GeminiUsageMetadata
 
 	helper.StreamScannerHandler(c, resp, info, func(data string) bool {
 		var geminiResponse GeminiChatResponse
@@ -808,15 +1245,45 @@ func GeminiChatStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *
 			common.LogError(c, "error unmarshalling stream response: "+err.Error())
 			return false
 		}
+		if geminiResponse.ModelVersion != "" {
+			modelVersion = geminiResponse.ModelVersion
+		}
+		// 尚未输出内容时被拦截，直接返回拦截原因
+		if sentCount == 0 {
+			if blockedErr = getGeminiBlockedError(&geminiResponse); blockedErr != nil {
+				return false
+			}
+			if isGeminiMalformedFunctionCall(&geminiResponse) {
+				malformedUsage = &geminiResponse.UsageMetadata
+				return false
+			}
+		}
+		// 已输出部分内容后被拦截或调用工具，标记后由计费逻辑处理
+		markGeminiResponse(c, &geminiResponse)
+		// 已输出部分内容后上游出错，按配置保留已输出的内容并补发错误事件
+		if geminiResponse.Error != nil && sentCount > 0 && model_setting.GetGlobalSettings().StreamPartialContentOnError {
+			streamErr = types.NewOpenAIError(errors.New(geminiResponse.Error.Message), types.ErrorCodeBadResponse, geminiResponse.Error.Code)
+			return false
+		}
 
-		response, isStop, hasImage := streamResponseGeminiChat2OpenAI(&geminiResponse)
+		for _, candidate := range geminiResponse.Candidates {
+			for _, annotation := range groundingToAnnotations(candidate.GroundingMetadata) {
+				if !annotationSeen[*annotation.UrlCitation] {
+					annotationSeen[*annotation.UrlCitation] = true
+					annotations = append(annotations, annotation)
+				}
+			}
+		}
+
+		response, isStop, hasImage := streamResponseGeminiChat2OpenAI(&geminiResponse, toolCallStream)
 		if hasImage {
 			imageCount++
 		}
 		response.Id = id
 		response.Created = createAt
-		response.Model = info.UpstreamModelName
+		response.Model = info.ResponseModel(info.UpstreamModelName)
 		if geminiResponse.UsageMetadata.TotalTokenCount != 0 {
+			upstreamUsage = &geminiResponse.UsageMetadata
 			usage.PromptTokens = geminiResponse.UsageMetadata.PromptTokenCount
 			usage.CompletionTokens = geminiResponse.UsageMetadata.CandidatesTokenCount
 			usage.CompletionTokenDetails.ReasoningTokens = geminiResponse.UsageMetadata.ThoughtsTokenCount
@@ -833,12 +1300,37 @@ func GeminiChatStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *
 		if err != nil {
 			common.LogError(c, err.Error())
 		}
+		sentCount++
 		if isStop {
-			response := helper.GenerateStopResponse(id, createAt, info.UpstreamModelName, constant.FinishReasonStop)
+			response := helper.GenerateStopResponse(id, createAt, info.ResponseModel(info.UpstreamModelName), constant.FinishReasonStop)
+			response.Choices[0].Delta.Annotations = annotations
 			helper.ObjectData(c, response)
+			stopSent = true
 		}
 		return true
 	})
+	recordGeminiModelVersion(c, info, modelVersion)
+	if blockedErr != nil {
+		return nil, blockedErr
+	}
+	if malformedUsage != nil {
+		return handleGeminiMalformedFunctionCall(c, info, resp, *malformedUsage, GeminiChatStreamHandler)
+	}
+	if streamErr == nil {
+		// 流在函数调用参数返回完整前结束，未完成的调用不输出，补发错误事件
+		streamErr = toolCallStream.incompleteError()
+	}
+	if streamErr != nil {
+		common.LogWarn(c, fmt.Sprintf("gemini stream failed midway, keep partial content: %s", streamErr.Error()))
+		helper.StreamErrorData(c, info, streamErr)
+	}
+	if !stopSent && len(annotations) > 0 {
+		// 非正常结束时结束原因已随上游分片发送，这里只补发引用来源
+		response := helper.GenerateStopResponse(id, createAt, info.ResponseModel(info.UpstreamModelName), constant.FinishReasonStop)
+		response.Choices[0].FinishReason = nil
+		response.Choices[0].Delta.Annotations = annotations
+		helper.ObjectData(c, response)
+	}
 
 	var response *dto.ChatCompletionsStreamResponse
 
@@ -852,7 +1344,11 @@ func GeminiChatStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *
 	usage.CompletionTokens = usage.TotalTokens - usage.PromptTokens
 
 	if info.ShouldIncludeUsage {
-		response = helper.GenerateFinalUsageResponse(id, createAt, info.UpstreamModelName, *usage)
+		finalUsage := *usage
+		if info.IncludeUpstreamUsage && upstreamUsage != nil {
+			finalUsage.UpstreamUsage = upstreamUsage
+		}
+		response = helper.GenerateFinalUsageResponse(id, createAt, info.ResponseModel(info.UpstreamModelName), finalUsage)
 		err := helper.ObjectData(c, response)
 		if err != nil {
 			common.SysError("send final response failed: " + err.Error())
@@ -877,11 +1373,19 @@ func GeminiChatHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.R
 	if err != nil {
 		return nil, types.NewError(err, types.ErrorCodeBadResponseBody)
 	}
+	recordGeminiModelVersion(c, info, geminiResponse.ModelVersion)
+	if blockedErr := getGeminiBlockedError(&geminiResponse); blockedErr != nil {
+		return nil, blockedErr
+	}
+	if isGeminiMalformedFunctionCall(&geminiResponse) {
+		return handleGeminiMalformedFunctionCall(c, info, resp, geminiResponse.UsageMetadata, GeminiChatHandler)
+	}
 	if len(geminiResponse.Candidates) == 0 {
 		return nil, types.NewError(errors.New("no candidates returned"), types.ErrorCodeBadResponseBody)
 	}
+	markGeminiResponse(c, &geminiResponse)
 	fullTextResponse := responseGeminiChat2OpenAI(c, &geminiResponse)
-	fullTextResponse.Model = info.UpstreamModelName
+	fullTextResponse.Model = info.ResponseModel(info.UpstreamModelName)
 	usage := dto.Usage{
 		PromptTokens:     geminiResponse.UsageMetadata.PromptTokenCount,
 		CompletionTokens: geminiResponse.UsageMetadata.CandidatesTokenCount,
@@ -900,6 +1404,9 @@ func GeminiChatHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.R
 	}
 
 	fullTextResponse.Usage = usage
+	if info.IncludeUpstreamUsage {
+		fullTextResponse.Usage.UpstreamUsage = geminiResponse.UsageMetadata
+	}
 	jsonResponse, err := json.Marshal(fullTextResponse)
 	if err != nil {
 		return nil, types.NewError(err, types.ErrorCodeBadResponseBody)
@@ -933,7 +1440,7 @@ func GeminiEmbeddingHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *h
 				Index:     0,
 			},
 		},
-		Model: info.UpstreamModelName,
+		Model: info.ResponseModel(info.UpstreamModelName),
 	}
 
 	// calculate usage
@@ -0,0 +1,800 @@
+package gemini
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/setting/model_setting"
+	"one-api/types"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Gemini 拦截 prompt 与候选内容时的响应
+const (
+	geminiPromptSafetyBlockFixture   = `{"promptFeedback":{"blockReason":"SAFETY","safetyRatings":[{"category":"HARM_CATEGORY_DANGEROUS_CONTENT","probability":"HIGH","blocked":true},{"category":"HARM_CATEGORY_HARASSMENT","probability":"NEGLIGIBLE"}]},"usageMetadata":{"promptTokenCount":8,"totalTokenCount":8}}`
+	geminiResponseSafetyBlockFixture = `{"candidates":[{"content":{"role":"model"},"finishReason":"SAFETY","safetyRatings":[{"category":"HARM_CATEGORY_SEXUALLY_EXPLICIT","probability":"HIGH","blocked":true}]}],"usageMetadata":{"promptTokenCount":8,"totalTokenCount":8}}`
+	geminiRecitationBlockFixture     = `{"candidates":[{"content":{"role":"model"},"finishReason":"RECITATION"}],"usageMetadata":{"promptTokenCount":8,"totalTokenCount":8}}`
+)
+
+func TestGeminiBlockedResponses(t *testing.T) {
+	constant.StreamingTimeout = 60
+	tests := []struct {
+		name     string
+		fixture  string
+		code     types.ErrorCode
+		contains []string
+	}{
+		{"prompt safety", geminiPromptSafetyBlockFixture, types.ErrorCodePromptBlocked, []string{"prompt blocked", "SAFETY", "HARM_CATEGORY_DANGEROUS_CONTENT"}},
+		{"response safety", geminiResponseSafetyBlockFixture, types.ErrorCodeResponseBlocked, []string{"response blocked", "SAFETY", "HARM_CATEGORY_SEXUALLY_EXPLICIT"}},
+		{"response recitation", geminiRecitationBlockFixture, types.ErrorCodeResponseBlocked, []string{"response blocked", "RECITATION"}},
+	}
+	for _, tt := range tests {
+		for _, stream := range []bool{false, true} {
+			name := tt.name
+			if stream {
+				name += " stream"
+			}
+			t.Run(name, func(t *testing.T) {
+				gin.SetMode(gin.TestMode)
+				c, _ := gin.CreateTestContext(httptest.NewRecorder())
+				c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+				info := &relaycommon.RelayInfo{
+					RelayFormat:       relaycommon.RelayFormatOpenAI,
+					IsStream:          stream,
+					OriginModelName:   "gemini-2.5-flash",
+					UpstreamModelName: "gemini-2.5-flash",
+				}
+				body, contentType := tt.fixture, "application/json"
+				if stream {
+					body, contentType = "data: "+tt.fixture+"\n\n", "text/event-stream"
+				}
+				resp := &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{"Content-Type": []string{contentType}},
+					Body:       io.NopCloser(strings.NewReader(body)),
+				}
+				var apiErr *types.NewAPIError
+				if stream {
+					_, apiErr = GeminiChatStreamHandler(c, info, resp)
+				} else {
+					_, apiErr = GeminiChatHandler(c, info, resp)
+				}
+				if apiErr == nil {
+					t.Fatal("expected a blocked error")
+				}
+				if apiErr.GetErrorCode() != tt.code || apiErr.StatusCode != http.StatusBadRequest {
+					t.Errorf("error = %s/%d, want %s/400", apiErr.GetErrorCode(), apiErr.StatusCode, tt.code)
+				}
+				for _, want := range tt.contains {
+					if !strings.Contains(apiErr.Error(), want) {
+						t.Errorf("error %q does not mention %s", apiErr.Error(), want)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestGeminiNativeHandlerMarksBlockedAndToolUseResponses(t *testing.T) {
+	const toolCallFixture = `{"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"get_weather","args":{"city":"Paris"}}}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":8,"totalTokenCount":8}}`
+	tests := []struct {
+		name    string
+		fixture string
+		key     constant.ContextKey
+	}{
+		{"safety block", geminiResponseSafetyBlockFixture, constant.ContextKeyResponseBlocked},
+		{"recitation block", geminiRecitationBlockFixture, constant.ContextKeyResponseBlocked},
+		{"tool call", toolCallFixture, constant.ContextKeyResponseToolUse},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1beta/models/gemini-2.5-flash:generateContent", nil)
+			resp := &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+				Body:       io.NopCloser(strings.NewReader(tt.fixture)),
+			}
+			if _, apiErr := GeminiTextGenerationHandler(c, &relaycommon.RelayInfo{UpstreamModelName: "gemini-2.5-flash"}, resp); apiErr != nil {
+				t.Fatalf("GeminiTextGenerationHandler: %v", apiErr)
+			}
+			if !common.GetContextKeyBool(c, tt.key) {
+				t.Errorf("%s was not marked", tt.key)
+			}
+		})
+	}
+}
+
+func newGeminiConvertRequest(t *testing.T, body string) dto.GeneralOpenAIRequest {
+	t.Helper()
+	var request dto.GeneralOpenAIRequest
+	if err := common.UnmarshalJsonStr(body, &request); err != nil {
+		t.Fatalf("unmarshal request: %v", err)
+	}
+	return request
+}
+
+func newVertexGeminiInfo() *relaycommon.RelayInfo {
+	return &relaycommon.RelayInfo{
+		ChannelType:       constant.ChannelTypeVertexAi,
+		OriginModelName:   "gemini-2.5-flash",
+		UpstreamModelName: "gemini-2.5-flash",
+	}
+}
+
+func TestCovertGemini2OpenAIUsesGcsImageAsFileData(t *testing.T) {
+	constant.GeminiVisionMaxImageNum = 16
+	request := newGeminiConvertRequest(t, `{"model":"gemini-2.5-flash","messages":[{"role":"user","content":[
+		{"type":"text","text":"describe"},
+		{"type":"image_url","image_url":{"url":"gs://test-bucket/images/cat.png"}}]}]}`)
+	geminiRequest, err := CovertGemini2OpenAI(request, newVertexGeminiInfo())
+	if err != nil {
+		t.Fatalf("CovertGemini2OpenAI: %v", err)
+	}
+	parts := geminiRequest.Contents[0].Parts
+	if len(parts) != 2 || parts[1].FileData == nil {
+		t.Fatalf("parts = %+v, want text and fileData", parts)
+	}
+	if parts[1].FileData.FileUri != "gs://test-bucket/images/cat.png" || parts[1].FileData.MimeType != "image/png" {
+		t.Errorf("fileData = %+v, want the gs:// uri with image/png", parts[1].FileData)
+	}
+	if parts[1].InlineData != nil {
+		t.Error("a gs:// image must not be inlined")
+	}
+}
+
+func TestCovertGemini2OpenAICollapsesSystemAndDeveloperMessages(t *testing.T) {
+	request := newGeminiConvertRequest(t, `{"model":"gemini-2.5-flash","messages":[
+		{"role":"system","content":"You are a helpful assistant."},
+		{"role":"system","content":"Answer in French."},
+		{"role":"developer","content":"Keep answers short."},
+		{"role":"user","content":"hello"}]}`)
+	geminiRequest, err := CovertGemini2OpenAI(request, newVertexGeminiInfo())
+	if err != nil {
+		t.Fatalf("CovertGemini2OpenAI: %v", err)
+	}
+	if geminiRequest.SystemInstructions == nil || len(geminiRequest.SystemInstructions.Parts) != 1 {
+		t.Fatalf("systemInstruction = %+v, want a single part", geminiRequest.SystemInstructions)
+	}
+	want := "You are a helpful assistant.\nAnswer in French.\nKeep answers short."
+	if got := geminiRequest.SystemInstructions.Parts[0].Text; got != want {
+		t.Errorf("systemInstruction = %q, want %q", got, want)
+	}
+	if len(geminiRequest.Contents) != 1 || geminiRequest.Contents[0].Role != "user" || geminiRequest.Contents[0].Parts[0].Text != "hello" {
+		t.Errorf("contents = %+v, want only the user turn", geminiRequest.Contents)
+	}
+}
+
+func TestCovertGemini2OpenAIMergesConsecutiveSameRoleMessages(t *testing.T) {
+	constant.GeminiVisionMaxImageNum = 16
+	request := newGeminiConvertRequest(t, `{"model":"gemini-2.5-flash","messages":[
+		{"role":"user","content":"first"},
+		{"role":"user","content":[
+			{"type":"text","text":"second"},
+			{"type":"image_url","image_url":{"url":"gs://test-bucket/images/cat.png"}}]},
+		{"role":"user","content":"third"},
+		{"role":"assistant","content":"answer"},
+		{"role":"user","content":"follow up"}]}`)
+	geminiRequest, err := CovertGemini2OpenAI(request, newVertexGeminiInfo())
+	if err != nil {
+		t.Fatalf("CovertGemini2OpenAI: %v", err)
+	}
+	contents := geminiRequest.Contents
+	if len(contents) != 3 || contents[0].Role != "user" || contents[1].Role != "model" || contents[2].Role != "user" {
+		t.Fatalf("contents = %+v, want merged user, model and user turns", contents)
+	}
+	parts := contents[0].Parts
+	if len(parts) != 4 || parts[0].Text != "first" || parts[1].Text != "second" || parts[2].FileData == nil || parts[3].Text != "third" {
+		t.Errorf("merged parts = %+v, want first, second, the image and third in order", parts)
+	}
+}
+
+func TestGeminiMultipleCandidates(t *testing.T) {
+	request := newGeminiConvertRequest(t, `{"model":"gemini-2.5-flash","n":3,"messages":[{"role":"user","content":"name a colour"}]}`)
+	geminiRequest, err := CovertGemini2OpenAI(request, newVertexGeminiInfo())
+	if err != nil {
+		t.Fatalf("CovertGemini2OpenAI: %v", err)
+	}
+	if geminiRequest.GenerationConfig.CandidateCount != 3 {
+		t.Errorf("candidateCount = %d, want 3", geminiRequest.GenerationConfig.CandidateCount)
+	}
+	streamInfo := newVertexGeminiInfo()
+	streamInfo.IsStream = true
+	if _, err := CovertGemini2OpenAI(request, streamInfo); err == nil {
+		t.Error("n > 1 with stream should be rejected")
+	}
+
+	const candidatesFixture = `{"candidates":[
+		{"index":0,"content":{"role":"model","parts":[{"text":"red"}]},"finishReason":"STOP"},
+		{"index":1,"content":{"role":"model","parts":[{"text":"green"}]},"finishReason":"STOP"},
+		{"index":2,"content":{"role":"model","parts":[{"text":"blue"}]},"finishReason":"STOP"}],
+		"usageMetadata":{"promptTokenCount":5,"candidatesTokenCount":3,"totalTokenCount":8}}`
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	info := newVertexGeminiInfo()
+	info.RelayFormat = relaycommon.RelayFormatOpenAI
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(candidatesFixture)),
+	}
+	usage, apiErr := GeminiChatHandler(c, info, resp)
+	if apiErr != nil {
+		t.Fatalf("GeminiChatHandler: %v", apiErr)
+	}
+	var response dto.OpenAITextResponse
+	if err := common.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("unmarshal %s: %v", recorder.Body.String(), err)
+	}
+	if len(response.Choices) != 3 {
+		t.Fatalf("got %d choices, want 3", len(response.Choices))
+	}
+	for i, want := range []string{"red", "green", "blue"} {
+		if response.Choices[i].Index != i || response.Choices[i].Message.StringContent() != want {
+			t.Errorf("choice %d = %d/%q, want %d/%q", i, response.Choices[i].Index, response.Choices[i].Message.StringContent(), i, want)
+		}
+	}
+	if usage.PromptTokens != 5 || usage.CompletionTokens != 3 || usage.TotalTokens != 8 {
+		t.Errorf("usage = %d/%d/%d, want 5/3/8 across all candidates", usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens)
+	}
+}
+
+func TestGeminiIncludeThoughts(t *testing.T) {
+	const thoughtFixture = `{"candidates":[{"index":0,"content":{"role":"model","parts":[{"text":"Considering the colours.","thought":true},{"text":"blue"}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":5,"candidatesTokenCount":1,"thoughtsTokenCount":4,"totalTokenCount":10}}`
+	tests := []struct {
+		name      string
+		extraBody string
+		want      bool
+	}{
+		{"default", "", false},
+		{"on", `,"extra_body":{"google":{"thinking_config":{"include_thoughts":true}}}`, true},
+		{"off", `,"extra_body":{"google":{"thinking_config":{"include_thoughts":false}}}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := newGeminiConvertRequest(t, `{"model":"gemini-2.5-flash","messages":[{"role":"user","content":"name a colour"}]`+tt.extraBody+`}`)
+			geminiRequest, err := CovertGemini2OpenAI(request, newVertexGeminiInfo())
+			if err != nil {
+				t.Fatalf("CovertGemini2OpenAI: %v", err)
+			}
+			thinkingConfig := geminiRequest.GenerationConfig.ThinkingConfig
+			if got := thinkingConfig != nil && thinkingConfig.IncludeThoughts; got != tt.want {
+				t.Errorf("includeThoughts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// 返回的思考内容映射为 reasoning_content，与回答内容分开
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	var response GeminiChatResponse
+	if err := common.UnmarshalJsonStr(thoughtFixture, &response); err != nil {
+		t.Fatalf("unmarshal fixture: %v", err)
+	}
+	openaiResponse := responseGeminiChat2OpenAI(c, &response)
+	message := openaiResponse.Choices[0].Message
+	if message.ReasoningContent != "Considering the colours." || message.StringContent() != "blue" {
+		t.Errorf("reasoning = %q, content = %q, want the thought and answer kept apart", message.ReasoningContent, message.StringContent())
+	}
+}
+
+func TestCovertGemini2OpenAIRejectsMalformedDataURI(t *testing.T) {
+	constant.GeminiVisionMaxImageNum = 16
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"missing base64 marker", "data:image/png,iVBORw0KGgo=", "missing ';base64' marker"},
+		{"unsupported mime", "data:image/bmp;base64,Qk0=", "mime type 'image/bmp' is not supported"},
+		{"invalid base64", "data:image/png;base64,%%%", "invalid base64 data"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 第一张图片合法，第二张有问题，错误信息应指出附件序号 2
+			request := newGeminiConvertRequest(t, `{"model":"gemini-2.5-flash","messages":[{"role":"user","content":[
+				{"type":"image_url","image_url":{"url":"data:image/png;base64,iVBORw0KGgo="}},
+				{"type":"image_url","image_url":{"url":"`+tt.url+`"}}]}]}`)
+			_, err := CovertGemini2OpenAI(request, newVertexGeminiInfo())
+			apiErr, ok := err.(*types.NewAPIError)
+			if !ok {
+				t.Fatalf("err = %v, want a NewAPIError", err)
+			}
+			if apiErr.GetErrorCode() != types.ErrorCodeInvalidRequest || apiErr.StatusCode != http.StatusBadRequest {
+				t.Errorf("error = %s/%d, want %s/400", apiErr.GetErrorCode(), apiErr.StatusCode, types.ErrorCodeInvalidRequest)
+			}
+			if !strings.Contains(err.Error(), "attachment index 2") || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want attachment index 2 and %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCovertGemini2OpenAIMapsGenerationParameters(t *testing.T) {
+	tests := []struct {
+		name   string
+		params string
+		want   string
+	}{
+		{"stop string", `"stop":"END"`, `"stopSequences":["END"]`},
+		{"stop array", `"stop":["END","STOP"]`, `"stopSequences":["END","STOP"]`},
+		{"max_tokens", `"max_tokens":256`, `"maxOutputTokens":256`},
+		{"max_completion_tokens", `"max_completion_tokens":512`, `"maxOutputTokens":512`},
+		{"presence_penalty", `"presence_penalty":0.5`, `"presencePenalty":0.5`},
+		{"frequency_penalty", `"frequency_penalty":-0.25`, `"frequencyPenalty":-0.25`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := newGeminiConvertRequest(t, `{"model":"gemini-2.5-flash",`+tt.params+`,"messages":[{"role":"user","content":"hello"}]}`)
+			geminiRequest, err := CovertGemini2OpenAI(request, newVertexGeminiInfo())
+			if err != nil {
+				t.Fatalf("CovertGemini2OpenAI: %v", err)
+			}
+			generationConfig, err := common.Marshal(geminiRequest.GenerationConfig)
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			if !strings.Contains(string(generationConfig), tt.want) {
+				t.Errorf("generationConfig %s does not contain %s", generationConfig, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeminiGroundingCitations(t *testing.T) {
+	constant.StreamingTimeout = 60
+	const groundedFixture = `{"candidates":[{"index":0,"content":{"role":"model","parts":[{"text":"Paris is the capital. It hosts the Louvre."}]},"finishReason":"STOP",
+		"groundingMetadata":{"webSearchQueries":["capital of france"],
+		"groundingChunks":[{"web":{"uri":"https://example.com/paris","title":"Paris"}},{"web":{"uri":"https://example.com/louvre","title":"Louvre"}}],
+		"groundingSupports":[{"segment":{"startIndex":0,"endIndex":21,"text":"Paris is the capital."},"groundingChunkIndices":[0]},{"segment":{"startIndex":22,"endIndex":42,"text":"It hosts the Louvre."},"groundingChunkIndices":[1]}]}}],
+		"usageMetadata":{"promptTokenCount":5,"candidatesTokenCount":9,"totalTokenCount":14}}`
+	want := []dto.UrlCitation{
+		{Url: "https://example.com/paris", Title: "Paris", StartIndex: 0, EndIndex: 21},
+		{Url: "https://example.com/louvre", Title: "Louvre", StartIndex: 22, EndIndex: 42},
+	}
+	checkAnnotations := func(t *testing.T, annotations []dto.Annotation) {
+		t.Helper()
+		if len(annotations) != len(want) {
+			t.Fatalf("got %d annotations, want %d: %+v", len(annotations), len(want), annotations)
+		}
+		for i, annotation := range annotations {
+			if annotation.Type != "url_citation" || annotation.UrlCitation == nil || *annotation.UrlCitation != want[i] {
+				t.Errorf("annotation %d = %+v, want url_citation %+v", i, annotation, want[i])
+			}
+		}
+	}
+
+	t.Run("non-stream", func(t *testing.T) {
+		gin.SetMode(gin.TestMode)
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		info := newVertexGeminiInfo()
+		info.RelayFormat = relaycommon.RelayFormatOpenAI
+		resp := &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(strings.ReplaceAll(groundedFixture, "\n", ""))),
+		}
+		if _, apiErr := GeminiChatHandler(c, info, resp); apiErr != nil {
+			t.Fatalf("GeminiChatHandler: %v", apiErr)
+		}
+		var response dto.OpenAITextResponse
+		if err := common.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatalf("unmarshal %s: %v", recorder.Body.String(), err)
+		}
+		checkAnnotations(t, response.Choices[0].Message.Annotations)
+	})
+
+	t.Run("stream accumulates across chunks", func(t *testing.T) {
+		// 两个引用来源分别出现在不同分片中，第二个分片重复了第一个来源
+		chunks := []string{
+			`{"candidates":[{"index":0,"content":{"role":"model","parts":[{"text":"Paris is the capital. "}]},
+				"groundingMetadata":{"groundingChunks":[{"web":{"uri":"https://example.com/paris","title":"Paris"}}],
+				"groundingSupports":[{"segment":{"startIndex":0,"endIndex":21},"groundingChunkIndices":[0]}]}}]}`,
+			groundedFixture,
+		}
+		var body strings.Builder
+		for _, chunk := range chunks {
+			body.WriteString("data: " + strings.ReplaceAll(chunk, "\n", "") + "\n\n")
+		}
+		gin.SetMode(gin.TestMode)
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		info := newVertexGeminiInfo()
+		info.RelayFormat = relaycommon.RelayFormatOpenAI
+		info.IsStream = true
+		resp := &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+			Body:       io.NopCloser(strings.NewReader(body.String())),
+		}
+		if _, apiErr := GeminiChatStreamHandler(c, info, resp); apiErr != nil {
+			t.Fatalf("GeminiChatStreamHandler: %v", apiErr)
+		}
+		var annotations []dto.Annotation
+		for _, line := range strings.Split(recorder.Body.String(), "\n") {
+			data, ok := strings.CutPrefix(line, "data: ")
+			if !ok || data == "[DONE]" {
+				continue
+			}
+			var response dto.ChatCompletionsStreamResponse
+			if err := common.UnmarshalJsonStr(data, &response); err != nil {
+				t.Fatalf("unmarshal %s: %v", data, err)
+			}
+			for _, choice := range response.Choices {
+				annotations = append(annotations, choice.Delta.Annotations...)
+			}
+		}
+		checkAnnotations(t, annotations)
+	})
+}
+
+func TestCovertGemini2OpenAIPassesVideoMetadata(t *testing.T) {
+	request := newGeminiConvertRequest(t, `{"model":"gemini-2.5-flash","messages":[{"role":"user","content":[
+		{"type":"text","text":"summarise the clip"},
+		{"type":"video_url","video_url":{"url":"gs://test-bucket/videos/talk.mp4","video_metadata":{"fps":2,"start_offset":"10s","end_offset":"1m30s"}}}]}]}`)
+	geminiRequest, err := CovertGemini2OpenAI(request, newVertexGeminiInfo())
+	if err != nil {
+		t.Fatalf("CovertGemini2OpenAI: %v", err)
+	}
+	body, _ := common.Marshal(geminiRequest)
+	var sent struct {
+		Contents []struct {
+			Parts []map[string]any `json:"parts"`
+		} `json:"contents"`
+	}
+	if err := common.Unmarshal(body, &sent); err != nil {
+		t.Fatalf("unmarshal %s: %v", body, err)
+	}
+	parts := sent.Contents[0].Parts
+	if len(parts) != 2 {
+		t.Fatalf("parts = %v, want text and video", parts)
+	}
+	fileData, _ := parts[1]["fileData"].(map[string]any)
+	if fileData["fileUri"] != "gs://test-bucket/videos/talk.mp4" || fileData["mimeType"] != "video/mp4" {
+		t.Errorf("fileData = %v, want the gs:// uri with video/mp4", fileData)
+	}
+	videoMetadata, _ := parts[1]["videoMetadata"].(map[string]any)
+	if videoMetadata["fps"] != float64(2) || videoMetadata["startOffset"] != "10s" || videoMetadata["endOffset"] != "90s" {
+		t.Errorf("videoMetadata = %v, want fps 2 from 10s to 90s", videoMetadata)
+	}
+
+	tests := []struct {
+		name     string
+		metadata string
+		want     string
+	}{
+		{"end before start", `{"start_offset":"30s","end_offset":"10s"}`, "end_offset must be greater than start_offset"},
+		{"negative start", `{"start_offset":"-5s"}`, "invalid start_offset"},
+		{"not a duration", `{"end_offset":"soon"}`, "invalid end_offset"},
+		{"fps too high", `{"fps":60}`, "fps must be between"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := newGeminiConvertRequest(t, `{"model":"gemini-2.5-flash","messages":[{"role":"user","content":[
+				{"type":"video_url","video_url":{"url":"gs://test-bucket/videos/talk.mp4","video_metadata":`+tt.metadata+`}}]}]}`)
+			_, err := CovertGemini2OpenAI(request, newVertexGeminiInfo())
+			if err == nil || !strings.Contains(err.Error(), "attachment index 1") || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want attachment index 1 and %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestThinkingAdaptorCapsBudget(t *testing.T) {
+	geminiSettings := model_setting.GetGeminiSettings()
+	globalSettings := model_setting.GetGlobalSettings()
+	originalEnabled, originalGlobalMax := geminiSettings.ThinkingAdapterEnabled, globalSettings.MaxThinkingBudgetTokens
+	geminiSettings.ThinkingAdapterEnabled = true
+	defer func() {
+		geminiSettings.ThinkingAdapterEnabled, globalSettings.MaxThinkingBudgetTokens = originalEnabled, originalGlobalMax
+	}()
+
+	tests := []struct {
+		name       string
+		model      string
+		maxOutput  uint
+		channelMax int
+		globalMax  int
+		wantBudget int
+	}{
+		{"suffix budget over the channel cap", "gemini-2.5-flash-thinking-20000", 0, 4096, 0, 4096},
+		{"suffix budget over the global cap", "gemini-2.5-flash-thinking-20000", 0, 0, 8000, 8000},
+		{"channel cap wins over global", "gemini-2.5-flash-thinking-20000", 0, 2048, 8000, 2048},
+		{"suffix budget under the cap", "gemini-2.5-flash-thinking-1000", 0, 4096, 0, 1000},
+		{"derived budget over the cap", "gemini-2.5-flash-thinking", 20000, 0, 5000, 5000},
+		{"no cap", "gemini-2.5-flash-thinking-20000", 0, 0, 0, 20000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			globalSettings.MaxThinkingBudgetTokens = tt.globalMax
+			info := newVertexGeminiInfo()
+			info.UpstreamModelName = tt.model
+			info.ChannelSetting.MaxThinkingBudgetTokens = tt.channelMax
+			geminiRequest := &GeminiChatRequest{}
+			geminiRequest.GenerationConfig.MaxOutputTokens = tt.maxOutput
+			ThinkingAdaptor(geminiRequest, info)
+			thinkingConfig := geminiRequest.GenerationConfig.ThinkingConfig
+			if thinkingConfig == nil || thinkingConfig.ThinkingBudget == nil {
+				t.Fatalf("thinkingConfig = %+v, want a budget", thinkingConfig)
+			}
+			if *thinkingConfig.ThinkingBudget != tt.wantBudget {
+				t.Errorf("thinkingBudget = %d, want %d", *thinkingConfig.ThinkingBudget, tt.wantBudget)
+			}
+		})
+	}
+}
+
+func TestGeminiStreamKeepsPartialContentOnError(t *testing.T) {
+	constant.StreamingTimeout = 60
+	globalSettings := model_setting.GetGlobalSettings()
+	original := globalSettings.StreamPartialContentOnError
+	globalSettings.StreamPartialContentOnError = true
+	defer func() { globalSettings.StreamPartialContentOnError = original }()
+
+	// 输出部分内容后上游返回错误
+	chunks := []string{
+		`{"candidates":[{"index":0,"content":{"role":"model","parts":[{"text":"The answer"}]}}],"usageMetadata":{"promptTokenCount":5,"candidatesTokenCount":2,"totalTokenCount":7}}`,
+		`{"error":{"code":503,"message":"The model is overloaded","status":"UNAVAILABLE"}}`,
+	}
+	var body strings.Builder
+	for _, chunk := range chunks {
+		body.WriteString("data: " + chunk + "\n\n")
+	}
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	info := newVertexGeminiInfo()
+	info.RelayFormat = relaycommon.RelayFormatOpenAI
+	info.IsStream = true
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       io.NopCloser(strings.NewReader(body.String())),
+	}
+	usage, apiErr := GeminiChatStreamHandler(c, info, resp)
+	if apiErr != nil {
+		t.Fatalf("GeminiChatStreamHandler: %v", apiErr)
+	}
+	output := recorder.Body.String()
+	textAt := strings.Index(output, `"content":"The answer"`)
+	errorAt := strings.Index(output, `"error":{`)
+	if textAt < 0 || errorAt < 0 || errorAt < textAt {
+		t.Fatalf("want the partial content followed by an error event:\n%s", output)
+	}
+	if !strings.Contains(output[errorAt:], "The model is overloaded") {
+		t.Errorf("error event does not carry the upstream message:\n%s", output[errorAt:])
+	}
+	if usage == nil || usage.PromptTokens != 5 {
+		t.Errorf("usage = %+v, want the usage of the partial content", usage)
+	}
+}
+
+func TestGeminiImageModalityReturnsInlineImage(t *testing.T) {
+	request := newGeminiConvertRequest(t, `{"model":"gemini-2.5-flash","modalities":["text","image"],"messages":[{"role":"user","content":"draw a cat"}]}`)
+	geminiRequest, err := CovertGemini2OpenAI(request, newVertexGeminiInfo())
+	if err != nil {
+		t.Fatalf("CovertGemini2OpenAI: %v", err)
+	}
+	if got := geminiRequest.GenerationConfig.ResponseModalities; len(got) != 2 || got[0] != "TEXT" || got[1] != "IMAGE" {
+		t.Errorf("responseModalities = %v, want [TEXT IMAGE]", got)
+	}
+	invalid := newGeminiConvertRequest(t, `{"model":"gemini-2.5-flash","modalities":["video"],"messages":[{"role":"user","content":"draw a cat"}]}`)
+	if _, err := CovertGemini2OpenAI(invalid, newVertexGeminiInfo()); err == nil {
+		t.Error("an unsupported modality should be rejected")
+	}
+
+	const imageFixture = `{"candidates":[{"index":0,"content":{"role":"model","parts":[{"text":"Here is a cat."},{"inlineData":{"mimeType":"image/png","data":"iVBORw0KGgo="}}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":5,"candidatesTokenCount":4,"totalTokenCount":9}}`
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	info := newVertexGeminiInfo()
+	info.RelayFormat = relaycommon.RelayFormatOpenAI
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(imageFixture)),
+	}
+	if _, apiErr := GeminiChatHandler(c, info, resp); apiErr != nil {
+		t.Fatalf("GeminiChatHandler: %v", apiErr)
+	}
+	var response dto.OpenAITextResponse
+	if err := common.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("unmarshal %s: %v", recorder.Body.String(), err)
+	}
+	if len(response.Choices) != 1 {
+		t.Fatalf("got %d choices, want 1", len(response.Choices))
+	}
+	content := response.Choices[0].Message.StringContent()
+	if !strings.Contains(content, "Here is a cat.") || !strings.Contains(content, "![image](data:image/png;base64,iVBORw0KGgo=)") {
+		t.Errorf("content = %q, want the text and the inline image", content)
+	}
+}
+
+func TestGeminiLogprobs(t *testing.T) {
+	request := newGeminiConvertRequest(t, `{"model":"gemini-2.5-flash","logprobs":true,"top_logprobs":2,"messages":[{"role":"user","content":"hi"}]}`)
+	geminiRequest, err := CovertGemini2OpenAI(request, newVertexGeminiInfo())
+	if err != nil {
+		t.Fatalf("CovertGemini2OpenAI: %v", err)
+	}
+	config := geminiRequest.GenerationConfig
+	if !config.ResponseLogprobs || config.Logprobs == nil || *config.Logprobs != 2 {
+		t.Errorf("responseLogprobs = %v, logprobs = %v, want true and 2", config.ResponseLogprobs, config.Logprobs)
+	}
+
+	const logprobsFixture = `{"candidates":[{"index":0,"content":{"role":"model","parts":[{"text":"Hello there"}]},"finishReason":"STOP","avgLogprobs":-0.15,
+		"logprobsResult":{"topCandidates":[{"candidates":[{"token":"Hello","logProbability":-0.1},{"token":"Hi","logProbability":-2.4}]},{"candidates":[{"token":" there","logProbability":-0.2},{"token":" world","logProbability":-1.9}]}],
+		"chosenCandidates":[{"token":"Hello","logProbability":-0.1},{"token":" there","logProbability":-0.2}]}}],
+		"usageMetadata":{"promptTokenCount":2,"candidatesTokenCount":2,"totalTokenCount":4}}`
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	info := newVertexGeminiInfo()
+	info.RelayFormat = relaycommon.RelayFormatOpenAI
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(logprobsFixture)),
+	}
+	if _, apiErr := GeminiChatHandler(c, info, resp); apiErr != nil {
+		t.Fatalf("GeminiChatHandler: %v", apiErr)
+	}
+	var response dto.OpenAITextResponse
+	if err := common.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("unmarshal %s: %v", recorder.Body.String(), err)
+	}
+	logprobs := response.Choices[0].Logprobs
+	if logprobs == nil || len(logprobs.Content) != 2 {
+		t.Fatalf("logprobs = %+v, want 2 tokens", logprobs)
+	}
+	first := logprobs.Content[0]
+	if first.Token != "Hello" || first.Logprob != -0.1 || len(first.TopLogprobs) != 2 || first.TopLogprobs[1].Token != "Hi" {
+		t.Errorf("first token = %+v, want Hello with 2 top logprobs", first)
+	}
+
+	// 流式响应中各分片携带本分片 token 的对数概率，按顺序拼接后与完整结果一致
+	chunks := []string{
+		`{"candidates":[{"index":0,"content":{"role":"model","parts":[{"text":"Hello"}]},"logprobsResult":{"chosenCandidates":[{"token":"Hello","logProbability":-0.1}]}}]}`,
+		`{"candidates":[{"index":0,"content":{"role":"model","parts":[{"text":" there"}]},"finishReason":"STOP","logprobsResult":{"chosenCandidates":[{"token":" there","logProbability":-0.2}]}}],"usageMetadata":{"promptTokenCount":2,"candidatesTokenCount":2,"totalTokenCount":4}}`,
+	}
+	responses, _, err := streamGeminiToolCalls(t, chunks)
+	if err != nil {
+		t.Fatalf("GeminiChatStreamHandler: %v", err)
+	}
+	var tokens []string
+	for _, response := range responses {
+		for _, choice := range response.Choices {
+			if choice.Logprobs == nil || *choice.Logprobs == nil {
+				continue
+			}
+			data, _ := common.Marshal(*choice.Logprobs)
+			var chunkLogprobs dto.ChoiceLogprobs
+			if err := common.Unmarshal(data, &chunkLogprobs); err != nil {
+				t.Fatalf("unmarshal logprobs %s: %v", data, err)
+			}
+			for _, token := range chunkLogprobs.Content {
+				tokens = append(tokens, token.Token)
+			}
+		}
+	}
+	if strings.Join(tokens, "|") != "Hello| there" {
+		t.Errorf("streamed logprob tokens = %q, want [Hello  there]", tokens)
+	}
+}
+
+func TestCovertGemini2OpenAIMapsSeed(t *testing.T) {
+	tests := []struct {
+		name string
+		seed string
+		want string
+	}{
+		{"seed set", `,"seed":42`, `"seed":42`},
+		{"seed zero kept", `,"seed":0`, `"seed":0`},
+		{"seed absent", ``, ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := newGeminiConvertRequest(t, `{"model":"gemini-2.5-flash"`+tt.seed+`,"messages":[{"role":"user","content":"hi"}]}`)
+			geminiRequest, err := CovertGemini2OpenAI(request, newVertexGeminiInfo())
+			if err != nil {
+				t.Fatalf("CovertGemini2OpenAI: %v", err)
+			}
+			config, _ := common.Marshal(geminiRequest.GenerationConfig)
+			if tt.want == "" {
+				if strings.Contains(string(config), `"seed"`) {
+					t.Errorf("generationConfig = %s, want no seed", config)
+				}
+			} else if !strings.Contains(string(config), tt.want) {
+				t.Errorf("generationConfig = %s, want %s", config, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeminiFinishReason2OpenAI(t *testing.T) {
+	tests := []struct {
+		reason string
+		want   string
+	}{
+		{"STOP", constant.FinishReasonStop},
+		{"MAX_TOKENS", constant.FinishReasonLength},
+		{"TOOL_CALLS", constant.FinishReasonToolCalls},
+		{"SAFETY", constant.FinishReasonContentFilter},
+		{"RECITATION", constant.FinishReasonContentFilter},
+		{"BLOCKLIST", constant.FinishReasonContentFilter},
+		{"PROHIBITED_CONTENT", constant.FinishReasonContentFilter},
+		{"SPII", constant.FinishReasonContentFilter},
+		{"IMAGE_SAFETY", constant.FinishReasonContentFilter},
+		{"IMAGE_PROHIBITED_CONTENT", constant.FinishReasonContentFilter},
+		{"IMAGE_RECITATION", constant.FinishReasonContentFilter},
+		{"FINISH_REASON_UNSPECIFIED", constant.FinishReasonStop},
+		{"MALFORMED_FUNCTION_CALL", constant.FinishReasonStop},
+	}
+	for _, tt := range tests {
+		if got := geminiFinishReason2OpenAI(tt.reason); got != tt.want {
+			t.Errorf("geminiFinishReason2OpenAI(%s) = %s, want %s", tt.reason, got, tt.want)
+		}
+	}
+
+	// 流式与非流式响应使用同一映射
+	response := &GeminiChatResponse{Candidates: []GeminiChatCandidate{{FinishReason: common.GetPointer("MAX_TOKENS")}}}
+	stream, _, _ := streamResponseGeminiChat2OpenAI(response, nil)
+	if len(stream.Choices) != 1 || stream.Choices[0].FinishReason == nil || *stream.Choices[0].FinishReason != constant.FinishReasonLength {
+		t.Errorf("stream finish_reason = %+v, want length", stream.Choices)
+	}
+}
+
+func TestCovertGemini2OpenAIMapsReasoningEffort(t *testing.T) {
+	settings := model_setting.GetGeminiSettings()
+	originalBudget := settings.ReasoningEffortBudget
+	settings.ReasoningEffortBudget = map[string]int{"low": 512, "medium": 4096, "high": 16384}
+	defer func() { settings.ReasoningEffortBudget = originalBudget }()
+
+	tests := []struct {
+		name       string
+		body       string
+		channelMax int
+		wantBudget int // 为 -2 表示不设置 thinkingBudget
+	}{
+		{"low", `"reasoning_effort":"low"`, 0, 512},
+		{"medium", `"reasoning_effort":"medium"`, 0, 4096},
+		{"high", `"reasoning_effort":"high"`, 0, 16384},
+		{"capped by channel", `"reasoning_effort":"high"`, 2048, 2048},
+		{"explicit thinking budget wins", `"reasoning_effort":"high","extra_body":{"google":{"thinking_config":{"thinking_budget":1000}}}`, 0, 1000},
+		{"unknown effort ignored", `"reasoning_effort":"extreme"`, 0, -2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := newGeminiConvertRequest(t, `{"model":"gemini-2.5-flash",`+tt.body+`,"messages":[{"role":"user","content":"hi"}]}`)
+			info := newVertexGeminiInfo()
+			info.ChannelSetting.MaxThinkingBudgetTokens = tt.channelMax
+			geminiRequest, err := CovertGemini2OpenAI(request, info)
+			if err != nil {
+				t.Fatalf("CovertGemini2OpenAI: %v", err)
+			}
+			budget := -2
+			if thinkingConfig := geminiRequest.GenerationConfig.ThinkingConfig; thinkingConfig != nil && thinkingConfig.ThinkingBudget != nil {
+				budget = *thinkingConfig.ThinkingBudget
+			}
+			if budget != tt.wantBudget {
+				t.Errorf("thinkingBudget = %d, want %d", budget, tt.wantBudget)
+			}
+		})
+	}
+}
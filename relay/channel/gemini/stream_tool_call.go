@@ -0,0 +1,257 @@
+package gemini
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/types"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// geminiToolCallStream 跟踪流式响应中的函数调用，转换为 OpenAI 格式的 tool_calls
+// 同一响应中的调用按出现顺序分配 index，参数分片返回的调用先缓存，参数完整后一次输出
+type geminiToolCallStream struct {
+	nextIndex int
+	current   *geminiStreamingToolCall
+}
+
+// geminiStreamingToolCall 参数仍在分片返回中的调用
+type geminiStreamingToolCall struct {
+	index       int
+	name        string
+	args        strings.Builder
+	argsOpened  bool
+	emittedKeys int
+	// 正在拼接的顶层字符串参数
+	openKey string
+	// 非顶层路径的参数无法按顺序拼接，调用结束时一并写入
+	nested map[string]any
+}
+
+// toolCallDeltas 将函数调用分片转换为 tool_calls，返回调用是否已结束
+// 参数分片返回时在调用结束前不输出，结束时输出一个包含完整参数的调用
+func (s *geminiToolCallStream) toolCallDeltas(part *GeminiPart) ([]dto.ToolCallResponse, bool) {
+	call := part.FunctionCall
+	if s.current == nil {
+		if !call.WillContinue && len(call.PartialArgs) == 0 {
+			toolCall := getResponseToolCall(part)
+			if toolCall == nil {
+				return nil, false
+			}
+			toolCall.SetIndex(s.nextIndex)
+			s.nextIndex++
+			return []dto.ToolCallResponse{*toolCall}, true
+		}
+		s.current = &geminiStreamingToolCall{
+			index:  s.nextIndex,
+			name:   call.FunctionName,
+			nested: make(map[string]any),
+		}
+		s.nextIndex++
+	}
+
+	toolCall := s.current
+	for _, partialArg := range call.PartialArgs {
+		toolCall.appendPartialArg(&toolCall.args, partialArg)
+	}
+	if call.WillContinue {
+		return nil, false
+	}
+	toolCall.finish(&toolCall.args)
+	s.current = nil
+	delta := dto.ToolCallResponse{
+		ID:   fmt.Sprintf("call_%s", common.GetUUID()),
+		Type: "function",
+		Function: dto.FunctionResponse{
+			Name:      toolCall.name,
+			Arguments: toolCall.args.String(),
+		},
+	}
+	delta.SetIndex(toolCall.index)
+	return []dto.ToolCallResponse{delta}, true
+}
+
+// incompleteError 流结束时仍有参数未接收完整的调用，返回错误，该调用不会输出给客户端
+func (s *geminiToolCallStream) incompleteError() *types.NewAPIError {
+	if s.current == nil {
+		return nil
+	}
+	err := fmt.Errorf("gemini stream ended before the arguments of function call %s were complete (%d bytes received)",
+		s.current.name, s.current.args.Len())
+	return types.NewErrorWithStatusCode(err, types.ErrorCodeBadResponse, http.StatusBadGateway)
+}
+
+var topLevelJsonPathRegex = regexp.MustCompile(`^\$\.([A-Za-z_][A-Za-z0-9_]*)$`)
+
+func (t *geminiStreamingToolCall) appendPartialArg(args *strings.Builder, partialArg FunctionCallPartialArg) {
+	value := partialArg.value()
+	match := topLevelJsonPathRegex.FindStringSubmatch(partialArg.JsonPath)
+	if match == nil {
+		t.closeOpenKey(args)
+		setJsonPathValue(t.nested, partialArg.JsonPath, value)
+		return
+	}
+	key := match[1]
+	if partialArg.StringValue == nil {
+		t.closeOpenKey(args)
+		t.writeKey(args, key)
+		valueBytes, _ := common.Marshal(value)
+		args.Write(valueBytes)
+		return
+	}
+	if t.openKey != key {
+		t.closeOpenKey(args)
+		t.writeKey(args, key)
+		args.WriteString(`"`)
+		t.openKey = key
+	}
+	// 只输出转义后的字符串内容，引号在该参数结束时补齐
+	escaped, _ := common.Marshal(*partialArg.StringValue)
+	args.Write(escaped[1 : len(escaped)-1])
+	if !partialArg.WillContinue {
+		t.closeOpenKey(args)
+	}
+}
+
+func (t *geminiStreamingToolCall) writeKey(args *strings.Builder, key string) {
+	if !t.argsOpened {
+		args.WriteString("{")
+		t.argsOpened = true
+	}
+	if t.emittedKeys > 0 {
+		args.WriteString(",")
+	}
+	keyBytes, _ := common.Marshal(key)
+	args.Write(keyBytes)
+	args.WriteString(":")
+	t.emittedKeys++
+}
+
+func (t *geminiStreamingToolCall) closeOpenKey(args *strings.Builder) {
+	if t.openKey != "" {
+		args.WriteString(`"`)
+		t.openKey = ""
+	}
+}
+
+func (t *geminiStreamingToolCall) finish(args *strings.Builder) {
+	t.closeOpenKey(args)
+	keys := make([]string, 0, len(t.nested))
+	for key := range t.nested {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		t.writeKey(args, key)
+		valueBytes, _ := common.Marshal(t.nested[key])
+		args.Write(valueBytes)
+	}
+	if !t.argsOpened {
+		args.WriteString("{")
+	}
+	args.WriteString("}")
+}
+
+func (p FunctionCallPartialArg) value() any {
+	switch {
+	case p.StringValue != nil:
+		return *p.StringValue
+	case p.NumberValue != nil:
+		return *p.NumberValue
+	case p.BoolValue != nil:
+		return *p.BoolValue
+	}
+	return nil
+}
+
+var jsonPathSegmentRegex = regexp.MustCompile(`\.([^.\[\]]+)|\[(\d+)\]`)
+
+// setJsonPathValue 按 JSON 路径（如 $.a.b[0]）写入参数值，字符串分片追加到已有值之后
+func setJsonPathValue(root map[string]any, path string, value any) {
+	segments := jsonPathSegmentRegex.FindAllStringSubmatch(strings.TrimPrefix(path, "$"), -1)
+	if len(segments) == 0 || segments[0][1] == "" {
+		return
+	}
+	var container any = root
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		var next any
+		if !last {
+			if segments[i+1][1] != "" {
+				next = map[string]any{}
+			} else {
+				next = []any{}
+			}
+		}
+		switch node := container.(type) {
+		case map[string]any:
+			key := segment[1]
+			if last {
+				node[key] = mergeJsonPathValue(node[key], value)
+				return
+			}
+			if existing, ok := node[key]; ok {
+				next = existing
+			}
+			node[key] = next
+		case []any:
+			index, err := strconv.Atoi(segment[2])
+			if err != nil || segment[2] == "" {
+				return
+			}
+			for len(node) <= index {
+				node = append(node, nil)
+			}
+			if last {
+				node[index] = mergeJsonPathValue(node[index], value)
+			} else if node[index] != nil {
+				next = node[index]
+			} else {
+				node[index] = next
+			}
+			// 切片扩容后需要写回父节点
+			setJsonPathContainer(root, segments[:i], node)
+		default:
+			return
+		}
+		if last {
+			return
+		}
+		container = next
+	}
+}
+
+func mergeJsonPathValue(existing any, value any) any {
+	if existingString, ok := existing.(string); ok {
+		if valueString, ok := value.(string); ok {
+			return existingString + valueString
+		}
+	}
+	return value
+}
+
+func setJsonPathContainer(root map[string]any, segments [][]string, container []any) {
+	var parent any = root
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		switch node := parent.(type) {
+		case map[string]any:
+			if last {
+				node[segment[1]] = container
+				return
+			}
+			parent = node[segment[1]]
+		case []any:
+			index, _ := strconv.Atoi(segment[2])
+			if last {
+				node[index] = container
+				return
+			}
+			parent = node[index]
+		}
+	}
+}
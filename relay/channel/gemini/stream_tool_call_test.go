@@ -0,0 +1,128 @@
+package gemini
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// streamGeminiToolCalls 将 Gemini 流式分片交给流式处理器，返回各个 OpenAI 分片及原始输出
+func streamGeminiToolCalls(t *testing.T, chunks []string) ([]dto.ChatCompletionsStreamResponse, string, error) {
+	t.Helper()
+	constant.StreamingTimeout = 60
+	var body strings.Builder
+	for _, chunk := range chunks {
+		body.WriteString("data: " + chunk + "\n\n")
+	}
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	info := newVertexGeminiInfo()
+	info.RelayFormat = relaycommon.RelayFormatOpenAI
+	info.IsStream = true
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       io.NopCloser(strings.NewReader(body.String())),
+	}
+	_, apiErr := GeminiChatStreamHandler(c, info, resp)
+	var responses []dto.ChatCompletionsStreamResponse
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var response dto.ChatCompletionsStreamResponse
+		if err := common.UnmarshalJsonStr(data, &response); err != nil {
+			t.Fatalf("unmarshal %s: %v", data, err)
+		}
+		responses = append(responses, response)
+	}
+	if apiErr != nil {
+		return responses, recorder.Body.String(), apiErr
+	}
+	return responses, recorder.Body.String(), nil
+}
+
+func TestGeminiStreamToolCallDeltas(t *testing.T) {
+	chunks := []string{
+		// 一次返回完整调用
+		`{"candidates":[{"index":0,"content":{"role":"model","parts":[{"functionCall":{"name":"get_weather","args":{"city":"Paris"}}}]}}]}`,
+		// 参数按路径分片返回
+		`{"candidates":[{"index":0,"content":{"role":"model","parts":[{"functionCall":{"name":"search","partialArgs":[{"jsonPath":"$.query","stringValue":"new \"","willContinue":true}],"willContinue":true}}]}}]}`,
+		`{"candidates":[{"index":0,"content":{"role":"model","parts":[{"functionCall":{"partialArgs":[{"jsonPath":"$.query","stringValue":"york\""},{"jsonPath":"$.limit","numberValue":3},{"jsonPath":"$.filter.lang","stringValue":"en"}]}}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":5,"candidatesTokenCount":4,"totalTokenCount":9}}`,
+	}
+	responses, _, err := streamGeminiToolCalls(t, chunks)
+	if err != nil {
+		t.Fatalf("GeminiChatStreamHandler: %v", err)
+	}
+	var toolCalls []dto.ToolCallResponse
+	var toolCallChunks int
+	for _, response := range responses {
+		for _, choice := range response.Choices {
+			if len(choice.Delta.ToolCalls) > 0 {
+				toolCallChunks++
+			}
+			toolCalls = append(toolCalls, choice.Delta.ToolCalls...)
+		}
+	}
+	if len(toolCalls) != 2 || toolCallChunks != 2 {
+		t.Fatalf("got %d tool calls in %d chunks, want one delta per call: %+v", len(toolCalls), toolCallChunks, toolCalls)
+	}
+	want := []struct {
+		name string
+		args map[string]any
+	}{
+		{"get_weather", map[string]any{"city": "Paris"}},
+		{"search", map[string]any{"query": `new "york"`, "limit": float64(3), "filter": map[string]any{"lang": "en"}}},
+	}
+	for i, toolCall := range toolCalls {
+		if toolCall.Index == nil || *toolCall.Index != i || toolCall.Type != "function" || toolCall.ID == "" {
+			t.Errorf("tool call %d = %+v, want index %d with an id", i, toolCall, i)
+		}
+		var args map[string]any
+		if err := common.UnmarshalJsonStr(toolCall.Function.Arguments, &args); err != nil {
+			t.Fatalf("tool call %d arguments %q are not valid JSON: %v", i, toolCall.Function.Arguments, err)
+		}
+		if toolCall.Function.Name != want[i].name || common.MapToJsonStr(args) != common.MapToJsonStr(want[i].args) {
+			t.Errorf("tool call %d = %s(%s), want %s(%v)", i, toolCall.Function.Name, toolCall.Function.Arguments, want[i].name, want[i].args)
+		}
+	}
+}
+
+func TestGeminiStreamToolCallTruncatedMidArguments(t *testing.T) {
+	chunks := []string{
+		`{"candidates":[{"index":0,"content":{"role":"model","parts":[{"text":"Let me search."}]}}]}`,
+		`{"candidates":[{"index":0,"content":{"role":"model","parts":[{"functionCall":{"name":"search","partialArgs":[{"jsonPath":"$.query","stringValue":"new \"","willContinue":true}],"willContinue":true}}]}}]}`,
+		// 流在参数返回完整前结束
+		`{"candidates":[{"index":0,"content":{"role":"model","parts":[{"functionCall":{"partialArgs":[{"jsonPath":"$.query","stringValue":"yo","willContinue":true}],"willContinue":true}}]}}],"usageMetadata":{"promptTokenCount":5,"candidatesTokenCount":4,"totalTokenCount":9}}`,
+	}
+	responses, output, err := streamGeminiToolCalls(t, chunks)
+	if err != nil {
+		t.Fatalf("GeminiChatStreamHandler: %v", err)
+	}
+	var text strings.Builder
+	for _, response := range responses {
+		for _, choice := range response.Choices {
+			if len(choice.Delta.ToolCalls) > 0 {
+				t.Errorf("incomplete tool call was emitted: %+v", choice.Delta.ToolCalls)
+			}
+			text.WriteString(choice.Delta.GetContentString())
+		}
+	}
+	if text.String() != "Let me search." {
+		t.Errorf("content = %q, want the text sent before the tool call", text.String())
+	}
+	if !strings.Contains(output, `"error":{`) || !strings.Contains(output, "arguments of function call search were complete") {
+		t.Errorf("want an error event about the incomplete arguments:\n%s", output)
+	}
+}
@@ -7,7 +7,7 @@ type OllamaRequest struct {
 	Messages         []dto.Message         `json:"messages,omitempty"`
 	Stream           bool                  `json:"stream,omitempty"`
 	Temperature      *float64              `json:"temperature,omitempty"`
-	Seed             float64               `json:"seed,omitempty"`
+	Seed             *float64              `json:"seed,omitempty"`
 	Topp             float64               `json:"top_p,omitempty"`
 	TopK             int                   `json:"top_k,omitempty"`
 	Stop             any                   `json:"stop,omitempty"`
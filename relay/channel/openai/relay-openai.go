@@ -38,6 +38,7 @@ func sendStreamData(c *gin.Context, info *relaycommon.RelayInfo, data string, fo
 	if err := common.UnmarshalJsonStr(data, &lastStreamResponse); err != nil {
 		return err
 	}
+	lastStreamResponse.Model = info.ResponseModel(lastStreamResponse.Model)
 
 	if !thinkToContent {
 		return helper.ObjectData(c, lastStreamResponse)
@@ -126,7 +127,8 @@ func OaiStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Re
 	var forceFormat bool
 	var thinkToContent bool
 
-	if info.ChannelSetting.ForceFormat {
+	// 需要改写模型名时重新序列化每个分片
+	if info.ChannelSetting.ForceFormat || info.RewriteResponseModel {
 		forceFormat = true
 	}
 
@@ -177,7 +179,7 @@ func OaiStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Re
 		}
 	}
 
-	handleFinalResponse(c, info, lastStreamData, responseId, createAt, model, systemFingerprint, usage, containStreamUsage)
+	handleFinalResponse(c, info, lastStreamData, responseId, createAt, info.ResponseModel(model), systemFingerprint, usage, containStreamUsage)
 
 	return usage, nil
 }
@@ -202,6 +204,10 @@ func OpenaiHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Respo
 	if info.ChannelSetting.ForceFormat {
 		forceFormat = true
 	}
+	if model := info.ResponseModel(simpleResponse.Model); model != simpleResponse.Model {
+		simpleResponse.Model = model
+		forceFormat = true
+	}
 
 	if simpleResponse.Usage.TotalTokens == 0 || (simpleResponse.Usage.PromptTokens == 0 && simpleResponse.Usage.CompletionTokens == 0) {
 		completionTokens := 0
@@ -1,11 +1,12 @@
 package vertex
 
 import (
-	"encoding/json"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"one-api/common"
 	"one-api/dto"
 	"one-api/relay/channel"
 	"one-api/relay/channel/claude"
@@ -21,9 +22,10 @@ import (
 )
 
 const (
-	RequestModeClaude = 1
-	RequestModeGemini = 2
-	RequestModeLlama  = 3
+	RequestModeClaude    = 1
+	RequestModeGemini    = 2
+	RequestModeLlama     = 3
+	RequestModeEmbedding = 4
 )
 
 var claudeModelMap = map[string]string{
@@ -37,8 +39,6 @@ var claudeModelMap = map[string]string{
 	"claude-opus-4-20250514":     "claude-opus-4@20250514",
 }
 
-const anthropicVersion = "vertex-2023-10-16"
-
 type Adaptor struct {
 	RequestMode        int
 	AccountCredentials Credentials
@@ -50,13 +50,33 @@ func (a *Adaptor) ConvertClaudeRequest(c *gin.Context, info *relaycommon.RelayIn
 	} else {
 		c.Set("request_model", request.Model)
 	}
-	vertexClaudeReq := copyRequest(request, anthropicVersion)
+	version, err := getAnthropicVersion(info)
+	if err != nil {
+		return nil, err
+	}
+	vertexClaudeReq := copyRequest(request, version)
+	if err = clampSamplingParams(c, a.RequestMode, vertexClaudeReq.Temperature, &vertexClaudeReq.TopP); err != nil {
+		return nil, err
+	}
 	return vertexClaudeReq, nil
 }
 
 func (a *Adaptor) ConvertAudioRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.AudioRequest) (io.Reader, error) {
-	//TODO implement me
-	return nil, errors.New("not implemented")
+	if info.RelayMode != constant.RelayModeAudioSpeech {
+		return nil, errors.New("only audio speech is supported")
+	}
+	if a.RequestMode != RequestModeGemini {
+		return nil, errors.New("audio speech is only supported for gemini tts models")
+	}
+	geminiRequest, err := gemini.ConvertAudioRequest2Gemini(info, request)
+	if err != nil {
+		return nil, err
+	}
+	jsonData, err := common.Marshal(geminiRequest)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(jsonData), nil
 }
 
 func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.ImageRequest) (any, error) {
@@ -67,6 +87,8 @@ func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInf
 func (a *Adaptor) Init(info *relaycommon.RelayInfo) {
 	if strings.HasPrefix(info.UpstreamModelName, "claude") {
 		a.RequestMode = RequestModeClaude
+	} else if isEmbeddingModel(info.UpstreamModelName) {
+		a.RequestMode = RequestModeEmbedding
 	} else if strings.HasPrefix(info.UpstreamModelName, "gemini") {
 		a.RequestMode = RequestModeGemini
 	} else if strings.Contains(info.UpstreamModelName, "llama") {
@@ -75,14 +97,17 @@ func (a *Adaptor) Init(info *relaycommon.RelayInfo) {
 }
 
 func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
-	adc := &Credentials{}
-	if err := json.Unmarshal([]byte(info.ApiKey), adc); err != nil {
-		return "", fmt.Errorf("failed to decode credentials file: %w", err)
+	region, err := resolveRequestRegion(info, a.RequestMode)
+	if err != nil {
+		return "", err
+	}
+	adc, err := parseCredentials(info.ApiKey, region)
+	if err != nil {
+		return "", err
 	}
-	region := GetModelRegion(info.ApiVersion, info.OriginModelName)
 	a.AccountCredentials = *adc
 	suffix := ""
-	if a.RequestMode == RequestModeGemini {
+	if a.RequestMode == RequestModeGemini || a.RequestMode == RequestModeEmbedding {
 		if model_setting.GetGeminiSettings().ThinkingAdapterEnabled {
 			// 新增逻辑：处理 -thinking-<budget> 格式
 			if strings.Contains(info.UpstreamModelName, "-thinking-") {
@@ -95,28 +120,21 @@ func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
 			}
 		}
 
-		if info.IsStream {
+		if a.RequestMode == RequestModeEmbedding {
+			suffix = "predict"
+		} else if info.IsStream {
 			suffix = "streamGenerateContent?alt=sse"
 		} else {
 			suffix = "generateContent"
 		}
-		if region == "global" {
-			return fmt.Sprintf(
-				"https://aiplatform.googleapis.com/v1/projects/%s/locations/global/publishers/google/models/%s:%s",
-				adc.ProjectID,
-				info.UpstreamModelName,
-				suffix,
-			), nil
-		} else {
-			return fmt.Sprintf(
-				"https://%s-aiplatform.googleapis.com/v1/projects/%s/locations/%s/publishers/google/models/%s:%s",
-				region,
-				adc.ProjectID,
-				region,
-				info.UpstreamModelName,
-				suffix,
-			), nil
-		}
+		return fmt.Sprintf(
+			"https://%s/v1/projects/%s/locations/%s/publishers/google/models/%s:%s",
+			getApiHost(info, region),
+			adc.ProjectID,
+			region,
+			info.UpstreamModelName,
+			suffix,
+		), nil
 	} else if a.RequestMode == RequestModeClaude {
 		if info.IsStream {
 			suffix = "streamRawPredict?alt=sse"
@@ -127,27 +145,18 @@ func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
 		if v, ok := claudeModelMap[info.UpstreamModelName]; ok {
 			model = v
 		}
-		if region == "global" {
-			return fmt.Sprintf(
-				"https://aiplatform.googleapis.com/v1/projects/%s/locations/global/publishers/anthropic/models/%s:%s",
-				adc.ProjectID,
-				model,
-				suffix,
-			), nil
-		} else {
-			return fmt.Sprintf(
-				"https://%s-aiplatform.googleapis.com/v1/projects/%s/locations/%s/publishers/anthropic/models/%s:%s",
-				region,
-				adc.ProjectID,
-				region,
-				model,
-				suffix,
-			), nil
-		}
-	} else if a.RequestMode == RequestModeLlama {
 		return fmt.Sprintf(
-			"https://%s-aiplatform.googleapis.com/v1beta1/projects/%s/locations/%s/endpoints/openapi/chat/completions",
+			"https://%s/v1/projects/%s/locations/%s/publishers/anthropic/models/%s:%s",
+			getApiHost(info, region),
+			adc.ProjectID,
 			region,
+			model,
+			suffix,
+		), nil
+	} else if a.RequestMode == RequestModeLlama {
+		return fmt.Sprintf(
+			"https://%s/v1beta1/projects/%s/locations/%s/endpoints/openapi/chat/completions",
+			getApiHost(info, region),
 			adc.ProjectID,
 			region,
 		), nil
@@ -157,9 +166,26 @@ func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
 
 func (a *Adaptor) SetupRequestHeader(c *gin.Context, req *http.Header, info *relaycommon.RelayInfo) error {
 	channel.SetupApiRequestHeader(info, c, req)
+	channel.ForwardClientHeaders(c, req, info.ChannelSetting)
+	req.Set("User-Agent", model_setting.GetVertexSettings().GetUserAgent())
+	// 指定用于配额与计费归属的 GCP 项目
+	if info.ChannelSetting.VertexQuotaProject != "" {
+		req.Set("X-Goog-User-Project", info.ChannelSetting.VertexQuotaProject)
+	}
+	channel.SetUpstreamHeaders(req, info.ChannelSetting, map[string]string{
+		"project_id": a.AccountCredentials.ProjectID,
+	})
+	if a.RequestMode == RequestModeClaude {
+		// 服务端工具（代码执行、网页搜索等）依赖 beta 请求头，渠道剔除该请求头时不转发客户端传入的值
+		clientBeta := ""
+		if !channel.ClientHeaderStripped(info.ChannelSetting, "anthropic-beta") {
+			clientBeta = c.Request.Header.Get("anthropic-beta")
+		}
+		claude.SetAnthropicBetaHeaders(req, info.OriginModelName, clientBeta)
+	}
 	accessToken, err := getAccessToken(a, info)
 	if err != nil {
-		return err
+		return newAuthAPIError(err)
 	}
 	req.Set("Authorization", "Bearer "+accessToken)
 	return nil
@@ -174,15 +200,27 @@ func (a *Adaptor) ConvertOpenAIRequest(c *gin.Context, info *relaycommon.RelayIn
 		if err != nil {
 			return nil, err
 		}
-		vertexClaudeReq := copyRequest(claudeReq, anthropicVersion)
+		version, err := getAnthropicVersion(info)
+		if err != nil {
+			return nil, err
+		}
+		vertexClaudeReq := copyRequest(claudeReq, version)
+		if err = clampSamplingParams(c, a.RequestMode, vertexClaudeReq.Temperature, &vertexClaudeReq.TopP); err != nil {
+			return nil, err
+		}
 		c.Set("request_model", claudeReq.Model)
 		info.UpstreamModelName = claudeReq.Model
+		info.MaxCompletionTokens = int(claudeReq.MaxTokens)
 		return vertexClaudeReq, nil
 	} else if a.RequestMode == RequestModeGemini {
 		geminiRequest, err := gemini.CovertGemini2OpenAI(*request, info)
 		if err != nil {
 			return nil, err
 		}
+		generationConfig := &geminiRequest.GenerationConfig
+		if err = clampSamplingParams(c, a.RequestMode, generationConfig.Temperature, &generationConfig.TopP); err != nil {
+			return nil, err
+		}
 		c.Set("request_model", request.Model)
 		return geminiRequest, nil
 	} else if a.RequestMode == RequestModeLlama {
@@ -196,8 +234,10 @@ func (a *Adaptor) ConvertRerankRequest(c *gin.Context, relayMode int, request dt
 }
 
 func (a *Adaptor) ConvertEmbeddingRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.EmbeddingRequest) (any, error) {
-	//TODO implement me
-	return nil, errors.New("not implemented")
+	if a.RequestMode != RequestModeEmbedding {
+		return nil, fmt.Errorf("model %s does not support embeddings", info.UpstreamModelName)
+	}
+	return convertEmbeddingRequest(request)
 }
 
 func (a *Adaptor) ConvertOpenAIResponsesRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.OpenAIResponsesRequest) (any, error) {
@@ -206,10 +246,21 @@ func (a *Adaptor) ConvertOpenAIResponsesRequest(c *gin.Context, info *relaycommo
 }
 
 func (a *Adaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (any, error) {
+	if a.RequestMode == RequestModeEmbedding {
+		return a.doEmbeddingRequest(c, info, requestBody)
+	}
 	return channel.DoApiRequest(a, c, info, requestBody)
 }
 
 func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (usage any, err *types.NewAPIError) {
+	// 响应中回显的上游模型名统一改写为客户端请求的模型名，在序列化响应前设置
+	info.RewriteResponseModel = true
+	if info.RelayMode == constant.RelayModeAudioSpeech && a.RequestMode == RequestModeGemini {
+		return gemini.GeminiTTSHandler(c, info, resp)
+	}
+	if a.RequestMode == RequestModeEmbedding {
+		return VertexEmbeddingHandler(c, info, resp)
+	}
 	if info.IsStream {
 		switch a.RequestMode {
 		case RequestModeClaude:
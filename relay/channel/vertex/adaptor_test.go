@@ -0,0 +1,414 @@
+package vertex
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	relayconstant "one-api/relay/constant"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newTestContext() *gin.Context {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/v1/messages", nil)
+	return c
+}
+
+func TestConvertClaudeRequestAnthropicVersionOverride(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions map[string]string
+		want     string
+	}{
+		{"default", nil, dto.DefaultVertexAnthropicVersion},
+		{"model override", map[string]string{"claude-sonnet-4-20250514": "vertex-2025-06-01"}, "vertex-2025-06-01"},
+		{"channel default", map[string]string{"default": "vertex-2024-10-22"}, "vertex-2024-10-22"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := &relaycommon.RelayInfo{
+				OriginModelName:   "claude-sonnet-4-20250514",
+				UpstreamModelName: "claude-sonnet-4-20250514",
+				ChannelSetting:    dto.ChannelSettings{VertexAnthropicVersions: tt.versions},
+			}
+			adaptor := &Adaptor{RequestMode: RequestModeClaude}
+			converted, err := adaptor.ConvertClaudeRequest(newTestContext(), info, &dto.ClaudeRequest{
+				Model:     "claude-sonnet-4-20250514",
+				MaxTokens: 1024,
+			})
+			if err != nil {
+				t.Fatalf("ConvertClaudeRequest: %v", err)
+			}
+			body, err := common.Marshal(converted)
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			if !strings.Contains(string(body), `"anthropic_version":"`+tt.want+`"`) {
+				t.Errorf("body %s does not contain anthropic_version %s", body, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertClaudeRequestInvalidAnthropicVersion(t *testing.T) {
+	info := &relaycommon.RelayInfo{
+		OriginModelName: "claude-sonnet-4-20250514",
+		ChannelSetting:  dto.ChannelSettings{VertexAnthropicVersions: map[string]string{"default": "2023-06-01"}},
+	}
+	adaptor := &Adaptor{RequestMode: RequestModeClaude}
+	if _, err := adaptor.ConvertClaudeRequest(newTestContext(), info, &dto.ClaudeRequest{}); err == nil {
+		t.Fatal("expected an error for a malformed anthropic version")
+	}
+}
+
+func TestDoResponseReturnsRequestedModel(t *testing.T) {
+	const (
+		requested = "claude-3-5-sonnet-20241022"
+		upstream  = "claude-3-5-sonnet-v2@20241022"
+	)
+	claudeBody := `{"id":"msg_1","type":"message","role":"assistant","model":"` + upstream + `","content":[{"type":"text","text":"hi"}],"stop_reason":"end_turn","usage":{"input_tokens":3,"output_tokens":1}}`
+	geminiBody := `{"candidates":[{"content":{"role":"model","parts":[{"text":"hi"}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":3,"candidatesTokenCount":1,"totalTokenCount":4}}`
+	tests := []struct {
+		name        string
+		requestMode int
+		relayFormat string
+		body        string
+	}{
+		{"claude", RequestModeClaude, relaycommon.RelayFormatClaude, claudeBody},
+		{"claude to openai", RequestModeClaude, relaycommon.RelayFormatOpenAI, claudeBody},
+		{"gemini to openai", RequestModeGemini, relaycommon.RelayFormatOpenAI, geminiBody},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = httptest.NewRequest("POST", "/v1/messages", nil)
+			info := &relaycommon.RelayInfo{
+				RelayFormat:       tt.relayFormat,
+				RequestModelName:  requested,
+				OriginModelName:   "claude-sonnet-4-20250514",
+				UpstreamModelName: upstream,
+			}
+			resp := &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+				Body:       io.NopCloser(strings.NewReader(tt.body)),
+			}
+			adaptor := &Adaptor{RequestMode: tt.requestMode}
+			if _, err := adaptor.DoResponse(c, resp, info); err != nil {
+				t.Fatalf("DoResponse: %v", err)
+			}
+			var response struct {
+				Model string `json:"model"`
+			}
+			if err := common.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatalf("unmarshal %s: %v", recorder.Body.String(), err)
+			}
+			if response.Model != requested {
+				t.Errorf("model = %q, want %q", response.Model, requested)
+			}
+			// 设置了 Content-Length 时必须与改写后的响应体长度一致
+			if got, want := recorder.Header().Get("Content-Length"), strconv.Itoa(recorder.Body.Len()); got != "" && got != want {
+				t.Errorf("Content-Length = %s, body is %s bytes", got, want)
+			}
+		})
+	}
+}
+
+func TestDoResponseStreamReturnsRequestedModel(t *testing.T) {
+	constant.StreamingTimeout = 60
+	const requested = "claude-3-5-sonnet-20241022"
+	events := []string{
+		`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-3-5-sonnet-v2@20241022","content":[],"usage":{"input_tokens":3,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"hi"}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":1}}`,
+		`{"type":"message_stop"}`,
+	}
+	var body strings.Builder
+	for _, event := range events {
+		body.WriteString("data: " + event + "\n\n")
+	}
+	for _, relayFormat := range []string{relaycommon.RelayFormatClaude, relaycommon.RelayFormatOpenAI} {
+		t.Run(relayFormat, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = httptest.NewRequest("POST", "/v1/messages", nil)
+			info := &relaycommon.RelayInfo{
+				RelayFormat:        relayFormat,
+				IsStream:           true,
+				ShouldIncludeUsage: true,
+				RequestModelName:   requested,
+				OriginModelName:    "claude-sonnet-4-20250514",
+				UpstreamModelName:  "claude-3-5-sonnet-v2@20241022",
+			}
+			resp := &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+				Body:       io.NopCloser(strings.NewReader(body.String())),
+			}
+			adaptor := &Adaptor{RequestMode: RequestModeClaude}
+			if _, err := adaptor.DoResponse(c, resp, info); err != nil {
+				t.Fatalf("DoResponse: %v", err)
+			}
+			output := recorder.Body.String()
+			if strings.Contains(output, "v2@") {
+				t.Errorf("stream leaks upstream model: %s", output)
+			}
+			if !strings.Contains(output, `"model":"`+requested+`"`) {
+				t.Errorf("stream does not contain requested model: %s", output)
+			}
+		})
+	}
+}
+
+func TestSetupRequestHeaderRespectsStripHeadersForAnthropicBeta(t *testing.T) {
+	const channelId = 9103
+	const clientEmail = "header@test-project.iam.gserviceaccount.com"
+	Cache.SetDefault(fmt.Sprintf("access-token-%d-%s", channelId, clientEmail), "test-token")
+	tests := []struct {
+		name         string
+		stripHeaders []string
+		want         string
+	}{
+		{"forwarded", nil, "code-execution-2025-05-22"},
+		{"stripped", []string{"anthropic-beta"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestContext()
+			c.Request.Header.Set("anthropic-beta", "code-execution-2025-05-22")
+			info := &relaycommon.RelayInfo{
+				ChannelId:       channelId,
+				OriginModelName: "claude-sonnet-4-20250514",
+				ChannelSetting:  dto.ChannelSettings{StripHeaders: tt.stripHeaders},
+			}
+			adaptor := &Adaptor{RequestMode: RequestModeClaude, AccountCredentials: Credentials{ClientEmail: clientEmail}}
+			header := http.Header{}
+			if err := adaptor.SetupRequestHeader(c, &header, info); err != nil {
+				t.Fatalf("SetupRequestHeader: %v", err)
+			}
+			if got := header.Get("anthropic-beta"); got != tt.want {
+				t.Errorf("anthropic-beta = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAudioSpeechContentTypeMatchesFormat(t *testing.T) {
+	pcm := base64.StdEncoding.EncodeToString([]byte{0, 0, 1, 0, 2, 0, 3, 0})
+	ttsBody := `{"candidates":[{"content":{"role":"model","parts":[{"inlineData":{"mimeType":"audio/L16;codec=pcm;rate=24000","data":"` + pcm + `"}}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":5,"candidatesTokenCount":8,"totalTokenCount":13}}`
+	tests := []struct {
+		name        string
+		format      string
+		contentType string
+		bodyLen     int
+	}{
+		{"default", "", "audio/wav", 44 + 8},
+		{"wav", "wav", "audio/wav", 44 + 8},
+		{"pcm", "pcm", "audio/pcm", 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = httptest.NewRequest("POST", "/v1/audio/speech", nil)
+			info := &relaycommon.RelayInfo{
+				RelayMode:         relayconstant.RelayModeAudioSpeech,
+				OriginModelName:   "gemini-2.5-flash-preview-tts",
+				UpstreamModelName: "gemini-2.5-flash-preview-tts",
+			}
+			adaptor := &Adaptor{}
+			adaptor.Init(info)
+			reader, err := adaptor.ConvertAudioRequest(c, info, dto.AudioRequest{
+				Model:          "gemini-2.5-flash-preview-tts",
+				Input:          "hello",
+				Voice:          "puck",
+				ResponseFormat: tt.format,
+			})
+			if err != nil {
+				t.Fatalf("ConvertAudioRequest: %v", err)
+			}
+			requestBody, _ := io.ReadAll(reader)
+			if !strings.Contains(string(requestBody), `"voiceName":"Puck"`) || !strings.Contains(string(requestBody), `"responseModalities":["AUDIO"]`) {
+				t.Errorf("request body %s does not map voice and modality", requestBody)
+			}
+			resp := &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+				Body:       io.NopCloser(strings.NewReader(ttsBody)),
+			}
+			if _, apiErr := adaptor.DoResponse(c, resp, info); apiErr != nil {
+				t.Fatalf("DoResponse: %v", apiErr)
+			}
+			if got := recorder.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %s, want %s", got, tt.contentType)
+			}
+			if recorder.Body.Len() != tt.bodyLen {
+				t.Errorf("body is %d bytes, want %d", recorder.Body.Len(), tt.bodyLen)
+			}
+		})
+	}
+}
+
+func TestAudioSpeechRejectsUnsupportedVoiceAndFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		request dto.AudioRequest
+		want    string
+	}{
+		{"voice", dto.AudioRequest{Input: "hello", Voice: "alloy"}, "voice 'alloy' is not supported"},
+		{"format", dto.AudioRequest{Input: "hello", ResponseFormat: "mp3"}, "response_format 'mp3' is not supported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := &relaycommon.RelayInfo{
+				RelayMode:         relayconstant.RelayModeAudioSpeech,
+				UpstreamModelName: "gemini-2.5-flash-preview-tts",
+			}
+			adaptor := &Adaptor{}
+			adaptor.Init(info)
+			_, err := adaptor.ConvertAudioRequest(newTestContext(), info, tt.request)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetupRequestHeaderForwardsAllowedClientHeaders(t *testing.T) {
+	const channelId = 9104
+	const clientEmail = "forward@test-project.iam.gserviceaccount.com"
+	Cache.SetDefault(fmt.Sprintf("access-token-%d-%s", channelId, clientEmail), "test-token")
+
+	c := newTestContext()
+	c.Request.Header.Set("X-Trace-Id", "trace-1")
+	c.Request.Header.Set("Traceparent", "00-abc-def-01")
+	c.Request.Header.Set("X-Internal-Debug", "secret")
+	c.Request.Header.Set("X-Not-Listed", "value")
+	c.Request.Header.Set("Authorization", "Bearer client-key")
+	c.Request.Header.Set("Cookie", "session=client")
+	c.Request.Header.Set("X-Api-Key", "client-key")
+	info := &relaycommon.RelayInfo{
+		ChannelId:       channelId,
+		OriginModelName: "claude-sonnet-4-20250514",
+		ChannelSetting: dto.ChannelSettings{
+			ForwardHeaders: []string{"x-trace-id", "traceparent", "x-internal-debug", "authorization", "cookie", "x-api-key"},
+			StripHeaders:   []string{"X-Internal-Debug"},
+		},
+	}
+	adaptor := &Adaptor{RequestMode: RequestModeClaude, AccountCredentials: Credentials{ClientEmail: clientEmail}}
+	header := http.Header{}
+	if err := adaptor.SetupRequestHeader(c, &header, info); err != nil {
+		t.Fatalf("SetupRequestHeader: %v", err)
+	}
+	want := map[string]string{
+		"X-Trace-Id":       "trace-1",
+		"Traceparent":      "00-abc-def-01",
+		"X-Internal-Debug": "",
+		"X-Not-Listed":     "",
+		"Authorization":    "Bearer test-token",
+		"Cookie":           "",
+		"X-Api-Key":        "",
+	}
+	for key, value := range want {
+		if got := header.Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+}
+
+func TestSetupRequestHeaderSetsChannelUpstreamHeaders(t *testing.T) {
+	const channelId = 9105
+	const clientEmail = "upstream-headers@test-project.iam.gserviceaccount.com"
+	Cache.SetDefault(fmt.Sprintf("access-token-%d-%s", channelId, clientEmail), "test-token")
+
+	info := &relaycommon.RelayInfo{
+		ChannelId:       channelId,
+		OriginModelName: "claude-sonnet-4-20250514",
+		ChannelSetting: dto.ChannelSettings{
+			UpstreamHeaders: map[string]string{
+				"X-Goog-User-Project": "{project_id}-billing",
+				"X-Team":              "search",
+				"authorization":       "Bearer override",
+			},
+		},
+	}
+	adaptor := &Adaptor{RequestMode: RequestModeClaude, AccountCredentials: Credentials{ProjectID: "test-project", ClientEmail: clientEmail}}
+	header := http.Header{}
+	if err := adaptor.SetupRequestHeader(newTestContext(), &header, info); err != nil {
+		t.Fatalf("SetupRequestHeader: %v", err)
+	}
+	want := map[string]string{
+		"X-Goog-User-Project": "test-project-billing",
+		"X-Team":              "search",
+		"Authorization":       "Bearer test-token",
+	}
+	for key, value := range want {
+		if got := header.Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+	// 配置中的鉴权请求头在保存时即被拒绝
+	if err := info.ChannelSetting.Validate(); err == nil {
+		t.Error("Validate should reject an upstream Authorization header")
+	}
+}
+
+func TestDoRequestReturnsUpstreamURLInDebugMode(t *testing.T) {
+	const channelId = 9106
+	gin.SetMode(gin.TestMode)
+	newMockGoogleServer(t, channelId, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	originalDebug := common.DebugEnabled
+	defer func() { common.DebugEnabled = originalDebug }()
+
+	for _, debug := range []bool{true, false} {
+		common.DebugEnabled = debug
+		info := &relaycommon.RelayInfo{
+			ChannelId:         channelId,
+			ApiKey:            testBatchCredentials,
+			ApiVersion:        "us-central1",
+			OriginModelName:   "gemini-2.5-flash",
+			UpstreamModelName: "gemini-2.5-flash",
+			ChannelSetting:    dto.ChannelSettings{PreferGlobalRegion: true},
+		}
+		adaptor := &Adaptor{}
+		adaptor.Init(info)
+		wantURL, err := adaptor.GetRequestURL(info)
+		if err != nil {
+			t.Fatalf("GetRequestURL: %v", err)
+		}
+		if !strings.HasPrefix(wantURL, "https://aiplatform.googleapis.com/") {
+			t.Fatalf("url = %s, want the global endpoint", wantURL)
+		}
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		resp, err := adaptor.DoRequest(c, info, strings.NewReader(`{}`))
+		if err != nil {
+			t.Fatalf("DoRequest: %v", err)
+		}
+		resp.(*http.Response).Body.Close()
+		got := recorder.Header().Get("X-Upstream-URL")
+		if debug && got != wantURL {
+			t.Errorf("X-Upstream-URL = %q, want %q", got, wantURL)
+		}
+		if !debug && got != "" {
+			t.Errorf("X-Upstream-URL = %q outside debug mode, want empty", got)
+		}
+	}
+}
@@ -0,0 +1,470 @@
+package vertex
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"one-api/model"
+	"one-api/relay/channel"
+	"one-api/relay/channel/gemini"
+	relaycommon "one-api/relay/common"
+	"one-api/service"
+	"one-api/setting/model_setting"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// batchDefaultRegion 批量预测不支持 global 区域，渠道配置为 global 时使用该区域
+const batchDefaultRegion = "us-central1"
+
+// BatchSubmitRequest 批量预测任务提交请求，requests 与 input_uri 二选一
+// requests 中每一项为一个 Gemini generateContent 请求体，提交前以 JSONL 格式上传到 output_uri_prefix 所在的存储桶
+// 使用 input_uri 时需通过 request_count 提供文件中的请求数，用于预扣额度，完成时按实际用量结算
+type BatchSubmitRequest struct {
+	Model           string            `json:"model"`
+	DisplayName     string            `json:"display_name,omitempty"`
+	Requests        []json.RawMessage `json:"requests,omitempty"`
+	InputUri        string            `json:"input_uri,omitempty"`
+	RequestCount    int               `json:"request_count,omitempty"`
+	OutputUriPrefix string            `json:"output_uri_prefix"`
+}
+
+type batchGcsSource struct {
+	Uris []string `json:"uris"`
+}
+
+type batchGcsDestination struct {
+	OutputUriPrefix string `json:"outputUriPrefix"`
+}
+
+type batchPredictionJobRequest struct {
+	DisplayName string `json:"displayName"`
+	Model       string `json:"model"`
+	InputConfig struct {
+		InstancesFormat string         `json:"instancesFormat"`
+		GcsSource       batchGcsSource `json:"gcsSource"`
+	} `json:"inputConfig"`
+	OutputConfig struct {
+		PredictionsFormat string              `json:"predictionsFormat"`
+		GcsDestination    batchGcsDestination `json:"gcsDestination"`
+	} `json:"outputConfig"`
+}
+
+type batchPredictionJob struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+	OutputInfo *struct {
+		GcsOutputDirectory string `json:"gcsOutputDirectory"`
+	} `json:"outputInfo,omitempty"`
+}
+
+// BatchTaskAdaptor Vertex AI Gemini 批量预测（batchPredictionJobs）异步任务适配器
+type BatchTaskAdaptor struct {
+	adaptor Adaptor
+	region  string
+}
+
+func (a *BatchTaskAdaptor) Init(info *relaycommon.TaskRelayInfo) {
+	a.region = GetModelRegion(info.ApiVersion, info.OriginModelName)
+	if a.region == "" || a.region == "global" {
+		a.region = batchDefaultRegion
+	}
+}
+
+func (a *BatchTaskAdaptor) ValidateRequestAndSetAction(c *gin.Context, info *relaycommon.TaskRelayInfo) *dto.TaskError {
+	info.Action = constant.TaskActionBatchPredict
+
+	req := BatchSubmitRequest{}
+	if err := common.UnmarshalBodyReusable(c, &req); err != nil {
+		return service.TaskErrorWrapperLocal(err, "invalid_request", http.StatusBadRequest)
+	}
+	if !strings.HasPrefix(info.UpstreamModelName, "gemini") {
+		return service.TaskErrorWrapperLocal(fmt.Errorf("batch prediction is only supported for gemini models"), "invalid_request", http.StatusBadRequest)
+	}
+	if (len(req.Requests) == 0) == (req.InputUri == "") {
+		return service.TaskErrorWrapperLocal(fmt.Errorf("exactly one of requests and input_uri is required"), "invalid_request", http.StatusBadRequest)
+	}
+	if req.InputUri != "" && !strings.HasPrefix(req.InputUri, "gs://") {
+		return service.TaskErrorWrapperLocal(fmt.Errorf("input_uri must be a gs:// uri"), "invalid_request", http.StatusBadRequest)
+	}
+	if req.InputUri != "" && req.RequestCount <= 0 {
+		return service.TaskErrorWrapperLocal(fmt.Errorf("request_count is required with input_uri"), "invalid_request", http.StatusBadRequest)
+	}
+	info.BatchRequestCount = req.RequestCount
+	if len(req.Requests) > 0 {
+		info.BatchRequestCount = len(req.Requests)
+	}
+	if _, _, err := parseGcsUri(req.OutputUriPrefix); err != nil {
+		return service.TaskErrorWrapperLocal(fmt.Errorf("invalid output_uri_prefix: %w", err), "invalid_request", http.StatusBadRequest)
+	}
+	adc, err := parseCredentials(info.ApiKey, a.region)
+	if err != nil {
+		return service.TaskErrorWrapperLocal(err, "invalid_credentials", http.StatusInternalServerError)
+	}
+	a.adaptor.AccountCredentials = *adc
+	c.Set("task_request", req)
+	return nil
+}
+
+func (a *BatchTaskAdaptor) BuildRequestURL(info *relaycommon.TaskRelayInfo) (string, error) {
+	return fmt.Sprintf(
+		"https://%s/v1/projects/%s/locations/%s/batchPredictionJobs",
+		getApiHost(info.RelayInfo, a.region),
+		a.adaptor.AccountCredentials.ProjectID,
+		a.region,
+	), nil
+}
+
+func (a *BatchTaskAdaptor) BuildRequestHeader(c *gin.Context, req *http.Request, info *relaycommon.TaskRelayInfo) error {
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	return a.setAuthHeader(req.Header, info.RelayInfo)
+}
+
+func (a *BatchTaskAdaptor) BuildRequestBody(c *gin.Context, info *relaycommon.TaskRelayInfo) (io.Reader, error) {
+	v, exists := c.Get("task_request")
+	if !exists {
+		return nil, fmt.Errorf("request not found in context")
+	}
+	req := v.(BatchSubmitRequest)
+
+	inputUri := req.InputUri
+	if len(req.Requests) > 0 {
+		var err error
+		inputUri, err = a.uploadBatchInput(c, info.RelayInfo, req)
+		if err != nil {
+			return nil, fmt.Errorf("upload batch input failed: %w", err)
+		}
+	}
+
+	displayName := req.DisplayName
+	if displayName == "" {
+		displayName = fmt.Sprintf("batch-%s", common.GetRandomString(12))
+	}
+	jobRequest := batchPredictionJobRequest{
+		DisplayName: displayName,
+		Model:       "publishers/google/models/" + info.UpstreamModelName,
+	}
+	jobRequest.InputConfig.InstancesFormat = "jsonl"
+	jobRequest.InputConfig.GcsSource.Uris = []string{inputUri}
+	jobRequest.OutputConfig.PredictionsFormat = "jsonl"
+	jobRequest.OutputConfig.GcsDestination.OutputUriPrefix = req.OutputUriPrefix
+	data, err := common.Marshal(jobRequest)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+func (a *BatchTaskAdaptor) DoRequest(c *gin.Context, info *relaycommon.TaskRelayInfo, requestBody io.Reader) (*http.Response, error) {
+	return channel.DoTaskApiRequest(a, c, info, requestBody)
+}
+
+func (a *BatchTaskAdaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycommon.TaskRelayInfo) (taskID string, taskData []byte, taskErr *dto.TaskError) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", nil, service.TaskErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError)
+	}
+	_ = resp.Body.Close()
+
+	var job batchPredictionJob
+	if err := common.Unmarshal(responseBody, &job); err != nil {
+		return "", nil, service.TaskErrorWrapper(fmt.Errorf("%w, body: %s", err, responseBody), "unmarshal_response_body_failed", http.StatusInternalServerError)
+	}
+	// 任务名格式为 projects/{project}/locations/{region}/batchPredictionJobs/{id}，完整名称保存在任务数据中用于查询
+	taskID = job.Name[strings.LastIndex(job.Name, "/")+1:]
+	if taskID == "" {
+		return "", nil, service.TaskErrorWrapper(fmt.Errorf("empty batch prediction job name, body: %s", responseBody), "invalid_response", http.StatusInternalServerError)
+	}
+	common.LogInfo(c, fmt.Sprintf("[VERTEX] Batch prediction job created | Job:%s | State:%s", job.Name, job.State))
+	c.JSON(http.StatusOK, gin.H{"task_id": taskID})
+	return taskID, responseBody, nil
+}
+
+// initFetch 根据任务完整名称中的区域加载凭证，body 需包含 name（任务完整名称）、channel_id 与 channel_setting
+func (a *BatchTaskAdaptor) initFetch(key string, body map[string]any) (name string, region string, info *relaycommon.RelayInfo, err error) {
+	name, ok := body["name"].(string)
+	if !ok || name == "" {
+		return "", "", nil, fmt.Errorf("invalid batch prediction job name")
+	}
+	channelId, _ := body["channel_id"].(int)
+	setting, _ := body["channel_setting"].(dto.ChannelSettings)
+	info = &relaycommon.RelayInfo{ChannelId: channelId, ChannelSetting: setting}
+
+	region = batchDefaultRegion
+	if parts := strings.Split(name, "/"); len(parts) >= 4 && parts[2] == "locations" {
+		region = parts[3]
+	}
+	adc, err := parseCredentials(key, region)
+	if err != nil {
+		return "", "", nil, err
+	}
+	a.adaptor.AccountCredentials = *adc
+	return name, region, info, nil
+}
+
+// FetchTask 查询批量预测任务状态，body 需包含 name（任务完整名称）、channel_id 与 channel_setting
+func (a *BatchTaskAdaptor) FetchTask(baseUrl, key string, body map[string]any) (*http.Response, error) {
+	name, region, info, err := a.initFetch(key, body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("https://%s/v1/%s", getApiHost(info, region), name), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if err := a.setAuthHeader(req.Header, info); err != nil {
+		return nil, err
+	}
+	client, err := service.GetChannelHttpClient(info.ChannelId, info.ChannelSetting)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+type batchPredictionLine struct {
+	Status   string                     `json:"status"`
+	Response *gemini.GeminiChatResponse `json:"response"`
+}
+
+// FetchBatchUsage 读取已完成任务输出目录中的预测结果，汇总成功请求的用量，返回用量与成功的请求数
+// body 与 FetchTask 相同，另需包含 output_directory（任务详情中的 gcsOutputDirectory）
+func (a *BatchTaskAdaptor) FetchBatchUsage(key string, body map[string]any) (*dto.Usage, int, error) {
+	_, _, info, err := a.initFetch(key, body)
+	if err != nil {
+		return nil, 0, err
+	}
+	outputDirectory, _ := body["output_directory"].(string)
+	bucket, prefix, err := parseGcsUri(outputDirectory)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid output directory %q: %w", outputDirectory, err)
+	}
+	client, err := service.GetChannelHttpClient(info.ChannelId, info.ChannelSetting)
+	if err != nil {
+		return nil, 0, err
+	}
+	objects, err := a.listBatchOutputs(client, info, bucket, strings.TrimSuffix(prefix, "/")+"/")
+	if err != nil {
+		return nil, 0, fmt.Errorf("list batch outputs failed: %w", err)
+	}
+	usage := &dto.Usage{}
+	succeeded := 0
+	for _, object := range objects {
+		if err := a.readBatchOutput(client, info, bucket, object, func(line *batchPredictionLine) {
+			if line.Status != "" || line.Response == nil {
+				return
+			}
+			succeeded++
+			metadata := line.Response.UsageMetadata
+			usage.PromptTokens += metadata.PromptTokenCount
+			usage.CompletionTokens += metadata.CandidatesTokenCount + metadata.ThoughtsTokenCount
+			usage.CompletionTokenDetails.ReasoningTokens += metadata.ThoughtsTokenCount
+		}); err != nil {
+			return nil, 0, fmt.Errorf("read batch output %s failed: %w", object, err)
+		}
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return usage, succeeded, nil
+}
+
+// listBatchOutputs 列出输出目录中的预测结果文件
+func (a *BatchTaskAdaptor) listBatchOutputs(client *http.Client, info *relaycommon.RelayInfo, bucket string, prefix string) ([]string, error) {
+	var objects []string
+	pageToken := ""
+	for {
+		query := url.Values{}
+		query.Set("prefix", prefix)
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		var page struct {
+			Items []struct {
+				Name string `json:"name"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		listURL := fmt.Sprintf("https://storage.googleapis.com/storage/v1/b/%s/o?%s", url.PathEscape(bucket), query.Encode())
+		if err := a.doStorageRequest(client, info, listURL, func(body io.Reader) error {
+			return json.NewDecoder(body).Decode(&page)
+		}); err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			if strings.HasSuffix(item.Name, ".jsonl") {
+				objects = append(objects, item.Name)
+			}
+		}
+		if page.NextPageToken == "" {
+			return objects, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// readBatchOutput 逐行读取预测结果文件
+func (a *BatchTaskAdaptor) readBatchOutput(client *http.Client, info *relaycommon.RelayInfo, bucket string, object string, handle func(line *batchPredictionLine)) error {
+	downloadURL := fmt.Sprintf("https://storage.googleapis.com/storage/v1/b/%s/o/%s?alt=media", url.PathEscape(bucket), url.PathEscape(object))
+	return a.doStorageRequest(client, info, downloadURL, func(body io.Reader) error {
+		reader := bufio.NewReader(body)
+		for {
+			data, err := reader.ReadBytes('\n')
+			if len(bytes.TrimSpace(data)) > 0 {
+				var line batchPredictionLine
+				if unmarshalErr := common.Unmarshal(data, &line); unmarshalErr != nil {
+					return unmarshalErr
+				}
+				handle(&line)
+			}
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	})
+}
+
+func (a *BatchTaskAdaptor) doStorageRequest(client *http.Client, info *relaycommon.RelayInfo, requestURL string, handle func(body io.Reader) error) error {
+	req, err := http.NewRequest(http.MethodGet, requestURL, nil)
+	if err != nil {
+		return err
+	}
+	if err := a.setAuthHeader(req.Header, info); err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("status code %d: %s", resp.StatusCode, body)
+	}
+	return handle(resp.Body)
+}
+
+func (a *BatchTaskAdaptor) ParseTaskResult(respBody []byte) (*relaycommon.TaskInfo, error) {
+	var job batchPredictionJob
+	if err := common.Unmarshal(respBody, &job); err != nil {
+		return nil, fmt.Errorf("unmarshal batch prediction job failed: %w", err)
+	}
+	if job.Name == "" && job.Error != nil {
+		return nil, fmt.Errorf("fetch batch prediction job failed: %s", job.Error.Message)
+	}
+	taskResult := &relaycommon.TaskInfo{
+		TaskID: job.Name[strings.LastIndex(job.Name, "/")+1:],
+	}
+	switch job.State {
+	case "JOB_STATE_QUEUED", "JOB_STATE_PENDING":
+		taskResult.Status = model.TaskStatusQueued
+	case "JOB_STATE_RUNNING", "JOB_STATE_UPDATING", "JOB_STATE_PAUSED", "JOB_STATE_CANCELLING":
+		taskResult.Status = model.TaskStatusInProgress
+	case "JOB_STATE_SUCCEEDED", "JOB_STATE_PARTIALLY_SUCCEEDED":
+		taskResult.Status = model.TaskStatusSuccess
+		if job.OutputInfo != nil {
+			taskResult.Url = job.OutputInfo.GcsOutputDirectory
+		}
+	case "JOB_STATE_FAILED", "JOB_STATE_CANCELLED", "JOB_STATE_EXPIRED":
+		taskResult.Status = model.TaskStatusFailure
+		taskResult.Reason = job.State
+		if job.Error != nil && job.Error.Message != "" {
+			taskResult.Reason = job.Error.Message
+		}
+	default:
+		taskResult.Status = model.TaskStatusUnknown
+	}
+	return taskResult, nil
+}
+
+func (a *BatchTaskAdaptor) GetModelList() []string {
+	return []string{"gemini-2.5-pro", "gemini-2.5-flash", "gemini-2.5-flash-lite", "gemini-2.0-flash"}
+}
+
+func (a *BatchTaskAdaptor) GetChannelName() string {
+	return ChannelName
+}
+
+func (a *BatchTaskAdaptor) setAuthHeader(header http.Header, info *relaycommon.RelayInfo) error {
+	header.Set("User-Agent", model_setting.GetVertexSettings().GetUserAgent())
+	if info.ChannelSetting.VertexQuotaProject != "" {
+		header.Set("X-Goog-User-Project", info.ChannelSetting.VertexQuotaProject)
+	}
+	accessToken, err := getAccessToken(&a.adaptor, info)
+	if err != nil {
+		return newAuthAPIError(err)
+	}
+	header.Set("Authorization", "Bearer "+accessToken)
+	return nil
+}
+
+// uploadBatchInput 将内联请求以 JSONL 格式上传到输出存储桶，返回输入文件的 gs:// 地址
+func (a *BatchTaskAdaptor) uploadBatchInput(c *gin.Context, info *relaycommon.RelayInfo, req BatchSubmitRequest) (string, error) {
+	bucket, prefix, err := parseGcsUri(req.OutputUriPrefix)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	for _, request := range req.Requests {
+		line, err := common.Marshal(map[string]json.RawMessage{"request": request})
+		if err != nil {
+			return "", err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	object := strings.TrimPrefix(strings.TrimSuffix(prefix, "/")+"/input-"+common.GetRandomString(16)+".jsonl", "/")
+	uploadURL := fmt.Sprintf("https://storage.googleapis.com/upload/storage/v1/b/%s/o?uploadType=media&name=%s", url.PathEscape(bucket), url.QueryEscape(object))
+	httpReq, err := http.NewRequestWithContext(c.Request.Context(), http.MethodPost, uploadURL, &buf)
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Content-Type", "application/jsonl")
+	if err := a.setAuthHeader(httpReq.Header, info); err != nil {
+		return "", err
+	}
+	client, err := service.GetChannelHttpClient(info.ChannelId, info.ChannelSetting)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("status code %d: %s", resp.StatusCode, body)
+	}
+	inputUri := fmt.Sprintf("gs://%s/%s", bucket, object)
+	common.LogInfo(c, fmt.Sprintf("[VERTEX] Batch input uploaded | Uri:%s | Requests:%d", inputUri, len(req.Requests)))
+	return inputUri, nil
+}
+
+// parseGcsUri 解析 gs://bucket/prefix 格式的地址
+func parseGcsUri(uri string) (bucket string, object string, err error) {
+	if !strings.HasPrefix(uri, "gs://") {
+		return "", "", errors.New("must be a gs:// uri")
+	}
+	bucket, object, _ = strings.Cut(strings.TrimPrefix(uri, "gs://"), "/")
+	if bucket == "" {
+		return "", "", errors.New("bucket is empty")
+	}
+	return bucket, object, nil
+}
@@ -0,0 +1,176 @@
+package vertex
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/service"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testBatchCredentials = `{"project_id":"test-project","private_key":"unused","client_email":"batch@test-project.iam.gserviceaccount.com"}`
+
+// redirectTransport 将所有请求转发到测试服务器
+type redirectTransport struct {
+	target *url.URL
+	base   http.RoundTripper
+}
+
+func (t *redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+	return t.base.RoundTrip(req)
+}
+
+// newMockGoogleServer 启动模拟的 Vertex AI 与 Cloud Storage 接口，并预置渠道的 access token
+func newMockGoogleServer(t *testing.T, channelId int, handler http.Handler) {
+	t.Helper()
+	server := httptest.NewTLSServer(handler)
+	target, _ := url.Parse(server.URL)
+	originalTransport := http.DefaultTransport
+	http.DefaultTransport = &redirectTransport{target: target, base: server.Client().Transport}
+	service.InitHttpClient()
+	t.Cleanup(func() {
+		http.DefaultTransport = originalTransport
+		server.Close()
+	})
+	Cache.SetDefault(fmt.Sprintf("access-token-%d-%s", channelId, "batch@test-project.iam.gserviceaccount.com"), "test-token")
+}
+
+func TestBatchTaskSubmitAgainstMockEndpoint(t *testing.T) {
+	const channelId = 9101
+	var uploaded, jobRequest string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/upload/storage/v1/b/test-bucket/o", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		uploaded = string(body)
+		w.Write([]byte(`{}`))
+	})
+	mux.HandleFunc("/v1/projects/test-project/locations/us-central1/batchPredictionJobs", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		jobRequest = string(body)
+		w.Write([]byte(`{"name":"projects/test-project/locations/us-central1/batchPredictionJobs/4242","state":"JOB_STATE_PENDING"}`))
+	})
+	newMockGoogleServer(t, channelId, mux)
+
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/vertex/batches", strings.NewReader(
+		`{"model":"gemini-2.5-flash","requests":[{"contents":[{"role":"user","parts":[{"text":"a"}]}]},{"contents":[{"role":"user","parts":[{"text":"b"}]}]}],"output_uri_prefix":"gs://test-bucket/jobs"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	info := &relaycommon.TaskRelayInfo{RelayInfo: &relaycommon.RelayInfo{
+		ChannelId:         channelId,
+		ApiKey:            testBatchCredentials,
+		OriginModelName:   "gemini-2.5-flash",
+		UpstreamModelName: "gemini-2.5-flash",
+	}}
+
+	adaptor := &BatchTaskAdaptor{}
+	adaptor.Init(info)
+	if taskErr := adaptor.ValidateRequestAndSetAction(c, info); taskErr != nil {
+		t.Fatalf("ValidateRequestAndSetAction: %s", taskErr.Message)
+	}
+	if info.BatchRequestCount != 2 {
+		t.Errorf("BatchRequestCount = %d, want 2", info.BatchRequestCount)
+	}
+	body, err := adaptor.BuildRequestBody(c, info)
+	if err != nil {
+		t.Fatalf("BuildRequestBody: %v", err)
+	}
+	resp, err := adaptor.DoRequest(c, info, body)
+	if err != nil {
+		t.Fatalf("DoRequest: %v", err)
+	}
+	taskID, _, taskErr := adaptor.DoResponse(c, resp, info)
+	if taskErr != nil {
+		t.Fatalf("DoResponse: %s", taskErr.Message)
+	}
+	if taskID != "4242" {
+		t.Errorf("taskID = %q, want 4242", taskID)
+	}
+	if strings.Count(uploaded, "\n") != 2 {
+		t.Errorf("uploaded input should have 2 lines, got %q", uploaded)
+	}
+	if !strings.Contains(jobRequest, `"model":"publishers/google/models/gemini-2.5-flash"`) || !strings.Contains(jobRequest, "gs://test-bucket/jobs/input-") {
+		t.Errorf("unexpected job request %s", jobRequest)
+	}
+}
+
+func TestBatchTaskRequiresRequestCountWithInputUri(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/vertex/batches", strings.NewReader(
+		`{"model":"gemini-2.5-flash","input_uri":"gs://test-bucket/input.jsonl","output_uri_prefix":"gs://test-bucket/jobs"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	info := &relaycommon.TaskRelayInfo{RelayInfo: &relaycommon.RelayInfo{
+		ApiKey:            testBatchCredentials,
+		UpstreamModelName: "gemini-2.5-flash",
+	}}
+	adaptor := &BatchTaskAdaptor{}
+	adaptor.Init(info)
+	if taskErr := adaptor.ValidateRequestAndSetAction(c, info); taskErr == nil {
+		t.Fatal("expected an error when request_count is missing")
+	}
+}
+
+func TestFetchBatchUsageAgainstMockEndpoint(t *testing.T) {
+	const channelId = 9102
+	const outputPrefix = "jobs/prediction-model-1/"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/storage/v1/b/test-bucket/o", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("prefix") != outputPrefix {
+			t.Errorf("prefix = %q, want %q", r.URL.Query().Get("prefix"), outputPrefix)
+		}
+		if r.URL.Query().Get("pageToken") == "" {
+			w.Write([]byte(`{"items":[{"name":"jobs/prediction-model-1/predictions_1.jsonl"}],"nextPageToken":"next"}`))
+			return
+		}
+		w.Write([]byte(`{"items":[{"name":"jobs/prediction-model-1/predictions_2.jsonl"},{"name":"jobs/prediction-model-1/"}]}`))
+	})
+	mux.HandleFunc("/storage/v1/b/test-bucket/o/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("alt") != "media" {
+			t.Errorf("download without alt=media: %s", r.URL)
+		}
+		switch strings.TrimPrefix(r.URL.Path, "/storage/v1/b/test-bucket/o/") {
+		case "jobs/prediction-model-1/predictions_1.jsonl":
+			w.Write([]byte(`{"status":"","response":{"usageMetadata":{"promptTokenCount":10,"candidatesTokenCount":20,"thoughtsTokenCount":5,"totalTokenCount":35}}}
+{"status":"Bad Request: invalid contents"}
+`))
+		case "jobs/prediction-model-1/predictions_2.jsonl":
+			w.Write([]byte(`{"status":"","response":{"usageMetadata":{"promptTokenCount":7,"candidatesTokenCount":3,"totalTokenCount":10}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	newMockGoogleServer(t, channelId, mux)
+
+	adaptor := &BatchTaskAdaptor{}
+	usage, succeeded, err := adaptor.FetchBatchUsage(testBatchCredentials, map[string]any{
+		"name":             "projects/test-project/locations/us-central1/batchPredictionJobs/4242",
+		"channel_id":       channelId,
+		"channel_setting":  dto.ChannelSettings{},
+		"output_directory": "gs://test-bucket/jobs/prediction-model-1",
+	})
+	if err != nil {
+		t.Fatalf("FetchBatchUsage: %v", err)
+	}
+	if succeeded != 2 {
+		t.Errorf("succeeded = %d, want 2", succeeded)
+	}
+	if usage.PromptTokens != 17 || usage.CompletionTokens != 28 || usage.TotalTokens != 45 {
+		t.Errorf("usage = %+v, want prompt 17 completion 28 total 45", usage)
+	}
+}
@@ -0,0 +1,155 @@
+package vertex
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/relay/channel"
+	relaycommon "one-api/relay/common"
+	"one-api/setting/model_setting"
+	"one-api/types"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func isEmbeddingModel(model string) bool {
+	return strings.HasPrefix(model, "text-embedding") ||
+		strings.HasPrefix(model, "text-multilingual-embedding") ||
+		strings.HasPrefix(model, "textembedding-gecko") ||
+		strings.HasPrefix(model, "gemini-embedding")
+}
+
+func convertEmbeddingRequest(request dto.EmbeddingRequest) (*VertexEmbeddingRequest, error) {
+	if request.Input == nil {
+		return nil, errors.New("input is required")
+	}
+	inputs := request.ParseInput()
+	if len(inputs) == 0 {
+		return nil, errors.New("input is empty")
+	}
+	vertexRequest := &VertexEmbeddingRequest{
+		Instances: make([]VertexEmbeddingInstance, 0, len(inputs)),
+	}
+	for _, input := range inputs {
+		vertexRequest.Instances = append(vertexRequest.Instances, VertexEmbeddingInstance{Content: input})
+	}
+	if request.Dimensions > 0 {
+		vertexRequest.Parameters = &VertexEmbeddingParameters{OutputDimensionality: request.Dimensions}
+	}
+	return vertexRequest, nil
+}
+
+// doEmbeddingRequest 输入数超过单次请求上限时拆分为多次上游请求，并按顺序合并结果
+func (a *Adaptor) doEmbeddingRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (*http.Response, error) {
+	body, err := io.ReadAll(requestBody)
+	if err != nil {
+		return nil, err
+	}
+	var request VertexEmbeddingRequest
+	if err := common.Unmarshal(body, &request); err != nil {
+		return nil, err
+	}
+	batchSize := model_setting.GetVertexSettings().GetEmbeddingBatchSize(info.UpstreamModelName)
+	if len(request.Instances) <= batchSize {
+		return channel.DoApiRequest(a, c, info, bytes.NewReader(body))
+	}
+
+	common.LogInfo(c, fmt.Sprintf("[VERTEX] Embedding request chunked | Inputs:%d | BatchSize:%d", len(request.Instances), batchSize))
+	merged := VertexEmbeddingResponse{
+		Predictions: make([]VertexEmbeddingPrediction, 0, len(request.Instances)),
+	}
+	var lastResp *http.Response
+	for start := 0; start < len(request.Instances); start += batchSize {
+		end := min(start+batchSize, len(request.Instances))
+		chunk := VertexEmbeddingRequest{
+			Instances:  request.Instances[start:end],
+			Parameters: request.Parameters,
+		}
+		chunkBody, err := common.Marshal(chunk)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := channel.DoApiRequest(a, c, info, bytes.NewReader(chunkBody))
+		if err != nil {
+			return nil, err
+		}
+		// 任一分片失败时直接返回该响应，由上层统一处理错误
+		if resp.StatusCode != http.StatusOK {
+			return resp, nil
+		}
+		var chunkResponse VertexEmbeddingResponse
+		responseBody, err := io.ReadAll(resp.Body)
+		common.CloseResponseBodyGracefully(resp)
+		if err != nil {
+			return nil, err
+		}
+		if err := common.Unmarshal(responseBody, &chunkResponse); err != nil {
+			return nil, err
+		}
+		if len(chunkResponse.Predictions) != end-start {
+			return nil, fmt.Errorf("embedding chunk returned %d predictions, expected %d", len(chunkResponse.Predictions), end-start)
+		}
+		merged.Predictions = append(merged.Predictions, chunkResponse.Predictions...)
+		lastResp = resp
+	}
+
+	mergedBody, err := common.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        lastResp.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(mergedBody)),
+		ContentLength: int64(len(mergedBody)),
+	}, nil
+}
+
+func VertexEmbeddingHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Response) (*dto.Usage, *types.NewAPIError) {
+	defer common.CloseResponseBodyGracefully(resp)
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeBadResponseBody)
+	}
+	var vertexResponse VertexEmbeddingResponse
+	if err := common.Unmarshal(responseBody, &vertexResponse); err != nil {
+		return nil, types.NewError(err, types.ErrorCodeBadResponseBody)
+	}
+
+	openAIResponse := dto.OpenAIEmbeddingResponse{
+		Object: "list",
+		Data:   make([]dto.OpenAIEmbeddingResponseItem, 0, len(vertexResponse.Predictions)),
+		Model:  info.ResponseModel(info.UpstreamModelName),
+	}
+	promptTokens := 0
+	for i, prediction := range vertexResponse.Predictions {
+		openAIResponse.Data = append(openAIResponse.Data, dto.OpenAIEmbeddingResponseItem{
+			Object:    "embedding",
+			Embedding: prediction.Embeddings.Values,
+			Index:     i,
+		})
+		promptTokens += prediction.Embeddings.Statistics.TokenCount
+	}
+	// 上游未返回 token 统计时使用本地计算的输入 token
+	if promptTokens == 0 {
+		promptTokens = info.PromptTokens
+	}
+	usage := &dto.Usage{
+		PromptTokens: promptTokens,
+		TotalTokens:  promptTokens,
+	}
+	openAIResponse.Usage = *usage
+
+	jsonResponse, err := common.Marshal(openAIResponse)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeBadResponseBody)
+	}
+	common.IOCopyBytesGracefully(c, resp, jsonResponse)
+	return usage, nil
+}
@@ -0,0 +1,120 @@
+package vertex
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/setting/model_setting"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newMockEmbeddingServer 模拟 Vertex embedding 接口，向量取输入中的序号，token 数为序号的位数
+func newMockEmbeddingServer(t *testing.T, channelId int) *[]int {
+	t.Helper()
+	var chunkSizes []int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/projects/test-project/locations/us-central1/publishers/google/models/text-embedding-005:predict", func(w http.ResponseWriter, r *http.Request) {
+		var request VertexEmbeddingRequest
+		body, _ := io.ReadAll(r.Body)
+		if err := common.Unmarshal(body, &request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		chunkSizes = append(chunkSizes, len(request.Instances))
+		var response VertexEmbeddingResponse
+		for _, instance := range request.Instances {
+			n, _ := strconv.Atoi(strings.TrimPrefix(instance.Content, "input-"))
+			var prediction VertexEmbeddingPrediction
+			prediction.Embeddings.Values = []float64{float64(n)}
+			prediction.Embeddings.Statistics.TokenCount = len(strconv.Itoa(n))
+			response.Predictions = append(response.Predictions, prediction)
+		}
+		data, _ := common.Marshal(response)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+	newMockGoogleServer(t, channelId, mux)
+	return &chunkSizes
+}
+
+func TestEmbeddingChunksLargeInputInOrder(t *testing.T) {
+	const channelId = 9121
+	vertexSettings := model_setting.GetVertexSettings()
+	originalBatchSize := vertexSettings.EmbeddingBatchSize
+	defer func() { vertexSettings.EmbeddingBatchSize = originalBatchSize }()
+
+	// 与 JSON 解析后的请求一致，input 为 []any
+	inputs := make([]any, 600)
+	wantTokens := 0
+	for i := range inputs {
+		inputs[i] = "input-" + strconv.Itoa(i)
+		wantTokens += len(strconv.Itoa(i))
+	}
+	tests := []struct {
+		name       string
+		batchSize  map[string]int
+		wantChunks []int
+	}{
+		{"default batch size", map[string]int{"default": 250}, []int{250, 250, 100}},
+		{"per-model batch size", map[string]int{"default": 250, "text-embedding-005": 100}, []int{100, 100, 100, 100, 100, 100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vertexSettings.EmbeddingBatchSize = tt.batchSize
+			chunkSizes := newMockEmbeddingServer(t, channelId)
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/embeddings", nil)
+			info := &relaycommon.RelayInfo{
+				ChannelId:         channelId,
+				ApiKey:            testBatchCredentials,
+				ApiVersion:        "us-central1",
+				OriginModelName:   "text-embedding-005",
+				UpstreamModelName: "text-embedding-005",
+			}
+			adaptor := &Adaptor{}
+			adaptor.Init(info)
+			converted, err := adaptor.ConvertEmbeddingRequest(c, info, dto.EmbeddingRequest{Model: "text-embedding-005", Input: inputs})
+			if err != nil {
+				t.Fatalf("ConvertEmbeddingRequest: %v", err)
+			}
+			body, _ := common.Marshal(converted)
+			resp, err := adaptor.DoRequest(c, info, bytes.NewReader(body))
+			if err != nil {
+				t.Fatalf("DoRequest: %v", err)
+			}
+			usage, apiErr := adaptor.DoResponse(c, resp.(*http.Response), info)
+			if apiErr != nil {
+				t.Fatalf("DoResponse: %v", apiErr)
+			}
+
+			if !slices.Equal(*chunkSizes, tt.wantChunks) {
+				t.Errorf("upstream chunks = %v, want %v", *chunkSizes, tt.wantChunks)
+			}
+			var response dto.OpenAIEmbeddingResponse
+			if err := common.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatalf("unmarshal response: %v", err)
+			}
+			if len(response.Data) != len(inputs) {
+				t.Fatalf("got %d embeddings, want %d", len(response.Data), len(inputs))
+			}
+			for i, item := range response.Data {
+				if item.Index != i || len(item.Embedding) != 1 || item.Embedding[0] != float64(i) {
+					t.Fatalf("embedding %d = index %d %v, want the vector for input-%d", i, item.Index, item.Embedding, i)
+				}
+			}
+			if got := usage.(*dto.Usage).PromptTokens; got != wantTokens || response.Usage.PromptTokens != wantTokens {
+				t.Errorf("prompt tokens = %d (response %d), want %d summed across chunks", got, response.Usage.PromptTokens, wantTokens)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package vertex
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// modelAvailableRegions 各模型在 Vertex AI 上可用的区域，按模型名前缀匹配（最长前缀优先）
+// 数据来源于 Vertex AI 官方文档，模型上线新区域后需要同步更新
+var modelAvailableRegions = map[string][]string{
+	"claude-opus-4":               {"global", "us-east5", "europe-west1"},
+	"claude-sonnet-4":             {"global", "us-east5", "europe-west1", "asia-east1"},
+	"claude-3-7-sonnet":           {"us-east5", "europe-west1"},
+	"claude-3-5-sonnet":           {"us-east5", "europe-west1"},
+	"claude-3-5-haiku":            {"us-east5"},
+	"claude-3-opus":               {"us-east5"},
+	"claude-3-haiku":              {"us-east5", "europe-west1", "asia-southeast1"},
+	"gemini-2.5-":                 {"global", "us-central1", "us-east1", "us-east4", "us-east5", "us-south1", "us-west1", "us-west4", "europe-central2", "europe-north1", "europe-southwest1", "europe-west1", "europe-west4", "europe-west8", "europe-west9"},
+	"gemini-2.0-flash":            {"global", "us-central1", "us-east1", "us-east4", "us-east5", "us-south1", "us-west1", "us-west4", "europe-central2", "europe-north1", "europe-southwest1", "europe-west1", "europe-west4", "europe-west8", "europe-west9"},
+	"gemini-embedding":            {"us-central1", "us-east1", "us-east4", "us-west1", "europe-west1", "europe-west4", "asia-northeast1"},
+	"text-embedding":              {"us-central1", "us-east1", "us-east4", "us-west1", "europe-west1", "europe-west4", "asia-northeast1", "asia-southeast1"},
+	"text-multilingual-embedding": {"us-central1", "us-east1", "us-east4", "us-west1", "europe-west1", "europe-west4", "asia-northeast1", "asia-southeast1"},
+}
+
+// 模型到可用区域的匹配结果缓存，表为静态数据，缓存无需失效
+var availableRegionsCache sync.Map
+
+// GetAvailableRegions 获取模型可用的区域列表，未收录的模型返回 nil
+func GetAvailableRegions(model string) []string {
+	model = strings.TrimSuffix(model, "-thinking")
+	if cached, ok := availableRegionsCache.Load(model); ok {
+		return slices.Clone(cached.([]string))
+	}
+	matchedPrefix := ""
+	var regions []string
+	for prefix, available := range modelAvailableRegions {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(matchedPrefix) {
+			matchedPrefix = prefix
+			regions = available
+		}
+	}
+	availableRegionsCache.Store(model, regions)
+	return slices.Clone(regions)
+}
+
+// ValidateModelRegions 校验渠道区域配置中各模型的区域是否可用，未收录的模型跳过校验
+// modelMapping 为渠道的模型重定向，按重定向后的上游模型校验
+func ValidateModelRegions(other string, models []string, modelMapping map[string]string) error {
+	for _, model := range models {
+		upstreamModel := model
+		if mapped, ok := modelMapping[model]; ok && mapped != "" {
+			upstreamModel = mapped
+		}
+		available := GetAvailableRegions(upstreamModel)
+		if len(available) == 0 {
+			continue
+		}
+		for _, region := range GetModelRegions(other, model) {
+			if !slices.Contains(available, region) {
+				return fmt.Errorf("model %s is not available in region %s, available regions: %s", upstreamModel, region, strings.Join(available, ", "))
+			}
+		}
+	}
+	return nil
+}
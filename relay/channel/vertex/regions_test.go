@@ -0,0 +1,51 @@
+package vertex
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestGetAvailableRegionsForKnownModels(t *testing.T) {
+	regions := GetAvailableRegions("claude-sonnet-4-20250514")
+	for _, want := range []string{"us-east5", "europe-west1", "global"} {
+		if !slices.Contains(regions, want) {
+			t.Errorf("claude-sonnet-4 regions = %v, want %s", regions, want)
+		}
+	}
+	// Claude 不在 us-central1 提供
+	if slices.Contains(regions, "us-central1") {
+		t.Errorf("claude-sonnet-4 regions = %v, must not include us-central1", regions)
+	}
+	if got := GetAvailableRegions("claude-sonnet-4-20250514-thinking"); !slices.Equal(got, regions) {
+		t.Errorf("-thinking suffix regions = %v, want %v", got, regions)
+	}
+	if got := GetAvailableRegions("claude-3-5-haiku-20241022"); !slices.Equal(got, []string{"us-east5"}) {
+		t.Errorf("claude-3-5-haiku regions = %v, want [us-east5]", got)
+	}
+	if got := GetAvailableRegions("unknown-model"); got != nil {
+		t.Errorf("unknown model regions = %v, want nil", got)
+	}
+
+	// 返回副本，调用方修改不影响缓存
+	regions[0] = "mutated"
+	if slices.Contains(GetAvailableRegions("claude-sonnet-4-20250514"), "mutated") {
+		t.Error("the cached region list was mutated through a returned slice")
+	}
+}
+
+func TestValidateModelRegions(t *testing.T) {
+	const regions = `{"default":"us-central1","claude-*":"us-east5"}`
+	if err := ValidateModelRegions(regions, []string{"claude-sonnet-4-20250514", "gemini-2.5-flash", "unknown-model"}, nil); err != nil {
+		t.Errorf("valid configuration rejected: %v", err)
+	}
+	err := ValidateModelRegions(`{"default":"us-central1"}`, []string{"claude-sonnet-4-20250514"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "not available in region us-central1") {
+		t.Errorf("err = %v, want claude-sonnet-4 rejected in us-central1", err)
+	}
+	// 按重定向后的上游模型校验
+	err = ValidateModelRegions(`{"default":"europe-west1"}`, []string{"fast"}, map[string]string{"fast": "claude-3-5-haiku-20241022"})
+	if err == nil || !strings.Contains(err.Error(), "claude-3-5-haiku-20241022") {
+		t.Errorf("err = %v, want the mapped model rejected in europe-west1", err)
+	}
+}
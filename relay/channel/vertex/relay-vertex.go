@@ -1,19 +1,192 @@
 package vertex
 
-import "one-api/common"
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/types"
+	"slices"
+	"strings"
+)
 
+// GetModelRegion 获取模型使用的区域，配置了多个区域时返回首选区域
 func GetModelRegion(other string, localModelName string) string {
+	regions := GetModelRegions(other, localModelName)
+	if len(regions) == 0 {
+		return ""
+	}
+	return regions[0]
+}
+
+// GetModelRegions 获取模型按优先级排列的区域列表
+// 区域配置可以是单个区域字符串，也可以是 JSON 对象，值为区域字符串或按优先级排列的区域数组，
+// 键按 精确模型名 > 通配前缀（如 "claude-*"，最长前缀优先）> default 的顺序匹配
+func GetModelRegions(other string, localModelName string) []string {
 	// if other is json string
 	if common.IsJsonObject(other) {
 		m, err := common.StrToMap(other)
 		if err != nil {
-			return other // return original if parsing fails
+			return []string{other} // return original if parsing fails
+		}
+		if v, ok := m[localModelName]; ok {
+			if regions := parseRegions(v); len(regions) > 0 {
+				return regions
+			}
 		}
-		if m[localModelName] != nil {
-			return m[localModelName].(string)
-		} else {
-			return m["default"].(string)
+		matchedPrefix := ""
+		var matchedRegions []string
+		for key, v := range m {
+			if !strings.HasSuffix(key, "*") {
+				continue
+			}
+			prefix := strings.TrimSuffix(key, "*")
+			if strings.HasPrefix(localModelName, prefix) && len(prefix) >= len(matchedPrefix) {
+				if regions := parseRegions(v); len(regions) > 0 {
+					matchedPrefix = prefix
+					matchedRegions = regions
+				}
+			}
 		}
+		if matchedRegions != nil {
+			return matchedRegions
+		}
+		return parseRegions(m["default"])
+	}
+	if other == "" {
+		return nil
+	}
+	return []string{other}
+}
+
+func parseRegions(v any) []string {
+	switch regions := v.(type) {
+	case string:
+		if regions == "" {
+			return nil
+		}
+		return []string{regions}
+	case []any:
+		result := make([]string, 0, len(regions))
+		for _, region := range regions {
+			if s, ok := region.(string); ok && s != "" {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// globalRegionModelPrefixes 支持 global 端点的模型前缀
+var globalRegionModelPrefixes = []string{
+	"claude-sonnet-4",
+	"claude-opus-4",
+	"claude-haiku-4",
+	"gemini-2.0-flash",
+	"gemini-2.5-",
+}
+
+func supportsGlobalRegion(model string) bool {
+	for _, prefix := range globalRegionModelPrefixes {
+		if strings.HasPrefix(model, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveRequestRegion 获取请求使用的区域，渠道开启优先 global 且模型支持时使用 global 端点
+// 请求头 X-Vertex-Endpoint 指定了端点类型时优先于渠道配置，区域故障转移指定的区域优先级最高
+func resolveRequestRegion(info *relaycommon.RelayInfo, requestMode int) (string, error) {
+	if info.VertexRegion != "" {
+		return info.VertexRegion, nil
+	}
+	if info.VertexEndpoint != "" {
+		return resolveRequestedEndpointRegion(info, requestMode)
+	}
+	return resolveDefaultRegion(info, requestMode), nil
+}
+
+// FailoverRegions 返回首选区域不可用时按优先级依次尝试的其他区域，请求头指定了端点类型时不做故障转移
+func FailoverRegions(info *relaycommon.RelayInfo, requestMode int) []string {
+	if info.VertexEndpoint != "" {
+		return nil
+	}
+	first := resolveDefaultRegion(info, requestMode)
+	var regions []string
+	for _, region := range GetModelRegions(info.ApiVersion, info.OriginModelName) {
+		if region != first && !slices.Contains(regions, region) {
+			regions = append(regions, region)
+		}
+	}
+	return regions
+}
+
+func resolveDefaultRegion(info *relaycommon.RelayInfo, requestMode int) string {
+	region := GetModelRegion(info.ApiVersion, info.OriginModelName)
+	if !info.ChannelSetting.PreferGlobalRegion || region == "global" {
+		return region
+	}
+	if requestMode != RequestModeClaude && requestMode != RequestModeGemini {
+		return region
+	}
+	if !supportsGlobalRegion(info.UpstreamModelName) {
+		common.SysLog(fmt.Sprintf("vertex channel #%d prefers global region, but model %s does not support it, using region %s", info.ChannelId, info.UpstreamModelName, region))
+		return region
+	}
+	return "global"
+}
+
+// resolveRequestedEndpointRegion 按请求头指定的端点类型选择区域，模型或渠道不支持时返回 400 错误
+// global 需模型支持且渠道为该模型配置了 global 区域或开启了优先 global；regional 使用渠道配置中的首个非 global 区域
+func resolveRequestedEndpointRegion(info *relaycommon.RelayInfo, requestMode int) (string, error) {
+	endpoint := strings.ToLower(strings.TrimSpace(info.VertexEndpoint))
+	if endpoint != VertexEndpointGlobal && endpoint != VertexEndpointRegional {
+		return "", newEndpointError(fmt.Errorf("invalid %s header %q, must be %q or %q", VertexEndpointHeader, info.VertexEndpoint, VertexEndpointGlobal, VertexEndpointRegional))
+	}
+	if requestMode != RequestModeClaude && requestMode != RequestModeGemini {
+		return "", newEndpointError(fmt.Errorf("%s header is only supported for claude and gemini models", VertexEndpointHeader))
+	}
+	regions := GetModelRegions(info.ApiVersion, info.OriginModelName)
+	if endpoint == VertexEndpointGlobal {
+		if !supportsGlobalRegion(info.UpstreamModelName) {
+			return "", newEndpointError(fmt.Errorf("model %s does not support the global endpoint", info.UpstreamModelName))
+		}
+		if !info.ChannelSetting.PreferGlobalRegion && !slices.Contains(regions, "global") {
+			return "", newEndpointError(fmt.Errorf("global endpoint is not enabled for model %s on this channel", info.OriginModelName))
+		}
+		return "global", nil
+	}
+	for _, region := range regions {
+		if region != "global" {
+			return region, nil
+		}
+	}
+	return "", newEndpointError(fmt.Errorf("no regional endpoint is configured for model %s on this channel", info.OriginModelName))
+}
+
+func newEndpointError(err error) *types.NewAPIError {
+	return types.NewErrorWithStatusCode(err, types.ErrorCodeInvalidRequest, http.StatusBadRequest)
+}
+
+// getApiHost 获取请求使用的 API 域名，渠道配置了自定义域名时替换默认域名，路径结构保持不变
+func getApiHost(info *relaycommon.RelayInfo, region string) string {
+	if host := info.ChannelSetting.VertexApiHost; host != "" {
+		return strings.ReplaceAll(host, "{region}", region)
+	}
+	if region == "global" {
+		return "aiplatform.googleapis.com"
+	}
+	return region + "-aiplatform.googleapis.com"
+}
+
+// getAnthropicVersion 获取渠道为该模型配置的 anthropic_version，未配置时使用默认值
+func getAnthropicVersion(info *relaycommon.RelayInfo) (string, error) {
+	version := info.ChannelSetting.GetVertexAnthropicVersion(info.OriginModelName)
+	if !dto.IsValidVertexAnthropicVersion(version) {
+		return "", fmt.Errorf("invalid vertex anthropic version: %s", version)
 	}
-	return other
+	return version, nil
 }
@@ -0,0 +1,196 @@
+package vertex
+
+import (
+	"errors"
+	"net/http"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/types"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const testRegionCredentials = `{"project_id":"test-project","client_email":"test@test-project.iam.gserviceaccount.com","private_key":"test"}`
+
+func TestGetRequestURLResolvesRegionPerModel(t *testing.T) {
+	const regions = `{"default":"us-central1","claude-*":["us-east5","europe-west1"],"gemini-2.5-pro":["europe-west4","us-central1"]}`
+	tests := []struct {
+		model      string
+		wantRegion string
+	}{
+		{"claude-sonnet-4-20250514", "us-east5"},
+		{"gemini-2.5-pro", "europe-west4"},
+		{"gemini-2.0-flash", "us-central1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			info := &relaycommon.RelayInfo{
+				ApiKey:            testRegionCredentials,
+				ApiVersion:        regions,
+				OriginModelName:   tt.model,
+				UpstreamModelName: tt.model,
+			}
+			adaptor := &Adaptor{}
+			adaptor.Init(info)
+			url, err := adaptor.GetRequestURL(info)
+			if err != nil {
+				t.Fatalf("GetRequestURL: %v", err)
+			}
+			if !strings.HasPrefix(url, "https://"+tt.wantRegion+"-aiplatform.googleapis.com/") || !strings.Contains(url, "/locations/"+tt.wantRegion+"/") {
+				t.Errorf("url = %s, want region %s", url, tt.wantRegion)
+			}
+		})
+	}
+}
+
+func TestGetModelRegionsMatchOrder(t *testing.T) {
+	const regions = `{"default":"us-central1","claude-*":"us-east5","claude-opus-*":["europe-west1","us-east5"],"claude-opus-4-20250514":"asia-east1"}`
+	tests := []struct {
+		model string
+		want  []string
+	}{
+		{"claude-opus-4-20250514", []string{"asia-east1"}},
+		{"claude-opus-4-1-20250805", []string{"europe-west1", "us-east5"}},
+		{"claude-sonnet-4-20250514", []string{"us-east5"}},
+		{"gemini-2.5-flash", []string{"us-central1"}},
+	}
+	for _, tt := range tests {
+		if got := GetModelRegions(regions, tt.model); !slices.Equal(got, tt.want) {
+			t.Errorf("GetModelRegions(%s) = %v, want %v", tt.model, got, tt.want)
+		}
+	}
+	if got := GetModelRegions("us-east5", "gemini-2.5-flash"); !slices.Equal(got, []string{"us-east5"}) {
+		t.Errorf("single region config = %v, want [us-east5]", got)
+	}
+}
+
+func TestGetRequestURLPreferGlobalRegion(t *testing.T) {
+	tests := []struct {
+		name       string
+		model      string
+		prefer     bool
+		wantRegion string
+	}{
+		{"supported claude", "claude-sonnet-4-20250514", true, "global"},
+		{"supported gemini", "gemini-2.5-flash", true, "global"},
+		{"unsupported model falls back to regional", "claude-3-5-sonnet-20241022", true, "us-east5"},
+		{"flag off", "claude-sonnet-4-20250514", false, "us-east5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := &relaycommon.RelayInfo{
+				ApiKey:            testRegionCredentials,
+				ApiVersion:        "us-east5",
+				OriginModelName:   tt.model,
+				UpstreamModelName: tt.model,
+				ChannelSetting:    dto.ChannelSettings{PreferGlobalRegion: tt.prefer},
+			}
+			adaptor := &Adaptor{}
+			adaptor.Init(info)
+			url, err := adaptor.GetRequestURL(info)
+			if err != nil {
+				t.Fatalf("GetRequestURL: %v", err)
+			}
+			wantHost := tt.wantRegion + "-aiplatform.googleapis.com"
+			if tt.wantRegion == "global" {
+				wantHost = "aiplatform.googleapis.com"
+			}
+			if !strings.HasPrefix(url, "https://"+wantHost+"/") || !strings.Contains(url, "/locations/"+tt.wantRegion+"/") {
+				t.Errorf("url = %s, want region %s", url, tt.wantRegion)
+			}
+		})
+	}
+}
+
+func TestGetRequestURLUsesApiHostOverride(t *testing.T) {
+	tests := []struct {
+		name   string
+		host   string
+		model  string
+		region string
+		want   string
+	}{
+		{
+			"psc host for claude", "aiplatform-psc.p.googleapis.com", "claude-sonnet-4-20250514", "us-east5",
+			"https://aiplatform-psc.p.googleapis.com/v1/projects/test-project/locations/us-east5/publishers/anthropic/models/claude-sonnet-4@20250514:streamRawPredict?alt=sse",
+		},
+		{
+			"region placeholder for gemini", "{region}-aiplatform.example.internal", "gemini-2.5-flash", "europe-west4",
+			"https://europe-west4-aiplatform.example.internal/v1/projects/test-project/locations/europe-west4/publishers/google/models/gemini-2.5-flash:streamGenerateContent?alt=sse",
+		},
+		{
+			"default host", "", "gemini-2.5-flash", "europe-west4",
+			"https://europe-west4-aiplatform.googleapis.com/v1/projects/test-project/locations/europe-west4/publishers/google/models/gemini-2.5-flash:streamGenerateContent?alt=sse",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := &relaycommon.RelayInfo{
+				ApiKey:            testRegionCredentials,
+				ApiVersion:        tt.region,
+				IsStream:          true,
+				OriginModelName:   tt.model,
+				UpstreamModelName: tt.model,
+				ChannelSetting:    dto.ChannelSettings{VertexApiHost: tt.host},
+			}
+			adaptor := &Adaptor{}
+			adaptor.Init(info)
+			url, err := adaptor.GetRequestURL(info)
+			if err != nil {
+				t.Fatalf("GetRequestURL: %v", err)
+			}
+			if url != tt.want {
+				t.Errorf("url = %s, want %s", url, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetRequestURLHonorsEndpointHeader(t *testing.T) {
+	tests := []struct {
+		name       string
+		model      string
+		regions    string
+		prefer     bool
+		endpoint   string
+		wantRegion string // 为空时期望返回 400 错误
+	}{
+		{"regional forced over preferred global", "claude-sonnet-4-20250514", "us-east5", true, "regional", "us-east5"},
+		{"regional forced for gemini", "gemini-2.5-flash", `{"default":"global","gemini-*":["global","europe-west4"]}`, false, "Regional", "europe-west4"},
+		{"global requested with global preferred", "claude-sonnet-4-20250514", "us-east5", true, "global", "global"},
+		{"global configured for the model", "gemini-2.5-flash", `{"default":"us-east5","gemini-*":"global"}`, false, "global", "global"},
+		{"global not allowed by channel", "claude-sonnet-4-20250514", "us-east5", false, "global", ""},
+		{"global unsupported by model", "claude-3-5-sonnet-20241022", "us-east5", true, "global", ""},
+		{"no regional endpoint configured", "claude-sonnet-4-20250514", "global", false, "regional", ""},
+		{"invalid header value", "claude-sonnet-4-20250514", "us-east5", true, "auto", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := &relaycommon.RelayInfo{
+				ApiKey:            testRegionCredentials,
+				ApiVersion:        tt.regions,
+				OriginModelName:   tt.model,
+				UpstreamModelName: tt.model,
+				VertexEndpoint:    tt.endpoint,
+				ChannelSetting:    dto.ChannelSettings{PreferGlobalRegion: tt.prefer},
+			}
+			adaptor := &Adaptor{}
+			adaptor.Init(info)
+			url, err := adaptor.GetRequestURL(info)
+			if tt.wantRegion == "" {
+				var apiErr *types.NewAPIError
+				if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+					t.Fatalf("err = %v, url = %s, want a 400 error", err, url)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetRequestURL: %v", err)
+			}
+			if !strings.Contains(url, "/locations/"+tt.wantRegion+"/") {
+				t.Errorf("url = %s, want region %s", url, tt.wantRegion)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package vertex
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/setting/model_setting"
+	"one-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// samplingRange 上游接受的采样参数上限，下限均为 0
+type samplingRange struct {
+	maxTemperature float64
+	maxTopP        float64
+}
+
+var samplingRanges = map[int]samplingRange{
+	RequestModeClaude: {maxTemperature: 1, maxTopP: 1},
+	RequestModeGemini: {maxTemperature: 2, maxTopP: 1},
+}
+
+// clampSamplingParams 将 temperature 与 top_p 限制在上游接受的范围内，避免上游返回 400
+// 开启严格模式时超出范围直接返回错误
+func clampSamplingParams(c *gin.Context, requestMode int, temperature *float64, topP *float64) error {
+	valueRange, ok := samplingRanges[requestMode]
+	if !ok {
+		return nil
+	}
+	if temperature != nil {
+		if err := clampSamplingParam(c, "temperature", temperature, valueRange.maxTemperature); err != nil {
+			return err
+		}
+	}
+	if topP != nil {
+		if err := clampSamplingParam(c, "top_p", topP, valueRange.maxTopP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func clampSamplingParam(c *gin.Context, name string, value *float64, maxValue float64) error {
+	if *value >= 0 && *value <= maxValue {
+		return nil
+	}
+	if model_setting.GetVertexSettings().StrictSamplingParams {
+		return types.NewErrorWithStatusCode(fmt.Errorf("%s must be between 0 and %v, got %v", name, maxValue, *value),
+			types.ErrorCodeInvalidRequest, http.StatusBadRequest)
+	}
+	clamped := min(max(*value, 0), maxValue)
+	if common.DebugEnabled {
+		common.LogInfo(c, fmt.Sprintf("[VERTEX] %s clamped to valid range | From:%v | To:%v", name, *value, clamped))
+	}
+	*value = clamped
+	return nil
+}
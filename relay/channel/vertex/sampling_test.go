@@ -0,0 +1,90 @@
+package vertex
+
+import (
+	"errors"
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"one-api/relay/channel/gemini"
+	relaycommon "one-api/relay/common"
+	"one-api/setting/model_setting"
+	"one-api/types"
+	"testing"
+)
+
+func TestConvertClaudeRequestClampsSamplingParams(t *testing.T) {
+	vertexSettings := model_setting.GetVertexSettings()
+	original := vertexSettings.StrictSamplingParams
+	defer func() { vertexSettings.StrictSamplingParams = original }()
+
+	newRequest := func() *dto.ClaudeRequest {
+		return &dto.ClaudeRequest{
+			Model:       "claude-sonnet-4-20250514",
+			MaxTokens:   1024,
+			Temperature: common.GetPointer(1.5),
+			TopP:        1.2,
+		}
+	}
+	info := &relaycommon.RelayInfo{
+		OriginModelName:   "claude-sonnet-4-20250514",
+		UpstreamModelName: "claude-sonnet-4-20250514",
+	}
+	adaptor := &Adaptor{RequestMode: RequestModeClaude}
+
+	vertexSettings.StrictSamplingParams = false
+	converted, err := adaptor.ConvertClaudeRequest(newTestContext(), info, newRequest())
+	if err != nil {
+		t.Fatalf("ConvertClaudeRequest: %v", err)
+	}
+	request := converted.(*VertexAIClaudeRequest)
+	if *request.Temperature != 1 || request.TopP != 1 {
+		t.Errorf("temperature = %v, top_p = %v, want both clamped to 1", *request.Temperature, request.TopP)
+	}
+
+	// 严格模式下超出范围直接返回 400
+	vertexSettings.StrictSamplingParams = true
+	_, err = adaptor.ConvertClaudeRequest(newTestContext(), info, newRequest())
+	var apiErr *types.NewAPIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("strict mode error = %v, want a 400 error", err)
+	}
+}
+
+func TestConvertOpenAIRequestClampsGeminiTemperature(t *testing.T) {
+	vertexSettings := model_setting.GetVertexSettings()
+	original := vertexSettings.StrictSamplingParams
+	vertexSettings.StrictSamplingParams = false
+	defer func() { vertexSettings.StrictSamplingParams = original }()
+
+	tests := []struct {
+		temperature float64
+		want        float64
+	}{
+		// Gemini 接受 0 到 2 的 temperature
+		{1.5, 1.5},
+		{2.0, 2.0},
+		{2.5, 2.0},
+		{-0.5, 0},
+	}
+	for _, tt := range tests {
+		info := &relaycommon.RelayInfo{
+			ChannelType:       constant.ChannelTypeVertexAi,
+			OriginModelName:   "gemini-2.5-flash",
+			UpstreamModelName: "gemini-2.5-flash",
+		}
+		adaptor := &Adaptor{RequestMode: RequestModeGemini}
+		converted, err := adaptor.ConvertOpenAIRequest(newTestContext(), info, &dto.GeneralOpenAIRequest{
+			Model:       "gemini-2.5-flash",
+			Messages:    []dto.Message{{Role: "user", Content: "hello"}},
+			Temperature: common.GetPointer(tt.temperature),
+		})
+		if err != nil {
+			t.Fatalf("ConvertOpenAIRequest(temperature %v): %v", tt.temperature, err)
+		}
+		request := converted.(*gemini.GeminiChatRequest)
+		if got := *request.GenerationConfig.Temperature; got != tt.want {
+			t.Errorf("temperature %v converted to %v, want %v", tt.temperature, got, tt.want)
+		}
+	}
+}
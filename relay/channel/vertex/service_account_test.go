@@ -0,0 +1,281 @@
+package vertex
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/setting/model_setting"
+	"one-api/types"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestCredentials 生成带有效 RSA 私钥的服务账号凭证，使签名可以成功
+func newTestCredentials(t *testing.T, clientEmail string) string {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	credentials, _ := common.Marshal(map[string]string{
+		"project_id":   "test-project",
+		"client_email": clientEmail,
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	})
+	return string(credentials)
+}
+
+// newMockTokenServer 模拟 Google 令牌接口，按给定状态码与响应体返回
+func newMockTokenServer(t *testing.T, channelId int, statusCode int, body string) *int {
+	t.Helper()
+	calls := new(int)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v4/token", func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		w.Write([]byte(body))
+	})
+	newMockGoogleServer(t, channelId, mux)
+	return calls
+}
+
+func TestGetAccessTokenFailureClasses(t *testing.T) {
+	tests := []struct {
+		name       string
+		channelId  int
+		signable   bool
+		statusCode int
+		body       string
+		wantKind   string
+		wantCode   types.ErrorCode
+		wantCalls  int
+	}{
+		{"signing", 9301, false, http.StatusOK, `{}`, AuthErrorKindSigning, types.ErrorCodeVertexAuthFailed, 0},
+		{"network", 9302, true, http.StatusServiceUnavailable, `{"error":"backend_error"}`, AuthErrorKindNetwork, types.ErrorCodeVertexAuthFailed, tokenExchangeMaxAttempts},
+		{"rejected", 9303, true, http.StatusForbidden, `{"error":"access_denied"}`, AuthErrorKindRejected, types.ErrorCodeVertexAuthFailed, 1},
+		{"expired", 9304, true, http.StatusBadRequest, `{"error":"invalid_grant","error_description":"Invalid JWT Signature."}`, AuthErrorKindExpired, types.ErrorCodeVertexKeyExpired, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const clientEmail = "token@test-project.iam.gserviceaccount.com"
+			calls := newMockTokenServer(t, tt.channelId, tt.statusCode, tt.body)
+			apiKey := `{"project_id":"test-project","private_key":"invalid","client_email":"` + clientEmail + `"}`
+			if tt.signable {
+				apiKey = newTestCredentials(t, clientEmail)
+			}
+			adc, err := parseCredentials(apiKey, "")
+			if err != nil {
+				t.Fatalf("parseCredentials: %v", err)
+			}
+			adaptor := &Adaptor{AccountCredentials: *adc}
+			_, err = getAccessToken(adaptor, &relaycommon.RelayInfo{ChannelId: tt.channelId})
+			var authErr *AuthError
+			if !errors.As(err, &authErr) {
+				t.Fatalf("error %v is not an AuthError", err)
+			}
+			if authErr.Kind != tt.wantKind {
+				t.Errorf("kind = %s, want %s", authErr.Kind, tt.wantKind)
+			}
+			if code := newAuthAPIError(err).GetErrorCode(); code != tt.wantCode {
+				t.Errorf("error code = %s, want %s", code, tt.wantCode)
+			}
+			if *calls != tt.wantCalls {
+				t.Errorf("token endpoint called %d times, want %d", *calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestGetAccessTokenRetriesRateLimitedTokenExchange(t *testing.T) {
+	const channelId = 9305
+	// 每次运行使用不同的账号，避免命中缓存的 access token
+	clientEmail := fmt.Sprintf("retry-%d@test-project.iam.gserviceaccount.com", time.Now().UnixNano())
+	var calls int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v4/token", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		if calls <= 2 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error":"rate_limit_exceeded"}`))
+			return
+		}
+		w.Write([]byte(`{"access_token":"minted-token","expires_in":3600,"token_type":"Bearer"}`))
+	})
+	newMockGoogleServer(t, channelId, mux)
+	adc, err := parseCredentials(newTestCredentials(t, clientEmail), "")
+	if err != nil {
+		t.Fatalf("parseCredentials: %v", err)
+	}
+
+	start := time.Now()
+	token, err := getAccessToken(&Adaptor{AccountCredentials: *adc}, &relaycommon.RelayInfo{ChannelId: channelId})
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("getAccessToken: %v", err)
+	}
+	if token != "minted-token" || calls != 3 {
+		t.Errorf("token = %q after %d calls, want minted-token after 3", token, calls)
+	}
+	// 两次退避分别不少于基础退避的 1 倍与 2 倍，且远小于总时限
+	if minBackoff := 3 * tokenExchangeBaseBackoff; elapsed < minBackoff || elapsed >= tokenExchangeDeadline {
+		t.Errorf("elapsed %v, want at least %v of backoff and under %v", elapsed, minBackoff, tokenExchangeDeadline)
+	}
+}
+
+func TestDoRequestReportsExpiredKeyForEveryRequestMode(t *testing.T) {
+	tests := []struct {
+		name        string
+		channelId   int
+		requestMode int
+		model       string
+	}{
+		{"claude", 9311, RequestModeClaude, "claude-sonnet-4-20250514"},
+		{"gemini", 9312, RequestModeGemini, "gemini-2.5-flash"},
+		{"embedding", 9313, RequestModeEmbedding, "text-embedding-005"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newMockTokenServer(t, tt.channelId, http.StatusBadRequest, `{"error":"invalid_grant","error_description":"Invalid JWT Signature."}`)
+			info := &relaycommon.RelayInfo{
+				ChannelId:         tt.channelId,
+				ApiKey:            newTestCredentials(t, "expired@test-project.iam.gserviceaccount.com"),
+				OriginModelName:   tt.model,
+				UpstreamModelName: tt.model,
+			}
+			adaptor := &Adaptor{RequestMode: tt.requestMode}
+			_, err := adaptor.DoRequest(newTestContext(), info, strings.NewReader(`{"instances":[{"content":"a"}]}`))
+			var apiErr *types.NewAPIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("error %v is not a NewAPIError", err)
+			}
+			if apiErr.GetErrorCode() != types.ErrorCodeVertexKeyExpired {
+				t.Errorf("error code = %s, want %s", apiErr.GetErrorCode(), types.ErrorCodeVertexKeyExpired)
+			}
+		})
+	}
+}
+
+func TestOutboundRequestsCarryUserAgent(t *testing.T) {
+	const channelId = 9321
+	vertexSettings := model_setting.GetVertexSettings()
+	originalUserAgent := vertexSettings.UserAgent
+	defer func() { vertexSettings.UserAgent = originalUserAgent }()
+
+	tests := []struct {
+		name      string
+		userAgent string
+		want      string
+	}{
+		{"default", "", "new-api/" + common.Version},
+		{"configured", "acme-gateway/1.0", "acme-gateway/1.0"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vertexSettings.UserAgent = tt.userAgent
+			var tokenUserAgent, relayUserAgent, quotaProject string
+			mux := http.NewServeMux()
+			mux.HandleFunc("/oauth2/v4/token", func(w http.ResponseWriter, r *http.Request) {
+				tokenUserAgent = r.Header.Get("User-Agent")
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"access_token":"minted-token","expires_in":3600,"token_type":"Bearer"}`))
+			})
+			mux.HandleFunc("/v1/projects/test-project/locations/us-central1/publishers/google/models/gemini-2.5-flash:generateContent", func(w http.ResponseWriter, r *http.Request) {
+				relayUserAgent = r.Header.Get("User-Agent")
+				quotaProject = r.Header.Get("X-Goog-User-Project")
+				w.Write([]byte(`{}`))
+			})
+			newMockGoogleServer(t, channelId, mux)
+			info := &relaycommon.RelayInfo{
+				ChannelId:         channelId,
+				ApiKey:            newTestCredentials(t, fmt.Sprintf("agent-%d@test-project.iam.gserviceaccount.com", i)),
+				ApiVersion:        "us-central1",
+				OriginModelName:   "gemini-2.5-flash",
+				UpstreamModelName: "gemini-2.5-flash",
+				ChannelSetting:    dto.ChannelSettings{VertexQuotaProject: "billing-project"},
+			}
+			adaptor := &Adaptor{}
+			adaptor.Init(info)
+			if _, err := adaptor.DoRequest(newTestContext(), info, strings.NewReader(`{}`)); err != nil {
+				t.Fatalf("DoRequest: %v", err)
+			}
+			if tokenUserAgent != tt.want || relayUserAgent != tt.want {
+				t.Errorf("User-Agent token=%q relay=%q, want %q", tokenUserAgent, relayUserAgent, tt.want)
+			}
+			if quotaProject != "billing-project" {
+				t.Errorf("X-Goog-User-Project = %q, want billing-project", quotaProject)
+			}
+		})
+	}
+}
+
+func TestRegionCredentialsSelectProjectAndToken(t *testing.T) {
+	const channelId = 9306
+	credentials := func(project string, email string) string {
+		return `{"project_id":"` + project + `","private_key":"unused","client_email":"` + email + `"}`
+	}
+	apiKey := `{"default":` + credentials("default-project", "default@default-project.iam.gserviceaccount.com") +
+		`,"us-east5":` + credentials("us-project", "us@us-project.iam.gserviceaccount.com") +
+		`,"europe-west4":` + credentials("eu-project", "eu@eu-project.iam.gserviceaccount.com") + `}`
+	for email, token := range map[string]string{
+		"default@default-project.iam.gserviceaccount.com": "token-default",
+		"us@us-project.iam.gserviceaccount.com":           "token-us",
+		"eu@eu-project.iam.gserviceaccount.com":           "token-eu",
+	} {
+		Cache.SetDefault(fmt.Sprintf("access-token-%d-%s", channelId, email), token)
+	}
+	tests := []struct {
+		region      string
+		wantProject string
+		wantToken   string
+	}{
+		{"us-east5", "us-project", "token-us"},
+		{"europe-west4", "eu-project", "token-eu"},
+		{"asia-northeast1", "default-project", "token-default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.region, func(t *testing.T) {
+			info := &relaycommon.RelayInfo{
+				ChannelId:         channelId,
+				ApiKey:            apiKey,
+				ApiVersion:        tt.region,
+				OriginModelName:   "gemini-2.5-flash",
+				UpstreamModelName: "gemini-2.5-flash",
+			}
+			adaptor := &Adaptor{}
+			adaptor.Init(info)
+			url, err := adaptor.GetRequestURL(info)
+			if err != nil {
+				t.Fatalf("GetRequestURL: %v", err)
+			}
+			if !strings.Contains(url, "/projects/"+tt.wantProject+"/locations/"+tt.region+"/") {
+				t.Errorf("url = %s, want project %s in %s", url, tt.wantProject, tt.region)
+			}
+			header := http.Header{}
+			if err := adaptor.SetupRequestHeader(newTestContext(), &header, info); err != nil {
+				t.Fatalf("SetupRequestHeader: %v", err)
+			}
+			if got := header.Get("Authorization"); got != "Bearer "+tt.wantToken {
+				t.Errorf("Authorization = %q, want the %s token", got, tt.wantToken)
+			}
+		})
+	}
+
+	// 未配置请求地区且没有 default 时报错
+	if _, err := parseCredentials(`{"us-east5":`+credentials("us-project", "us@us-project.iam.gserviceaccount.com")+`}`, "europe-west4"); err == nil {
+		t.Error("parseCredentials should fail without matching or default credentials")
+	}
+}
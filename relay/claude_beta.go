@@ -0,0 +1,102 @@
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/middleware"
+	"one-api/model"
+	"one-api/relay/channel"
+	"one-api/relay/channel/claude"
+	relaycommon "one-api/relay/common"
+	"one-api/relay/helper"
+	"one-api/setting/model_setting"
+	"one-api/types"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// anthropicBetaProbe 用于判断渠道是否转发客户端 anthropic-beta 的探测值
+const anthropicBetaProbe = "new-api-client-beta-probe"
+
+// newChannelHeaderContext 构造发往该渠道的模拟 Claude 请求，返回初始化后的上下文与适配器
+func newChannelHeaderContext(ch *model.Channel, modelName string, clientBeta string) (*gin.Context, *relaycommon.RelayInfo, channel.Adaptor, *types.NewAPIError) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	c.Request.Header.Set("Content-Type", "application/json")
+	if clientBeta != "" {
+		c.Request.Header.Set("anthropic-beta", clientBeta)
+	}
+	if apiErr := middleware.SetupContextForSelectedChannel(c, ch, modelName); apiErr != nil {
+		return nil, nil, nil, apiErr
+	}
+	info := relaycommon.GenRelayInfoClaude(c)
+	if err := helper.ModelMappedHelper(c, info, nil); err != nil {
+		return nil, nil, nil, types.NewError(err, types.ErrorCodeChannelModelMappedError)
+	}
+	apiType, _ := common.ChannelType2APIType(ch.Type)
+	adaptor := GetAdaptor(apiType)
+	if adaptor == nil {
+		return nil, nil, nil, types.NewError(fmt.Errorf("invalid api type: %d, adaptor is nil", apiType), types.ErrorCodeInvalidApiType)
+	}
+	adaptor.Init(info)
+	// 部分适配器（如 Vertex）在生成请求地址时解析凭证，设置请求头前需要先调用
+	if _, err := adaptor.GetRequestURL(info); err != nil {
+		return nil, nil, nil, types.NewError(err, types.ErrorCodeDoRequestFailed)
+	}
+	return c, info, adaptor, nil
+}
+
+// channelRequestHeader 调用渠道适配器的 SetupRequestHeader，返回模拟请求实际发送的请求头
+func channelRequestHeader(ch *model.Channel, modelName string, clientBeta string) (http.Header, *types.NewAPIError) {
+	c, info, adaptor, apiErr := newChannelHeaderContext(ch, modelName, clientBeta)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	header := http.Header{}
+	if err := adaptor.SetupRequestHeader(c, &header, info); err != nil {
+		var newAPIError *types.NewAPIError
+		if errors.As(err, &newAPIError) {
+			return nil, newAPIError
+		}
+		return nil, types.NewError(err, types.ErrorCodeDoRequestFailed)
+	}
+	return header, nil
+}
+
+func splitAnthropicBeta(header http.Header) []string {
+	betas := []string{}
+	for _, value := range header.Values("anthropic-beta") {
+		for _, beta := range strings.Split(value, ",") {
+			if beta = strings.TrimSpace(beta); beta != "" {
+				betas = append(betas, beta)
+			}
+		}
+	}
+	return betas
+}
+
+// ResolveChannelAnthropicBeta 按渠道适配器设置请求头的逻辑计算模型实际发送的 anthropic-beta，clientBeta 为模拟的客户端请求头
+// Vertex 渠道会获取 access token，其余情况不发送任何上游请求
+func ResolveChannelAnthropicBeta(ch *model.Channel, modelName string, clientBeta string) (*claude.AnthropicBetaHeaders, *types.NewAPIError) {
+	header, apiErr := channelRequestHeader(ch, modelName, clientBeta)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	probeHeader, apiErr := channelRequestHeader(ch, modelName, anthropicBetaProbe)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	injected := model_setting.GetClaudeSettings().HeadersSettings[modelName]
+	if injected == nil {
+		injected = map[string][]string{}
+	}
+	return &claude.AnthropicBetaHeaders{
+		AnthropicBeta:       splitAnthropicBeta(header),
+		ClientBetaForwarded: common.StringsContains(splitAnthropicBeta(probeHeader), anthropicBetaProbe),
+		InjectedHeaders:     injected,
+	}, nil
+}
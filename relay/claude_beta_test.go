@@ -0,0 +1,94 @@
+package relay
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	"one-api/relay/channel/vertex"
+	"one-api/service"
+	"one-api/setting/model_setting"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveChannelAnthropicBetaMatchesSentHeaders(t *testing.T) {
+	const (
+		channelId   = 9201
+		clientEmail = "beta@test-project.iam.gserviceaccount.com"
+		modelName   = "claude-sonnet-4-20250514"
+		clientBeta  = "code-execution-2025-05-22"
+	)
+	var sent http.Header
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = r.Header.Clone()
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	originalTransport := http.DefaultTransport
+	http.DefaultTransport = server.Client().Transport
+	service.InitHttpClient()
+	defer func() {
+		http.DefaultTransport = originalTransport
+		service.InitHttpClient()
+	}()
+	vertex.Cache.SetDefault(fmt.Sprintf("access-token-%d-%s", channelId, clientEmail), "test-token")
+
+	claudeSettings := model_setting.GetClaudeSettings()
+	originalHeaders := claudeSettings.HeadersSettings
+	defer func() { claudeSettings.HeadersSettings = originalHeaders }()
+
+	target, _ := url.Parse(server.URL)
+	tests := []struct {
+		name          string
+		stripHeaders  string
+		injected      map[string][]string
+		wantForwarded bool
+	}{
+		{"forward client beta", `[]`, nil, true},
+		{"strip client beta", `["anthropic-beta"]`, nil, false},
+		{"model headers override client beta", `[]`, map[string][]string{"anthropic-beta": {"output-128k-2025-02-19"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claudeSettings.HeadersSettings = map[string]map[string][]string{}
+			if tt.injected != nil {
+				claudeSettings.HeadersSettings[modelName] = tt.injected
+			}
+			setting := fmt.Sprintf(`{"vertex_api_host":%q,"strip_headers":%s}`, target.Host, tt.stripHeaders)
+			ch := &model.Channel{
+				Id:      channelId,
+				Type:    constant.ChannelTypeVertexAi,
+				Key:     `{"project_id":"test-project","private_key":"unused","client_email":"` + clientEmail + `"}`,
+				Setting: &setting,
+			}
+			reported, apiErr := ResolveChannelAnthropicBeta(ch, modelName, clientBeta)
+			if apiErr != nil {
+				t.Fatalf("ResolveChannelAnthropicBeta: %v", apiErr)
+			}
+			if reported.ClientBetaForwarded != tt.wantForwarded {
+				t.Errorf("ClientBetaForwarded = %v, want %v", reported.ClientBetaForwarded, tt.wantForwarded)
+			}
+
+			c, info, adaptor, apiErr := newChannelHeaderContext(ch, modelName, clientBeta)
+			if apiErr != nil {
+				t.Fatalf("newChannelHeaderContext: %v", apiErr)
+			}
+			resp, err := adaptor.DoRequest(c, info, strings.NewReader(`{}`))
+			if err != nil {
+				t.Fatalf("DoRequest: %v", err)
+			}
+			resp.(*http.Response).Body.Close()
+			if got := splitAnthropicBeta(sent); !reflect.DeepEqual(got, reported.AnthropicBeta) {
+				t.Errorf("reported anthropic-beta %v, upstream received %v", reported.AnthropicBeta, got)
+			}
+			if common.StringsContains(reported.AnthropicBeta, clientBeta) != tt.wantForwarded {
+				t.Errorf("client beta forwarded mismatch: %v", reported.AnthropicBeta)
+			}
+		})
+	}
+}
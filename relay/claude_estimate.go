@@ -0,0 +1,82 @@
+package relay
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/relay/helper"
+	"one-api/setting/model_setting"
+	"one-api/types"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClaudeEstimateHelper 复用计价逻辑预估 Claude 请求的费用，不请求上游也不扣费
+func ClaudeEstimateHelper(c *gin.Context) (*dto.ClaudeCostEstimate, *types.NewAPIError) {
+	relayInfo := relaycommon.GenRelayInfoClaude(c)
+
+	textRequest, err := getAndValidateClaudeRequest(c, relayInfo)
+	if err != nil {
+		return nil, types.NewErrorWithStatusCode(err, types.ErrorCodeInvalidRequest, http.StatusBadRequest)
+	}
+	err = helper.ModelMappedHelper(c, relayInfo, textRequest)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeChannelModelMappedError)
+	}
+	promptTokens, err := getClaudePromptTokens(textRequest, relayInfo)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeCountTokenFailed)
+	}
+	if err = applyMaxOutputTokensHeader(c, textRequest); err != nil {
+		return nil, types.NewErrorWithStatusCode(err, types.ErrorCodeInvalidRequest, http.StatusBadRequest)
+	}
+
+	claudeSettings := model_setting.GetClaudeSettings()
+	maxTokens := int(textRequest.MaxTokens)
+	if maxTokens == 0 {
+		maxTokens = claudeSettings.GetDefaultMaxTokens(textRequest.Model)
+	}
+	thinkingBudget := 0
+	if textRequest.Thinking != nil && textRequest.Thinking.BudgetTokens != nil {
+		thinkingBudget = *textRequest.Thinking.BudgetTokens
+	} else if claudeSettings.ThinkingAdapterEnabled && strings.HasSuffix(textRequest.Model, "-thinking") {
+		// 与 ClaudeHelper 的思考适配保持一致
+		maxTokens = max(maxTokens, 1280)
+		thinkingBudget = claudeSettings.GetThinkingBudgetTokens(strings.TrimSuffix(textRequest.Model, "-thinking"), maxTokens)
+	}
+
+	priceData, err := helper.ModelPriceHelper(c, relayInfo, promptTokens, maxTokens)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeModelPriceError)
+	}
+
+	estimate := &dto.ClaudeCostEstimate{
+		Model:                textRequest.Model,
+		PromptTokens:         promptTokens,
+		MaxTokens:            maxTokens,
+		ThinkingBudgetTokens: thinkingBudget,
+		UsePrice:             priceData.UsePrice,
+		PromptQuota:          estimateClaudeQuota(priceData, promptTokens, 0),
+		QuotaWithoutThinking: estimateClaudeQuota(priceData, promptTokens, max(maxTokens-thinkingBudget, 0)),
+		QuotaWithThinking:    estimateClaudeQuota(priceData, promptTokens, maxTokens),
+	}
+	common.LogInfo(c, fmt.Sprintf("[CLAUDE] Cost estimated | Model:%s | PromptTokens:%d | MaxTokens:%d | Quota:%d",
+		estimate.Model, promptTokens, maxTokens, estimate.QuotaWithThinking))
+	return estimate, nil
+}
+
+// estimateClaudeQuota 按 PostClaudeConsumeQuota 的计费公式估算费用，不考虑缓存
+func estimateClaudeQuota(priceData helper.PriceData, promptTokens int, completionTokens int) int {
+	groupRatio := priceData.GroupRatioInfo.GroupRatio
+	if priceData.UsePrice {
+		return int(priceData.ModelPrice * common.QuotaPerUnit * groupRatio)
+	}
+	quota := (float64(promptTokens) + float64(completionTokens)*priceData.CompletionRatio) * groupRatio * priceData.ModelRatio
+	if priceData.ModelRatio != 0 && quota <= 0 {
+		quota = 1
+	}
+	return int(quota)
+}
@@ -0,0 +1,74 @@
+package relay
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"one-api/model"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestClaudeEstimateMatchesActualCharge(t *testing.T) {
+	ch, _ := setupClaudeRelayTest(t)
+	var upstreamCalls int32
+	var inputTokens, outputTokens int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&upstreamCalls, 1)
+		stream := strings.Replace(claudeTestStream, `"input_tokens":10`, fmt.Sprintf(`"input_tokens":%d`, inputTokens), 1)
+		stream = strings.Replace(stream, `"usage":{"output_tokens":5}`, fmt.Sprintf(`"usage":{"output_tokens":%d}`, outputTokens), 1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(stream))
+	}))
+	defer server.Close()
+	baseURL := server.URL
+	ch.BaseURL = &baseURL
+
+	const body = `{"model":"claude-sonnet-4-20250514","max_tokens":1024,"stream":true,"messages":[{"role":"user","content":"estimate the cost of this request"}]}`
+	c, _ := newClaudeRelayTestContext(t, ch, body, nil)
+	estimate, apiErr := ClaudeEstimateHelper(c)
+	if apiErr != nil {
+		t.Fatalf("ClaudeEstimateHelper: %v", apiErr)
+	}
+	// 预估不请求上游也不扣费
+	var consumeLogs int64
+	model.LOG_DB.Model(&model.Log{}).Where("type = ?", model.LogTypeConsume).Count(&consumeLogs)
+	if atomic.LoadInt32(&upstreamCalls) != 0 || consumeLogs != 0 {
+		t.Fatalf("estimate called upstream %d times and wrote %d consume logs, want none", upstreamCalls, consumeLogs)
+	}
+	if estimate.MaxTokens != 1024 || estimate.ThinkingBudgetTokens != 0 || estimate.QuotaWithThinking != estimate.QuotaWithoutThinking {
+		t.Errorf("estimate = %+v, want max_tokens 1024 without thinking", estimate)
+	}
+	if estimate.PromptQuota <= 0 || estimate.QuotaWithThinking <= estimate.PromptQuota {
+		t.Errorf("estimate = %+v, want the output to add to the prompt cost", estimate)
+	}
+
+	tests := []struct {
+		name         string
+		outputTokens int
+		wantEqual    bool
+	}{
+		{"output uses max_tokens", 1024, true},
+		{"shorter output", 100, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inputTokens, outputTokens = estimate.PromptTokens, tt.outputTokens
+			c, _ := newClaudeRelayTestContext(t, ch, body, nil)
+			if apiErr := ClaudeHelper(c); apiErr != nil {
+				t.Fatalf("ClaudeHelper: %v", apiErr)
+			}
+			var log model.Log
+			if err := model.LOG_DB.Where("type = ?", model.LogTypeConsume).Order("id desc").First(&log).Error; err != nil {
+				t.Fatalf("consume log: %v", err)
+			}
+			if tt.wantEqual && log.Quota != estimate.QuotaWithThinking {
+				t.Errorf("charged %d, estimated %d", log.Quota, estimate.QuotaWithThinking)
+			}
+			if !tt.wantEqual && (log.Quota <= estimate.PromptQuota || log.Quota >= estimate.QuotaWithThinking) {
+				t.Errorf("charged %d, want between the prompt cost %d and the estimate %d", log.Quota, estimate.PromptQuota, estimate.QuotaWithThinking)
+			}
+		})
+	}
+}
@@ -2,24 +2,49 @@ package relay
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
 	"one-api/common"
+	"one-api/constant"
 	"one-api/dto"
+	"one-api/model"
+	"one-api/relay/channel"
+	"one-api/relay/channel/vertex"
 	relaycommon "one-api/relay/common"
 	"one-api/relay/helper"
 	"one-api/service"
 	"one-api/setting/model_setting"
 	"one-api/types"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-func getAndValidateClaudeRequest(c *gin.Context) (textRequest *dto.ClaudeRequest, err error) {
+func getAndValidateClaudeRequest(c *gin.Context, info *relaycommon.RelayInfo) (textRequest *dto.ClaudeRequest, err error) {
+	// 在解析与计算 token 之前拦截过大的请求体
+	maxBodyBytes := info.ChannelSetting.GetMaxRequestBodyBytes()
+	if maxBodyBytes > 0 {
+		requestBody, err := common.GetRequestBody(c)
+		if err != nil {
+			return nil, err
+		}
+		if len(requestBody) > maxBodyBytes {
+			return nil, fmt.Errorf("request body too large: %d bytes, max %d bytes", len(requestBody), maxBodyBytes)
+		}
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+	}
+	if model_setting.GetClaudeSettings().StrictRequestFields {
+		if err = checkClaudeUnknownFields(c); err != nil {
+			return nil, err
+		}
+	}
 	textRequest = &dto.ClaudeRequest{}
 	err = c.ShouldBindJSON(textRequest)
 	if err != nil {
@@ -28,12 +53,30 @@ func getAndValidateClaudeRequest(c *gin.Context) (textRequest *dto.ClaudeRequest
 	if textRequest.Messages == nil || len(textRequest.Messages) == 0 {
 		return nil, errors.New("field messages is required")
 	}
+	if maxMessages := info.ChannelSetting.GetMaxMessages(); maxMessages > 0 && len(textRequest.Messages) > maxMessages {
+		return nil, fmt.Errorf("too many messages: %d, max %d", len(textRequest.Messages), maxMessages)
+	}
 	if textRequest.Model == "" {
 		return nil, errors.New("field model is required")
 	}
 	return textRequest, nil
 }
 
+// checkClaudeUnknownFields 严格模式下拒绝包含未知字段的请求，其余解析错误由后续绑定处理
+func checkClaudeUnknownFields(c *gin.Context) error {
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+	decoder := json.NewDecoder(bytes.NewReader(requestBody))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&dto.ClaudeRequest{}); err != nil && strings.HasPrefix(err.Error(), "json: unknown field ") {
+		return errors.New(strings.TrimPrefix(err.Error(), "json: "))
+	}
+	return nil
+}
+
 func ClaudeHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 	startTime := time.Now()
 
@@ -42,9 +85,20 @@ func ClaudeHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 	// [CLAUDE] 请求开始日志
 	common.LogInfo(c, fmt.Sprintf("[CLAUDE] Request started | User:%d | Channel:%d | Model:%s | IsStream:%v", 
 		relayInfo.UserId, relayInfo.ChannelId, relayInfo.OriginModelName, relayInfo.IsStream))
+	if traceHeader := common.GetContextKeyString(c, constant.ContextKeyTraceHeader); traceHeader != "" {
+		common.LogInfo(c, fmt.Sprintf("[CLAUDE] Client trace id reused as request id | Header:%s | Value:%s",
+			traceHeader, c.Request.Header.Get(traceHeader)))
+	}
+
+	spanAttrs := []attribute.KeyValue{
+		attribute.String("model", relayInfo.OriginModelName),
+		attribute.Int("channel_id", relayInfo.ChannelId),
+	}
 
 	// get & validate textRequest 获取并验证文本请求
-	textRequest, err := getAndValidateClaudeRequest(c)
+	span := common.StartSpan(c, "claude.validate", spanAttrs...)
+	textRequest, err := getAndValidateClaudeRequest(c, relayInfo)
+	common.EndSpan(span, err)
 	if err != nil {
 		common.LogError(c, fmt.Sprintf("[CLAUDE] Request validation failed | Error:%s", err.Error()))
 		return types.NewError(err, types.ErrorCodeInvalidRequest)
@@ -53,6 +107,47 @@ func ClaudeHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 	common.LogInfo(c, fmt.Sprintf("[CLAUDE] Request validated | Messages:%d | MaxTokens:%d | Stream:%v", 
 		len(textRequest.Messages), textRequest.MaxTokens, textRequest.Stream))
 
+	// 非流式请求支持 Idempotency-Key，重复请求直接返回已完成的响应，不再请求上游和扣费
+	idempotencyKey := c.Request.Header.Get("Idempotency-Key")
+	useIdempotency := idempotencyKey != "" && !textRequest.Stream && model_setting.GetClaudeSettings().IdempotencyEnabled
+	idempotencyCompleted := false
+	var idempotencyBodyHash string
+	if useIdempotency {
+		requestBody, err := common.GetRequestBody(c)
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeReadRequestBodyFailed)
+		}
+		idempotencyBodyHash = service.HashIdempotencyBody(requestBody)
+		record, acquired, err := service.AcquireIdempotencyKey(relayInfo.UserId, idempotencyKey, idempotencyBodyHash,
+			model_setting.GetClaudeSettings().GetIdempotencyTTL())
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeQueryDataError)
+		}
+		if !acquired {
+			if record.BodyHash != idempotencyBodyHash {
+				common.LogWarn(c, fmt.Sprintf("[CLAUDE] Idempotency key reused with a different body | Key:%s", idempotencyKey))
+				return types.NewErrorWithStatusCode(errors.New("Idempotency-Key has already been used with a different request body"),
+					types.ErrorCodeIdempotencyKeyMismatch, http.StatusUnprocessableEntity)
+			}
+			if record.Pending {
+				common.LogInfo(c, fmt.Sprintf("[CLAUDE] Idempotency key in progress | Key:%s", idempotencyKey))
+				return types.NewErrorWithStatusCode(errors.New("a request with the same Idempotency-Key is still in progress"),
+					types.ErrorCodeIdempotencyKeyInUse, http.StatusConflict)
+			}
+			common.LogInfo(c, fmt.Sprintf("[CLAUDE] Idempotency key hit, replay cached response | Key:%s", idempotencyKey))
+			c.Data(http.StatusOK, record.ContentType, []byte(record.Body))
+			return nil
+		}
+		// 请求未完成时释放幂等键，失败后客户端可以使用同一幂等键重试
+		defer func() {
+			if !idempotencyCompleted {
+				if err := service.ReleaseIdempotencyKey(relayInfo.UserId, idempotencyKey); err != nil {
+					common.LogError(c, fmt.Sprintf("[CLAUDE] Release idempotency key failed | Error:%s", err.Error()))
+				}
+			}
+		}()
+	}
+
 	// [CLAUDE] 强制启用流式处理，忽略客户端设置
 	textRequest.Stream = true
 	relayInfo.IsStream = true
@@ -62,10 +157,21 @@ func ClaudeHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 	if err != nil {
 		return types.NewError(err, types.ErrorCodeChannelModelMappedError)
 	}
+	if err = checkTokenModelAllowed(c, relayInfo); err != nil {
+		common.LogWarn(c, fmt.Sprintf("[CLAUDE] Model not allowed for token | TokenId:%d | Model:%s | UpstreamModel:%s",
+			relayInfo.TokenId, relayInfo.OriginModelName, relayInfo.UpstreamModelName))
+		return types.NewErrorWithStatusCode(err, types.ErrorCodeModelNotSupported, http.StatusForbidden)
+	}
+
+	// 在计算 token 前注入，使渠道系统提示词计入 prompt tokens
+	applyChannelSystemPrompt(c, relayInfo, textRequest)
 
 	// [CLAUDE] Token计算开始
 	tokenCountStart := time.Now()
+	span = common.StartSpan(c, "claude.token_count", spanAttrs...)
 	promptTokens, err := getClaudePromptTokens(textRequest, relayInfo)
+	span.SetAttributes(attribute.Int("prompt_tokens", promptTokens))
+	common.EndSpan(span, err)
 	tokenCountTime := time.Since(tokenCountStart)
 	// count messages token error 计算promptTokens错误
 	if err != nil {
@@ -73,19 +179,109 @@ func ClaudeHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		return types.NewError(err, types.ErrorCodeCountTokenFailed)
 	}
 
-	common.LogInfo(c, fmt.Sprintf("[CLAUDE] Token counted | PromptTokens:%d | Time:%v", promptTokens, tokenCountTime))
+	common.LogInfo(c, fmt.Sprintf("[CLAUDE] Token counted | PromptTokens:%d | Estimated:%v | Time:%v",
+		promptTokens, model_setting.GetClaudeSettings().FastTokenEstimate, tokenCountTime))
+
+	if newAPIError := applyClaudeModelRoute(c, relayInfo, textRequest, promptTokens); newAPIError != nil {
+		return newAPIError
+	}
+
+	if err = applyMaxOutputTokensHeader(c, textRequest); err != nil {
+		return types.NewErrorWithStatusCode(err, types.ErrorCodeInvalidRequest, http.StatusBadRequest)
+	}
+	applyClaudeMetadataUserId(textRequest, relayInfo.UserId)
+
+	if textRequest.MaxTokens == 0 {
+		textRequest.MaxTokens = uint(model_setting.GetClaudeSettings().GetDefaultMaxTokens(textRequest.Model))
+	}
+
+	disableThinking := isClaudeThinkingDisabled(c, textRequest)
+	if model_setting.GetClaudeSettings().ThinkingAdapterEnabled &&
+		strings.HasSuffix(textRequest.Model, "-thinking") {
+		// 请求头限制的 max_tokens 不足以开启思考时保持不开启
+		headerCapped := c.Request.Header.Get(MaxOutputTokensHeader) != "" && textRequest.MaxTokens < 1280
+		if textRequest.Thinking == nil && !headerCapped && !disableThinking {
+			// 因为BudgetTokens 必须大于1024
+			if textRequest.MaxTokens < 1280 {
+				textRequest.MaxTokens = 1280
+			}
+
+			// BudgetTokens 按模型配置的比例计算，默认为 max_tokens 的 80%
+			textRequest.Thinking = &dto.Thinking{
+				Type:         "enabled",
+				BudgetTokens: common.GetPointer[int](model_setting.GetClaudeSettings().GetThinkingBudgetTokens(strings.TrimSuffix(textRequest.Model, "-thinking"), int(textRequest.MaxTokens))),
+			}
+			// TODO: 临时处理
+			// https://docs.anthropic.com/en/docs/build-with-claude/extended-thinking#important-considerations-when-using-extended-thinking
+			// 思考模式不支持 top_k，非思考请求的采样参数保持原样透传
+			textRequest.TopP = 0
+			textRequest.TopK = 0
+			textRequest.Temperature = common.GetPointer[float64](1.0)
+		} else if textRequest.Thinking != nil && !disableThinking {
+			if err := resolveClaudeThinkingConflict(c, textRequest); err != nil {
+				return types.NewErrorWithStatusCode(err, types.ErrorCodeInvalidRequest, http.StatusBadRequest)
+			}
+		}
+		textRequest.Model = strings.TrimSuffix(textRequest.Model, "-thinking")
+		relayInfo.UpstreamModelName = textRequest.Model
+	}
+	if disableThinking && textRequest.Thinking != nil {
+		common.LogInfo(c, "[CLAUDE] Thinking disabled by request, thinking block removed")
+		textRequest.Thinking = nil
+	}
+	if err = checkClaudeThinkingSupported(c, relayInfo, textRequest); err != nil {
+		return types.NewErrorWithStatusCode(err, types.ErrorCodeInvalidRequest, http.StatusBadRequest)
+	}
+	applyMaxThinkingBudget(c, relayInfo, textRequest)
+	applyClaudeParameterPolicy(c, relayInfo, textRequest)
+	relayInfo.MaxCompletionTokens = int(textRequest.MaxTokens)
+
+	// 在 max_tokens 的所有调整（默认值、思考模式、参数策略）之后校验上下文窗口
+	if err = validateClaudeContextWindow(c, relayInfo, textRequest, promptTokens); err != nil {
+		common.LogWarn(c, fmt.Sprintf("[CLAUDE] Context window exceeded | Error:%s", err.Error()))
+		return types.NewErrorWithStatusCode(err, types.ErrorCodeInvalidRequest, http.StatusBadRequest)
+	}
 
 	priceData, err := helper.ModelPriceHelper(c, relayInfo, promptTokens, int(textRequest.MaxTokens))
 	if err != nil {
 		return types.NewError(err, types.ErrorCodeModelPriceError)
 	}
 
+	// TPM 限流：按 prompt tokens 加预估最大输出预占额度，结束后按实际用量校正
+	tpmReservations, newAPIError := reserveClaudeTPM(c, relayInfo, textRequest, promptTokens)
+	if newAPIError != nil {
+		return newAPIError
+	}
+	var actualTokens int
+	defer func() {
+		for _, reservation := range tpmReservations {
+			service.ReconcileTPM(reservation, actualTokens)
+		}
+	}()
+
+	budgetReservation, newAPIError := reserveClaudeTokenBudget(c, relayInfo, textRequest, promptTokens)
+	if newAPIError != nil {
+		return newAPIError
+	}
+	defer func() {
+		service.ReconcileTokenBudget(budgetReservation, actualTokens)
+	}()
+
+	releaseConcurrency, newAPIError := acquireClaudeConcurrency(c, relayInfo)
+	if newAPIError != nil {
+		return newAPIError
+	}
+	defer releaseConcurrency()
+
 	// pre-consume quota 预消耗配额
+	span = common.StartSpan(c, "claude.pre_consume", spanAttrs...)
 	preConsumedQuota, userQuota, newAPIError := preConsumeQuota(c, priceData.ShouldPreConsumedQuota, relayInfo)
-
+	span.SetAttributes(attribute.Int("pre_consumed_quota", preConsumedQuota))
 	if newAPIError != nil {
+		common.EndSpan(span, newAPIError)
 		return newAPIError
 	}
+	common.EndSpan(span, nil)
 	defer func() {
 		if newAPIError != nil {
 			returnPreConsumedQuota(c, relayInfo, userQuota, preConsumedQuota)
@@ -97,61 +293,364 @@ func ClaudeHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		return types.NewError(fmt.Errorf("invalid api type: %d", relayInfo.ApiType), types.ErrorCodeInvalidApiType)
 	}
 	adaptor.Init(relayInfo)
-	var requestBody io.Reader
 
-	if textRequest.MaxTokens == 0 {
-		textRequest.MaxTokens = uint(model_setting.GetClaudeSettings().GetDefaultMaxTokens(textRequest.Model))
+	statusCodeMappingStr := c.GetString("status_code_mapping")
+
+	var recorder *helper.ResponseRecorder
+	if useIdempotency {
+		var stopRecorder func()
+		recorder, stopRecorder = helper.StartResponseRecorder(c)
+		defer stopRecorder()
+	}
+	// 命中影子采样时记录主请求输出，用于与影子渠道的输出对比
+	var shadowRecorder *helper.ResponseRecorder
+	if shouldSampleClaudeShadow() {
+		var stopShadowRecorder func()
+		shadowRecorder, stopShadowRecorder = helper.StartResponseRecorder(c)
+		defer stopShadowRecorder()
 	}
 
-	if model_setting.GetClaudeSettings().ThinkingAdapterEnabled &&
-		strings.HasSuffix(textRequest.Model, "-thinking") {
-		if textRequest.Thinking == nil {
-			// 因为BudgetTokens 必须大于1024
-			if textRequest.MaxTokens < 1280 {
-				textRequest.MaxTokens = 1280
-			}
+	var httpResp *http.Response
+	upstreamStart := time.Now()
+	fallbackModels := getClaudeFallbackModels(relayInfo)
+	retryBudget := newClaudeRetryBudget()
+	defer retryBudget.logSpent(c)
+	for attempt := 0; ; attempt++ {
+		httpResp, newAPIError = doClaudeUpstreamRequest(c, adaptor, relayInfo, textRequest, spanAttrs, retryBudget)
+		if newAPIError == nil {
+			break
+		}
+		// 主模型不可用或过载时按渠道配置切换到备用模型，次数受备用链长度和重试预算限制
+		if attempt >= len(fallbackModels) || !shouldFallbackClaude(newAPIError) ||
+			!retryBudget.allow(c, claudeRetryKindFallback, 0) {
+			// reset status code 重置状态码
+			service.ResetStatusCode(newAPIError, statusCodeMappingStr)
+			return newAPIError
+		}
+		fallbackModel := fallbackModels[attempt]
+		common.LogWarn(c, fmt.Sprintf("[CLAUDE] Falling back to another model | From:%s | To:%s | Status:%d | Error:%s",
+			relayInfo.OriginModelName, fallbackModel, newAPIError.StatusCode, newAPIError.Error()))
+		// 按实际提供服务的模型计费
+		fallbackPriceData, switchErr := switchClaudeFallbackModel(c, relayInfo, textRequest, fallbackModel, promptTokens)
+		if switchErr != nil {
+			// 备用模型不可用时返回主模型的错误，客户端并未请求备用模型
+			common.LogWarn(c, fmt.Sprintf("[CLAUDE] Fallback model rejected | Model:%s | Error:%s", fallbackModel, switchErr.Error()))
+			service.ResetStatusCode(newAPIError, statusCodeMappingStr)
+			return newAPIError
+		}
+		priceData = fallbackPriceData
+		adaptor.Init(relayInfo)
+	}
 
-			// BudgetTokens 为 max_tokens 的 80%
-			textRequest.Thinking = &dto.Thinking{
-				Type:         "enabled",
-				BudgetTokens: common.GetPointer[int](int(float64(textRequest.MaxTokens) * model_setting.GetClaudeSettings().ThinkingAdapterBudgetTokensPercentage)),
+	upstreamTime := time.Since(upstreamStart)
+
+	// [CLAUDE] 开始响应处理
+	responseProcessStart := time.Now()
+	span = common.StartSpan(c, "claude.response_process", spanAttrs...)
+	usage, newAPIError := adaptor.DoResponse(c, httpResp, relayInfo)
+	responseProcessTime := time.Since(responseProcessStart)
+	
+	if newAPIError != nil {
+		common.EndSpan(span, newAPIError)
+		// reset status code 重置状态码
+		service.ResetStatusCode(newAPIError, statusCodeMappingStr)
+		common.LogError(c, fmt.Sprintf("[CLAUDE] Response processing failed | Error:%s | Time:%v", 
+			newAPIError.Error(), responseProcessTime))
+		return newAPIError
+	}
+	
+	common.EndSpan(span, nil)
+
+	// [CLAUDE] 记录最终使用情况
+	logClaudeRequestCompleted(c, usage, claudeRequestTimings{
+		total:           time.Since(startTime),
+		tokenCount:      tokenCountTime,
+		upstream:        upstreamTime,
+		responseProcess: responseProcessTime,
+	})
+	
+	if usageInfo, ok := usage.(*dto.Usage); ok && usageInfo != nil {
+		common.ObserveRelayMetrics(relayInfo.UpstreamModelName, relayInfo.ChannelId, usageInfo.PromptTokens, usageInfo.CompletionTokens, upstreamTime)
+		recordClaudePromptTokenDelta(c, relayInfo, usageInfo)
+	}
+
+	span = common.StartSpan(c, "claude.consume_quota", append(spanAttrs,
+		attribute.Bool("sla_breached", common.GetContextKeyBool(c, constant.ContextKeySLABreached)))...)
+	service.PostClaudeConsumeQuota(c, relayInfo, usage.(*dto.Usage), preConsumedQuota, userQuota, priceData, "")
+	if usageInfo, ok := usage.(*dto.Usage); ok && usageInfo != nil {
+		actualTokens = usageInfo.PromptTokens + usageInfo.CompletionTokens
+		span.SetAttributes(
+			attribute.Int("prompt_tokens", usageInfo.PromptTokens),
+			attribute.Int("completion_tokens", usageInfo.CompletionTokens),
+		)
+	}
+	common.EndSpan(span, nil)
+	if recorder != nil {
+		record := &service.IdempotencyRecord{
+			BodyHash:    idempotencyBodyHash,
+			ContentType: c.Writer.Header().Get("Content-Type"),
+			Body:        recorder.Body.String(),
+		}
+		// 响应已返回并计费，保存失败时保留占用，避免重试再次扣费
+		idempotencyCompleted = true
+		if err := service.CompleteIdempotencyKey(relayInfo.UserId, idempotencyKey, record, model_setting.GetClaudeSettings().GetIdempotencyTTL()); err != nil {
+			common.LogError(c, fmt.Sprintf("[CLAUDE] Save idempotency record failed | Error:%s", err.Error()))
+		}
+	}
+	if shadowRecorder != nil {
+		startClaudeShadow(c, relayInfo, shadowRecorder.Body.String())
+	}
+	return nil
+}
+
+// claudeRequestTimings 请求各阶段耗时
+type claudeRequestTimings struct {
+	total           time.Duration
+	tokenCount      time.Duration
+	upstream        time.Duration
+	responseProcess time.Duration
+}
+
+// logClaudeRequestCompleted 慢请求始终记录各阶段耗时明细，其余请求按采样比例记录
+// 超过 SLA 的请求在上下文中标记，消费日志据此记录违约，日志不参与采样
+func logClaudeRequestCompleted(c *gin.Context, usage any, timings claudeRequestTimings) {
+	usageStr := "Usage:nil"
+	if usageInfo, ok := usage.(*dto.Usage); ok && usageInfo != nil {
+		usageStr = fmt.Sprintf("PromptTokens:%d | CompletionTokens:%d | TotalTokens:%d",
+			usageInfo.PromptTokens, usageInfo.CompletionTokens, usageInfo.TotalTokens)
+	}
+	claudeSettings := model_setting.GetClaudeSettings()
+	slaBreached := claudeSettings.SLAThresholdMs > 0 && timings.total > time.Duration(claudeSettings.SLAThresholdMs)*time.Millisecond
+	if slaBreached {
+		common.SetContextKey(c, constant.ContextKeySLABreached, true)
+	}
+	if claudeSettings.SlowRequestThresholdMs > 0 && timings.total >= time.Duration(claudeSettings.SlowRequestThresholdMs)*time.Millisecond {
+		common.LogWarn(c, fmt.Sprintf("[CLAUDE] Slow request completed | TotalTime:%v | TokenCountTime:%v | UpstreamTime:%v | ResponseProcessTime:%v | SLABreached:%v | %s",
+			timings.total, timings.tokenCount, timings.upstream, timings.responseProcess, slaBreached, usageStr))
+		return
+	}
+	if slaBreached {
+		common.LogWarn(c, fmt.Sprintf("[CLAUDE] Request completed | TotalTime:%v | SLABreached:true | SLA:%dms | %s",
+			timings.total, claudeSettings.SLAThresholdMs, usageStr))
+		return
+	}
+	if rand.Float64()*100 >= claudeSettings.CompletedLogPercentage {
+		return
+	}
+	common.LogInfo(c, fmt.Sprintf("[CLAUDE] Request completed | TotalTime:%v | %s", timings.total, usageStr))
+}
+
+// recordClaudePromptTokenDelta 记录预估输入 token 与上游返回值的差异，用于校准 tokenizer
+// 上游的 input_tokens 不含缓存读写部分，对比时需要加上
+func recordClaudePromptTokenDelta(c *gin.Context, info *relaycommon.RelayInfo, usage *dto.Usage) {
+	estimated := info.PromptTokens
+	actual := usage.PromptTokens + usage.PromptTokensDetails.CachedTokens + usage.PromptTokensDetails.CachedCreationTokens
+	if estimated <= 0 || actual <= 0 {
+		return
+	}
+	delta := actual - estimated
+	common.SetContextKey(c, constant.ContextKeyPromptTokensDelta, delta)
+	common.ObservePromptTokenEstimate(info.UpstreamModelName, estimated, actual)
+	if delta != 0 {
+		common.LogInfo(c, fmt.Sprintf("[CLAUDE] Prompt token estimate | Model:%s | Estimated:%d | Actual:%d | Delta:%d | DeltaPercent:%.1f%%",
+			info.UpstreamModelName, estimated, actual, delta, float64(delta)*100/float64(estimated)))
+	}
+}
+
+func getClaudePromptTokens(textRequest *dto.ClaudeRequest, info *relaycommon.RelayInfo) (int, error) {
+	var promptTokens int
+	var err error
+	switch info.RelayMode {
+	default:
+		// 估算值仅用于预扣费和限流，上游返回用量后按实际用量结算
+		if model_setting.GetClaudeSettings().FastTokenEstimate {
+			promptTokens, err = service.EstimateTokenClaudeRequest(*textRequest)
+		} else {
+			promptTokens, err = service.CountTokenClaudeRequest(*textRequest, info.UpstreamModelName)
+		}
+	}
+	info.PromptTokens = promptTokens
+	return promptTokens, err
+}
+
+// acquireClaudeConcurrency 按渠道+模型限制同时进行的上游请求数
+func acquireClaudeConcurrency(c *gin.Context, info *relaycommon.RelayInfo) (func(), *types.NewAPIError) {
+	claudeSettings := model_setting.GetClaudeSettings()
+	limit := claudeSettings.GetConcurrencyLimit(info.OriginModelName)
+	if limit <= 0 {
+		return func() {}, nil
+	}
+	key := fmt.Sprintf("%d:%s", info.ChannelId, info.OriginModelName)
+	wait := time.Duration(claudeSettings.ConcurrencyWaitSeconds) * time.Second
+	release, ok := service.AcquireConcurrency(c.Request.Context(), key, limit, wait)
+	if !ok {
+		common.LogWarn(c, fmt.Sprintf("[CLAUDE] Concurrency limit reached | Channel:%d | Model:%s | Limit:%d", info.ChannelId, info.OriginModelName, limit))
+		return nil, types.NewErrorWithStatusCode(fmt.Errorf("channel #%d has reached the concurrency limit %d for model %s", info.ChannelId, limit, info.OriginModelName),
+			types.ErrorCodeChannelUnavailable, http.StatusServiceUnavailable)
+	}
+	return release, nil
+}
+
+// reserveClaudeTPM 按用户和渠道分别预占 TPM 额度，任一超限时释放已预占的额度并拒绝请求
+func reserveClaudeTPM(c *gin.Context, info *relaycommon.RelayInfo, textRequest *dto.ClaudeRequest, promptTokens int) ([]*service.TPMReservation, *types.NewAPIError) {
+	claudeSettings := model_setting.GetClaudeSettings()
+	if !claudeSettings.TPMLimitEnabled {
+		return nil, nil
+	}
+	estimatedTokens := estimateClaudeRequestTokens(textRequest, promptTokens)
+
+	limits := []struct {
+		key   string
+		limit int
+	}{
+		{fmt.Sprintf("user:%d", info.UserId), claudeSettings.UserTPMLimit},
+		{fmt.Sprintf("channel:%d", info.ChannelId), claudeSettings.ChannelTPMLimit},
+	}
+	reservations := make([]*service.TPMReservation, 0, len(limits))
+	for _, l := range limits {
+		reservation, ok, err := service.ReserveTPM(l.key, estimatedTokens, l.limit)
+		if err != nil {
+			// 限流存储异常时放行，避免影响正常请求
+			common.LogError(c, fmt.Sprintf("[CLAUDE] TPM reserve failed | Key:%s | Error:%s", l.key, err.Error()))
+			continue
+		}
+		if !ok {
+			for _, r := range reservations {
+				service.ReconcileTPM(r, 0)
 			}
-			// TODO: 临时处理
-			// https://docs.anthropic.com/en/docs/build-with-claude/extended-thinking#important-considerations-when-using-extended-thinking
-			textRequest.TopP = 0
-			textRequest.Temperature = common.GetPointer[float64](1.0)
+			common.LogWarn(c, fmt.Sprintf("[CLAUDE] TPM limit exceeded | Key:%s | Limit:%d | EstimatedTokens:%d", l.key, l.limit, estimatedTokens))
+			return nil, types.NewErrorWithStatusCode(fmt.Errorf("tokens per minute limit exceeded: %d", l.limit), types.ErrorCodeRateLimitExceeded, http.StatusTooManyRequests)
 		}
-		textRequest.Model = strings.TrimSuffix(textRequest.Model, "-thinking")
-		relayInfo.UpstreamModelName = textRequest.Model
+		if reservation != nil {
+			reservations = append(reservations, reservation)
+		}
+	}
+	return reservations, nil
+}
+
+// estimateClaudeRequestTokens 预估请求的最大 token 用量：输入加上 max_tokens（未设置时取模型默认值）
+func estimateClaudeRequestTokens(textRequest *dto.ClaudeRequest, promptTokens int) int {
+	maxTokens := int(textRequest.MaxTokens)
+	if maxTokens == 0 {
+		maxTokens = model_setting.GetClaudeSettings().GetDefaultMaxTokens(textRequest.Model)
 	}
+	return promptTokens + maxTokens
+}
+
+// reserveClaudeTokenBudget 从用户当天的 token 额度中预占预估用量，剩余额度不足时拒绝请求
+func reserveClaudeTokenBudget(c *gin.Context, info *relaycommon.RelayInfo, textRequest *dto.ClaudeRequest, promptTokens int) (*service.TokenBudgetReservation, *types.NewAPIError) {
+	claudeSettings := model_setting.GetClaudeSettings()
+	budget := claudeSettings.UserDailyTokenBudget
+	if budget <= 0 {
+		return nil, nil
+	}
+	estimatedTokens := estimateClaudeRequestTokens(textRequest, promptTokens)
+	reservation, used, ok, err := service.ReserveTokenBudget(info.UserId, estimatedTokens, budget, claudeSettings.TokenBudgetResetHour)
+	if err != nil {
+		// 额度存储异常时放行，避免影响正常请求
+		common.LogError(c, fmt.Sprintf("[CLAUDE] Token budget reserve failed | UserId:%d | Error:%s", info.UserId, err.Error()))
+		return nil, nil
+	}
+	if !ok {
+		common.LogWarn(c, fmt.Sprintf("[CLAUDE] Daily token budget exceeded | UserId:%d | Budget:%d | Used:%d | EstimatedTokens:%d",
+			info.UserId, budget, used, estimatedTokens))
+		return nil, types.NewErrorWithStatusCode(fmt.Errorf("daily token budget exceeded: %d of %d tokens used, this request needs up to %d", used, budget, estimatedTokens),
+			types.ErrorCodeTokenBudgetExceeded, http.StatusTooManyRequests)
+	}
+	return reservation, nil
+}
 
+// doClaudeUpstreamRequest 转换请求并调用上游，非 200 响应转换为错误返回
+func doClaudeUpstreamRequest(c *gin.Context, adaptor channel.Adaptor, relayInfo *relaycommon.RelayInfo, textRequest *dto.ClaudeRequest, spanAttrs []attribute.KeyValue, retryBudget *claudeRetryBudget) (*http.Response, *types.NewAPIError) {
+	span := common.StartSpan(c, "claude.convert", spanAttrs...)
 	convertedRequest, err := adaptor.ConvertClaudeRequest(c, relayInfo, textRequest)
 	if err != nil {
-		return types.NewError(err, types.ErrorCodeConvertRequestFailed)
+		common.EndSpan(span, err)
+		var apiErr *types.NewAPIError
+		if errors.As(err, &apiErr) {
+			return nil, apiErr
+		}
+		return nil, types.NewError(err, types.ErrorCodeConvertRequestFailed)
+	}
+	convertedRequest, err = applyClaudeRequestTransformers(c, relayInfo, convertedRequest)
+	if err != nil {
+		common.EndSpan(span, err)
+		return nil, types.NewError(err, types.ErrorCodeConvertRequestFailed)
 	}
-	jsonData, err := common.Marshal(convertedRequest)
+	var marshalOptions common.MarshalOptions
+	if options := relayInfo.ChannelSetting.RequestMarshalOptions; options != nil {
+		marshalOptions = *options
+	}
+	jsonData, err := common.MarshalWithOptions(convertedRequest, marshalOptions)
+	common.EndSpan(span, err)
 	if common.DebugEnabled {
 		println("requestBody: ", string(jsonData))
 	}
 	if err != nil {
-		return types.NewError(err, types.ErrorCodeConvertRequestFailed)
+		return nil, types.NewError(err, types.ErrorCodeConvertRequestFailed)
 	}
-	requestBody = bytes.NewBuffer(jsonData)
 
-	statusCodeMappingStr := c.GetString("status_code_mapping")
+	// 首选区域不可用时按渠道配置的区域顺序故障转移，切换区域与过载重试共享重试预算
+	relayInfo.VertexRegion = ""
+	failoverRegions := getClaudeFailoverRegions(adaptor, relayInfo)
+	for failover := 0; ; failover++ {
+		httpResp, newAPIError := callClaudeUpstreamWithRetry(c, adaptor, relayInfo, jsonData, spanAttrs, retryBudget)
+		if newAPIError == nil || failover >= len(failoverRegions) || !shouldFailoverClaudeRegion(newAPIError) ||
+			!retryBudget.allow(c, claudeRetryKindRegionFailover, 0) {
+			return httpResp, newAPIError
+		}
+		common.LogWarn(c, fmt.Sprintf("[CLAUDE] Failing over to another region | To:%s | Status:%d | Error:%s",
+			failoverRegions[failover], newAPIError.StatusCode, newAPIError.Error()))
+		relayInfo.VertexRegion = failoverRegions[failover]
+	}
+}
+
+// callClaudeUpstreamWithRetry 上游过载时使用同一请求体退避重试，重试不涉及额度，不会重复扣费
+func callClaudeUpstreamWithRetry(c *gin.Context, adaptor channel.Adaptor, relayInfo *relaycommon.RelayInfo, jsonData []byte, spanAttrs []attribute.KeyValue, retryBudget *claudeRetryBudget) (*http.Response, *types.NewAPIError) {
+	retryTimes := model_setting.GetClaudeSettings().OverloadedRetryTimes
+	for retry := 0; ; retry++ {
+		httpResp, newAPIError := callClaudeUpstream(c, adaptor, relayInfo, jsonData, spanAttrs)
+		if newAPIError == nil || retry >= retryTimes || !isClaudeOverloaded(newAPIError) {
+			return httpResp, newAPIError
+		}
+		delay := getClaudeOverloadedRetryDelay(retry)
+		if !retryBudget.allow(c, claudeRetryKindOverloaded, delay) {
+			return httpResp, newAPIError
+		}
+		common.LogWarn(c, fmt.Sprintf("[CLAUDE] Upstream overloaded, retrying | Retry:%d/%d | Delay:%v | Status:%d",
+			retry+1, retryTimes, delay, newAPIError.StatusCode))
+		select {
+		case <-time.After(delay):
+		case <-c.Request.Context().Done():
+			return nil, newAPIError
+		}
+	}
+}
+
+// callClaudeUpstream 发送已转换的请求体，非 200 响应转换为错误返回
+func callClaudeUpstream(c *gin.Context, adaptor channel.Adaptor, relayInfo *relaycommon.RelayInfo, jsonData []byte, spanAttrs []attribute.KeyValue) (*http.Response, *types.NewAPIError) {
+	requestBody := bytes.NewBuffer(jsonData)
+
 	// [CLAUDE] 准备上游API调用
 	requestSize := len(jsonData)
 	common.LogInfo(c, fmt.Sprintf("[CLAUDE] Calling upstream API | URL:%s | RequestSize:%d bytes | Model:%s", 
 		relayInfo.BaseUrl, requestSize, relayInfo.UpstreamModelName))
-	
+
 	upstreamCallStart := time.Now()
 	var httpResp *http.Response
+	span := common.StartSpan(c, "claude.upstream_call", append(spanAttrs, attribute.String("upstream_model", relayInfo.UpstreamModelName))...)
 	resp, err := adaptor.DoRequest(c, relayInfo, requestBody)
+	common.EndSpan(span, err)
 	upstreamCallTime := time.Since(upstreamCallStart)
 	
 	if err != nil {
 		common.LogError(c, fmt.Sprintf("[CLAUDE] Upstream API call failed | Error:%s | Time:%v", err.Error(), upstreamCallTime))
-		return types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
+		// 渠道鉴权等已分类的错误保留原错误码
+		var apiErr *types.NewAPIError
+		if errors.As(err, &apiErr) {
+			return nil, apiErr
+		}
+		return nil, types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
 	}
 
 	if resp != nil {
@@ -167,47 +666,309 @@ func ClaudeHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		if httpResp.StatusCode != http.StatusOK {
 			common.LogWarn(c, fmt.Sprintf("[CLAUDE] Upstream API error status | Status:%d | Time:%v", 
 				httpResp.StatusCode, upstreamCallTime))
-			newAPIError = service.RelayErrorHandler(c, httpResp, false)
-			// reset status code 重置状态码
-			service.ResetStatusCode(newAPIError, statusCodeMappingStr)
-			return newAPIError
+			return nil, service.RelayErrorHandler(c, httpResp, false)
 		}
 	}
+	return httpResp, nil
+}
 
-	// [CLAUDE] 开始响应处理
-	responseProcessStart := time.Now()
-	usage, newAPIError := adaptor.DoResponse(c, httpResp, relayInfo)
-	responseProcessTime := time.Since(responseProcessStart)
-	
-	if newAPIError != nil {
-		// reset status code 重置状态码
-		service.ResetStatusCode(newAPIError, statusCodeMappingStr)
-		common.LogError(c, fmt.Sprintf("[CLAUDE] Response processing failed | Error:%s | Time:%v", 
-			newAPIError.Error(), responseProcessTime))
-		return newAPIError
+// maxClaudeFallbackAttempts 备用模型的最大切换次数
+const maxClaudeFallbackAttempts = 2
+
+// getClaudeFallbackModels 按渠道配置的备用模型链依次获取备用模型，遇到循环时停止
+func getClaudeFallbackModels(info *relaycommon.RelayInfo) []string {
+	fallbackMap := info.ChannelSetting.FallbackModels
+	if len(fallbackMap) == 0 {
+		return nil
 	}
-	
-	// [CLAUDE] 记录最终使用情况
-	totalTime := time.Since(startTime)
-	if usage != nil {
-		usageInfo := usage.(*dto.Usage)
-		common.LogInfo(c, fmt.Sprintf("[CLAUDE] Request completed | TotalTime:%v | PromptTokens:%d | CompletionTokens:%d | TotalTokens:%d", 
-			totalTime, usageInfo.PromptTokens, usageInfo.CompletionTokens, usageInfo.TotalTokens))
-	} else {
-		common.LogInfo(c, fmt.Sprintf("[CLAUDE] Request completed | TotalTime:%v | Usage:nil", totalTime))
+	visited := map[string]bool{info.OriginModelName: true}
+	models := make([]string, 0, maxClaudeFallbackAttempts)
+	current := info.OriginModelName
+	for len(models) < maxClaudeFallbackAttempts {
+		next, ok := fallbackMap[current]
+		if !ok || next == "" || visited[next] {
+			break
+		}
+		visited[next] = true
+		models = append(models, next)
+		current = next
+	}
+	return models
+}
+
+// switchClaudeFallbackModel 切换到备用模型，备用模型与请求的模型一样经过渠道模型映射、令牌模型限制、
+// 上下文窗口与思考支持校验，并按备用模型重新计价
+func switchClaudeFallbackModel(c *gin.Context, info *relaycommon.RelayInfo, textRequest *dto.ClaudeRequest, fallbackModel string, promptTokens int) (helper.PriceData, *types.NewAPIError) {
+	info.OriginModelName = fallbackModel
+	info.UpstreamModelName = fallbackModel
+	info.IsModelMapped = false
+	if err := helper.ModelMappedHelper(c, info, textRequest); err != nil {
+		return helper.PriceData{}, types.NewError(err, types.ErrorCodeChannelModelMappedError)
+	}
+	textRequest.Model = info.UpstreamModelName
+	if err := checkTokenModelAllowed(c, info); err != nil {
+		return helper.PriceData{}, types.NewErrorWithStatusCode(err, types.ErrorCodeModelNotSupported, http.StatusForbidden)
+	}
+	if err := validateClaudeContextWindow(c, info, textRequest, promptTokens); err != nil {
+		return helper.PriceData{}, types.NewErrorWithStatusCode(err, types.ErrorCodeInvalidRequest, http.StatusBadRequest)
+	}
+	if err := checkClaudeThinkingSupported(c, info, textRequest); err != nil {
+		return helper.PriceData{}, types.NewErrorWithStatusCode(err, types.ErrorCodeInvalidRequest, http.StatusBadRequest)
+	}
+	priceData, err := helper.ModelPriceHelper(c, info, promptTokens, int(textRequest.MaxTokens))
+	if err != nil {
+		return helper.PriceData{}, types.NewError(err, types.ErrorCodeModelPriceError)
+	}
+	return priceData, nil
+}
+
+// shouldFallbackClaude 仅在模型不可用或过载时切换备用模型，客户端请求错误不切换
+func shouldFallbackClaude(err *types.NewAPIError) bool {
+	switch err.StatusCode {
+	case http.StatusNotFound, http.StatusTooManyRequests, http.StatusServiceUnavailable, 529:
+		return true
+	}
+	return false
+}
+
+// getClaudeFailoverRegions 获取 Vertex 渠道首选区域之外可故障转移的区域，其他渠道返回 nil
+func getClaudeFailoverRegions(adaptor channel.Adaptor, info *relaycommon.RelayInfo) []string {
+	vertexAdaptor, ok := adaptor.(*vertex.Adaptor)
+	if !ok {
+		return nil
+	}
+	return vertex.FailoverRegions(info, vertexAdaptor.RequestMode)
+}
+
+// shouldFailoverClaudeRegion 区域限流、过载或服务端错误时切换区域，超时不切换
+func shouldFailoverClaudeRegion(err *types.NewAPIError) bool {
+	switch err.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, 529:
+		return true
+	}
+	return isClaudeOverloaded(err)
+}
+
+// isClaudeOverloaded 上游返回 529 或 overloaded_error 时视为过载
+func isClaudeOverloaded(err *types.NewAPIError) bool {
+	if err.StatusCode == 529 {
+		return true
+	}
+	switch relayError := err.RelayError.(type) {
+	case types.OpenAIError:
+		return relayError.Type == "overloaded_error"
+	case types.ClaudeError:
+		return relayError.Type == "overloaded_error"
+	}
+	return false
+}
+
+// getClaudeOverloadedRetryDelay 指数退避，实际等待时间在 [delay/2, delay) 内随机，避免重试集中
+func getClaudeOverloadedRetryDelay(retry int) time.Duration {
+	claudeSettings := model_setting.GetClaudeSettings()
+	delay := time.Duration(claudeSettings.OverloadedRetryDelayMs) * time.Millisecond << min(retry, 16)
+	if maxDelay := time.Duration(claudeSettings.OverloadedMaxDelayMs) * time.Millisecond; maxDelay > 0 && delay > maxDelay {
+		delay = maxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// MaxOutputTokensHeader 前置网关通过该请求头统一限制输出 token 上限
+const MaxOutputTokensHeader = "X-Max-Output-Tokens"
+
+// claudeMinThinkingBudget Claude 要求的最小思考预算
+const claudeMinThinkingBudget = 1024
+
+// applyMaxOutputTokensHeader 按请求头限制 max_tokens，并同步收紧思考预算
+func applyMaxOutputTokensHeader(c *gin.Context, textRequest *dto.ClaudeRequest) error {
+	headerValue := c.Request.Header.Get(MaxOutputTokensHeader)
+	if headerValue == "" {
+		return nil
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(headerValue))
+	if err != nil || limit <= 0 {
+		return fmt.Errorf("invalid %s header: must be a positive integer", MaxOutputTokensHeader)
+	}
+	maxTokens := int(textRequest.MaxTokens)
+	if maxTokens == 0 {
+		maxTokens = model_setting.GetClaudeSettings().GetDefaultMaxTokens(textRequest.Model)
+	}
+	if maxTokens <= limit {
+		return nil
+	}
+	common.LogInfo(c, fmt.Sprintf("[CLAUDE] MaxTokens clamped by header | From:%d | To:%d", maxTokens, limit))
+	textRequest.MaxTokens = uint(limit)
+
+	// 思考预算必须小于 max_tokens，且不低于最小预算，无法满足时关闭思考
+	if textRequest.Thinking != nil && textRequest.Thinking.BudgetTokens != nil && *textRequest.Thinking.BudgetTokens >= limit {
+		if limit <= claudeMinThinkingBudget {
+			textRequest.Thinking = nil
+		} else {
+			budget := model_setting.GetClaudeSettings().GetThinkingBudgetTokens(strings.TrimSuffix(textRequest.Model, "-thinking"), limit)
+			textRequest.Thinking.BudgetTokens = common.GetPointer(budget)
+		}
 	}
-	
-	service.PostClaudeConsumeQuota(c, relayInfo, usage.(*dto.Usage), preConsumedQuota, userQuota, priceData, "")
 	return nil
 }
 
-func getClaudePromptTokens(textRequest *dto.ClaudeRequest, info *relaycommon.RelayInfo) (int, error) {
-	var promptTokens int
-	var err error
-	switch info.RelayMode {
+// validateClaudeContextWindow 输入与最大输出之和超过模型上下文窗口时直接拒绝，避免预扣额度后再由上游返回 400
+func validateClaudeContextWindow(c *gin.Context, info *relaycommon.RelayInfo, textRequest *dto.ClaudeRequest, promptTokens int) error {
+	// 开启 1M 上下文 beta 的请求由上游校验
+	if strings.Contains(c.Request.Header.Get("anthropic-beta"), "context-1m") {
+		return nil
+	}
+	claudeSettings := model_setting.GetClaudeSettings()
+	contextWindow := claudeSettings.GetContextWindowTokens(strings.TrimSuffix(info.UpstreamModelName, "-thinking"))
+	if contextWindow <= 0 {
+		return nil
+	}
+	maxTokens := int(textRequest.MaxTokens)
+	if maxTokens == 0 {
+		maxTokens = claudeSettings.GetDefaultMaxTokens(textRequest.Model)
+	}
+	if overflow := promptTokens + maxTokens - contextWindow; overflow > 0 {
+		return fmt.Errorf("prompt tokens (%d) + max_tokens (%d) exceed the %d token context window of model %s by %d tokens",
+			promptTokens, maxTokens, contextWindow, info.UpstreamModelName, overflow)
+	}
+	return nil
+}
+
+// checkTokenModelAllowed 按令牌的模型限制校验重定向后的模型，令牌显式允许请求的模型名时，渠道重定向的结果同样允许
+func checkTokenModelAllowed(c *gin.Context, info *relaycommon.RelayInfo) error {
+	if !common.GetContextKeyBool(c, constant.ContextKeyTokenModelLimitEnabled) {
+		return nil
+	}
+	limits, _ := common.GetContextKeyType[map[string]bool](c, constant.ContextKeyTokenModelLimit)
+	if model.IsModelAllowedByLimits(limits, info.UpstreamModelName) || model.IsModelAllowedByLimits(limits, info.OriginModelName) {
+		return nil
+	}
+	return fmt.Errorf("model %s is not allowed for this token", info.UpstreamModelName)
+}
+
+// ClaudeDisableThinkingHeader 客户端设置为 true 时强制关闭思考，与请求体中的 disable_thinking 等效
+const ClaudeDisableThinkingHeader = "X-Disable-Thinking"
+
+// isClaudeThinkingDisabled 判断请求是否强制关闭思考，优先于模型名 -thinking 后缀
+// 请求体中的 disable_thinking 为网关扩展字段，读取后清除
+func isClaudeThinkingDisabled(c *gin.Context, textRequest *dto.ClaudeRequest) bool {
+	disabled := textRequest.DisableThinking || strings.EqualFold(c.Request.Header.Get(ClaudeDisableThinkingHeader), "true")
+	textRequest.DisableThinking = false
+	return disabled
+}
+
+// resolveClaudeThinkingConflict 模型名带 -thinking 后缀且请求体指定了 thinking 时，以请求体为准
+// 请求体的配置不满足上游要求时直接报错，预算上限仍由 applyMaxThinkingBudget 限制
+func resolveClaudeThinkingConflict(c *gin.Context, textRequest *dto.ClaudeRequest) error {
+	thinking := textRequest.Thinking
+	switch thinking.Type {
+	case "disabled":
+		common.LogInfo(c, "[CLAUDE] Thinking config resolved | Suffix:enabled | Block:disabled | Result:disabled")
+		textRequest.Thinking = nil
+		return nil
+	case "enabled":
 	default:
-		promptTokens, err = service.CountTokenClaudeRequest(*textRequest, info.UpstreamModelName)
+		return fmt.Errorf("invalid thinking.type %q, must be \"enabled\" or \"disabled\"", thinking.Type)
+	}
+	if thinking.BudgetTokens == nil {
+		return errors.New("thinking.budget_tokens is required when thinking is enabled")
+	}
+	budget := *thinking.BudgetTokens
+	if budget < claudeMinThinkingBudget {
+		return fmt.Errorf("thinking.budget_tokens must be at least %d, got %d", claudeMinThinkingBudget, budget)
+	}
+	if budget >= int(textRequest.MaxTokens) {
+		return fmt.Errorf("thinking.budget_tokens (%d) must be less than max_tokens (%d)", budget, textRequest.MaxTokens)
+	}
+	suffixBudget := model_setting.GetClaudeSettings().GetThinkingBudgetTokens(strings.TrimSuffix(textRequest.Model, "-thinking"), int(textRequest.MaxTokens))
+	result := "block"
+	if budget == suffixBudget {
+		result = "agree"
+	}
+	common.LogInfo(c, fmt.Sprintf("[CLAUDE] Thinking config resolved | Suffix:%d | Block:%d | Result:%s", suffixBudget, budget, result))
+	return nil
+}
+
+// checkClaudeThinkingSupported 实际请求的模型不支持扩展思考时按配置移除思考配置或返回错误，避免上游返回 400
+func checkClaudeThinkingSupported(c *gin.Context, info *relaycommon.RelayInfo, textRequest *dto.ClaudeRequest) error {
+	claudeSettings := model_setting.GetClaudeSettings()
+	if textRequest.Thinking == nil || claudeSettings.SupportsThinking(info.UpstreamModelName) {
+		return nil
+	}
+	if claudeSettings.NoThinkingReject {
+		return fmt.Errorf("model %s does not support extended thinking", info.UpstreamModelName)
+	}
+	common.LogWarn(c, fmt.Sprintf("[CLAUDE] Thinking not supported by model, thinking removed | Model:%s", info.UpstreamModelName))
+	textRequest.Thinking = nil
+	return nil
+}
+
+// applyMaxThinkingBudget 将客户端指定或适配生成的思考预算限制在配置的上限内
+func applyMaxThinkingBudget(c *gin.Context, info *relaycommon.RelayInfo, textRequest *dto.ClaudeRequest) {
+	maxBudget := info.GetMaxThinkingBudgetTokens()
+	if maxBudget <= 0 || textRequest.Thinking == nil || textRequest.Thinking.BudgetTokens == nil {
+		return
+	}
+	maxBudget = max(maxBudget, claudeMinThinkingBudget)
+	if *textRequest.Thinking.BudgetTokens <= maxBudget {
+		return
+	}
+	common.LogWarn(c, fmt.Sprintf("[CLAUDE] Thinking budget clamped | From:%d | To:%d", *textRequest.Thinking.BudgetTokens, maxBudget))
+	textRequest.Thinking.BudgetTokens = common.GetPointer(maxBudget)
+}
+
+// applyChannelSystemPrompt 按渠道配置注入默认系统提示词，客户端自带的系统提示词保留
+func applyChannelSystemPrompt(c *gin.Context, info *relaycommon.RelayInfo, textRequest *dto.ClaudeRequest) {
+	prompt := info.ChannelSetting.DefaultSystemPrompt
+	if prompt == "" {
+		return
+	}
+	mode := info.ChannelSetting.GetDefaultSystemPromptMode()
+	var blocks []dto.ClaudeMediaMessage
+	hasSystem := false
+	if textRequest.IsStringSystem() {
+		hasSystem = textRequest.GetStringSystem() != ""
+	} else if textRequest.System != nil {
+		blocks = textRequest.ParseSystem()
+		hasSystem = len(blocks) > 0
+	}
+	if hasSystem && mode == dto.SystemPromptModeReplaceIfAbsent {
+		return
+	}
+
+	switch {
+	case !hasSystem:
+		textRequest.SetStringSystem(prompt)
+	case textRequest.IsStringSystem() && mode == dto.SystemPromptModeAppend:
+		textRequest.SetStringSystem(textRequest.GetStringSystem() + "\n\n" + prompt)
+	case textRequest.IsStringSystem():
+		textRequest.SetStringSystem(prompt + "\n\n" + textRequest.GetStringSystem())
+	default:
+		// 数组形式的系统提示词以独立文本块注入，不影响客户端块上的 cache_control
+		promptBlock := dto.ClaudeMediaMessage{Type: "text"}
+		promptBlock.SetText(prompt)
+		if mode == dto.SystemPromptModeAppend {
+			blocks = append(blocks, promptBlock)
+		} else {
+			blocks = append([]dto.ClaudeMediaMessage{promptBlock}, blocks...)
+		}
+		textRequest.System = blocks
+	}
+	common.LogInfo(c, fmt.Sprintf("[CLAUDE] Channel system prompt injected | Mode:%s | HasClientSystem:%v", mode, hasSystem))
+}
+
+// applyClaudeMetadataUserId 向上游传递哈希后的用户 id，便于上游做滥用追踪
+func applyClaudeMetadataUserId(textRequest *dto.ClaudeRequest, userId int) {
+	claudeSettings := model_setting.GetClaudeSettings()
+	if !claudeSettings.MetadataUserIdEnabled {
+		return
+	}
+	if textRequest.Metadata != nil && textRequest.Metadata.UserId != "" && !claudeSettings.MetadataUserIdOverride {
+		return
+	}
+	textRequest.Metadata = &dto.ClaudeMetadata{
+		UserId: claudeSettings.HashMetadataUserId(userId),
 	}
-	info.PromptTokens = promptTokens
-	return promptTokens, err
 }
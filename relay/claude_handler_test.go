@@ -0,0 +1,1320 @@
+package relay
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"one-api/middleware"
+	"one-api/model"
+	"one-api/relay/channel/vertex"
+	relaycommon "one-api/relay/common"
+	"one-api/service"
+	"one-api/setting/model_setting"
+	"one-api/setting/ratio_setting"
+	"one-api/types"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newClaudeFallbackTestInfo() *relaycommon.RelayInfo {
+	return &relaycommon.RelayInfo{
+		RelayFormat:       relaycommon.RelayFormatClaude,
+		OriginModelName:   "claude-sonnet-4-20250514",
+		UpstreamModelName: "claude-sonnet-4-20250514",
+	}
+}
+
+func TestSwitchClaudeFallbackModelRemapsAndReprices(t *testing.T) {
+	ratio_setting.InitRatioSettings()
+	c := newClaudeRouteTestContext()
+	c.Set("model_mapping", `{"claude-3-5-haiku-20241022":"claude-3-5-haiku@20241022"}`)
+	info := newClaudeFallbackTestInfo()
+	request := &dto.ClaudeRequest{Model: "claude-sonnet-4-20250514", MaxTokens: 1024}
+
+	priceData, err := switchClaudeFallbackModel(c, info, request, "claude-3-5-haiku-20241022", 100)
+	if err != nil {
+		t.Fatalf("switchClaudeFallbackModel: %v", err)
+	}
+	if info.OriginModelName != "claude-3-5-haiku-20241022" {
+		t.Errorf("OriginModelName = %s, want the fallback model", info.OriginModelName)
+	}
+	if info.UpstreamModelName != "claude-3-5-haiku@20241022" || request.Model != "claude-3-5-haiku@20241022" {
+		t.Errorf("upstream = %s / %s, want the mapped fallback model", info.UpstreamModelName, request.Model)
+	}
+	wantRatio, _, _ := ratio_setting.GetModelRatio("claude-3-5-haiku-20241022")
+	if priceData.ModelRatio != wantRatio {
+		t.Errorf("ModelRatio = %v, want fallback ratio %v", priceData.ModelRatio, wantRatio)
+	}
+}
+
+func TestSwitchClaudeFallbackModelChecksTokenAllowlist(t *testing.T) {
+	ratio_setting.InitRatioSettings()
+	c := newClaudeRouteTestContext()
+	common.SetContextKey(c, constant.ContextKeyTokenModelLimitEnabled, true)
+	common.SetContextKey(c, constant.ContextKeyTokenModelLimit, map[string]bool{"claude-sonnet-4-20250514": true})
+	info := newClaudeFallbackTestInfo()
+	request := &dto.ClaudeRequest{Model: "claude-sonnet-4-20250514", MaxTokens: 1024}
+	if _, err := switchClaudeFallbackModel(c, info, request, "claude-3-5-haiku-20241022", 100); err == nil {
+		t.Fatal("expected a fallback outside the token allowlist to be rejected")
+	}
+}
+
+func TestSwitchClaudeFallbackModelChecksContextWindow(t *testing.T) {
+	ratio_setting.InitRatioSettings()
+	settings := model_setting.GetClaudeSettings()
+	original := settings.ContextWindowTokens
+	settings.ContextWindowTokens = map[string]int{"default": 200000, "claude-3-5-haiku-20241022": 4096}
+	defer func() { settings.ContextWindowTokens = original }()
+
+	c := newClaudeRouteTestContext()
+	info := newClaudeFallbackTestInfo()
+	request := &dto.ClaudeRequest{Model: "claude-sonnet-4-20250514", MaxTokens: 1024}
+	if _, err := switchClaudeFallbackModel(c, info, request, "claude-3-5-haiku-20241022", 8000); err == nil {
+		t.Fatal("expected a prompt exceeding the fallback context window to be rejected")
+	}
+}
+
+func TestValidateClaudeContextWindowBoundary(t *testing.T) {
+	settings := model_setting.GetClaudeSettings()
+	original := settings.ContextWindowTokens
+	defer func() { settings.ContextWindowTokens = original }()
+
+	c := newClaudeRouteTestContext()
+	info := newClaudeFallbackTestInfo()
+	request := &dto.ClaudeRequest{Model: "claude-sonnet-4-20250514", MaxTokens: 8192}
+	if err := validateClaudeContextWindow(c, info, request, 1000000); err != nil {
+		t.Fatalf("validation should be disabled without configured context windows: %v", err)
+	}
+
+	settings.ContextWindowTokens = map[string]int{"claude-sonnet-4-20250514": 200000}
+	if err := validateClaudeContextWindow(c, info, request, 200000-8192); err != nil {
+		t.Errorf("prompt + max_tokens equal to the context window should pass: %v", err)
+	}
+	if err := validateClaudeContextWindow(c, info, request, 200000-8192+1); err == nil {
+		t.Error("prompt + max_tokens one token over the context window should be rejected")
+	}
+}
+
+func TestClaudeContextWindowCountsThinkingMaxTokens(t *testing.T) {
+	ch, calls := setupClaudeRelayTest(t)
+	settings := model_setting.GetClaudeSettings()
+	originalWindow, originalAdapter := settings.ContextWindowTokens, settings.ThinkingAdapterEnabled
+	settings.ContextWindowTokens = map[string]int{"claude-3-7-sonnet-20250219": 1000}
+	settings.ThinkingAdapterEnabled = true
+	defer func() {
+		settings.ContextWindowTokens, settings.ThinkingAdapterEnabled = originalWindow, originalAdapter
+	}()
+
+	// max_tokens 为 100 时满足上下文窗口，思考模式将其提升到 1280 后超出
+	body := `{"model":"claude-3-7-sonnet-20250219-thinking","max_tokens":100,"messages":[{"role":"user","content":"hello"}]}`
+	c, _ := newClaudeRelayTestContext(t, ch, body, nil)
+	common.SetContextKey(c, constant.ContextKeyOriginalModel, "claude-3-7-sonnet-20250219-thinking")
+	apiErr := ClaudeHelper(c)
+	if apiErr == nil || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("got %v, want 400 for max_tokens raised past the context window", apiErr)
+	}
+	if got := atomic.LoadInt32(calls); got != 0 {
+		t.Errorf("upstream called %d times, want 0", got)
+	}
+}
+
+func TestClaudeTPMLimitReservesAndReconciles(t *testing.T) {
+	ch, calls := setupClaudeRelayTest(t)
+	settings := model_setting.GetClaudeSettings()
+	originalEnabled, originalUser, originalChannel := settings.TPMLimitEnabled, settings.UserTPMLimit, settings.ChannelTPMLimit
+	settings.TPMLimitEnabled, settings.UserTPMLimit, settings.ChannelTPMLimit = true, 0, 3000
+	defer func() {
+		settings.TPMLimitEnabled, settings.UserTPMLimit, settings.ChannelTPMLimit = originalEnabled, originalUser, originalChannel
+	}()
+
+	// 每次预占 prompt tokens 加 1024，结束后按实际用量校正，因此连续请求不会累积到上限
+	body := `{"model":"claude-sonnet-4-20250514","max_tokens":1024,"messages":[{"role":"user","content":"hello"}]}`
+	for i := 0; i < 3; i++ {
+		c, _ := newClaudeRelayTestContext(t, ch, body, nil)
+		if apiErr := ClaudeHelper(c); apiErr != nil {
+			t.Fatalf("request %d: %v", i+1, apiErr)
+		}
+	}
+
+	// 预估用量超过剩余额度时在请求上游前拒绝
+	body = `{"model":"claude-sonnet-4-20250514","max_tokens":4000,"messages":[{"role":"user","content":"hello"}]}`
+	c, _ := newClaudeRelayTestContext(t, ch, body, nil)
+	apiErr := ClaudeHelper(c)
+	if apiErr == nil || apiErr.GetErrorCode() != types.ErrorCodeRateLimitExceeded || apiErr.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("got %v, want a 429 rate limit error", apiErr)
+	}
+	if got := atomic.LoadInt32(calls); got != 3 {
+		t.Errorf("upstream called %d times, want 3", got)
+	}
+}
+
+func TestGetAndValidateClaudeRequestSizeLimits(t *testing.T) {
+	body := `{"model":"claude-sonnet-4-20250514","max_tokens":16,"messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"hello"},{"role":"user","content":"bye"}]}`
+	tests := []struct {
+		name    string
+		setting dto.ChannelSettings
+		wantErr bool
+	}{
+		{"body at the limit", dto.ChannelSettings{MaxRequestBodyBytes: len(body)}, false},
+		{"body one byte over", dto.ChannelSettings{MaxRequestBodyBytes: len(body) - 1}, true},
+		{"messages at the limit", dto.ChannelSettings{MaxMessages: 3}, false},
+		{"messages one over", dto.ChannelSettings{MaxMessages: 2}, true},
+		{"limits disabled", dto.ChannelSettings{MaxRequestBodyBytes: -1, MaxMessages: -1}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+			c.Request.Header.Set("Content-Type", "application/json")
+			info := &relaycommon.RelayInfo{ChannelSetting: tt.setting}
+			request, err := getAndValidateClaudeRequest(c, info)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && len(request.Messages) != 3 {
+				t.Errorf("got %d messages, want 3", len(request.Messages))
+			}
+		})
+	}
+}
+
+func TestClaudeHelperRejectsOversizedRequestBeforeUpstream(t *testing.T) {
+	ch, calls := setupClaudeRelayTest(t)
+	setting := `{"max_messages":1}`
+	ch.Setting = &setting
+	body := `{"model":"claude-sonnet-4-20250514","max_tokens":16,"messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"hello"},{"role":"user","content":"bye"}]}`
+	c, _ := newClaudeRelayTestContext(t, ch, body, nil)
+	apiErr := ClaudeHelper(c)
+	if apiErr == nil || apiErr.GetErrorCode() != types.ErrorCodeInvalidRequest || !strings.Contains(apiErr.Error(), "too many messages") {
+		t.Fatalf("got %v, want a too many messages error", apiErr)
+	}
+	if got := atomic.LoadInt32(calls); got != 0 {
+		t.Errorf("upstream called %d times, want 0", got)
+	}
+}
+
+func TestClaudeHelperTracingSpanTree(t *testing.T) {
+	ch, _ := setupClaudeRelayTest(t)
+	spanRecorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder))
+	originalProvider, originalEnabled := otel.GetTracerProvider(), common.TracingEnabled
+	otel.SetTracerProvider(provider)
+	common.TracingEnabled = true
+	defer func() {
+		otel.SetTracerProvider(originalProvider)
+		common.TracingEnabled = originalEnabled
+	}()
+
+	body := `{"model":"claude-sonnet-4-20250514","max_tokens":1024,"messages":[{"role":"user","content":"hello"}]}`
+	c, _ := newClaudeRelayTestContext(t, ch, body, nil)
+	ctx, root := provider.Tracer("test").Start(c.Request.Context(), "POST /v1/messages")
+	c.Request = c.Request.WithContext(ctx)
+	if apiErr := ClaudeHelper(c); apiErr != nil {
+		t.Fatalf("ClaudeHelper: %v", apiErr)
+	}
+	root.End()
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range spanRecorder.Ended() {
+		spans[span.Name()] = span
+	}
+	phases := []string{"claude.validate", "claude.token_count", "claude.pre_consume", "claude.convert", "claude.upstream_call", "claude.response_process", "claude.consume_quota"}
+	for _, name := range phases {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("missing span %s", name)
+			continue
+		}
+		if span.Parent().SpanID() != root.SpanContext().SpanID() {
+			t.Errorf("span %s is not a child of the request span", name)
+		}
+		attrs := attribute.NewSet(span.Attributes()...)
+		if model, _ := attrs.Value("model"); model.AsString() != "claude-sonnet-4-20250514" {
+			t.Errorf("span %s model = %q", name, model.AsString())
+		}
+		if channelId, _ := attrs.Value("channel_id"); channelId.AsInt64() != int64(ch.Id) {
+			t.Errorf("span %s channel_id = %d, want %d", name, channelId.AsInt64(), ch.Id)
+		}
+	}
+	if span, ok := spans["claude.consume_quota"]; ok {
+		attrs := attribute.NewSet(span.Attributes()...)
+		if promptTokens, _ := attrs.Value("prompt_tokens"); promptTokens.AsInt64() <= 0 {
+			t.Errorf("consume_quota prompt_tokens = %d, want the actual usage", promptTokens.AsInt64())
+		}
+	}
+}
+
+func TestClaudeServerToolsRoundTripThroughVertex(t *testing.T) {
+	const (
+		channelId   = 9302
+		clientEmail = "tools@test-project.iam.gserviceaccount.com"
+	)
+	setupClaudeRelayTest(t)
+	var upstreamBody []byte
+	var upstreamBeta string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamBody, _ = io.ReadAll(r.Body)
+		upstreamBeta = r.Header.Get("anthropic-beta")
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(claudeTestStream))
+	}))
+	defer server.Close()
+	originalTransport := http.DefaultTransport
+	http.DefaultTransport = server.Client().Transport
+	service.InitHttpClient()
+	defer func() {
+		http.DefaultTransport = originalTransport
+		service.InitHttpClient()
+	}()
+	vertex.Cache.SetDefault(fmt.Sprintf("access-token-%d-%s", channelId, clientEmail), "test-token")
+
+	target, _ := url.Parse(server.URL)
+	setting := fmt.Sprintf(`{"vertex_api_host":%q}`, target.Host)
+	ch := &model.Channel{
+		Id:      channelId,
+		Type:    constant.ChannelTypeVertexAi,
+		Key:     `{"project_id":"test-project","private_key":"unused","client_email":"` + clientEmail + `"}`,
+		Setting: &setting,
+		Other:   "us-east5",
+	}
+	// 服务端工具与尚未识别的工具类型都应原样转发
+	const tools = `[{"type":"code_execution_20250522","name":"code_execution"},{"type":"web_search_20250305","name":"web_search","max_uses":3,"allowed_domains":["example.com"]},{"type":"future_tool_20991231","name":"future","options":{"depth":2}}]`
+	body := `{"model":"claude-sonnet-4-20250514","max_tokens":1024,"stream":true,"container":"container_abc","tools":` + tools + `,"messages":[{"role":"user","content":"run print(1)"}]}`
+	c, _ := newClaudeRelayTestContext(t, ch, body, map[string]string{"anthropic-beta": "code-execution-2025-05-22"})
+	if apiErr := ClaudeHelper(c); apiErr != nil {
+		t.Fatalf("ClaudeHelper: %v", apiErr)
+	}
+
+	var forwarded struct {
+		Tools     []map[string]any `json:"tools"`
+		Container string           `json:"container"`
+	}
+	if err := common.Unmarshal(upstreamBody, &forwarded); err != nil {
+		t.Fatalf("unmarshal upstream body %s: %v", upstreamBody, err)
+	}
+	var want []map[string]any
+	_ = common.UnmarshalJsonStr(tools, &want)
+	if !reflect.DeepEqual(forwarded.Tools, want) {
+		t.Errorf("tools = %v, want %v", forwarded.Tools, want)
+	}
+	if forwarded.Container != "container_abc" {
+		t.Errorf("container = %q, want container_abc", forwarded.Container)
+	}
+	if upstreamBeta != "code-execution-2025-05-22" {
+		t.Errorf("anthropic-beta = %q, want code-execution-2025-05-22", upstreamBeta)
+	}
+}
+
+func TestClaudeToolResultImageRoundTripThroughVertex(t *testing.T) {
+	const (
+		channelId   = 9303
+		clientEmail = "tool-result@test-project.iam.gserviceaccount.com"
+	)
+	setupClaudeRelayTest(t)
+	var upstreamBody []byte
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(claudeTestStream))
+	}))
+	defer server.Close()
+	originalTransport := http.DefaultTransport
+	http.DefaultTransport = server.Client().Transport
+	service.InitHttpClient()
+	defer func() {
+		http.DefaultTransport = originalTransport
+		service.InitHttpClient()
+	}()
+	vertex.Cache.SetDefault(fmt.Sprintf("access-token-%d-%s", channelId, clientEmail), "test-token")
+
+	target, _ := url.Parse(server.URL)
+	setting := fmt.Sprintf(`{"vertex_api_host":%q}`, target.Host)
+	ch := &model.Channel{
+		Id:      channelId,
+		Type:    constant.ChannelTypeVertexAi,
+		Key:     `{"project_id":"test-project","private_key":"unused","client_email":"` + clientEmail + `"}`,
+		Setting: &setting,
+		Other:   "us-east5",
+	}
+	// 截图的 base64 数据很长，按图片估算 token 而不是按文本计算
+	toolResult := `[{"type":"text","text":"screenshot taken"},{"type":"image","source":{"type":"base64","media_type":"image/png","data":"` + strings.Repeat("iVBORw0KGgo", 4000) + `"}}]`
+	body := `{"model":"claude-sonnet-4-20250514","max_tokens":1024,"stream":true,"messages":[` +
+		`{"role":"user","content":"take a screenshot"},` +
+		`{"role":"assistant","content":[{"type":"tool_use","id":"toolu_1","name":"computer","input":{"action":"screenshot"}}]},` +
+		`{"role":"user","content":[{"type":"tool_result","tool_use_id":"toolu_1","content":` + toolResult + `}]}]}`
+	c, _ := newClaudeRelayTestContext(t, ch, body, nil)
+	if apiErr := ClaudeHelper(c); apiErr != nil {
+		t.Fatalf("ClaudeHelper: %v", apiErr)
+	}
+
+	var forwarded struct {
+		Messages []map[string]any `json:"messages"`
+	}
+	if err := common.Unmarshal(upstreamBody, &forwarded); err != nil || len(forwarded.Messages) != 3 {
+		t.Fatalf("unexpected upstream body %.500s: %v", upstreamBody, err)
+	}
+	var want any
+	_ = common.UnmarshalJsonStr(toolResult, &want)
+	blocks, _ := forwarded.Messages[2]["content"].([]any)
+	if len(blocks) != 1 {
+		t.Fatalf("forwarded content = %.500v, want a single tool_result", forwarded.Messages[2]["content"])
+	}
+	if block, _ := blocks[0].(map[string]any); block["type"] != "tool_result" || !reflect.DeepEqual(block["content"], want) {
+		t.Errorf("forwarded tool_result content does not match the request")
+	}
+
+	var log model.Log
+	if err := model.LOG_DB.Where("type = ?", model.LogTypeConsume).First(&log).Error; err != nil {
+		t.Fatalf("consume log: %v", err)
+	}
+	other, _ := common.StrToMap(log.Other)
+	if estimated, _ := other["prompt_tokens_estimated"].(float64); estimated < 1000 || estimated > 1200 {
+		t.Errorf("estimated prompt tokens = %v, want the image counted as 1000 tokens", estimated)
+	}
+}
+
+func TestMaxOutputTokensHeaderLowersMaxTokens(t *testing.T) {
+	ch, _ := setupClaudeRelayTest(t)
+	var upstreamBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(claudeTestStream))
+	}))
+	defer server.Close()
+	baseURL := server.URL
+	ch.BaseURL = &baseURL
+
+	body := `{"model":"claude-sonnet-4-20250514","max_tokens":8192,"stream":true,"thinking":{"type":"enabled","budget_tokens":6000},"messages":[{"role":"user","content":"hello"}]}`
+	c, _ := newClaudeRelayTestContext(t, ch, body, map[string]string{MaxOutputTokensHeader: "2000"})
+	if apiErr := ClaudeHelper(c); apiErr != nil {
+		t.Fatalf("ClaudeHelper: %v", apiErr)
+	}
+	var forwarded dto.ClaudeRequest
+	if err := common.Unmarshal(upstreamBody, &forwarded); err != nil {
+		t.Fatalf("unmarshal upstream body %s: %v", upstreamBody, err)
+	}
+	if forwarded.MaxTokens != 2000 {
+		t.Errorf("max_tokens = %d, want 2000", forwarded.MaxTokens)
+	}
+	// 思考预算随 max_tokens 一起收紧
+	if forwarded.Thinking == nil || forwarded.Thinking.BudgetTokens == nil || *forwarded.Thinking.BudgetTokens >= 2000 || *forwarded.Thinking.BudgetTokens < 1024 {
+		t.Errorf("thinking = %+v, want a budget in [1024, 2000)", forwarded.Thinking)
+	}
+}
+
+func TestApplyMaxOutputTokensHeader(t *testing.T) {
+	tests := []struct {
+		name          string
+		header        string
+		maxTokens     uint
+		budget        int
+		wantMaxTokens uint
+		wantThinking  bool
+		wantErr       bool
+	}{
+		{"lowers client max_tokens", "1000", 4096, 0, 1000, false, false},
+		{"never raises max_tokens", "8000", 500, 0, 500, false, false},
+		{"disables thinking below the minimum budget", "1024", 4096, 2048, 1024, false, false},
+		{"zero", "0", 4096, 0, 4096, false, true},
+		{"negative", "-5", 4096, 0, 4096, false, true},
+		{"not a number", "lots", 4096, 0, 4096, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+			c.Request.Header.Set(MaxOutputTokensHeader, tt.header)
+			textRequest := &dto.ClaudeRequest{Model: "claude-sonnet-4-20250514", MaxTokens: tt.maxTokens}
+			if tt.budget > 0 {
+				textRequest.Thinking = &dto.Thinking{Type: "enabled", BudgetTokens: common.GetPointer(tt.budget)}
+			}
+			err := applyMaxOutputTokensHeader(c, textRequest)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if textRequest.MaxTokens != tt.wantMaxTokens {
+				t.Errorf("max_tokens = %d, want %d", textRequest.MaxTokens, tt.wantMaxTokens)
+			}
+			if (textRequest.Thinking != nil) != tt.wantThinking {
+				t.Errorf("thinking = %+v, want enabled %v", textRequest.Thinking, tt.wantThinking)
+			}
+		})
+	}
+}
+
+func TestClaudeHelperForwardsHashedMetadataUserId(t *testing.T) {
+	ch, _ := setupClaudeRelayTest(t)
+	settings := model_setting.GetClaudeSettings()
+	originalEnabled, originalSalt := settings.MetadataUserIdEnabled, settings.MetadataUserIdSalt
+	settings.MetadataUserIdEnabled, settings.MetadataUserIdSalt = true, "test-salt"
+	defer func() { settings.MetadataUserIdEnabled, settings.MetadataUserIdSalt = originalEnabled, originalSalt }()
+	var upstreamBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(claudeTestStream))
+	}))
+	defer server.Close()
+	baseURL := server.URL
+	ch.BaseURL = &baseURL
+
+	body := `{"model":"claude-sonnet-4-20250514","max_tokens":1024,"stream":true,"messages":[{"role":"user","content":"hello"}]}`
+	c, _ := newClaudeRelayTestContext(t, ch, body, nil)
+	if apiErr := ClaudeHelper(c); apiErr != nil {
+		t.Fatalf("ClaudeHelper: %v", apiErr)
+	}
+	var forwarded dto.ClaudeRequest
+	if err := common.Unmarshal(upstreamBody, &forwarded); err != nil {
+		t.Fatalf("unmarshal upstream body %s: %v", upstreamBody, err)
+	}
+	want := common.GenerateHMACWithKey([]byte("test-salt"), "1")
+	if forwarded.Metadata == nil || forwarded.Metadata.UserId != want {
+		t.Errorf("metadata = %+v, want user_id %s", forwarded.Metadata, want)
+	}
+}
+
+func TestApplyClaudeMetadataUserId(t *testing.T) {
+	settings := model_setting.GetClaudeSettings()
+	originalEnabled, originalOverride, originalSalt := settings.MetadataUserIdEnabled, settings.MetadataUserIdOverride, settings.MetadataUserIdSalt
+	defer func() {
+		settings.MetadataUserIdEnabled, settings.MetadataUserIdOverride, settings.MetadataUserIdSalt = originalEnabled, originalOverride, originalSalt
+	}()
+	settings.MetadataUserIdSalt = "test-salt"
+	hashed := common.GenerateHMACWithKey([]byte("test-salt"), "42")
+	tests := []struct {
+		name     string
+		enabled  bool
+		override bool
+		clientId string
+		want     string
+	}{
+		{"disabled", false, false, "", ""},
+		{"populated when missing", true, false, "", hashed},
+		{"client value kept", true, false, "client-user", "client-user"},
+		{"client value overridden", true, true, "client-user", hashed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings.MetadataUserIdEnabled, settings.MetadataUserIdOverride = tt.enabled, tt.override
+			textRequest := &dto.ClaudeRequest{Model: "claude-sonnet-4-20250514"}
+			if tt.clientId != "" {
+				textRequest.Metadata = &dto.ClaudeMetadata{UserId: tt.clientId}
+			}
+			applyClaudeMetadataUserId(textRequest, 42)
+			got := ""
+			if textRequest.Metadata != nil {
+				got = textRequest.Metadata.UserId
+			}
+			if got != tt.want {
+				t.Errorf("metadata.user_id = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClaudeConcurrencyLimitRejectsOrQueues(t *testing.T) {
+	tests := []struct {
+		name        string
+		waitSeconds int
+		wantQueued  bool
+	}{
+		{"fail fast", 0, false},
+		{"block until a slot frees", 5, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch, _ := setupClaudeRelayTest(t)
+			settings := model_setting.GetClaudeSettings()
+			originalLimits, originalWait := settings.ConcurrencyLimits, settings.ConcurrencyWaitSeconds
+			settings.ConcurrencyLimits = map[string]int{"claude-sonnet-4-20250514": 2}
+			settings.ConcurrencyWaitSeconds = tt.waitSeconds
+			defer func() { settings.ConcurrencyLimits, settings.ConcurrencyWaitSeconds = originalLimits, originalWait }()
+
+			// 上游在收到 unblock 信号前一直挂起，占住并发名额
+			arrived := make(chan struct{}, 3)
+			unblock := make(chan struct{})
+			var upstreamCalls int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&upstreamCalls, 1)
+				arrived <- struct{}{}
+				<-unblock
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.Write([]byte(claudeTestStream))
+			}))
+			defer server.Close()
+			baseURL := server.URL
+			ch.BaseURL = &baseURL
+
+			const body = `{"model":"claude-sonnet-4-20250514","max_tokens":1024,"stream":true,"messages":[{"role":"user","content":"hello"}]}`
+			inFlight := make(chan *types.NewAPIError, 2)
+			for i := 0; i < 2; i++ {
+				c, _ := newClaudeRelayTestContext(t, ch, body, nil)
+				go func() { inFlight <- ClaudeHelper(c) }()
+			}
+			for i := 0; i < 2; i++ {
+				select {
+				case <-arrived:
+				case <-time.After(5 * time.Second):
+					t.Fatal("in-flight requests did not reach the upstream")
+				}
+			}
+
+			// 第 N+1 个请求
+			extra := make(chan *types.NewAPIError, 1)
+			c, _ := newClaudeRelayTestContext(t, ch, body, nil)
+			go func() { extra <- ClaudeHelper(c) }()
+			if !tt.wantQueued {
+				select {
+				case apiErr := <-extra:
+					if apiErr == nil || apiErr.GetErrorCode() != types.ErrorCodeChannelUnavailable || apiErr.StatusCode != http.StatusServiceUnavailable {
+						t.Errorf("error = %v, want %s/503", apiErr, types.ErrorCodeChannelUnavailable)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("the N+1th request should fail fast")
+				}
+				close(unblock)
+			} else {
+				select {
+				case apiErr := <-extra:
+					t.Fatalf("the N+1th request should queue, got %v", apiErr)
+				case <-arrived:
+					t.Fatal("the N+1th request reached the upstream while the limit was full")
+				case <-time.After(300 * time.Millisecond):
+				}
+				close(unblock)
+				select {
+				case apiErr := <-extra:
+					if apiErr != nil {
+						t.Errorf("queued request: %v", apiErr)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("the queued request did not run after a slot freed")
+				}
+			}
+			for i := 0; i < 2; i++ {
+				if apiErr := <-inFlight; apiErr != nil {
+					t.Errorf("in-flight request: %v", apiErr)
+				}
+			}
+			wantCalls := int32(2)
+			if tt.wantQueued {
+				wantCalls = 3
+			}
+			if got := atomic.LoadInt32(&upstreamCalls); got != wantCalls {
+				t.Errorf("upstream calls = %d, want %d", got, wantCalls)
+			}
+		})
+	}
+}
+
+func TestClaudeSamplingParamsPassThroughWithoutThinking(t *testing.T) {
+	const (
+		channelId   = 9303
+		clientEmail = "sampling@test-project.iam.gserviceaccount.com"
+	)
+	tests := []struct {
+		name        string
+		channelType int
+	}{
+		{"anthropic", constant.ChannelTypeAnthropic},
+		{"vertex", constant.ChannelTypeVertexAi},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch, _ := setupClaudeRelayTest(t)
+			var upstreamBody []byte
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				upstreamBody, _ = io.ReadAll(r.Body)
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.Write([]byte(claudeTestStream))
+			}))
+			defer server.Close()
+			originalTransport := http.DefaultTransport
+			http.DefaultTransport = server.Client().Transport
+			service.InitHttpClient()
+			defer func() {
+				http.DefaultTransport = originalTransport
+				service.InitHttpClient()
+			}()
+			if tt.channelType == constant.ChannelTypeVertexAi {
+				vertex.Cache.SetDefault(fmt.Sprintf("access-token-%d-%s", channelId, clientEmail), "test-token")
+				target, _ := url.Parse(server.URL)
+				setting := fmt.Sprintf(`{"vertex_api_host":%q}`, target.Host)
+				ch = &model.Channel{
+					Id:      channelId,
+					Type:    constant.ChannelTypeVertexAi,
+					Key:     `{"project_id":"test-project","private_key":"unused","client_email":"` + clientEmail + `"}`,
+					Setting: &setting,
+					Other:   "us-east5",
+				}
+			} else {
+				baseURL := server.URL
+				ch.BaseURL = &baseURL
+			}
+
+			body := `{"model":"claude-sonnet-4-20250514","max_tokens":1024,"stream":true,"temperature":0.3,"top_p":0.9,"top_k":40,"messages":[{"role":"user","content":"hello"}]}`
+			c, _ := newClaudeRelayTestContext(t, ch, body, nil)
+			if apiErr := ClaudeHelper(c); apiErr != nil {
+				t.Fatalf("ClaudeHelper: %v", apiErr)
+			}
+			var forwarded struct {
+				Temperature *float64 `json:"temperature"`
+				TopP        *float64 `json:"top_p"`
+				TopK        *int     `json:"top_k"`
+			}
+			if err := common.Unmarshal(upstreamBody, &forwarded); err != nil {
+				t.Fatalf("unmarshal upstream body %s: %v", upstreamBody, err)
+			}
+			if forwarded.Temperature == nil || *forwarded.Temperature != 0.3 {
+				t.Errorf("temperature = %v, want 0.3", forwarded.Temperature)
+			}
+			if forwarded.TopP == nil || *forwarded.TopP != 0.9 {
+				t.Errorf("top_p = %v, want 0.9", forwarded.TopP)
+			}
+			if forwarded.TopK == nil || *forwarded.TopK != 40 {
+				t.Errorf("top_k = %v, want 40", forwarded.TopK)
+			}
+		})
+	}
+}
+
+func TestClaudeModelAliasResolvesBeforeVertexMapping(t *testing.T) {
+	const (
+		channelId   = 9304
+		clientEmail = "alias@test-project.iam.gserviceaccount.com"
+	)
+	setupClaudeRelayTest(t)
+	aliasSettings := model_setting.GetModelAliasSettings()
+	originalAliases := aliasSettings.GroupAliases
+	aliasSettings.GroupAliases = map[string]map[string]string{"default": {"corp-chat-fast": "claude-sonnet-4-20250514"}}
+	defer func() { aliasSettings.GroupAliases = originalAliases }()
+
+	var upstreamPath string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamPath = r.URL.Path
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(claudeTestStream))
+	}))
+	defer server.Close()
+	originalTransport := http.DefaultTransport
+	http.DefaultTransport = server.Client().Transport
+	service.InitHttpClient()
+	defer func() {
+		http.DefaultTransport = originalTransport
+		service.InitHttpClient()
+	}()
+	vertex.Cache.SetDefault(fmt.Sprintf("access-token-%d-%s", channelId, clientEmail), "test-token")
+	target, _ := url.Parse(server.URL)
+	setting := fmt.Sprintf(`{"vertex_api_host":%q}`, target.Host)
+	model.DB.Create(&model.Channel{
+		Id:      channelId,
+		Type:    constant.ChannelTypeVertexAi,
+		Key:     `{"project_id":"test-project","private_key":"unused","client_email":"` + clientEmail + `"}`,
+		Setting: &setting,
+		Other:   "us-east5",
+		Status:  common.ChannelStatusEnabled,
+		Name:    "vertex",
+	})
+
+	// 经过渠道分发中间件，别名在选择渠道前解析
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"corp-chat-fast","max_tokens":1024,"stream":true,"messages":[{"role":"user","content":"hello"}]}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	common.SetContextKey(c, constant.ContextKeyUserId, 1)
+	common.SetContextKey(c, constant.ContextKeyTokenId, 1)
+	common.SetContextKey(c, constant.ContextKeyTokenKey, "test-token")
+	common.SetContextKey(c, constant.ContextKeyTokenUnlimited, true)
+	common.SetContextKey(c, constant.ContextKeyUserGroup, "default")
+	common.SetContextKey(c, constant.ContextKeyTokenSpecificChannelId, strconv.Itoa(channelId))
+	middleware.Distribute()(c)
+	if c.IsAborted() {
+		t.Fatal("Distribute aborted the request")
+	}
+	if apiErr := ClaudeHelper(c); apiErr != nil {
+		t.Fatalf("ClaudeHelper: %v", apiErr)
+	}
+
+	if !strings.HasSuffix(upstreamPath, "/publishers/anthropic/models/claude-sonnet-4@20250514:streamRawPredict") {
+		t.Errorf("upstream path = %s, want the Vertex form of the resolved model", upstreamPath)
+	}
+	var log model.Log
+	if err := model.LOG_DB.Where("type = ?", model.LogTypeConsume).First(&log).Error; err != nil {
+		t.Fatalf("consume log: %v", err)
+	}
+	other, _ := common.StrToMap(log.Other)
+	if log.ModelName != "claude-sonnet-4-20250514" || other["model_alias"] != "corp-chat-fast" {
+		t.Errorf("log model = %s, model_alias = %v, want the resolved model and the alias", log.ModelName, other["model_alias"])
+	}
+}
+
+func TestClaudeHelperCapsThinkingBudget(t *testing.T) {
+	globalSettings := model_setting.GetGlobalSettings()
+	claudeSettings := model_setting.GetClaudeSettings()
+	originalGlobalMax, originalAdapter := globalSettings.MaxThinkingBudgetTokens, claudeSettings.ThinkingAdapterEnabled
+	claudeSettings.ThinkingAdapterEnabled = true
+	defer func() {
+		globalSettings.MaxThinkingBudgetTokens, claudeSettings.ThinkingAdapterEnabled = originalGlobalMax, originalAdapter
+	}()
+	tests := []struct {
+		name       string
+		channelMax int
+		globalMax  int
+		budget     int // 为 0 时通过 -thinking 后缀由适配器生成预算
+		wantBudget int
+	}{
+		{"client budget over the channel cap", 8000, 0, 30000, 8000},
+		{"client budget over the global cap", 0, 10000, 30000, 10000},
+		{"suffix-derived budget over the cap", 8000, 0, 0, 8000},
+		{"cap never below the minimum budget", 500, 0, 4000, 1024},
+		{"budget under the cap", 8000, 0, 4000, 4000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch, _ := setupClaudeRelayTest(t)
+			globalSettings.MaxThinkingBudgetTokens = tt.globalMax
+			var upstreamBody []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				upstreamBody, _ = io.ReadAll(r.Body)
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.Write([]byte(claudeTestStream))
+			}))
+			defer server.Close()
+			baseURL := server.URL
+			ch.BaseURL = &baseURL
+			setting := fmt.Sprintf(`{"max_thinking_budget_tokens":%d}`, tt.channelMax)
+			ch.Setting = &setting
+
+			body := fmt.Sprintf(`{"model":"claude-sonnet-4-20250514","max_tokens":32000,"stream":true,"thinking":{"type":"enabled","budget_tokens":%d},"messages":[{"role":"user","content":"hello"}]}`, tt.budget)
+			if tt.budget == 0 {
+				body = `{"model":"claude-3-7-sonnet-20250219-thinking","max_tokens":32000,"stream":true,"messages":[{"role":"user","content":"hello"}]}`
+			}
+			c, _ := newClaudeRelayTestContext(t, ch, body, nil)
+			if tt.budget == 0 {
+				common.SetContextKey(c, constant.ContextKeyOriginalModel, "claude-3-7-sonnet-20250219-thinking")
+			}
+			if apiErr := ClaudeHelper(c); apiErr != nil {
+				t.Fatalf("ClaudeHelper: %v", apiErr)
+			}
+			var forwarded dto.ClaudeRequest
+			if err := common.Unmarshal(upstreamBody, &forwarded); err != nil {
+				t.Fatalf("unmarshal upstream body %s: %v", upstreamBody, err)
+			}
+			if forwarded.Thinking == nil || forwarded.Thinking.BudgetTokens == nil || *forwarded.Thinking.BudgetTokens != tt.wantBudget {
+				t.Errorf("thinking = %+v, want budget %d", forwarded.Thinking, tt.wantBudget)
+			}
+		})
+	}
+}
+
+func TestLogClaudeRequestCompletedSamplesFastAndKeepsSlow(t *testing.T) {
+	settings := model_setting.GetClaudeSettings()
+	originalThreshold, originalPercentage := settings.SlowRequestThresholdMs, settings.CompletedLogPercentage
+	settings.SlowRequestThresholdMs, settings.CompletedLogPercentage = 500, 0
+	defer func() {
+		settings.SlowRequestThresholdMs, settings.CompletedLogPercentage = originalThreshold, originalPercentage
+	}()
+	logs := &lockedBuffer{}
+	originalWriter, originalErrorWriter := gin.DefaultWriter, gin.DefaultErrorWriter
+	gin.DefaultWriter, gin.DefaultErrorWriter = logs, logs
+	defer func() { gin.DefaultWriter, gin.DefaultErrorWriter = originalWriter, originalErrorWriter }()
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	usage := &dto.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15}
+
+	// 采样比例为 0 时普通请求不记录完成日志
+	logClaudeRequestCompleted(c, usage, claudeRequestTimings{total: 20 * time.Millisecond})
+	if output := logs.String(); strings.Contains(output, "[CLAUDE]") {
+		t.Errorf("fast request should be sampled out, got:\n%s", output)
+	}
+
+	// 慢请求始终记录各阶段耗时明细
+	logClaudeRequestCompleted(c, usage, claudeRequestTimings{
+		total:           800 * time.Millisecond,
+		tokenCount:      5 * time.Millisecond,
+		upstream:        700 * time.Millisecond,
+		responseProcess: 95 * time.Millisecond,
+	})
+	output := logs.String()
+	for _, want := range []string{"[CLAUDE] Slow request completed", "TotalTime:800ms", "TokenCountTime:5ms", "UpstreamTime:700ms", "ResponseProcessTime:95ms", "TotalTokens:15"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("slow request log is missing %q:\n%s", want, output)
+		}
+	}
+}
+
+func TestApplyChannelSystemPrompt(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	service.InitTokenEncoders()
+	const prompt = "Follow the brand guidelines."
+	tests := []struct {
+		name   string
+		mode   string
+		system string
+		want   []string
+	}{
+		{"no client system", "", ``, []string{prompt}},
+		{"prepend to string", dto.SystemPromptModePrepend, `"Be brief."`, []string{prompt + "\n\nBe brief."}},
+		{"append to string", dto.SystemPromptModeAppend, `"Be brief."`, []string{"Be brief.\n\n" + prompt}},
+		{"prepend to blocks", "", `[{"type":"text","text":"Be brief.","cache_control":{"type":"ephemeral"}}]`, []string{prompt, "Be brief."}},
+		{"append to blocks", dto.SystemPromptModeAppend, `[{"type":"text","text":"Be brief."}]`, []string{"Be brief.", prompt}},
+		{"replace if absent keeps client system", dto.SystemPromptModeReplaceIfAbsent, `"Be brief."`, []string{"Be brief."}},
+		{"replace if absent fills missing system", dto.SystemPromptModeReplaceIfAbsent, ``, []string{prompt}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"model":"claude-sonnet-4-20250514","max_tokens":16,"messages":[{"role":"user","content":"hi"}]}`
+			if tt.system != "" {
+				body = `{"model":"claude-sonnet-4-20250514","max_tokens":16,"system":` + tt.system + `,"messages":[{"role":"user","content":"hi"}]}`
+			}
+			var textRequest dto.ClaudeRequest
+			if err := common.UnmarshalJsonStr(body, &textRequest); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			before, _ := service.CountTokenClaudeRequest(textRequest, textRequest.Model)
+			withoutSystem := textRequest
+			withoutSystem.System = ""
+			messageTokens, _ := service.CountTokenClaudeRequest(withoutSystem, textRequest.Model)
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+			info := &relaycommon.RelayInfo{ChannelSetting: dto.ChannelSettings{DefaultSystemPrompt: prompt, DefaultSystemPromptMode: tt.mode}}
+			applyChannelSystemPrompt(c, info, &textRequest)
+
+			var got []string
+			if textRequest.IsStringSystem() {
+				got = []string{textRequest.GetStringSystem()}
+			} else {
+				for _, block := range textRequest.ParseSystem() {
+					got = append(got, block.GetText())
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("system = %q, want %q", got, tt.want)
+			}
+			// 注入的提示词计入 prompt tokens
+			after, _ := service.CountTokenClaudeRequest(textRequest, textRequest.Model)
+			injected := !reflect.DeepEqual(tt.want, []string{"Be brief."})
+			if minTokens := messageTokens + service.CountTextToken(prompt, textRequest.Model); injected && after < minTokens {
+				t.Errorf("prompt tokens = %d, want at least %d with the injected prompt counted", after, minTokens)
+			}
+			if !injected && after != before {
+				t.Errorf("prompt tokens %d -> %d, want unchanged", before, after)
+			}
+		})
+	}
+}
+
+func TestClaudeHelperDisableThinkingOverridesSuffix(t *testing.T) {
+	claudeSettings := model_setting.GetClaudeSettings()
+	originalAdapter := claudeSettings.ThinkingAdapterEnabled
+	claudeSettings.ThinkingAdapterEnabled = true
+	defer func() { claudeSettings.ThinkingAdapterEnabled = originalAdapter }()
+	tests := []struct {
+		name         string
+		extra        string
+		header       map[string]string
+		wantThinking bool
+	}{
+		{"suffix enables thinking", ``, nil, true},
+		{"disabled by body field", `"disable_thinking":true,`, nil, false},
+		{"disabled by header", ``, map[string]string{ClaudeDisableThinkingHeader: "true"}, false},
+		{"client thinking block removed", `"disable_thinking":true,"thinking":{"type":"enabled","budget_tokens":2048},`, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch, _ := setupClaudeRelayTest(t)
+			var upstreamBody []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				upstreamBody, _ = io.ReadAll(r.Body)
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.Write([]byte(claudeTestStream))
+			}))
+			defer server.Close()
+			ch.BaseURL = &server.URL
+
+			body := `{"model":"claude-3-7-sonnet-20250219-thinking","max_tokens":4096,"stream":true,` + tt.extra + `"messages":[{"role":"user","content":"hello"}]}`
+			c, _ := newClaudeRelayTestContext(t, ch, body, tt.header)
+			common.SetContextKey(c, constant.ContextKeyOriginalModel, "claude-3-7-sonnet-20250219-thinking")
+			if apiErr := ClaudeHelper(c); apiErr != nil {
+				t.Fatalf("ClaudeHelper: %v", apiErr)
+			}
+			forwarded := string(upstreamBody)
+			if got := strings.Contains(forwarded, `"thinking"`); got != tt.wantThinking {
+				t.Errorf("thinking forwarded = %v, want %v: %s", got, tt.wantThinking, forwarded)
+			}
+			if strings.Contains(forwarded, "disable_thinking") {
+				t.Errorf("gateway field disable_thinking must not reach upstream: %s", forwarded)
+			}
+			if !strings.Contains(forwarded, `"model":"claude-3-7-sonnet-20250219"`) {
+				t.Errorf("upstream model should drop the -thinking suffix: %s", forwarded)
+			}
+		})
+	}
+}
+
+func TestClaudeHelperFlagsSLABreach(t *testing.T) {
+	settings := model_setting.GetClaudeSettings()
+	originalSLA := settings.SLAThresholdMs
+	settings.SLAThresholdMs = 100
+	defer func() { settings.SLAThresholdMs = originalSLA }()
+	tests := []struct {
+		name       string
+		delay      time.Duration
+		wantBreach bool
+	}{
+		{"slow request", 300 * time.Millisecond, true},
+		{"fast request", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch, _ := setupClaudeRelayTest(t)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(tt.delay)
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.Write([]byte(claudeTestStream))
+			}))
+			defer server.Close()
+			ch.BaseURL = &server.URL
+
+			body := `{"model":"claude-sonnet-4-20250514","max_tokens":1024,"stream":true,"messages":[{"role":"user","content":"hello"}]}`
+			c, recorder := newClaudeRelayTestContext(t, ch, body, nil)
+			// 超过 SLA 只做标记，请求仍正常完成
+			if apiErr := ClaudeHelper(c); apiErr != nil {
+				t.Fatalf("ClaudeHelper: %v", apiErr)
+			}
+			if !strings.Contains(recorder.Body.String(), `"text":"hi"`) {
+				t.Errorf("response not delivered:\n%s", recorder.Body.String())
+			}
+			if got := common.GetContextKeyBool(c, constant.ContextKeySLABreached); got != tt.wantBreach {
+				t.Errorf("sla breached = %v, want %v", got, tt.wantBreach)
+			}
+			var log model.Log
+			if err := model.LOG_DB.Where("type = ?", model.LogTypeConsume).First(&log).Error; err != nil {
+				t.Fatalf("consume log: %v", err)
+			}
+			if got := strings.Contains(log.Other, `"sla_breached":true`); got != tt.wantBreach {
+				t.Errorf("consume log other = %s, want sla_breached %v", log.Other, tt.wantBreach)
+			}
+		})
+	}
+}
+
+func TestClaudeHelperEnforcesTokenModelAllowlist(t *testing.T) {
+	tests := []struct {
+		name      string
+		model     string
+		mapping   string
+		wantAllow bool
+	}{
+		{"allowed by wildcard", "claude-opus-4-20250514", "", true},
+		{"disallowed model", "claude-sonnet-4-20250514", "", false},
+		{"mapped to an allowed model", "claude-sonnet-4-20250514", `{"claude-sonnet-4-20250514":"claude-opus-4-20250514"}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch, calls := setupClaudeRelayTest(t)
+			if tt.mapping != "" {
+				ch.ModelMapping = &tt.mapping
+			}
+			body := `{"model":"` + tt.model + `","max_tokens":1024,"stream":true,"messages":[{"role":"user","content":"hello"}]}`
+			c, _ := newClaudeRelayTestContext(t, ch, body, nil)
+			common.SetContextKey(c, constant.ContextKeyOriginalModel, tt.model)
+			// 同一令牌只允许 claude-opus-*
+			common.SetContextKey(c, constant.ContextKeyTokenModelLimitEnabled, true)
+			common.SetContextKey(c, constant.ContextKeyTokenModelLimit, map[string]bool{"claude-opus-*": true})
+			var before model.User
+			model.DB.First(&before, 1)
+
+			apiErr := ClaudeHelper(c)
+			if tt.wantAllow {
+				if apiErr != nil {
+					t.Fatalf("ClaudeHelper: %v", apiErr)
+				}
+				return
+			}
+			if apiErr == nil || apiErr.GetErrorCode() != types.ErrorCodeModelNotSupported || apiErr.StatusCode != http.StatusForbidden {
+				t.Fatalf("got %v, want a 403 %s error", apiErr, types.ErrorCodeModelNotSupported)
+			}
+			if got := atomic.LoadInt32(calls); got != 0 {
+				t.Errorf("upstream called %d times, want 0", got)
+			}
+			// 拒绝发生在预扣费之前
+			var after model.User
+			model.DB.First(&after, 1)
+			if after.Quota != before.Quota {
+				t.Errorf("user quota %d -> %d, want unchanged", before.Quota, after.Quota)
+			}
+		})
+	}
+}
+
+func TestClaudeHelperResolvesThinkingSuffixAndBlock(t *testing.T) {
+	claudeSettings := model_setting.GetClaudeSettings()
+	originalAdapter := claudeSettings.ThinkingAdapterEnabled
+	claudeSettings.ThinkingAdapterEnabled = true
+	defer func() { claudeSettings.ThinkingAdapterEnabled = originalAdapter }()
+	suffixBudget := claudeSettings.GetThinkingBudgetTokens("claude-3-7-sonnet-20250219", 4096)
+	tests := []struct {
+		name       string
+		thinking   string
+		channelMax int
+		wantStatus int // 非 0 时期望请求被拒绝
+		wantBudget int // 为 0 表示不开启思考
+		wantLog    string
+	}{
+		{"block agrees with suffix", fmt.Sprintf(`{"type":"enabled","budget_tokens":%d}`, suffixBudget), 0, 0, suffixBudget, "Result:agree"},
+		{"block wins over suffix", `{"type":"enabled","budget_tokens":2048}`, 0, 0, 2048, "Result:block"},
+		{"block still capped", `{"type":"enabled","budget_tokens":3000}`, 1500, 0, 1500, "Result:block"},
+		{"block disables thinking", `{"type":"disabled"}`, 0, 0, 0, "Result:disabled"},
+		{"budget not below max_tokens", `{"type":"enabled","budget_tokens":4096}`, 0, http.StatusBadRequest, 0, ""},
+		{"budget under the minimum", `{"type":"enabled","budget_tokens":512}`, 0, http.StatusBadRequest, 0, ""},
+		{"unknown type", `{"type":"auto"}`, 0, http.StatusBadRequest, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch, _ := setupClaudeRelayTest(t)
+			var upstreamBody []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				upstreamBody, _ = io.ReadAll(r.Body)
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.Write([]byte(claudeTestStream))
+			}))
+			defer server.Close()
+			ch.BaseURL = &server.URL
+			setting := fmt.Sprintf(`{"max_thinking_budget_tokens":%d}`, tt.channelMax)
+			ch.Setting = &setting
+			logs := &lockedBuffer{}
+			originalWriter := gin.DefaultWriter
+			gin.DefaultWriter = logs
+			defer func() { gin.DefaultWriter = originalWriter }()
+
+			body := `{"model":"claude-3-7-sonnet-20250219-thinking","max_tokens":4096,"stream":true,"thinking":` + tt.thinking + `,"messages":[{"role":"user","content":"hello"}]}`
+			c, _ := newClaudeRelayTestContext(t, ch, body, nil)
+			common.SetContextKey(c, constant.ContextKeyOriginalModel, "claude-3-7-sonnet-20250219-thinking")
+			apiErr := ClaudeHelper(c)
+			if tt.wantStatus != 0 {
+				if apiErr == nil || apiErr.StatusCode != tt.wantStatus {
+					t.Fatalf("got %v, want status %d", apiErr, tt.wantStatus)
+				}
+				if upstreamBody != nil {
+					t.Errorf("conflicting request reached upstream: %s", upstreamBody)
+				}
+				return
+			}
+			if apiErr != nil {
+				t.Fatalf("ClaudeHelper: %v", apiErr)
+			}
+			var forwarded dto.ClaudeRequest
+			if err := common.Unmarshal(upstreamBody, &forwarded); err != nil {
+				t.Fatalf("unmarshal upstream body %s: %v", upstreamBody, err)
+			}
+			budget := 0
+			if forwarded.Thinking != nil && forwarded.Thinking.BudgetTokens != nil {
+				budget = *forwarded.Thinking.BudgetTokens
+			}
+			if budget != tt.wantBudget {
+				t.Errorf("forwarded thinking budget = %d, want %d", budget, tt.wantBudget)
+			}
+			if !strings.Contains(logs.String(), "[CLAUDE] Thinking config resolved") || !strings.Contains(logs.String(), tt.wantLog) {
+				t.Errorf("resolution log with %q missing", tt.wantLog)
+			}
+		})
+	}
+}
+
+func TestClaudeHelperFastTokenEstimateSettlesOnUpstreamUsage(t *testing.T) {
+	settings := model_setting.GetClaudeSettings()
+	originalFast := settings.FastTokenEstimate
+	defer func() { settings.FastTokenEstimate = originalFast }()
+	body := `{"model":"claude-sonnet-4-20250514","max_tokens":1024,"messages":[{"role":"user","content":"` + strings.Repeat("hello world ", 200) + `"}]}`
+
+	estimates := map[bool]float64{}
+	charges := map[bool]int{}
+	for _, fast := range []bool{false, true} {
+		t.Run(fmt.Sprintf("fast=%v", fast), func(t *testing.T) {
+			settings.FastTokenEstimate = fast
+			// 上游返回 input_tokens 10、output_tokens 5
+			ch, _ := setupClaudeRelayTest(t)
+
+			c, _ := newClaudeRelayTestContext(t, ch, body, nil)
+			if apiErr := ClaudeHelper(c); apiErr != nil {
+				t.Fatalf("ClaudeHelper: %v", apiErr)
+			}
+			var log model.Log
+			if err := model.LOG_DB.Where("type = ?", model.LogTypeConsume).First(&log).Error; err != nil {
+				t.Fatalf("consume log: %v", err)
+			}
+			// 按上游返回的用量结算，与预估值无关
+			if log.PromptTokens != 10 || log.CompletionTokens != 5 {
+				t.Errorf("logged tokens = %d/%d, want the upstream usage 10/5", log.PromptTokens, log.CompletionTokens)
+			}
+			var user model.User
+			model.DB.First(&user, 1)
+			if charged := 10000000 - user.Quota; charged != log.Quota {
+				t.Errorf("user charged %d, want the logged quota %d", charged, log.Quota)
+			}
+			other, _ := common.StrToMap(log.Other)
+			estimates[fast], _ = other["prompt_tokens_estimated"].(float64)
+			charges[fast] = log.Quota
+		})
+	}
+	if estimates[true] == estimates[false] {
+		t.Errorf("fast estimate = accurate count = %v, want the cheap estimate to be used", estimates[true])
+	}
+	if charges[true] != charges[false] {
+		t.Errorf("charge with fast estimate = %d, without = %d, want the same upstream-based charge", charges[true], charges[false])
+	}
+}
+
+func TestClaudeHelperStrictRequestFields(t *testing.T) {
+	settings := model_setting.GetClaudeSettings()
+	originalStrict := settings.StrictRequestFields
+	defer func() { settings.StrictRequestFields = originalStrict }()
+	const typoBody = `{"model":"claude-sonnet-4-20250514","max_tokens":1024,"temprature":0.5,"stream":true,"messages":[{"role":"user","content":"hello"}]}`
+	const validBody = `{"model":"claude-sonnet-4-20250514","max_tokens":1024,"temperature":0.5,"stream":true,"messages":[{"role":"user","content":"hello"}]}`
+	tests := []struct {
+		name       string
+		strict     bool
+		body       string
+		wantReject bool
+	}{
+		{"strict rejects a typo'd field", true, typoBody, true},
+		{"strict accepts known fields", true, validBody, false},
+		{"lenient ignores a typo'd field", false, typoBody, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings.StrictRequestFields = tt.strict
+			ch, calls := setupClaudeRelayTest(t)
+			c, _ := newClaudeRelayTestContext(t, ch, tt.body, nil)
+			apiErr := ClaudeHelper(c)
+			if !tt.wantReject {
+				if apiErr != nil {
+					t.Fatalf("ClaudeHelper: %v", apiErr)
+				}
+				return
+			}
+			if apiErr == nil || apiErr.GetErrorCode() != types.ErrorCodeInvalidRequest {
+				t.Fatalf("got %v, want %s", apiErr, types.ErrorCodeInvalidRequest)
+			}
+			if !strings.Contains(apiErr.Error(), `"temprature"`) {
+				t.Errorf("error = %q, want the unknown field named", apiErr.Error())
+			}
+			if got := atomic.LoadInt32(calls); got != 0 {
+				t.Errorf("upstream called %d times, want 0", got)
+			}
+		})
+	}
+}
+
+func TestClaudeHelperHandlesThinkingUnsupportedModel(t *testing.T) {
+	claudeSettings := model_setting.GetClaudeSettings()
+	originalAdapter, originalReject := claudeSettings.ThinkingAdapterEnabled, claudeSettings.NoThinkingReject
+	claudeSettings.ThinkingAdapterEnabled = true
+	defer func() {
+		claudeSettings.ThinkingAdapterEnabled, claudeSettings.NoThinkingReject = originalAdapter, originalReject
+	}()
+	for _, reject := range []bool{false, true} {
+		t.Run(fmt.Sprintf("reject=%v", reject), func(t *testing.T) {
+			claudeSettings.NoThinkingReject = reject
+			ch, _ := setupClaudeRelayTest(t)
+			// 该模型的 -thinking 版本默认没有配置倍率
+			var ratios map[string]float64
+			_ = common.UnmarshalJsonStr(ratio_setting.ModelRatio2JSONString(), &ratios)
+			ratios["claude-3-5-sonnet-20241022-thinking"] = 1.5
+			ratioJSON, _ := common.Marshal(ratios)
+			if err := ratio_setting.UpdateModelRatioByJSONString(string(ratioJSON)); err != nil {
+				t.Fatalf("update model ratio: %v", err)
+			}
+			var upstreamBody []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				upstreamBody, _ = io.ReadAll(r.Body)
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.Write([]byte(claudeTestStream))
+			}))
+			defer server.Close()
+			ch.BaseURL = &server.URL
+
+			body := `{"model":"claude-3-5-sonnet-20241022-thinking","max_tokens":4096,"stream":true,"messages":[{"role":"user","content":"hello"}]}`
+			c, _ := newClaudeRelayTestContext(t, ch, body, nil)
+			common.SetContextKey(c, constant.ContextKeyOriginalModel, "claude-3-5-sonnet-20241022-thinking")
+			apiErr := ClaudeHelper(c)
+			if reject {
+				if apiErr == nil || apiErr.StatusCode != http.StatusBadRequest || !strings.Contains(apiErr.Error(), "does not support extended thinking") {
+					t.Fatalf("got %v, want a 400 naming the unsupported thinking", apiErr)
+				}
+				if upstreamBody != nil {
+					t.Errorf("rejected request reached upstream: %s", upstreamBody)
+				}
+				return
+			}
+			if apiErr != nil {
+				t.Fatalf("ClaudeHelper: %v", apiErr)
+			}
+			var forwarded dto.ClaudeRequest
+			if err := common.Unmarshal(upstreamBody, &forwarded); err != nil {
+				t.Fatalf("unmarshal upstream body %s: %v", upstreamBody, err)
+			}
+			if forwarded.Thinking != nil || forwarded.Model != "claude-3-5-sonnet-20241022" {
+				t.Errorf("forwarded model = %s, thinking = %+v, want the base model without thinking", forwarded.Model, forwarded.Thinking)
+			}
+		})
+	}
+}
+
+func TestClaudeHelperRequestMarshalOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		setting string
+		want    string
+		notWant string
+	}{
+		{"html escaping disabled", `{"request_marshal_options":{"disable_html_escape":true}}`, `"keep <tag> & more"`, `\u003c`},
+		{"default escaping", ``, `"keep \u003ctag\u003e \u0026 more"`, `<tag>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch, _ := setupClaudeRelayTest(t)
+			var upstreamBody []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				upstreamBody, _ = io.ReadAll(r.Body)
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.Write([]byte(claudeTestStream))
+			}))
+			defer server.Close()
+			baseURL := server.URL
+			ch.BaseURL = &baseURL
+			if tt.setting != "" {
+				setting := tt.setting
+				ch.Setting = &setting
+			}
+
+			body := `{"model":"claude-sonnet-4-20250514","max_tokens":64,"messages":[{"role":"user","content":[{"type":"text","text":"keep <tag> & more"}]}]}`
+			c, _ := newClaudeRelayTestContext(t, ch, body, nil)
+			if apiErr := ClaudeHelper(c); apiErr != nil {
+				t.Fatalf("ClaudeHelper: %v", apiErr)
+			}
+			if !strings.Contains(string(upstreamBody), tt.want) || strings.Contains(string(upstreamBody), tt.notWant) {
+				t.Errorf("upstream body = %s, want %s without %s", upstreamBody, tt.want, tt.notWant)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package relay
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/middleware"
+	"one-api/model"
+	"one-api/service"
+	"one-api/setting/model_setting"
+	"one-api/setting/ratio_setting"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+const claudeTestStream = `event: message_start
+data: {"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-20250514","content":[],"usage":{"input_tokens":10,"output_tokens":1}}}
+
+event: content_block_start
+data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"hi"}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":0}
+
+event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":5}}
+
+event: message_stop
+data: {"type":"message_stop"}
+
+`
+
+// setupClaudeRelayTest 准备内存数据库、测试用户与令牌，以及返回固定流式响应的模拟 Anthropic 渠道
+func setupClaudeRelayTest(t *testing.T) (*model.Channel, *int32) {
+	t.Helper()
+	// 数据库文件放在测试临时目录，测试结束后自动删除，不会在源码目录留下文件
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	if err := db.AutoMigrate(&model.User{}, &model.Token{}, &model.Log{}, &model.Channel{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	originalDB, originalLogDB := model.DB, model.LOG_DB
+	model.DB, model.LOG_DB = db, db
+	t.Cleanup(func() { model.DB, model.LOG_DB = originalDB, originalLogDB })
+	common.RedisEnabled = false
+	constant.StreamingTimeout = 60
+	ratio_setting.InitRatioSettings()
+	service.InitTokenEncoders()
+	service.InitHttpClient()
+
+	db.Create(&model.User{Id: 1, Username: "test", Quota: 10000000, Status: common.UserStatusEnabled, Group: "default"})
+	db.Create(&model.Token{Id: 1, UserId: 1, Key: "test-token", Name: "test", Status: common.TokenStatusEnabled, UnlimitedQuota: true})
+
+	calls := new(int32)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(claudeTestStream))
+	}))
+	t.Cleanup(server.Close)
+	baseURL := server.URL
+	ch := &model.Channel{Id: 1, Type: constant.ChannelTypeAnthropic, Key: "sk-test", BaseURL: &baseURL, Name: "test"}
+	db.Create(ch)
+	return ch, calls
+}
+
+// newClaudeRelayTestContext 构造经过鉴权与渠道分发中间件后的 Claude 请求上下文
+func newClaudeRelayTestContext(t *testing.T, ch *model.Channel, body string, header map[string]string) (*gin.Context, *httptest.ResponseRecorder) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	for key, value := range header {
+		c.Request.Header.Set(key, value)
+	}
+	common.SetContextKey(c, constant.ContextKeyUserId, 1)
+	common.SetContextKey(c, constant.ContextKeyTokenId, 1)
+	common.SetContextKey(c, constant.ContextKeyTokenKey, "test-token")
+	common.SetContextKey(c, constant.ContextKeyTokenUnlimited, true)
+	common.SetContextKey(c, constant.ContextKeyUsingGroup, "default")
+	common.SetContextKey(c, constant.ContextKeyUserGroup, "default")
+	common.SetContextKey(c, constant.ContextKeyRequestStartTime, time.Now())
+	if apiErr := middleware.SetupContextForSelectedChannel(c, ch, "claude-sonnet-4-20250514"); apiErr != nil {
+		t.Fatalf("SetupContextForSelectedChannel: %v", apiErr)
+	}
+	return c, recorder
+}
+
+func TestClaudeIdempotencyKeyChargesOnce(t *testing.T) {
+	ch, calls := setupClaudeRelayTest(t)
+	claudeSettings := model_setting.GetClaudeSettings()
+	claudeSettings.IdempotencyEnabled = true
+	defer func() { claudeSettings.IdempotencyEnabled = false }()
+
+	body := `{"model":"claude-sonnet-4-20250514","max_tokens":1024,"messages":[{"role":"user","content":"hello"}]}`
+	// 幂等记录在进程内保留，每次运行使用新的键
+	header := map[string]string{"Idempotency-Key": fmt.Sprintf("charge-once-%d", time.Now().UnixNano())}
+	var responses []string
+	for i := 0; i < 2; i++ {
+		c, recorder := newClaudeRelayTestContext(t, ch, body, header)
+		if apiErr := ClaudeHelper(c); apiErr != nil {
+			t.Fatalf("request %d: %v", i+1, apiErr)
+		}
+		responses = append(responses, recorder.Body.String())
+	}
+	if got := atomic.LoadInt32(calls); got != 1 {
+		t.Errorf("upstream called %d times, want 1", got)
+	}
+	if responses[0] != responses[1] {
+		t.Errorf("replayed response differs:\n%s\n%s", responses[0], responses[1])
+	}
+	var consumeLogs int64
+	model.LOG_DB.Model(&model.Log{}).Where("type = ?", model.LogTypeConsume).Count(&consumeLogs)
+	if consumeLogs != 1 {
+		t.Errorf("consume logs = %d, want 1", consumeLogs)
+	}
+	var user model.User
+	model.DB.First(&user, 1)
+	if user.RequestCount != 1 {
+		t.Errorf("user request count = %d, want 1", user.RequestCount)
+	}
+
+	c, _ := newClaudeRelayTestContext(t, ch, strings.Replace(body, "hello", "bye", 1), header)
+	apiErr := ClaudeHelper(c)
+	if apiErr == nil || apiErr.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("reusing the key with a different body: got %v, want 422", apiErr)
+	}
+	if got := atomic.LoadInt32(calls); got != 1 {
+		t.Errorf("upstream called %d times after mismatched reuse, want 1", got)
+	}
+}
@@ -0,0 +1,123 @@
+package relay
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/model"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// histogramSamples 返回指定模型与渠道的直方图样本数与总和
+func histogramSamples(t *testing.T, name string, model string, channel string) (uint64, float64) {
+	t.Helper()
+	families, err := common.MetricsGatherer().Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != name || family.GetType() != dto.MetricType_HISTOGRAM {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["model"] == model && labels["channel"] == channel {
+				return metric.GetHistogram().GetSampleCount(), metric.GetHistogram().GetSampleSum()
+			}
+		}
+	}
+	return 0, 0
+}
+
+func TestClaudeHelperObservesRelayHistograms(t *testing.T) {
+	ch, _ := setupClaudeRelayTest(t)
+	originalEnabled := common.MetricsEnabled
+	common.MetricsEnabled = true
+	defer func() { common.MetricsEnabled = originalEnabled }()
+
+	const model = "claude-sonnet-4-20250514"
+	names := []string{"new_api_relay_prompt_tokens", "new_api_relay_completion_tokens", "new_api_relay_upstream_latency_seconds"}
+	before := map[string]uint64{}
+	for _, name := range names {
+		before[name], _ = histogramSamples(t, name, model, "1")
+	}
+	_, promptSumBefore := histogramSamples(t, "new_api_relay_prompt_tokens", model, "1")
+	_, completionSumBefore := histogramSamples(t, "new_api_relay_completion_tokens", model, "1")
+
+	body := `{"model":"claude-sonnet-4-20250514","max_tokens":1024,"stream":true,"messages":[{"role":"user","content":"hello"}]}`
+	c, _ := newClaudeRelayTestContext(t, ch, body, nil)
+	if apiErr := ClaudeHelper(c); apiErr != nil {
+		t.Fatalf("ClaudeHelper: %v", apiErr)
+	}
+
+	for _, name := range names {
+		if count, _ := histogramSamples(t, name, model, "1"); count != before[name]+1 {
+			t.Errorf("%s samples = %d, want %d", name, count, before[name]+1)
+		}
+	}
+	// 上游返回 input_tokens 10、output_tokens 5
+	if _, sum := histogramSamples(t, "new_api_relay_prompt_tokens", model, "1"); sum-promptSumBefore != 10 {
+		t.Errorf("prompt tokens observed = %v, want 10", sum-promptSumBefore)
+	}
+	if _, sum := histogramSamples(t, "new_api_relay_completion_tokens", model, "1"); sum-completionSumBefore != 5 {
+		t.Errorf("completion tokens observed = %v, want 5", sum-completionSumBefore)
+	}
+
+	// /metrics 接口输出相同的样本
+	recorder := httptest.NewRecorder()
+	promhttp.HandlerFor(common.MetricsGatherer(), promhttp.HandlerOpts{}).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, name := range names {
+		if !strings.Contains(recorder.Body.String(), name+`_count{channel="1",model="`+model+`"}`) {
+			t.Errorf("scrape output is missing %s", name)
+		}
+	}
+}
+
+func TestClaudeHelperRecordsPromptTokenEstimateDelta(t *testing.T) {
+	ch, _ := setupClaudeRelayTest(t)
+	originalEnabled := common.MetricsEnabled
+	common.MetricsEnabled = true
+	defer func() { common.MetricsEnabled = originalEnabled }()
+	logs := &lockedBuffer{}
+	originalWriter := gin.DefaultWriter
+	gin.DefaultWriter = logs
+	defer func() { gin.DefaultWriter = originalWriter }()
+
+	const modelName = "claude-sonnet-4-20250514"
+	countBefore, sumBefore := histogramSamples(t, "new_api_relay_prompt_token_estimate_ratio", modelName, "")
+	// 预估值远大于上游返回的 input_tokens 10
+	body := `{"model":"claude-sonnet-4-20250514","max_tokens":1024,"stream":true,"messages":[{"role":"user","content":"` + strings.Repeat("hello world ", 50) + `"}]}`
+	c, _ := newClaudeRelayTestContext(t, ch, body, nil)
+	if apiErr := ClaudeHelper(c); apiErr != nil {
+		t.Fatalf("ClaudeHelper: %v", apiErr)
+	}
+
+	var log model.Log
+	if err := model.LOG_DB.Where("type = ?", model.LogTypeConsume).First(&log).Error; err != nil {
+		t.Fatalf("consume log: %v", err)
+	}
+	other, _ := common.StrToMap(log.Other)
+	estimated, _ := other["prompt_tokens_estimated"].(float64)
+	delta, ok := other["prompt_tokens_delta"].(float64)
+	if !ok || estimated <= 10 || delta != 10-estimated {
+		t.Fatalf("consume log other = %s, want prompt_tokens_delta = 10 - prompt_tokens_estimated", log.Other)
+	}
+	wantLog := fmt.Sprintf("Estimated:%d | Actual:10 | Delta:%d", int(estimated), int(delta))
+	if !strings.Contains(logs.String(), "[CLAUDE] Prompt token estimate") || !strings.Contains(logs.String(), wantLog) {
+		t.Errorf("log is missing %q", wantLog)
+	}
+	count, sum := histogramSamples(t, "new_api_relay_prompt_token_estimate_ratio", modelName, "")
+	if count != countBefore+1 || math.Abs(sum-sumBefore-10/estimated) > 1e-9 {
+		t.Errorf("estimate ratio samples = %d (sum %v), want one more sample of %v", count, sum-sumBefore, 10/estimated)
+	}
+}
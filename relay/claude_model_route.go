@@ -0,0 +1,70 @@
+package relay
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/relay/helper"
+	"one-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// applyClaudeModelRoute 按渠道路由规则根据请求特征选择上游模型，计费也按选中的模型
+// 只有请求的模型配置了路由规则时才生效，直接请求具体模型即可绕过路由
+// 选中的模型同样经过渠道模型映射与令牌模型限制
+func applyClaudeModelRoute(c *gin.Context, info *relaycommon.RelayInfo, textRequest *dto.ClaudeRequest, promptTokens int) *types.NewAPIError {
+	rules := info.ChannelSetting.ModelRoutes[info.OriginModelName]
+	if len(rules) == 0 {
+		return nil
+	}
+	hasImages := claudeRequestHasImages(textRequest)
+	thinkingBudget := 0
+	if textRequest.Thinking != nil && textRequest.Thinking.BudgetTokens != nil {
+		thinkingBudget = *textRequest.Thinking.BudgetTokens
+	}
+	for i, rule := range rules {
+		if !rule.Match(promptTokens, hasImages, textRequest.Thinking != nil, thinkingBudget) {
+			continue
+		}
+		requested := info.OriginModelName
+		common.SetContextKey(c, constant.ContextKeyModelRoute, requested)
+		info.OriginModelName = rule.Model
+		info.UpstreamModelName = rule.Model
+		info.IsModelMapped = false
+		if err := helper.ModelMappedHelper(c, info, textRequest); err != nil {
+			return types.NewError(err, types.ErrorCodeChannelModelMappedError)
+		}
+		if err := checkTokenModelAllowed(c, info); err != nil {
+			common.LogWarn(c, fmt.Sprintf("[CLAUDE] Routed model not allowed for token | TokenId:%d | Model:%s | UpstreamModel:%s",
+				info.TokenId, info.OriginModelName, info.UpstreamModelName))
+			return types.NewErrorWithStatusCode(err, types.ErrorCodeModelNotSupported, http.StatusForbidden)
+		}
+		common.LogInfo(c, fmt.Sprintf("[CLAUDE] Model routed | From:%s | To:%s | Upstream:%s | Rule:%d | PromptTokens:%d | HasImages:%v | ThinkingBudget:%d",
+			requested, rule.Model, info.UpstreamModelName, i, promptTokens, hasImages, thinkingBudget))
+		return nil
+	}
+	common.LogInfo(c, fmt.Sprintf("[CLAUDE] No model route matched, using requested model | Model:%s | PromptTokens:%d", info.OriginModelName, promptTokens))
+	return nil
+}
+
+func claudeRequestHasImages(textRequest *dto.ClaudeRequest) bool {
+	for _, message := range textRequest.Messages {
+		if message.IsStringContent() {
+			continue
+		}
+		contents, err := message.ParseContent()
+		if err != nil {
+			continue
+		}
+		for _, content := range contents {
+			if content.Type == "image" {
+				return true
+			}
+		}
+	}
+	return false
+}
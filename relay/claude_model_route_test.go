@@ -0,0 +1,150 @@
+package relay
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"one-api/model"
+	relaycommon "one-api/relay/common"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newClaudeRouteTestContext() *gin.Context {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/v1/messages", nil)
+	return c
+}
+
+func newClaudeRouteTestInfo() *relaycommon.RelayInfo {
+	return &relaycommon.RelayInfo{
+		RelayFormat:       relaycommon.RelayFormatClaude,
+		OriginModelName:   "claude-auto",
+		UpstreamModelName: "claude-auto",
+		ChannelSetting: dto.ChannelSettings{ModelRoutes: map[string][]dto.ModelRouteRule{
+			"claude-auto": {
+				{Model: "claude-opus-4", MinPromptTokens: 10000},
+				{Model: "claude-haiku-3-5"},
+			},
+		}},
+	}
+}
+
+func TestClaudeModelRouteLargePromptRoutesToPremium(t *testing.T) {
+	c := newClaudeRouteTestContext()
+	info := newClaudeRouteTestInfo()
+	request := &dto.ClaudeRequest{Model: "claude-auto"}
+	if err := applyClaudeModelRoute(c, info, request, 20000); err != nil {
+		t.Fatalf("applyClaudeModelRoute: %v", err)
+	}
+	if info.OriginModelName != "claude-opus-4" || request.Model != "claude-opus-4" {
+		t.Errorf("routed to %s / %s, want claude-opus-4", info.OriginModelName, request.Model)
+	}
+
+	info = newClaudeRouteTestInfo()
+	request = &dto.ClaudeRequest{Model: "claude-auto"}
+	if err := applyClaudeModelRoute(c, info, request, 100); err != nil {
+		t.Fatalf("applyClaudeModelRoute: %v", err)
+	}
+	if info.OriginModelName != "claude-haiku-3-5" {
+		t.Errorf("small prompt routed to %s, want claude-haiku-3-5", info.OriginModelName)
+	}
+}
+
+func TestClaudeModelRouteAppliesChannelModelMapping(t *testing.T) {
+	c := newClaudeRouteTestContext()
+	c.Set("model_mapping", `{"claude-opus-4":"claude-opus-4@20250514"}`)
+	info := newClaudeRouteTestInfo()
+	request := &dto.ClaudeRequest{Model: "claude-auto"}
+	if err := applyClaudeModelRoute(c, info, request, 20000); err != nil {
+		t.Fatalf("applyClaudeModelRoute: %v", err)
+	}
+	if info.OriginModelName != "claude-opus-4" {
+		t.Errorf("OriginModelName = %s, want claude-opus-4", info.OriginModelName)
+	}
+	if info.UpstreamModelName != "claude-opus-4@20250514" || request.Model != "claude-opus-4@20250514" {
+		t.Errorf("upstream = %s / %s, want mapped claude-opus-4@20250514", info.UpstreamModelName, request.Model)
+	}
+}
+
+func TestClaudeModelRouteRejectsModelOutsideTokenAllowlist(t *testing.T) {
+	c := newClaudeRouteTestContext()
+	common.SetContextKey(c, constant.ContextKeyTokenModelLimitEnabled, true)
+	common.SetContextKey(c, constant.ContextKeyTokenModelLimit, map[string]bool{"claude-auto": true, "claude-haiku-3-5": true})
+	info := newClaudeRouteTestInfo()
+	if err := applyClaudeModelRoute(c, info, &dto.ClaudeRequest{Model: "claude-auto"}, 20000); err == nil {
+		t.Fatal("expected routing to a model outside the token allowlist to fail")
+	}
+	info = newClaudeRouteTestInfo()
+	if err := applyClaudeModelRoute(c, info, &dto.ClaudeRequest{Model: "claude-auto"}, 100); err != nil {
+		t.Fatalf("allowed route failed: %v", err)
+	}
+}
+
+func TestClaudeHelperRoutesLargePromptToPremiumModel(t *testing.T) {
+	largePrompt := strings.Repeat("Summarize the quarterly revenue report in detail. ", 200)
+	tests := []struct {
+		name       string
+		model      string
+		prompt     string
+		wantModel  string
+		wantRouted bool
+	}{
+		{"large prompt routes to premium", "claude-auto", largePrompt, "claude-sonnet-4-20250514", true},
+		{"small prompt routes to cheap", "claude-auto", "hello", "claude-3-5-haiku-20241022", true},
+		{"explicit model bypasses routing", "claude-3-5-haiku-20241022", largePrompt, "claude-3-5-haiku-20241022", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch, _ := setupClaudeRelayTest(t)
+			var upstreamBody []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				upstreamBody, _ = io.ReadAll(r.Body)
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.Write([]byte(claudeTestStream))
+			}))
+			defer server.Close()
+			baseURL := server.URL
+			ch.BaseURL = &baseURL
+			setting := `{"model_routes":{"claude-auto":[{"model":"claude-sonnet-4-20250514","min_prompt_tokens":1000},{"model":"claude-3-5-haiku-20241022"}]}}`
+			ch.Setting = &setting
+
+			body := fmt.Sprintf(`{"model":%q,"max_tokens":64,"messages":[{"role":"user","content":%q}]}`, tt.model, tt.prompt)
+			c, _ := newClaudeRelayTestContext(t, ch, body, nil)
+			common.SetContextKey(c, constant.ContextKeyOriginalModel, tt.model)
+			if apiErr := ClaudeHelper(c); apiErr != nil {
+				t.Fatalf("ClaudeHelper: %v", apiErr)
+			}
+			var forwarded dto.ClaudeRequest
+			if err := common.Unmarshal(upstreamBody, &forwarded); err != nil {
+				t.Fatalf("unmarshal upstream body %s: %v", upstreamBody, err)
+			}
+			if forwarded.Model != tt.wantModel {
+				t.Errorf("upstream model = %s, want %s", forwarded.Model, tt.wantModel)
+			}
+
+			// 消费日志按路由后的模型计费，并记录路由前请求的模型
+			var log model.Log
+			if err := model.LOG_DB.Where("type = ?", model.LogTypeConsume).First(&log).Error; err != nil {
+				t.Fatalf("consume log: %v", err)
+			}
+			if log.ModelName != tt.wantModel {
+				t.Errorf("log model = %s, want %s", log.ModelName, tt.wantModel)
+			}
+			other, _ := common.StrToMap(log.Other)
+			routedFrom, _ := other["model_routed_from"].(string)
+			if tt.wantRouted && routedFrom != "claude-auto" {
+				t.Errorf("model_routed_from = %q, want claude-auto", routedFrom)
+			} else if !tt.wantRouted && routedFrom != "" {
+				t.Errorf("model_routed_from = %q, want no routing", routedFrom)
+			}
+		})
+	}
+}
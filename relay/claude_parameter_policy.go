@@ -0,0 +1,71 @@
+package relay
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+// applyClaudeParameterPolicy 按渠道参数策略限制或覆盖请求参数
+// 在思考适配之后执行，处理的是最终发送给上游的取值
+func applyClaudeParameterPolicy(c *gin.Context, info *relaycommon.RelayInfo, textRequest *dto.ClaudeRequest) {
+	policy := info.ChannelSetting.ParameterPolicy
+	if policy == nil {
+		return
+	}
+	if rule := policy.MaxTokens; rule != nil {
+		maxTokens := uint(max(rule.Apply(float64(textRequest.MaxTokens)), 1))
+		if maxTokens != textRequest.MaxTokens {
+			logParameterOverride(c, "max_tokens", textRequest.MaxTokens, maxTokens)
+			textRequest.MaxTokens = maxTokens
+		}
+	}
+
+	// 开启思考时上游要求 temperature 为 1 且不支持调整 top_p，采样参数规则不生效
+	if textRequest.Thinking == nil {
+		if rule := policy.Temperature; rule != nil && (textRequest.Temperature != nil || rule.Force != nil) {
+			from := "unset"
+			temperature := 0.0
+			if textRequest.Temperature != nil {
+				from = fmt.Sprintf("%v", *textRequest.Temperature)
+				temperature = *textRequest.Temperature
+			}
+			if temperature = rule.Apply(temperature); textRequest.Temperature == nil || temperature != *textRequest.Temperature {
+				logParameterOverride(c, "temperature", from, temperature)
+				textRequest.Temperature = common.GetPointer(temperature)
+			}
+		}
+		if rule := policy.TopP; rule != nil && (textRequest.TopP != 0 || rule.Force != nil) {
+			if topP := rule.Apply(textRequest.TopP); topP != textRequest.TopP {
+				logParameterOverride(c, "top_p", textRequest.TopP, topP)
+				textRequest.TopP = topP
+			}
+		}
+	}
+
+	if textRequest.Thinking == nil || textRequest.Thinking.BudgetTokens == nil {
+		return
+	}
+	budget := *textRequest.Thinking.BudgetTokens
+	if rule := policy.ThinkingBudgetTokens; rule != nil {
+		budget = int(rule.Apply(float64(budget)))
+	}
+	// 思考预算必须小于 max_tokens，且不低于最小预算，无法满足时关闭思考
+	budget = min(budget, int(textRequest.MaxTokens)-1)
+	if budget < claudeMinThinkingBudget {
+		logParameterOverride(c, "thinking", "enabled", "disabled")
+		textRequest.Thinking = nil
+		return
+	}
+	if budget != *textRequest.Thinking.BudgetTokens {
+		logParameterOverride(c, "thinking_budget_tokens", *textRequest.Thinking.BudgetTokens, budget)
+		textRequest.Thinking.BudgetTokens = common.GetPointer(budget)
+	}
+}
+
+func logParameterOverride(c *gin.Context, name string, from any, to any) {
+	common.LogInfo(c, fmt.Sprintf("[CLAUDE] Parameter overridden by channel policy | Param:%s | From:%v | To:%v", name, from, to))
+}
@@ -0,0 +1,105 @@
+package relay
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestApplyClaudeParameterPolicy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	policy := &dto.ParameterPolicy{
+		Temperature:          &dto.ParameterRule{Max: common.GetPointer(0.7)},
+		TopP:                 &dto.ParameterRule{Force: common.GetPointer(0.9)},
+		MaxTokens:            &dto.ParameterRule{Max: common.GetPointer(4096.0)},
+		ThinkingBudgetTokens: &dto.ParameterRule{Max: common.GetPointer(8000.0)},
+	}
+	tests := []struct {
+		name            string
+		request         dto.ClaudeRequest
+		wantMaxTokens   uint
+		wantTemperature *float64
+		wantTopP        float64
+		wantBudget      int // 为 0 表示不开启思考
+	}{
+		{
+			"clamps temperature and caps max_tokens",
+			dto.ClaudeRequest{MaxTokens: 32000, Temperature: common.GetPointer(1.2)},
+			4096, common.GetPointer(0.7), 0.9, 0,
+		},
+		{
+			"values within the policy are kept",
+			dto.ClaudeRequest{MaxTokens: 1024, Temperature: common.GetPointer(0.3)},
+			1024, common.GetPointer(0.3), 0.9, 0,
+		},
+		{
+			"unset temperature stays unset",
+			dto.ClaudeRequest{MaxTokens: 1024},
+			1024, nil, 0.9, 0,
+		},
+		{
+			"thinking budget kept below the capped max_tokens",
+			dto.ClaudeRequest{MaxTokens: 32000, Thinking: &dto.Thinking{Type: "enabled", BudgetTokens: common.GetPointer(16000)}},
+			4096, nil, 0, 4095,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+			info := &relaycommon.RelayInfo{ChannelSetting: dto.ChannelSettings{ParameterPolicy: policy}}
+			request := tt.request
+			applyClaudeParameterPolicy(c, info, &request)
+			if request.MaxTokens != tt.wantMaxTokens {
+				t.Errorf("max_tokens = %d, want %d", request.MaxTokens, tt.wantMaxTokens)
+			}
+			if (request.Temperature == nil) != (tt.wantTemperature == nil) ||
+				(request.Temperature != nil && *request.Temperature != *tt.wantTemperature) {
+				t.Errorf("temperature = %v, want %v", request.Temperature, tt.wantTemperature)
+			}
+			if request.TopP != tt.wantTopP {
+				t.Errorf("top_p = %v, want %v", request.TopP, tt.wantTopP)
+			}
+			budget := 0
+			if request.Thinking != nil && request.Thinking.BudgetTokens != nil {
+				budget = *request.Thinking.BudgetTokens
+			}
+			if budget != tt.wantBudget {
+				t.Errorf("thinking budget = %d, want %d", budget, tt.wantBudget)
+			}
+		})
+	}
+}
+
+func TestClaudeHelperAppliesChannelParameterPolicy(t *testing.T) {
+	ch, _ := setupClaudeRelayTest(t)
+	var upstreamBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(claudeTestStream))
+	}))
+	defer server.Close()
+	ch.BaseURL = &server.URL
+	setting := `{"parameter_policy":{"temperature":{"max":0.5},"max_tokens":{"max":2048}}}`
+	ch.Setting = &setting
+
+	body := `{"model":"claude-sonnet-4-20250514","max_tokens":32000,"temperature":1,"stream":true,"messages":[{"role":"user","content":"hello"}]}`
+	c, _ := newClaudeRelayTestContext(t, ch, body, nil)
+	if apiErr := ClaudeHelper(c); apiErr != nil {
+		t.Fatalf("ClaudeHelper: %v", apiErr)
+	}
+	var forwarded dto.ClaudeRequest
+	if err := common.Unmarshal(upstreamBody, &forwarded); err != nil {
+		t.Fatalf("unmarshal upstream body %s: %v", upstreamBody, err)
+	}
+	if forwarded.Temperature == nil || *forwarded.Temperature != 0.5 || forwarded.MaxTokens != 2048 {
+		t.Errorf("upstream temperature = %v, max_tokens = %d, want 0.5 and 2048", forwarded.Temperature, forwarded.MaxTokens)
+	}
+}
@@ -0,0 +1,100 @@
+package relay
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/middleware"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// lockedBuffer 并发安全的日志缓冲，异步记录的日志也可能写入
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestClaudeRequestIdAppearsInEveryLogLine(t *testing.T) {
+	ch, _ := setupClaudeRelayTest(t)
+	logs := &lockedBuffer{}
+	originalWriter, originalErrorWriter := gin.DefaultWriter, gin.DefaultErrorWriter
+	gin.DefaultWriter, gin.DefaultErrorWriter = logs, logs
+	defer func() { gin.DefaultWriter, gin.DefaultErrorWriter = originalWriter, originalErrorWriter }()
+
+	body := `{"model":"claude-sonnet-4-20250514","max_tokens":1024,"messages":[{"role":"user","content":"hello"}]}`
+	c, recorder := newClaudeRelayTestContext(t, ch, body, nil)
+	middleware.RequestId()(c)
+	requestId := c.GetString(common.RequestIdKey)
+	if requestId == "" {
+		t.Fatal("request id was not generated")
+	}
+	if apiErr := ClaudeHelper(c); apiErr != nil {
+		t.Fatalf("ClaudeHelper: %v", apiErr)
+	}
+	if got := recorder.Header().Get(middleware.RequestIdHeader); got != requestId {
+		t.Errorf("response %s = %q, want %q", middleware.RequestIdHeader, got, requestId)
+	}
+
+	var claudeLines []string
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if strings.Contains(line, "[CLAUDE]") {
+			claudeLines = append(claudeLines, line)
+		}
+	}
+	for _, marker := range []string{"[CLAUDE] Request started", "[CLAUDE] Calling upstream API", "[CLAUDE] Request completed"} {
+		found := false
+		for _, line := range claudeLines {
+			if strings.Contains(line, marker) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("no %q log line", marker)
+		}
+	}
+	for _, line := range claudeLines {
+		if !strings.Contains(line, "| "+requestId+" |") {
+			t.Errorf("log line is missing request id %s: %s", requestId, line)
+		}
+	}
+}
+
+func TestClaudeUpstreamErrorCarriesRequestId(t *testing.T) {
+	ch, _ := setupClaudeRelayTest(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"type":"error","error":{"type":"invalid_request_error","message":"bad request"}}`))
+	}))
+	defer server.Close()
+	ch.BaseURL = &server.URL
+
+	body := `{"model":"claude-sonnet-4-20250514","max_tokens":1024,"messages":[{"role":"user","content":"hello"}]}`
+	c, _ := newClaudeRelayTestContext(t, ch, body, nil)
+	middleware.RequestId()(c)
+	apiErr := ClaudeHelper(c)
+	if apiErr == nil {
+		t.Fatal("expected the upstream error")
+	}
+	if want := c.GetString(common.RequestIdKey); apiErr.RequestId != want {
+		t.Errorf("error request id = %q, want %q", apiErr.RequestId, want)
+	}
+}
@@ -0,0 +1,50 @@
+package relay
+
+import (
+	"fmt"
+	"one-api/common"
+	relaycommon "one-api/relay/common"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClaudeRequestTransformer 在 ConvertClaudeRequest 之后、序列化之前修改发往上游的请求
+// request 为适配器转换后的请求对象，类型随渠道不同，返回值作为下一个转换器的输入
+type ClaudeRequestTransformer func(c *gin.Context, info *relaycommon.RelayInfo, request any) (any, error)
+
+var (
+	claudeRequestTransformers     = map[string]ClaudeRequestTransformer{}
+	claudeRequestTransformersLock sync.RWMutex
+)
+
+// RegisterClaudeRequestTransformer 注册请求体转换器，渠道通过 request_transformers 按名称启用，同名时覆盖
+func RegisterClaudeRequestTransformer(name string, transformer ClaudeRequestTransformer) {
+	claudeRequestTransformersLock.Lock()
+	defer claudeRequestTransformersLock.Unlock()
+	claudeRequestTransformers[name] = transformer
+}
+
+func getClaudeRequestTransformer(name string) (ClaudeRequestTransformer, bool) {
+	claudeRequestTransformersLock.RLock()
+	defer claudeRequestTransformersLock.RUnlock()
+	transformer, ok := claudeRequestTransformers[name]
+	return transformer, ok
+}
+
+// applyClaudeRequestTransformers 按渠道配置的顺序依次执行转换器，渠道配置了未注册的转换器时返回错误
+func applyClaudeRequestTransformers(c *gin.Context, info *relaycommon.RelayInfo, request any) (any, error) {
+	for _, name := range info.ChannelSetting.RequestTransformers {
+		transformer, ok := getClaudeRequestTransformer(name)
+		if !ok {
+			return nil, fmt.Errorf("request transformer %q is not registered", name)
+		}
+		transformed, err := transformer(c, info, request)
+		if err != nil {
+			return nil, fmt.Errorf("request transformer %q failed: %w", name, err)
+		}
+		request = transformed
+		common.LogInfo(c, fmt.Sprintf("[CLAUDE] Request transformer applied | Name:%s", name))
+	}
+	return request, nil
+}
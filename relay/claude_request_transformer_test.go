@@ -0,0 +1,79 @@
+package relay
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/types"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestClaudeHelperAppliesChannelRequestTransformers(t *testing.T) {
+	appendStop := func(stop string) ClaudeRequestTransformer {
+		return func(c *gin.Context, info *relaycommon.RelayInfo, request any) (any, error) {
+			claudeRequest := request.(*dto.ClaudeRequest)
+			claudeRequest.StopSequences = append(claudeRequest.StopSequences, stop)
+			return claudeRequest, nil
+		}
+	}
+	RegisterClaudeRequestTransformer("test-inject-metadata", func(c *gin.Context, info *relaycommon.RelayInfo, request any) (any, error) {
+		claudeRequest := request.(*dto.ClaudeRequest)
+		claudeRequest.Metadata = &dto.ClaudeMetadata{UserId: "injected-user"}
+		return claudeRequest, nil
+	})
+	RegisterClaudeRequestTransformer("test-stop-a", appendStop("a"))
+	RegisterClaudeRequestTransformer("test-stop-b", appendStop("b"))
+
+	ch, _ := setupClaudeRelayTest(t)
+	var upstreamBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(claudeTestStream))
+	}))
+	defer server.Close()
+	baseURL := server.URL
+	ch.BaseURL = &baseURL
+	// 只启用渠道配置的转换器，并按配置顺序执行
+	setting := `{"request_transformers":["test-inject-metadata","test-stop-b","test-stop-a"]}`
+	ch.Setting = &setting
+
+	body := `{"model":"claude-sonnet-4-20250514","max_tokens":64,"messages":[{"role":"user","content":"hello"}]}`
+	c, _ := newClaudeRelayTestContext(t, ch, body, nil)
+	if apiErr := ClaudeHelper(c); apiErr != nil {
+		t.Fatalf("ClaudeHelper: %v", apiErr)
+	}
+	var forwarded dto.ClaudeRequest
+	if err := common.Unmarshal(upstreamBody, &forwarded); err != nil {
+		t.Fatalf("unmarshal upstream body %s: %v", upstreamBody, err)
+	}
+	if forwarded.Metadata == nil || forwarded.Metadata.UserId != "injected-user" {
+		t.Errorf("metadata = %+v, want the injected user id", forwarded.Metadata)
+	}
+	if want := []string{"b", "a"}; !reflect.DeepEqual(forwarded.StopSequences, want) {
+		t.Errorf("stop_sequences = %v, want %v", forwarded.StopSequences, want)
+	}
+}
+
+func TestClaudeHelperRejectsUnregisteredRequestTransformer(t *testing.T) {
+	ch, calls := setupClaudeRelayTest(t)
+	setting := `{"request_transformers":["test-not-registered"]}`
+	ch.Setting = &setting
+
+	body := `{"model":"claude-sonnet-4-20250514","max_tokens":64,"messages":[{"role":"user","content":"hello"}]}`
+	c, _ := newClaudeRelayTestContext(t, ch, body, nil)
+	apiErr := ClaudeHelper(c)
+	if apiErr == nil || apiErr.GetErrorCode() != types.ErrorCodeConvertRequestFailed {
+		t.Fatalf("ClaudeHelper error = %v, want convert_request_failed", apiErr)
+	}
+	if got := atomic.LoadInt32(calls); got != 0 {
+		t.Errorf("upstream calls = %d, want 0", got)
+	}
+}
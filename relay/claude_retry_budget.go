@@ -0,0 +1,80 @@
+package relay
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/setting/model_setting"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	claudeRetryKindOverloaded     = "overloaded_retry"
+	claudeRetryKindRegionFailover = "region_failover"
+	claudeRetryKindFallback       = "fallback_model"
+)
+
+// claudeRetryBudget 单个请求内各类重试共享的预算，限制总重试次数和因重试增加的耗时
+// 增加的耗时从首次上游失败开始计算，包括失败请求本身和退避等待的时间
+type claudeRetryBudget struct {
+	maxAttempts  int
+	maxDelay     time.Duration
+	attempts     map[string]int
+	firstFailure time.Time
+	exhausted    bool
+}
+
+func newClaudeRetryBudget() *claudeRetryBudget {
+	claudeSettings := model_setting.GetClaudeSettings()
+	return &claudeRetryBudget{
+		maxAttempts: claudeSettings.RetryBudgetAttempts,
+		maxDelay:    time.Duration(claudeSettings.RetryBudgetDelayMs) * time.Millisecond,
+		attempts:    map[string]int{},
+	}
+}
+
+func (b *claudeRetryBudget) totalAttempts() int {
+	total := 0
+	for _, n := range b.attempts {
+		total += n
+	}
+	return total
+}
+
+func (b *claudeRetryBudget) addedLatency() time.Duration {
+	if b.firstFailure.IsZero() {
+		return 0
+	}
+	return time.Since(b.firstFailure)
+}
+
+// allow 在重试前调用，delay 为重试前需要等待的时间，预算足够时记录本次重试并返回 true
+func (b *claudeRetryBudget) allow(c *gin.Context, kind string, delay time.Duration) bool {
+	if b.firstFailure.IsZero() {
+		b.firstFailure = time.Now()
+	}
+	if b.exhausted {
+		return false
+	}
+	total := b.totalAttempts()
+	added := b.addedLatency() + delay
+	if (b.maxAttempts > 0 && total >= b.maxAttempts) || (b.maxDelay > 0 && added > b.maxDelay) {
+		b.exhausted = true
+		common.LogWarn(c, fmt.Sprintf("[CLAUDE] Retry budget exhausted | Kind:%s | Attempts:%d/%d | AddedLatency:%v/%v",
+			kind, total, b.maxAttempts, added, b.maxDelay))
+		return false
+	}
+	b.attempts[kind]++
+	return true
+}
+
+// logSpent 请求结束时记录预算的使用情况，未发生重试时不记录
+func (b *claudeRetryBudget) logSpent(c *gin.Context) {
+	if b.firstFailure.IsZero() {
+		return
+	}
+	common.LogInfo(c, fmt.Sprintf("[CLAUDE] Retry budget spent | Attempts:%d/%d | OverloadedRetries:%d | RegionFailovers:%d | Fallbacks:%d | AddedLatency:%v/%v | Exhausted:%v",
+		b.totalAttempts(), b.maxAttempts, b.attempts[claudeRetryKindOverloaded], b.attempts[claudeRetryKindRegionFailover], b.attempts[claudeRetryKindFallback],
+		b.addedLatency(), b.maxDelay, b.exhausted))
+}
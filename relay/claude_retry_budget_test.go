@@ -0,0 +1,147 @@
+package relay
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"one-api/constant"
+	"one-api/model"
+	"one-api/relay/channel/vertex"
+	"one-api/service"
+	"one-api/setting/model_setting"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRetryBudgetCoversRegionFailoverAndOverloadedRetry(t *testing.T) {
+	const (
+		channelId   = 9301
+		clientEmail = "failover@test-project.iam.gserviceaccount.com"
+	)
+	setupClaudeRelayTest(t)
+	var mu sync.Mutex
+	var regions []string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		regions = append(regions, strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/projects/test-project/locations/"), "/")[0])
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(529)
+		w.Write([]byte(`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`))
+	}))
+	defer server.Close()
+	originalTransport := http.DefaultTransport
+	http.DefaultTransport = server.Client().Transport
+	service.InitHttpClient()
+	defer func() {
+		http.DefaultTransport = originalTransport
+		service.InitHttpClient()
+	}()
+	vertex.Cache.SetDefault(fmt.Sprintf("access-token-%d-%s", channelId, clientEmail), "test-token")
+
+	settings := model_setting.GetClaudeSettings()
+	original := *settings
+	defer func() { *settings = original }()
+	settings.OverloadedRetryTimes = 2
+	settings.OverloadedRetryDelayMs = 1
+	settings.OverloadedMaxDelayMs = 1
+
+	target, _ := url.Parse(server.URL)
+	setting := fmt.Sprintf(`{"vertex_api_host":%q}`, target.Host)
+	other := `{"default":["us-east5","europe-west1","asia-southeast1"]}`
+	ch := &model.Channel{
+		Id:      channelId,
+		Type:    constant.ChannelTypeVertexAi,
+		Key:     `{"project_id":"test-project","private_key":"unused","client_email":"` + clientEmail + `"}`,
+		Setting: &setting,
+		Other:   other,
+	}
+	tests := []struct {
+		name        string
+		budget      int
+		wantRegions []string
+	}{
+		{"unlimited budget tries every region", 0, []string{
+			"us-east5", "us-east5", "us-east5",
+			"europe-west1", "europe-west1", "europe-west1",
+			"asia-southeast1", "asia-southeast1", "asia-southeast1",
+		}},
+		// 首个区域的 2 次过载重试与 1 次区域切换用完预算，切换后的区域不再重试
+		{"shared budget caps all attempts", 3, []string{"us-east5", "us-east5", "us-east5", "europe-west1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			regions = nil
+			settings.RetryBudgetAttempts = tt.budget
+			body := `{"model":"claude-sonnet-4-20250514","max_tokens":1024,"messages":[{"role":"user","content":"hello"}]}`
+			c, _ := newClaudeRelayTestContext(t, ch, body, nil)
+			apiErr := ClaudeHelper(c)
+			if apiErr == nil || apiErr.StatusCode != 529 {
+				t.Fatalf("got %v, want the upstream 529", apiErr)
+			}
+			if !reflect.DeepEqual(regions, tt.wantRegions) {
+				t.Errorf("upstream attempts %v, want %v", regions, tt.wantRegions)
+			}
+		})
+	}
+}
+
+func TestClaudeOverloadedRetrySucceedsWithoutDoubleCharge(t *testing.T) {
+	ch, _ := setupClaudeRelayTest(t)
+	settings := model_setting.GetClaudeSettings()
+	original := *settings
+	defer func() { *settings = original }()
+	settings.OverloadedRetryTimes = 2
+	settings.OverloadedRetryDelayMs = 1
+	settings.OverloadedMaxDelayMs = 1
+
+	// 前两次返回 529，第三次正常返回
+	var mu sync.Mutex
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		attempt := len(bodies)
+		mu.Unlock()
+		if attempt <= 2 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(529)
+			w.Write([]byte(`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(claudeTestStream))
+	}))
+	defer server.Close()
+	ch.BaseURL = &server.URL
+
+	body := `{"model":"claude-sonnet-4-20250514","max_tokens":1024,"stream":true,"messages":[{"role":"user","content":"hello"}]}`
+	c, recorder := newClaudeRelayTestContext(t, ch, body, nil)
+	if apiErr := ClaudeHelper(c); apiErr != nil {
+		t.Fatalf("ClaudeHelper: %v", apiErr)
+	}
+	if len(bodies) != 3 {
+		t.Fatalf("upstream called %d times, want 3", len(bodies))
+	}
+	if bodies[0] != bodies[1] || bodies[1] != bodies[2] {
+		t.Errorf("retries must reuse the converted body:\n%s\n%s\n%s", bodies[0], bodies[1], bodies[2])
+	}
+	if !strings.Contains(recorder.Body.String(), `"text":"hi"`) {
+		t.Errorf("client did not receive the successful response:\n%s", recorder.Body.String())
+	}
+	var consumeLogs int64
+	model.LOG_DB.Model(&model.Log{}).Where("type = ?", model.LogTypeConsume).Count(&consumeLogs)
+	if consumeLogs != 1 {
+		t.Errorf("consume logs = %d, want 1", consumeLogs)
+	}
+	var user model.User
+	model.DB.First(&user, 1)
+	if user.RequestCount != 1 {
+		t.Errorf("user request count = %d, want 1", user.RequestCount)
+	}
+}
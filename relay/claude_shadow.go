@@ -0,0 +1,157 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/middleware"
+	"one-api/model"
+	relaycommon "one-api/relay/common"
+	"one-api/relay/helper"
+	"one-api/setting/model_setting"
+	"time"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+// 影子请求日志中每侧输出的最大长度
+const claudeShadowLogMaxLength = 4000
+
+// 影子请求的最长执行时间
+const claudeShadowTimeout = 5 * time.Minute
+
+// shouldSampleClaudeShadow 按配置的比例决定本次请求是否发送影子请求
+func shouldSampleClaudeShadow() bool {
+	claudeSettings := model_setting.GetClaudeSettings()
+	if !claudeSettings.ShadowEnabled || claudeSettings.ShadowChannelId <= 0 || claudeSettings.ShadowPercentage <= 0 {
+		return false
+	}
+	return rand.Float64()*100 < claudeSettings.ShadowPercentage
+}
+
+// startClaudeShadow 在主请求完成后异步将原始请求发送到影子渠道，并记录两侧输出用于对比
+// 影子请求不计费、不返回给客户端，失败也不影响主请求
+func startClaudeShadow(c *gin.Context, info *relaycommon.RelayInfo, primaryOutput string) {
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		common.LogError(c, fmt.Sprintf("[CLAUDE] Shadow request skipped | Error:%s", err.Error()))
+		return
+	}
+	claudeSettings := model_setting.GetClaudeSettings()
+	shadowModel := claudeSettings.ShadowModel
+	if shadowModel == "" {
+		shadowModel = info.OriginModelName
+	}
+
+	// gin.Context 在请求结束后会被复用，需要在返回前复制请求和上下文键值
+	keys := c.Copy().Keys
+	request := c.Request.Clone(context.Background())
+	shadowWriter := httptest.NewRecorder()
+	shadowCtx, _ := gin.CreateTestContext(shadowWriter)
+	shadowCtx.Request = request
+	for key, value := range keys {
+		shadowCtx.Set(key, value)
+	}
+	shadowCtx.Set(common.KeyRequestBody, requestBody)
+
+	primaryChannelId := info.ChannelId
+	primaryModel := info.UpstreamModelName
+	gopool.Go(func() {
+		defer func() {
+			if r := recover(); r != nil {
+				common.SysError(fmt.Sprintf("[CLAUDE] Shadow request panic | Error:%v", r))
+			}
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), claudeShadowTimeout)
+		defer cancel()
+		shadowCtx.Request = shadowCtx.Request.WithContext(ctx)
+
+		startTime := time.Now()
+		err := doClaudeShadowRequest(shadowCtx, claudeSettings.ShadowChannelId, shadowModel)
+		if err != nil {
+			common.LogWarn(shadowCtx, fmt.Sprintf("[CLAUDE] Shadow request failed | ShadowChannel:%d | ShadowModel:%s | Time:%v | Error:%s",
+				claudeSettings.ShadowChannelId, shadowModel, time.Since(startTime), err.Error()))
+			return
+		}
+		common.LogInfo(shadowCtx, fmt.Sprintf("[CLAUDE] Shadow comparison | Channel:%d | Model:%s | ShadowChannel:%d | ShadowModel:%s | Time:%v | PrimaryOutput:%s | ShadowOutput:%s",
+			primaryChannelId, primaryModel, claudeSettings.ShadowChannelId, shadowModel, time.Since(startTime),
+			truncateShadowOutput(primaryOutput), truncateShadowOutput(shadowWriter.Body.String())))
+	})
+}
+
+// doClaudeShadowRequest 使用影子渠道重新发送原始请求，输出写入影子上下文，不预扣和结算额度
+func doClaudeShadowRequest(c *gin.Context, channelId int, shadowModel string) error {
+	channel, err := model.GetChannelById(channelId, true)
+	if err != nil {
+		return err
+	}
+	if newAPIError := middleware.SetupContextForSelectedChannel(c, channel, shadowModel); newAPIError != nil {
+		return newAPIError
+	}
+
+	textRequest := &dto.ClaudeRequest{}
+	if err = common.UnmarshalBodyReusable(c, textRequest); err != nil {
+		return err
+	}
+	textRequest.Model = shadowModel
+	textRequest.Stream = true
+	if isClaudeThinkingDisabled(c, textRequest) {
+		textRequest.Thinking = nil
+	}
+	if textRequest.MaxTokens == 0 {
+		textRequest.MaxTokens = uint(model_setting.GetClaudeSettings().GetDefaultMaxTokens(textRequest.Model))
+	}
+
+	info := relaycommon.GenRelayInfoClaude(c)
+	info.OriginModelName = shadowModel
+	info.IsStream = true
+	if err = helper.ModelMappedHelper(c, info, textRequest); err != nil {
+		return err
+	}
+
+	adaptor := GetAdaptor(info.ApiType)
+	if adaptor == nil {
+		return fmt.Errorf("invalid api type: %d", info.ApiType)
+	}
+	adaptor.Init(info)
+	convertedRequest, err := adaptor.ConvertClaudeRequest(c, info, textRequest)
+	if err != nil {
+		return err
+	}
+	jsonData, err := common.Marshal(convertedRequest)
+	if err != nil {
+		return err
+	}
+	resp, err := adaptor.DoRequest(c, info, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+	httpResp, ok := resp.(*http.Response)
+	if !ok || httpResp == nil {
+		return fmt.Errorf("unexpected shadow response type %T", resp)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(httpResp.Body)
+		common.CloseResponseBodyGracefully(httpResp)
+		return fmt.Errorf("status code %d: %s", httpResp.StatusCode, truncateShadowOutput(string(body)))
+	}
+	if _, newAPIError := adaptor.DoResponse(c, httpResp, info); newAPIError != nil {
+		return newAPIError
+	}
+	return nil
+}
+
+func truncateShadowOutput(output string) string {
+	runes := []rune(output)
+	if len(runes) <= claudeShadowLogMaxLength {
+		return output
+	}
+	return string(runes[:claudeShadowLogMaxLength]) + "...(truncated)"
+}
@@ -0,0 +1,67 @@
+package relay
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	"one-api/setting/model_setting"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClaudeShadowLeavesPrimaryResponseUnchanged(t *testing.T) {
+	ch, _ := setupClaudeRelayTest(t)
+	shadowBodies := make(chan []byte, 1)
+	shadowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(strings.Replace(claudeTestStream, `"text":"hi"`, `"text":"shadow output"`, 1)))
+		shadowBodies <- body
+	}))
+	defer shadowServer.Close()
+	shadowBaseURL := shadowServer.URL
+	model.DB.Create(&model.Channel{Id: 2, Type: constant.ChannelTypeAnthropic, Key: "sk-shadow", BaseURL: &shadowBaseURL, Name: "shadow", Status: common.ChannelStatusEnabled})
+
+	const body = `{"model":"claude-sonnet-4-20250514","max_tokens":1024,"stream":true,"messages":[{"role":"user","content":"hello"}]}`
+	c, baseline := newClaudeRelayTestContext(t, ch, body, nil)
+	if apiErr := ClaudeHelper(c); apiErr != nil {
+		t.Fatalf("baseline ClaudeHelper: %v", apiErr)
+	}
+
+	settings := model_setting.GetClaudeSettings()
+	originalEnabled, originalPercentage, originalChannel, originalModel := settings.ShadowEnabled, settings.ShadowPercentage, settings.ShadowChannelId, settings.ShadowModel
+	settings.ShadowEnabled, settings.ShadowPercentage, settings.ShadowChannelId, settings.ShadowModel = true, 100, 2, "claude-opus-4-20250514"
+	defer func() {
+		settings.ShadowEnabled, settings.ShadowPercentage, settings.ShadowChannelId, settings.ShadowModel = originalEnabled, originalPercentage, originalChannel, originalModel
+	}()
+	c, recorder := newClaudeRelayTestContext(t, ch, body, nil)
+	if apiErr := ClaudeHelper(c); apiErr != nil {
+		t.Fatalf("ClaudeHelper: %v", apiErr)
+	}
+	if recorder.Body.String() != baseline.Body.String() {
+		t.Errorf("primary response changed with shadow traffic:\n%s\nwant:\n%s", recorder.Body.String(), baseline.Body.String())
+	}
+	if strings.Contains(recorder.Body.String(), "shadow output") {
+		t.Error("the shadow output leaked into the client response")
+	}
+
+	select {
+	case shadowBody := <-shadowBodies:
+		if !strings.Contains(string(shadowBody), `"model":"claude-opus-4-20250514"`) {
+			t.Errorf("shadow request body = %s, want the shadow model", shadowBody)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the shadow channel was not called")
+	}
+	// 影子请求不计费
+	time.Sleep(100 * time.Millisecond)
+	var consumeLogs int64
+	model.LOG_DB.Model(&model.Log{}).Where("type = ?", model.LogTypeConsume).Count(&consumeLogs)
+	if consumeLogs != 2 {
+		t.Errorf("consume logs = %d, want one per primary request", consumeLogs)
+	}
+}
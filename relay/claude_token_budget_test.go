@@ -0,0 +1,65 @@
+package relay
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	"one-api/setting/model_setting"
+	"one-api/types"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClaudeHelperExhaustsDailyTokenBudget(t *testing.T) {
+	ch, calls := setupClaudeRelayTest(t)
+	// 额度计数在进程内共享，每次运行使用新的用户
+	userId := 100000 + int(time.Now().UnixNano()%100000)
+	model.DB.Create(&model.User{Id: userId, Username: fmt.Sprintf("budget-%d", userId), AffCode: fmt.Sprintf("budget-%d", userId), Quota: 10000000, Status: common.UserStatusEnabled, Group: "default"})
+
+	claudeSettings := model_setting.GetClaudeSettings()
+	originalBudget, originalResetHour := claudeSettings.UserDailyTokenBudget, claudeSettings.TokenBudgetResetHour
+	defer func() {
+		claudeSettings.UserDailyTokenBudget, claudeSettings.TokenBudgetResetHour = originalBudget, originalResetHour
+	}()
+	claudeSettings.UserDailyTokenBudget = 200
+	claudeSettings.TokenBudgetResetHour = time.Now().Hour()
+
+	send := func() *types.NewAPIError {
+		body := `{"model":"claude-sonnet-4-20250514","max_tokens":100,"messages":[{"role":"user","content":"hello"}]}`
+		c, _ := newClaudeRelayTestContext(t, ch, body, nil)
+		common.SetContextKey(c, constant.ContextKeyUserId, userId)
+		return ClaudeHelper(c)
+	}
+
+	// 每次预占 prompt + max_tokens，结束后按实际用量（输入 10 + 输出 5）校正，额度逐步耗尽
+	succeeded := 0
+	var apiErr *types.NewAPIError
+	for i := 0; i < 20; i++ {
+		if apiErr = send(); apiErr != nil {
+			break
+		}
+		succeeded++
+	}
+	if succeeded < 2 {
+		t.Fatalf("%d requests succeeded, want the budget to be corrected to actual usage after each request (error: %v)", succeeded, apiErr)
+	}
+	if apiErr == nil || apiErr.GetErrorCode() != types.ErrorCodeTokenBudgetExceeded || apiErr.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("error = %v, want token_budget_exceeded/429", apiErr)
+	}
+	if want := fmt.Sprintf("%d of 200 tokens used", succeeded*15); !strings.Contains(apiErr.Error(), want) {
+		t.Errorf("error %q, want %q", apiErr.Error(), want)
+	}
+	if got := atomic.LoadInt32(calls); got != int32(succeeded) {
+		t.Errorf("upstream calls = %d, want %d, the rejected request must not reach the upstream", got, succeeded)
+	}
+
+	// 到达下一个重置时刻后额度重新计算
+	claudeSettings.TokenBudgetResetHour = (time.Now().Hour() + 1) % 24
+	if apiErr := send(); apiErr != nil {
+		t.Errorf("request in a new budget period: %v", apiErr)
+	}
+}
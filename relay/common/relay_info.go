@@ -5,6 +5,7 @@ import (
 	"one-api/constant"
 	"one-api/dto"
 	relayconstant "one-api/relay/constant"
+	"one-api/setting/model_setting"
 	"strings"
 	"time"
 
@@ -105,6 +106,12 @@ type RelayInfo struct {
 	RelayFormat          string
 	SendResponseCount    int
 	ChannelCreateTime    int64
+	VertexEndpoint       string // 请求头 X-Vertex-Endpoint 指定的端点类型（global/regional），为空时按渠道配置选择
+	VertexRegion         string // 区域故障转移时使用的区域，为空时按渠道配置选择
+	IncludeUpstreamUsage bool   // 请求头 X-Include-Upstream-Usage 为 true 时，OpenAI 格式响应的 usage 中附带上游原始用量
+	RequestModelName     string // 客户端请求的模型名（别名解析前），不随模型路由或降级改变
+	RewriteResponseModel bool   // 为 true 时响应中的 model 字段统一返回 RequestModelName
+	MaxCompletionTokens  int    // 最终发送给上游的 max_tokens（已按渠道策略限制），0 表示未知
 	ThinkingContentInfo
 	*ClaudeConvertInfo
 	*RerankerInfo
@@ -273,6 +280,12 @@ func GenRelayInfo(c *gin.Context) *RelayInfo {
 	}
 	if info.ChannelType == constant.ChannelTypeVertexAi {
 		info.ApiVersion = c.GetString("region")
+		info.VertexEndpoint = c.Request.Header.Get("X-Vertex-Endpoint")
+	}
+	info.IncludeUpstreamUsage = c.Request.Header.Get("X-Include-Upstream-Usage") == "true"
+	info.RequestModelName = common.GetContextKeyString(c, constant.ContextKeyModelAlias)
+	if info.RequestModelName == "" {
+		info.RequestModelName = info.OriginModelName
 	}
 	if streamSupportedChannels[info.ChannelType] {
 		info.SupportStreamOptions = true
@@ -290,6 +303,14 @@ func GenRelayInfo(c *gin.Context) *RelayInfo {
 	return info
 }
 
+// ResponseModel 返回响应中回显的模型名，开启改写时返回客户端请求的模型名
+func (info *RelayInfo) ResponseModel(upstream string) string {
+	if info.RewriteResponseModel && info.RequestModelName != "" {
+		return info.RequestModelName
+	}
+	return upstream
+}
+
 func (info *RelayInfo) SetPromptTokens(promptTokens int) {
 	info.PromptTokens = promptTokens
 }
@@ -315,6 +336,8 @@ type TaskRelayInfo struct {
 	OriginTaskID string
 
 	ConsumeQuota bool
+	// BatchRequestCount 批量任务包含的请求数，提交时按请求数预扣额度
+	BatchRequestCount int
 }
 
 func GenTaskRelayInfo(c *gin.Context) *TaskRelayInfo {
@@ -342,3 +365,11 @@ type TaskInfo struct {
 	Url      string `json:"url,omitempty"`
 	Progress string `json:"progress,omitempty"`
 }
+
+// GetMaxThinkingBudgetTokens 获取思考预算上限，渠道配置优先于全局配置，0 表示不限制
+func (info *RelayInfo) GetMaxThinkingBudgetTokens() int {
+	if info.ChannelSetting.MaxThinkingBudgetTokens > 0 {
+		return info.ChannelSetting.MaxThinkingBudgetTokens
+	}
+	return model_setting.GetGlobalSettings().MaxThinkingBudgetTokens
+}
@@ -53,6 +53,9 @@ const (
 	RelayModeRealtime
 
 	RelayModeGemini
+
+	RelayModeVertexBatchFetchByID
+	RelayModeVertexBatchSubmit
 )
 
 func Path2RelayMode(path string) int {
@@ -165,3 +168,13 @@ func Path2RelayJimeng(method, path string) int {
 	}
 	return relayMode
 }
+
+func Path2RelayVertexBatch(method, path string) int {
+	relayMode := RelayModeUnknown
+	if method == http.MethodPost && strings.HasSuffix(path, "/vertex/batches") {
+		relayMode = RelayModeVertexBatchSubmit
+	} else if method == http.MethodGet && strings.Contains(path, "/vertex/batches/") {
+		relayMode = RelayModeVertexBatchFetchByID
+	}
+	return relayMode
+}
@@ -0,0 +1,166 @@
+package relay
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/middleware"
+	"one-api/model"
+	"one-api/relay/channel/vertex"
+	"one-api/service"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGeminiPassThroughForwardsBodyUnchanged(t *testing.T) {
+	const (
+		channelId   = 9305
+		clientEmail = "passthrough@test-project.iam.gserviceaccount.com"
+	)
+	setupClaudeRelayTest(t)
+	// 上游原始响应包含转换后会丢失的字段
+	const upstreamResponse = `{"candidates":[{"content":{"role":"model","parts":[{"text":"hi"}]},"finishReason":"STOP","avgLogprobs":-0.1,"futureField":{"a":1}}],"usageMetadata":{"promptTokenCount":7,"candidatesTokenCount":2,"totalTokenCount":9},"modelVersion":"gemini-2.5-flash"}`
+	var upstreamBody []byte
+	var upstreamPath string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamBody, _ = io.ReadAll(r.Body)
+		upstreamPath = r.URL.Path
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(upstreamResponse))
+	}))
+	defer server.Close()
+	originalTransport := http.DefaultTransport
+	http.DefaultTransport = server.Client().Transport
+	service.InitHttpClient()
+	defer func() {
+		http.DefaultTransport = originalTransport
+		service.InitHttpClient()
+	}()
+	vertex.Cache.SetDefault(fmt.Sprintf("access-token-%d-%s", channelId, clientEmail), "test-token")
+	target, _ := url.Parse(server.URL)
+	setting := fmt.Sprintf(`{"vertex_api_host":%q,"gemini_pass_through":true}`, target.Host)
+	ch := &model.Channel{
+		Id:      channelId,
+		Type:    constant.ChannelTypeVertexAi,
+		Key:     `{"project_id":"test-project","private_key":"unused","client_email":"` + clientEmail + `"}`,
+		Setting: &setting,
+		Other:   "us-central1",
+	}
+
+	// 客户端请求体的格式与未知字段都应原样保留
+	const body = `{
+  "contents": [{"role": "user", "parts": [{"text": "hello"}]}],
+  "generationConfig": {"temperature": 0.2, "responseModalities": ["TEXT"]},
+  "labels": {"team": "search"},
+  "futureRequestField": [1, 2, 3]
+}`
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1beta/models/gemini-2.5-flash:generateContent", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	common.SetContextKey(c, constant.ContextKeyUserId, 1)
+	common.SetContextKey(c, constant.ContextKeyTokenId, 1)
+	common.SetContextKey(c, constant.ContextKeyTokenKey, "test-token")
+	common.SetContextKey(c, constant.ContextKeyTokenUnlimited, true)
+	common.SetContextKey(c, constant.ContextKeyUsingGroup, "default")
+	common.SetContextKey(c, constant.ContextKeyUserGroup, "default")
+	common.SetContextKey(c, constant.ContextKeyRequestStartTime, time.Now())
+	if apiErr := middleware.SetupContextForSelectedChannel(c, ch, "gemini-2.5-flash"); apiErr != nil {
+		t.Fatalf("SetupContextForSelectedChannel: %v", apiErr)
+	}
+	if apiErr := GeminiHelper(c); apiErr != nil {
+		t.Fatalf("GeminiHelper: %v", apiErr)
+	}
+
+	if string(upstreamBody) != body {
+		t.Errorf("upstream body = %s, want the client body byte for byte", upstreamBody)
+	}
+	if !strings.HasSuffix(upstreamPath, "/locations/us-central1/publishers/google/models/gemini-2.5-flash:generateContent") {
+		t.Errorf("upstream path = %s, want the Vertex generateContent url", upstreamPath)
+	}
+	if recorder.Body.String() != upstreamResponse {
+		t.Errorf("response = %s, want the raw upstream response", recorder.Body.String())
+	}
+	// token 数以上游返回的用量为准
+	var log model.Log
+	if err := model.LOG_DB.Where("type = ?", model.LogTypeConsume).First(&log).Error; err != nil {
+		t.Fatalf("consume log: %v", err)
+	}
+	if log.PromptTokens != 7 || log.CompletionTokens != 2 {
+		t.Errorf("logged tokens = %d/%d, want the upstream usage 7/2", log.PromptTokens, log.CompletionTokens)
+	}
+}
+
+func TestGeminiHelperLogsServedModelVersion(t *testing.T) {
+	const (
+		channelId   = 9306
+		clientEmail = "model-version@test-project.iam.gserviceaccount.com"
+	)
+	setupClaudeRelayTest(t)
+	// 请求别名时上游返回实际提供服务的模型版本
+	const upstreamResponse = `{"candidates":[{"content":{"role":"model","parts":[{"text":"hi"}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":7,"candidatesTokenCount":2,"totalTokenCount":9},"modelVersion":"gemini-2.5-flash-preview-05-20"}`
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(upstreamResponse))
+	}))
+	defer server.Close()
+	originalTransport := http.DefaultTransport
+	http.DefaultTransport = server.Client().Transport
+	service.InitHttpClient()
+	defer func() {
+		http.DefaultTransport = originalTransport
+		service.InitHttpClient()
+	}()
+	vertex.Cache.SetDefault(fmt.Sprintf("access-token-%d-%s", channelId, clientEmail), "test-token")
+	target, _ := url.Parse(server.URL)
+	setting := fmt.Sprintf(`{"vertex_api_host":%q}`, target.Host)
+	ch := &model.Channel{
+		Id:      channelId,
+		Type:    constant.ChannelTypeVertexAi,
+		Key:     `{"project_id":"test-project","private_key":"unused","client_email":"` + clientEmail + `"}`,
+		Setting: &setting,
+		Other:   "us-central1",
+	}
+	logs := &lockedBuffer{}
+	originalWriter := gin.DefaultWriter
+	gin.DefaultWriter = logs
+	defer func() { gin.DefaultWriter = originalWriter }()
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1beta/models/gemini-2.5-flash:generateContent", strings.NewReader(`{"contents":[{"role":"user","parts":[{"text":"hello"}]}]}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	common.SetContextKey(c, constant.ContextKeyUserId, 1)
+	common.SetContextKey(c, constant.ContextKeyTokenId, 1)
+	common.SetContextKey(c, constant.ContextKeyTokenKey, "test-token")
+	common.SetContextKey(c, constant.ContextKeyTokenUnlimited, true)
+	common.SetContextKey(c, constant.ContextKeyUsingGroup, "default")
+	common.SetContextKey(c, constant.ContextKeyUserGroup, "default")
+	common.SetContextKey(c, constant.ContextKeyRequestStartTime, time.Now())
+	if apiErr := middleware.SetupContextForSelectedChannel(c, ch, "gemini-2.5-flash"); apiErr != nil {
+		t.Fatalf("SetupContextForSelectedChannel: %v", apiErr)
+	}
+	if apiErr := GeminiHelper(c); apiErr != nil {
+		t.Fatalf("GeminiHelper: %v", apiErr)
+	}
+
+	if !strings.Contains(logs.String(), "[GEMINI] Served model version | Model:gemini-2.5-flash | ModelVersion:gemini-2.5-flash-preview-05-20") {
+		t.Errorf("served model version was not logged:\n%s", logs.String())
+	}
+	var log model.Log
+	if err := model.LOG_DB.Where("type = ?", model.LogTypeConsume).First(&log).Error; err != nil {
+		t.Fatalf("consume log: %v", err)
+	}
+	other, _ := common.StrToMap(log.Other)
+	if other["model_version"] != "gemini-2.5-flash-preview-05-20" {
+		t.Errorf("consume log other = %s, want model_version", log.Other)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"one-api/common"
 	"one-api/dto"
+	relaycommon "one-api/relay/common"
 	"one-api/types"
 
 	"github.com/gin-gonic/gin"
@@ -94,6 +95,21 @@ func ObjectData(c *gin.Context, object interface{}) error {
 	return StringData(c, string(jsonData))
 }
 
+// StreamErrorData 在已开始的流式响应中按请求格式发送错误事件
+func StreamErrorData(c *gin.Context, info *relaycommon.RelayInfo, newAPIError *types.NewAPIError) {
+	if info.RelayFormat == relaycommon.RelayFormatClaude {
+		claudeError := newAPIError.ToClaudeError()
+		_ = ClaudeData(c, dto.ClaudeResponse{
+			Type:  "error",
+			Error: &claudeError,
+		})
+		return
+	}
+	_ = ObjectData(c, gin.H{
+		"error": newAPIError.ToOpenAIError(),
+	})
+}
+
 func Done(c *gin.Context) {
 	_ = StringData(c, "[DONE]")
 }
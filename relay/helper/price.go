@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"one-api/common"
 	relaycommon "one-api/relay/common"
+	"one-api/setting/model_setting"
+	"one-api/setting/operation_setting"
 	"one-api/setting/ratio_setting"
 
 	"github.com/gin-gonic/gin"
@@ -96,6 +98,21 @@ func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens
 		imageRatio, _ = ratio_setting.GetImageRatio(info.OriginModelName)
 		ratio := modelRatio * groupRatioInfo.GroupRatio
 		preConsumedQuota = int(float64(preConsumedTokens) * ratio)
+		// 流式输出在结束后才结算，按最坏情况（输出 max_tokens）预扣，多扣的部分在结算时退还
+		if info.IsStream && operation_setting.GetGeneralSetting().StreamPreConsumeFloor {
+			floorTokens := maxTokens
+			if floorTokens <= 0 {
+				// 未指定 max_tokens 时按模型的默认最大输出计算
+				floorTokens = model_setting.GetClaudeSettings().GetDefaultMaxTokens(info.OriginModelName)
+			}
+			floorQuota := int(float64(floorTokens) * ratio * completionRatio)
+			if floorQuota > preConsumedQuota {
+				if common.DebugEnabled {
+					println(fmt.Sprintf("stream pre-consume floor applied: %d -> %d", preConsumedQuota, floorQuota))
+				}
+				preConsumedQuota = floorQuota
+			}
+		}
 	} else {
 		preConsumedQuota = int(modelPrice * common.QuotaPerUnit * groupRatioInfo.GroupRatio)
 	}
@@ -0,0 +1,46 @@
+package helper
+
+import (
+	"net/http/httptest"
+	relaycommon "one-api/relay/common"
+	"one-api/setting/model_setting"
+	"one-api/setting/operation_setting"
+	"one-api/setting/ratio_setting"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStreamPreConsumeFloorCoversMaxTokens(t *testing.T) {
+	ratio_setting.InitRatioSettings()
+	generalSetting := operation_setting.GetGeneralSetting()
+	generalSetting.StreamPreConsumeFloor = true
+	defer func() { generalSetting.StreamPreConsumeFloor = false }()
+
+	const modelName = "claude-sonnet-4-20250514"
+	modelRatio, _, _ := ratio_setting.GetModelRatio(modelName)
+	completionRatio := ratio_setting.GetCompletionRatio(modelName)
+	groupRatio := ratio_setting.GetGroupRatio("default")
+	tests := []struct {
+		name          string
+		maxTokens     int
+		coveredTokens int
+	}{
+		{"explicit max_tokens", 64000, 64000},
+		{"default max_tokens", 0, model_setting.GetClaudeSettings().GetDefaultMaxTokens(modelName)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			info := &relaycommon.RelayInfo{OriginModelName: modelName, UsingGroup: "default", IsStream: true}
+			priceData, err := ModelPriceHelper(c, info, 100, tt.maxTokens)
+			if err != nil {
+				t.Fatalf("ModelPriceHelper: %v", err)
+			}
+			want := int(float64(tt.coveredTokens) * modelRatio * groupRatio * completionRatio)
+			if priceData.ShouldPreConsumedQuota < want {
+				t.Errorf("ShouldPreConsumedQuota = %d, want at least %d", priceData.ShouldPreConsumedQuota, want)
+			}
+		})
+	}
+}
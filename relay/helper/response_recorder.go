@@ -0,0 +1,32 @@
+package helper
+
+import (
+	"bytes"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ResponseRecorder 在写回客户端的同时记录响应内容
+type ResponseRecorder struct {
+	gin.ResponseWriter
+	Body bytes.Buffer
+}
+
+func (r *ResponseRecorder) Write(data []byte) (int, error) {
+	r.Body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *ResponseRecorder) WriteString(s string) (int, error) {
+	r.Body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
+
+// StartResponseRecorder 替换 c.Writer 开始记录响应，返回的函数用于恢复原始 Writer
+func StartResponseRecorder(c *gin.Context) (*ResponseRecorder, func()) {
+	recorder := &ResponseRecorder{ResponseWriter: c.Writer}
+	c.Writer = recorder
+	return recorder, func() {
+		c.Writer = recorder.ResponseWriter
+	}
+}
@@ -196,6 +196,10 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 			}
 
 			ticker.Reset(streamingTimeout)
+			// 上游有数据时推迟下一次 ping，保活注释只在空闲等待期间发送
+			if pingTicker != nil {
+				pingTicker.Reset(pingInterval)
+			}
 			data := scanner.Text()
 			if common.DebugEnabled {
 				println(data)
@@ -253,7 +257,11 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 		// 正常结束
 		common.LogInfo(c, "streaming finished")
 	case <-c.Request.Context().Done():
-		// 客户端断开连接
+		// 客户端断开连接，立即关闭上游响应体以中止读取
 		common.LogInfo(c, "client disconnected")
+		common.SetContextKey(c, constant.ContextKeyClientDisconnected, true)
+		if resp.Body != nil {
+			resp.Body.Close()
+		}
 	}
 }
@@ -0,0 +1,68 @@
+package helper
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/constant"
+	relaycommon "one-api/relay/common"
+	"one-api/setting/operation_setting"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStreamScannerSendsKeepaliveOnlyWhileIdle(t *testing.T) {
+	constant.StreamingTimeout = 60
+	generalSettings := operation_setting.GetGeneralSetting()
+	originalEnabled, originalSeconds := generalSettings.PingIntervalEnabled, generalSettings.PingIntervalSeconds
+	generalSettings.PingIntervalEnabled, generalSettings.PingIntervalSeconds = true, 1
+	defer func() {
+		generalSettings.PingIntervalEnabled, generalSettings.PingIntervalSeconds = originalEnabled, originalSeconds
+	}()
+
+	// 上游先静默 2.5 秒（模拟思考阶段），之后每 300 毫秒输出一个事件，间隔短于 ping 周期
+	upstream, upstreamWriter := io.Pipe()
+	go func() {
+		time.Sleep(2500 * time.Millisecond)
+		for i := 0; i < 6; i++ {
+			upstreamWriter.Write([]byte("data: {\"n\":" + strconv.Itoa(i) + "}\n\n"))
+			time.Sleep(300 * time.Millisecond)
+		}
+		upstreamWriter.Close()
+	}()
+
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	resp := &http.Response{StatusCode: http.StatusOK, Body: upstream}
+	StreamScannerHandler(c, resp, &relaycommon.RelayInfo{UpstreamModelName: "claude-sonnet-4-20250514"}, func(data string) bool {
+		c.Writer.Write([]byte("data: " + data + "\n\n"))
+		return true
+	})
+
+	output := recorder.Body.String()
+	firstData := strings.Index(output, "data: ")
+	if firstData < 0 {
+		t.Fatalf("no data forwarded:\n%s", output)
+	}
+	if pings := strings.Count(output[:firstData], ": PING\n\n"); pings < 1 {
+		t.Errorf("got %d keepalives during the upstream delay, want at least 1:\n%s", pings, output)
+	}
+	if strings.Contains(output[firstData:], ": PING") {
+		t.Errorf("keepalives must stop once data flows:\n%s", output)
+	}
+	if got := strings.Count(output, "data: "); got != 6 {
+		t.Errorf("got %d data events, want 6", got)
+	}
+	// 保活为独立的 SSE 注释事件，不能插入到数据事件内部
+	for _, event := range strings.Split(strings.TrimSuffix(output, "\n\n"), "\n\n") {
+		if event != ": PING" && !strings.HasPrefix(event, "data: ") {
+			t.Errorf("malformed event %q", event)
+		}
+	}
+}
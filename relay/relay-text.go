@@ -188,6 +188,11 @@ func TextHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 	} else {
 		convertedRequest, err := adaptor.ConvertOpenAIRequest(c, relayInfo, textRequest)
 		if err != nil {
+			// 转换过程中识别出的客户端错误直接返回
+			var apiErr *types.NewAPIError
+			if errors.As(err, &apiErr) {
+				return apiErr
+			}
 			return types.NewError(err, types.ErrorCodeConvertRequestFailed)
 		}
 		jsonData, err := json.Marshal(convertedRequest)
@@ -218,6 +223,10 @@ func TextHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 	resp, err := adaptor.DoRequest(c, relayInfo, requestBody)
 
 	if err != nil {
+		var apiErr *types.NewAPIError
+		if errors.As(err, &apiErr) {
+			return apiErr
+		}
 		return types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
 	}
 
@@ -342,6 +351,16 @@ func returnPreConsumedQuota(c *gin.Context, relayInfo *relaycommon.RelayInfo, us
 	}
 }
 
+// isTextGenerationRelayMode 是否为文本生成请求，仅此类请求适用无输出与安全拦截的计费策略
+func isTextGenerationRelayMode(relayMode int) bool {
+	switch relayMode {
+	case relayconstant.RelayModeChatCompletions, relayconstant.RelayModeCompletions,
+		relayconstant.RelayModeResponses, relayconstant.RelayModeGemini:
+		return true
+	}
+	return false
+}
+
 func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	usage *dto.Usage, preConsumedQuota int, userQuota int, priceData helper.PriceData, extraContent string) {
 	if usage == nil {
@@ -492,6 +511,11 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	} else {
 		logContent = fmt.Sprintf("模型价格 %.2f，分组倍率 %.2f", modelPrice, groupRatio)
 	}
+	if isTextGenerationRelayMode(relayInfo.RelayMode) {
+		var policyContent string
+		quota, policyContent = service.ApplyEmptyResponsePolicy(ctx, relayInfo, promptTokens, completionTokens, quota)
+		logContent += policyContent
+	}
 
 	// record all the consume log even if quota is 0
 	if totalTokens == 0 {
@@ -0,0 +1,67 @@
+package relay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"one-api/model"
+	relaycommon "one-api/relay/common"
+	relayconstant "one-api/relay/constant"
+	"one-api/relay/helper"
+	"one-api/setting/model_setting"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPostConsumeQuotaAppliesEmptyResponsePolicy(t *testing.T) {
+	setupClaudeRelayTest(t)
+	settings := model_setting.GetClaudeSettings()
+	originalCharge := settings.ChargeInputOnEmptyResponse
+	settings.ChargeInputOnEmptyResponse = false
+	defer func() { settings.ChargeInputOnEmptyResponse = originalCharge }()
+
+	priceData := helper.PriceData{ModelRatio: 1, CompletionRatio: 4, GroupRatioInfo: helper.GroupRatioInfo{GroupRatio: 1}}
+	tests := []struct {
+		name      string
+		usage     dto.Usage
+		marks     []constant.ContextKey
+		wantQuota int
+	}{
+		{"blocked response is refunded", dto.Usage{PromptTokens: 100, CompletionTokens: 20, TotalTokens: 120}, []constant.ContextKey{constant.ContextKeyResponseBlocked}, 0},
+		{"empty response is not charged", dto.Usage{PromptTokens: 100, TotalTokens: 100}, nil, 0},
+		{"tool-only response is billed normally", dto.Usage{PromptTokens: 100, TotalTokens: 100}, []constant.ContextKey{constant.ContextKeyResponseToolUse}, 100},
+		{"normal response is billed normally", dto.Usage{PromptTokens: 100, CompletionTokens: 20, TotalTokens: 120}, nil, 180},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model.LOG_DB.Where("1 = 1").Delete(&model.Log{})
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1beta/models/gemini-2.5-flash:generateContent", nil)
+			for _, key := range tt.marks {
+				common.SetContextKey(c, key, true)
+			}
+			info := &relaycommon.RelayInfo{
+				RelayMode:       relayconstant.RelayModeGemini,
+				UserId:          1,
+				TokenId:         1,
+				TokenKey:        "test-token",
+				ChannelId:       1,
+				OriginModelName: "gemini-2.5-flash",
+				StartTime:       time.Now(),
+			}
+			postConsumeQuota(c, info, &tt.usage, 0, 0, priceData, "")
+
+			var log model.Log
+			if err := model.LOG_DB.Where("type = ?", model.LogTypeConsume).First(&log).Error; err != nil {
+				t.Fatalf("consume log: %v", err)
+			}
+			if log.Quota != tt.wantQuota {
+				t.Errorf("quota = %d, want %d (%s)", log.Quota, tt.wantQuota, log.Content)
+			}
+		})
+	}
+}
@@ -110,6 +110,8 @@ func GetTaskAdaptor(platform commonconstant.TaskPlatform) channel.TaskAdaptor {
 		return &kling.TaskAdaptor{}
 	case commonconstant.TaskPlatformJimeng:
 		return &taskjimeng.TaskAdaptor{}
+	case commonconstant.TaskPlatformVertexBatch:
+		return &vertex.BatchTaskAdaptor{}
 	}
 	return nil
 }
@@ -15,6 +15,7 @@ import (
 	relayconstant "one-api/relay/constant"
 	"one-api/service"
 	"one-api/setting/ratio_setting"
+	"one-api/types"
 
 	"github.com/gin-gonic/gin"
 )
@@ -36,6 +37,9 @@ func RelayTaskSubmit(c *gin.Context, relayMode int) (taskErr *dto.TaskError) {
 	if taskErr != nil {
 		return
 	}
+	if platform == constant.TaskPlatformVertexBatch {
+		return relayBatchTaskSubmit(c, platform, adaptor, relayInfo)
+	}
 
 	modelName := relayInfo.OriginModelName
 	if modelName == "" {
@@ -108,6 +112,11 @@ func RelayTaskSubmit(c *gin.Context, relayMode int) (taskErr *dto.TaskError) {
 	// do request
 	resp, err := adaptor.DoRequest(c, relayInfo, requestBody)
 	if err != nil {
+		var apiErr *types.NewAPIError
+		if errors.As(err, &apiErr) {
+			taskErr = service.TaskErrorWrapper(apiErr, string(apiErr.GetErrorCode()), apiErr.StatusCode)
+			return
+		}
 		taskErr = service.TaskErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
 		return
 	}
@@ -176,9 +185,10 @@ func RelayTaskSubmit(c *gin.Context, relayMode int) (taskErr *dto.TaskError) {
 }
 
 var fetchRespBuilders = map[int]func(c *gin.Context) (respBody []byte, taskResp *dto.TaskError){
-	relayconstant.RelayModeSunoFetchByID:  sunoFetchByIDRespBodyBuilder,
-	relayconstant.RelayModeSunoFetch:      sunoFetchRespBodyBuilder,
-	relayconstant.RelayModeKlingFetchByID: videoFetchByIDRespBodyBuilder,
+	relayconstant.RelayModeSunoFetchByID:        sunoFetchByIDRespBodyBuilder,
+	relayconstant.RelayModeSunoFetch:            sunoFetchRespBodyBuilder,
+	relayconstant.RelayModeKlingFetchByID:       videoFetchByIDRespBodyBuilder,
+	relayconstant.RelayModeVertexBatchFetchByID: videoFetchByIDRespBodyBuilder,
 }
 
 func RelayTaskFetch(c *gin.Context, relayMode int) (taskResp *dto.TaskError) {
@@ -0,0 +1,81 @@
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"one-api/model"
+	"one-api/relay/channel"
+	relaycommon "one-api/relay/common"
+	"one-api/relay/helper"
+	"one-api/service"
+	"one-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// relayBatchTaskSubmit 提交批量预测任务，按请求数预扣额度，任务完成后按实际用量结算
+func relayBatchTaskSubmit(c *gin.Context, platform constant.TaskPlatform, adaptor channel.TaskAdaptor, relayInfo *relaycommon.TaskRelayInfo) (taskErr *dto.TaskError) {
+	priceData, err := helper.ModelPriceHelper(c, relayInfo.RelayInfo, 0, 0)
+	if err != nil {
+		return service.TaskErrorWrapperLocal(err, string(types.ErrorCodeModelPriceError), http.StatusInternalServerError)
+	}
+	// 每条请求按未指定 max_tokens 的文本请求预估
+	estimatedQuota := priceData.ShouldPreConsumedQuota * relayInfo.BatchRequestCount
+	preConsumedQuota, userQuota, apiErr := preConsumeQuota(c, estimatedQuota, relayInfo.RelayInfo)
+	if apiErr != nil {
+		return service.TaskErrorWrapperLocal(apiErr, string(apiErr.GetErrorCode()), apiErr.StatusCode)
+	}
+	defer func() {
+		if taskErr != nil {
+			returnPreConsumedQuota(c, relayInfo.RelayInfo, userQuota, preConsumedQuota)
+		}
+	}()
+
+	requestBody, err := adaptor.BuildRequestBody(c, relayInfo)
+	if err != nil {
+		return service.TaskErrorWrapper(err, "build_request_failed", http.StatusInternalServerError)
+	}
+	resp, err := adaptor.DoRequest(c, relayInfo, requestBody)
+	if err != nil {
+		var apiErr *types.NewAPIError
+		if errors.As(err, &apiErr) {
+			return service.TaskErrorWrapper(apiErr, string(apiErr.GetErrorCode()), apiErr.StatusCode)
+		}
+		return service.TaskErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	if resp != nil && resp.StatusCode != http.StatusOK {
+		responseBody, _ := io.ReadAll(resp.Body)
+		return service.TaskErrorWrapper(fmt.Errorf("%s", responseBody), "fail_to_fetch_task", resp.StatusCode)
+	}
+
+	taskID, taskData, taskErr := adaptor.DoResponse(c, resp, relayInfo)
+	if taskErr != nil {
+		return taskErr
+	}
+	task := model.InitTask(platform, relayInfo)
+	task.TaskID = taskID
+	task.Quota = preConsumedQuota
+	task.Data = taskData
+	task.Action = relayInfo.Action
+	task.Properties.Billing = &model.TaskBilling{
+		TokenId:         relayInfo.TokenId,
+		ModelName:       relayInfo.OriginModelName,
+		Group:           relayInfo.UsingGroup,
+		ModelRatio:      priceData.ModelRatio,
+		CompletionRatio: priceData.CompletionRatio,
+		GroupRatio:      priceData.GroupRatioInfo.GroupRatio,
+		ModelPrice:      priceData.ModelPrice,
+		UsePrice:        priceData.UsePrice,
+	}
+	if err := task.Insert(); err != nil {
+		return service.TaskErrorWrapper(err, "insert_task_failed", http.StatusInternalServerError)
+	}
+	common.LogInfo(c, fmt.Sprintf("[VERTEX] Batch task submitted | Task:%s | Requests:%d | PreConsumed:%s",
+		taskID, relayInfo.BatchRequestCount, common.LogQuota(preConsumedQuota)))
+	return nil
+}
@@ -101,6 +101,10 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.GET("/search", controller.SearchChannels)
 			channelRoute.GET("/models", controller.ChannelListModels)
 			channelRoute.GET("/models_enabled", controller.EnabledListModels)
+			channelRoute.GET("/error_stats", controller.GetChannelErrorStats)
+			channelRoute.DELETE("/error_stats", controller.ResetChannelErrorStats)
+			channelRoute.GET("/vertex/regions", controller.GetVertexModelRegions)
+			channelRoute.GET("/anthropic_beta/:id", controller.GetChannelAnthropicBeta)
 			channelRoute.GET("/:id", controller.GetChannel)
 			channelRoute.GET("/test", controller.TestAllChannels)
 			channelRoute.GET("/test/:id", controller.TestChannel)
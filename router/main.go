@@ -4,8 +4,10 @@ import (
 	"embed"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"net/http"
 	"one-api/common"
+	"one-api/middleware"
 	"os"
 	"strings"
 )
@@ -15,6 +17,9 @@ func SetRouter(router *gin.Engine, buildFS embed.FS, indexPage []byte) {
 	SetDashboardRouter(router)
 	SetRelayRouter(router)
 	SetVideoRouter(router)
+	if common.MetricsEnabled {
+		router.GET("/metrics", middleware.MetricsAuth(), gin.WrapH(promhttp.HandlerFor(common.MetricsGatherer(), promhttp.HandlerOpts{})))
+	}
 	frontendBaseUrl := os.Getenv("FRONTEND_BASE_URL")
 	if common.IsMasterNode && frontendBaseUrl != "" {
 		frontendBaseUrl = ""
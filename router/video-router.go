@@ -13,6 +13,8 @@ func SetVideoRouter(router *gin.Engine) {
 	{
 		videoV1Router.POST("/video/generations", controller.RelayTask)
 		videoV1Router.GET("/video/generations/:task_id", controller.RelayTask)
+		videoV1Router.POST("/vertex/batches", controller.RelayTask)
+		videoV1Router.GET("/vertex/batches/:task_id", controller.RelayTask)
 	}
 
 	klingV1Router := router.Group("/kling/v1")
@@ -42,9 +42,17 @@ func ShouldDisableChannel(channelType int, err *types.NewAPIError) bool {
 	if err == nil {
 		return false
 	}
+	// 并发已满属于临时不可用，不应禁用渠道
+	if err.GetErrorCode() == types.ErrorCodeChannelUnavailable {
+		return false
+	}
 	if types.IsChannelError(err) {
 		return true
 	}
+	// 服务账号密钥已失效，更换密钥前渠道无法恢复
+	if err.GetErrorCode() == types.ErrorCodeVertexKeyExpired {
+		return true
+	}
 	if types.IsLocalError(err) {
 		return false
 	}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"net/http"
+	"one-api/types"
+	"sync"
+)
+
+// ChannelErrorCategory 渠道错误分类，用于渠道健康度统计
+type ChannelErrorCategory string
+
+const (
+	ChannelErrorCategoryAuth        ChannelErrorCategory = "auth"
+	ChannelErrorCategoryRateLimit   ChannelErrorCategory = "rate_limit"
+	ChannelErrorCategoryUpstream5xx ChannelErrorCategory = "upstream_5xx"
+	ChannelErrorCategoryConversion  ChannelErrorCategory = "conversion"
+	ChannelErrorCategoryOther       ChannelErrorCategory = "other"
+)
+
+var (
+	channelErrorStats      = make(map[int]map[ChannelErrorCategory]int64)
+	channelErrorStatsMutex sync.RWMutex
+)
+
+// ClassifyChannelError 优先按错误码分类，无法识别时按状态码分类
+func ClassifyChannelError(err *types.NewAPIError) ChannelErrorCategory {
+	switch err.GetErrorCode() {
+	case types.ErrorCodeVertexAuthFailed, types.ErrorCodeVertexKeyExpired, types.ErrorCodeChannelInvalidKey, types.ErrorCodeChannelAwsClientError:
+		return ChannelErrorCategoryAuth
+	case types.ErrorCodeRateLimitExceeded:
+		return ChannelErrorCategoryRateLimit
+	case types.ErrorCodeConvertRequestFailed, types.ErrorCodeJsonMarshalFailed, types.ErrorCodeChannelParamOverrideInvalid,
+		types.ErrorCodeBadResponseBody, types.ErrorCodeReadResponseBodyFailed:
+		return ChannelErrorCategoryConversion
+	}
+	switch {
+	case err.StatusCode == http.StatusUnauthorized || err.StatusCode == http.StatusForbidden:
+		return ChannelErrorCategoryAuth
+	case err.StatusCode == http.StatusTooManyRequests:
+		return ChannelErrorCategoryRateLimit
+	case err.StatusCode >= http.StatusInternalServerError && !types.IsLocalError(err):
+		return ChannelErrorCategoryUpstream5xx
+	}
+	return ChannelErrorCategoryOther
+}
+
+// RecordChannelError 按分类累加渠道错误计数
+func RecordChannelError(channelId int, err *types.NewAPIError) {
+	if err == nil {
+		return
+	}
+	category := ClassifyChannelError(err)
+	channelErrorStatsMutex.Lock()
+	defer channelErrorStatsMutex.Unlock()
+	stats, ok := channelErrorStats[channelId]
+	if !ok {
+		stats = make(map[ChannelErrorCategory]int64)
+		channelErrorStats[channelId] = stats
+	}
+	stats[category]++
+}
+
+// GetChannelErrorStats 获取所有渠道的错误分类计数快照
+func GetChannelErrorStats() map[int]map[ChannelErrorCategory]int64 {
+	channelErrorStatsMutex.RLock()
+	defer channelErrorStatsMutex.RUnlock()
+	snapshot := make(map[int]map[ChannelErrorCategory]int64, len(channelErrorStats))
+	for channelId, stats := range channelErrorStats {
+		copied := make(map[ChannelErrorCategory]int64, len(stats))
+		for category, count := range stats {
+			copied[category] = count
+		}
+		snapshot[channelId] = copied
+	}
+	return snapshot
+}
+
+// ResetChannelErrorStats 清空渠道错误计数，channelId 为 0 时清空全部
+func ResetChannelErrorStats(channelId int) {
+	channelErrorStatsMutex.Lock()
+	defer channelErrorStatsMutex.Unlock()
+	if channelId == 0 {
+		channelErrorStats = make(map[int]map[ChannelErrorCategory]int64)
+		return
+	}
+	delete(channelErrorStats, channelId)
+}
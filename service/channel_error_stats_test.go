@@ -0,0 +1,68 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"one-api/types"
+	"testing"
+)
+
+func TestRecordChannelErrorBuckets(t *testing.T) {
+	ResetChannelErrorStats(0)
+	defer ResetChannelErrorStats(0)
+
+	upstreamErr := func(code string, statusCode int) *types.NewAPIError {
+		return types.WithOpenAIError(types.OpenAIError{Message: "upstream", Code: code}, statusCode)
+	}
+	errs := []struct {
+		channelId int
+		err       *types.NewAPIError
+	}{
+		{1, types.NewError(errors.New("expired"), types.ErrorCodeVertexKeyExpired)},
+		{1, types.NewErrorWithStatusCode(errors.New("denied"), types.ErrorCodeVertexAuthFailed, http.StatusUnauthorized)},
+		{1, upstreamErr("invalid_api_key", http.StatusUnauthorized)},
+		{1, types.NewErrorWithStatusCode(errors.New("tpm"), types.ErrorCodeRateLimitExceeded, http.StatusTooManyRequests)},
+		{1, upstreamErr("rate_limit", http.StatusTooManyRequests)},
+		{1, upstreamErr("overloaded", http.StatusServiceUnavailable)},
+		{1, types.NewError(errors.New("convert"), types.ErrorCodeConvertRequestFailed)},
+		{1, types.NewError(errors.New("bad body"), types.ErrorCodeBadResponseBody)},
+		// 本地产生的 500 不计为上游 5xx
+		{1, types.NewErrorWithStatusCode(errors.New("local"), types.ErrorCodeQueryDataError, http.StatusInternalServerError)},
+		{2, upstreamErr("internal", http.StatusBadGateway)},
+		{2, nil},
+	}
+	for _, e := range errs {
+		RecordChannelError(e.channelId, e.err)
+	}
+
+	want := map[int]map[ChannelErrorCategory]int64{
+		1: {
+			ChannelErrorCategoryAuth:        3,
+			ChannelErrorCategoryRateLimit:   2,
+			ChannelErrorCategoryUpstream5xx: 1,
+			ChannelErrorCategoryConversion:  2,
+			ChannelErrorCategoryOther:       1,
+		},
+		2: {ChannelErrorCategoryUpstream5xx: 1},
+	}
+	stats := GetChannelErrorStats()
+	if len(stats) != len(want) {
+		t.Fatalf("stats = %v, want %v", stats, want)
+	}
+	for channelId, buckets := range want {
+		if len(stats[channelId]) != len(buckets) {
+			t.Errorf("channel %d buckets = %v, want %v", channelId, stats[channelId], buckets)
+			continue
+		}
+		for category, count := range buckets {
+			if stats[channelId][category] != count {
+				t.Errorf("channel %d %s = %d, want %d", channelId, category, stats[channelId][category], count)
+			}
+		}
+	}
+
+	ResetChannelErrorStats(1)
+	if _, ok := GetChannelErrorStats()[1]; ok {
+		t.Error("channel 1 stats should be reset")
+	}
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+// 渠道+模型维度的并发限制，使用带缓冲的 channel 作为信号量，仅在单实例内生效
+
+// concurrencyIdleTTL 信号量空闲超过该时间后被清理
+const concurrencyIdleTTL = 10 * time.Minute
+
+type concurrencySemaphore struct {
+	sem      chan struct{}
+	limit    int
+	lastUsed time.Time
+}
+
+var (
+	concurrencySemaphores      = make(map[string]*concurrencySemaphore)
+	concurrencyMutex           sync.Mutex
+	concurrencyCleanupTaskOnce sync.Once
+)
+
+// getConcurrencySemaphore 获取 key 对应的信号量，上限变更后使用新的信号量，旧信号量上的请求结束后释放到旧信号量
+func getConcurrencySemaphore(key string, limit int) chan struct{} {
+	concurrencyCleanupTaskOnce.Do(startConcurrencyCleanupTask)
+	concurrencyMutex.Lock()
+	defer concurrencyMutex.Unlock()
+	s, ok := concurrencySemaphores[key]
+	if !ok || s.limit != limit {
+		s = &concurrencySemaphore{sem: make(chan struct{}, limit), limit: limit}
+		concurrencySemaphores[key] = s
+	}
+	s.lastUsed = time.Now()
+	return s.sem
+}
+
+func startConcurrencyCleanupTask() {
+	gopool.Go(func() {
+		for {
+			time.Sleep(time.Minute)
+			cleanupConcurrencySemaphores(time.Now())
+		}
+	})
+}
+
+// cleanupConcurrencySemaphores 清理没有请求占用且长时间未使用的信号量
+func cleanupConcurrencySemaphores(now time.Time) {
+	concurrencyMutex.Lock()
+	defer concurrencyMutex.Unlock()
+	for key, s := range concurrencySemaphores {
+		if len(s.sem) == 0 && now.Sub(s.lastUsed) > concurrencyIdleTTL {
+			delete(concurrencySemaphores, key)
+		}
+	}
+}
+
+// AcquireConcurrency 获取并发名额，wait 为 0 时立即失败，否则最多等待 wait
+// 获取成功时返回释放函数
+func AcquireConcurrency(ctx context.Context, key string, limit int, wait time.Duration) (func(), bool) {
+	if limit <= 0 {
+		return func() {}, true
+	}
+	sem := getConcurrencySemaphore(key, limit)
+	release := func() { <-sem }
+
+	select {
+	case sem <- struct{}{}:
+		return release, true
+	default:
+	}
+	if wait <= 0 {
+		return nil, false
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case sem <- struct{}{}:
+		return release, true
+	case <-timer.C:
+		return nil, false
+	case <-ctx.Done():
+		return nil, false
+	}
+}
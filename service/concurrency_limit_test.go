@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestConcurrencySemaphoreFollowsLimitChangesAndIsEvicted(t *testing.T) {
+	const key = "7101:claude-sonnet-4-20250514"
+	release, ok := AcquireConcurrency(context.Background(), key, 1, 0)
+	if !ok {
+		t.Fatal("first acquire should succeed")
+	}
+	if _, ok := AcquireConcurrency(context.Background(), key, 1, 0); ok {
+		t.Error("second acquire should hit the limit")
+	}
+	// 上限调大后使用新的信号量，键不随上限变化
+	releaseRaised, ok := AcquireConcurrency(context.Background(), key, 2, 0)
+	if !ok {
+		t.Error("acquire after raising the limit should succeed")
+	}
+	release()
+	releaseRaised()
+
+	cleanupConcurrencySemaphores(time.Now().Add(concurrencyIdleTTL + time.Minute))
+	concurrencyMutex.Lock()
+	_, ok = concurrencySemaphores[key]
+	concurrencyMutex.Unlock()
+	if ok {
+		t.Error("an idle semaphore should be evicted")
+	}
+}
+
+func TestConcurrencySemaphoreInUseIsKept(t *testing.T) {
+	const key = "7102:claude-sonnet-4-20250514"
+	release, ok := AcquireConcurrency(context.Background(), key, 1, 0)
+	if !ok {
+		t.Fatal("acquire should succeed")
+	}
+	defer release()
+	cleanupConcurrencySemaphores(time.Now().Add(concurrencyIdleTTL + time.Minute))
+	concurrencyMutex.Lock()
+	_, ok = concurrencySemaphores[key]
+	concurrencyMutex.Unlock()
+	if !ok {
+		t.Error("a semaphore held by a request must not be evicted")
+	}
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,7 +9,9 @@ import (
 	"net/http"
 	"one-api/common"
 	"one-api/dto"
+	"one-api/setting/operation_setting"
 	"one-api/types"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -91,6 +94,11 @@ func RelayErrorHandler(c *gin.Context, resp *http.Response, showBodyWhenFail boo
 		StatusCode: resp.StatusCode,
 		ErrorType:  types.ErrorTypeOpenAIError,
 	}
+	// 错误中携带请求 id，便于与日志关联
+	defer func() {
+		applyChannelErrorMapping(c, newApiErr)
+		newApiErr.RequestId = c.GetString(common.RequestIdKey)
+	}()
 
 	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -101,13 +109,16 @@ func RelayErrorHandler(c *gin.Context, resp *http.Response, showBodyWhenFail boo
 	
 	// [CLAUDE] 记录原始错误响应
 	bodyStr := string(responseBody)
-	if len(bodyStr) > 1000 {
-		bodyStr = bodyStr[:1000] + "...[truncated]"
+	if maxLength := operation_setting.GetGeneralSetting().GetErrorBodyLogMaxLength(); len(bodyStr) > maxLength {
+		bodyStr = common.TruncateUTF8(bodyStr, maxLength) + "...[truncated]"
 	}
 	common.LogError(c, fmt.Sprintf("[CLAUDE] Upstream error response | Body:%s", bodyStr))
 	
 	var errResponse dto.GeneralErrorResponse
 	err = common.Unmarshal(responseBody, &errResponse)
+	if err != nil {
+		err = unmarshalErrorBodyLenient(responseBody, &errResponse)
+	}
 	if err != nil {
 		common.LogError(c, fmt.Sprintf("[CLAUDE] Failed to parse error response | ParseError:%s", err.Error()))
 		if showBodyWhenFail {
@@ -128,6 +139,8 @@ func RelayErrorHandler(c *gin.Context, resp *http.Response, showBodyWhenFail boo
 		newApiErr.ErrorType = types.ErrorTypeOpenAIError
 	}
 	
+	classifyGoogleResourceExhausted(c, responseBody, newApiErr)
+
 	// [CLAUDE] 错误处理完成日志
 	common.LogError(c, fmt.Sprintf("[CLAUDE] Upstream error processing completed | FinalError:%s", newApiErr.Error()))
 	return
@@ -148,6 +161,9 @@ func RelayErrorHandlerLegacy(resp *http.Response, showBodyWhenFail bool) (newApi
 	var errResponse dto.GeneralErrorResponse
 
 	err = common.Unmarshal(responseBody, &errResponse)
+	if err != nil {
+		err = unmarshalErrorBodyLenient(responseBody, &errResponse)
+	}
 	if err != nil {
 		if showBodyWhenFail {
 			newApiErr.Err = fmt.Errorf("bad response status code %d, body: %s", resp.StatusCode, string(responseBody))
@@ -166,6 +182,20 @@ func RelayErrorHandlerLegacy(resp *http.Response, showBodyWhenFail bool) (newApi
 	return
 }
 
+var trailingCommaRegex = regexp.MustCompile(`,\s*([}\]])`)
+
+// unmarshalErrorBodyLenient 宽松解析上游错误响应：去除 BOM 与空白、多余的结尾逗号，只解析第一个 JSON 对象
+func unmarshalErrorBodyLenient(body []byte, v any) error {
+	body = bytes.TrimPrefix(bytes.TrimSpace(body), []byte("\xef\xbb\xbf"))
+	start := bytes.IndexByte(body, '{')
+	if start < 0 {
+		return errors.New("no json object found in error body")
+	}
+	body = trailingCommaRegex.ReplaceAll(body[start:], []byte("$1"))
+	// Decoder 只读取第一个 JSON 值，忽略其后的多余内容
+	return json.NewDecoder(bytes.NewReader(body)).Decode(v)
+}
+
 func ResetStatusCode(newApiErr *types.NewAPIError, statusCodeMappingStr string) {
 	if statusCodeMappingStr == "" || statusCodeMappingStr == "{}" {
 		return
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"one-api/types"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// applyChannelErrorMapping 按渠道配置替换上游错误的错误码和错误信息，如将区域限制的 403 替换为更友好的提示
+func applyChannelErrorMapping(c *gin.Context, newApiErr *types.NewAPIError) {
+	channelSetting, ok := common.GetContextKeyType[dto.ChannelSettings](c, constant.ContextKeyChannelSetting)
+	if !ok {
+		return
+	}
+	mapping, ok := channelSetting.ErrorMappings[strconv.Itoa(newApiErr.StatusCode)]
+	if !ok {
+		return
+	}
+	openAIError, ok := newApiErr.RelayError.(types.OpenAIError)
+	if !ok {
+		openAIError = types.OpenAIError{
+			Message: newApiErr.Error(),
+			Type:    "upstream_error",
+			Code:    newApiErr.GetErrorCode(),
+		}
+	}
+	originalCode := openAIError.Code
+	if mapping.Code != "" {
+		newApiErr.SetErrorCode(types.ErrorCode(mapping.Code))
+		openAIError.Code = mapping.Code
+	}
+	if mapping.Message != "" {
+		newApiErr.SetMessage(mapping.Message)
+		openAIError.Message = mapping.Message
+	}
+	newApiErr.RelayError = openAIError
+	newApiErr.ErrorType = types.ErrorTypeOpenAIError
+	common.LogInfo(c, fmt.Sprintf("[CLAUDE] Upstream error mapped by channel | Status:%d | From:%v | To:%v",
+		newApiErr.StatusCode, originalCode, openAIError.Code))
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"one-api/setting/operation_setting"
+	"one-api/types"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 轻微不规范的上游错误响应
+const (
+	bomErrorBodyFixture           = "\xef\xbb\xbf{\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}"
+	trailingCommaErrorBodyFixture = `{"error":{"type":"invalid_request_error","message":"max_tokens is too large",},}`
+	trailingDataErrorBodyFixture  = `{"error":{"type":"api_error","message":"Internal error"}}` + "\n<html>proxy footer</html>"
+)
+
+func TestRelayErrorHandlerParsesMalformedBodies(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantMessage string
+	}{
+		{"bom prefix", bomErrorBodyFixture, "Overloaded"},
+		{"trailing comma", trailingCommaErrorBodyFixture, "max_tokens is too large"},
+		{"trailing data", trailingDataErrorBodyFixture, "Internal error"},
+		{"unparseable falls back to the raw body", "<html>502 Bad Gateway</html>", "bad response status code 502, body: <html>502 Bad Gateway</html>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+			resp := &http.Response{
+				StatusCode: http.StatusBadGateway,
+				Body:       io.NopCloser(strings.NewReader(tt.body)),
+				Request:    httptest.NewRequest(http.MethodPost, "https://upstream.example.com/v1/messages", nil),
+			}
+			apiErr := RelayErrorHandler(c, resp, true)
+			if apiErr.Error() != tt.wantMessage {
+				t.Errorf("error = %q, want %q", apiErr.Error(), tt.wantMessage)
+			}
+			if apiErr.StatusCode != http.StatusBadGateway {
+				t.Errorf("status = %d, want 502", apiErr.StatusCode)
+			}
+		})
+	}
+}
+
+func TestRelayErrorHandlerTruncatesLoggedBodyOnRuneBoundary(t *testing.T) {
+	generalSettings := operation_setting.GetGeneralSetting()
+	originalLength := generalSettings.ErrorBodyLogMaxLength
+	originalWriter := gin.DefaultErrorWriter
+	defer func() {
+		generalSettings.ErrorBodyLogMaxLength = originalLength
+		gin.DefaultErrorWriter = originalWriter
+	}()
+
+	// "错" 占 3 字节，截断长度落在其中间
+	short := strings.Repeat("a", 9) + strings.Repeat("错", 10)
+	long := strings.Repeat("a", 999) + strings.Repeat("错", 10)
+	tests := []struct {
+		name      string
+		body      string
+		maxLength int
+		wantBody  string
+	}{
+		{"configured length inside a rune", short, 10, strings.Repeat("a", 9) + "...[truncated]"},
+		{"configured length after a rune", short, 12, strings.Repeat("a", 9) + "错...[truncated]"},
+		{"default length inside a rune", long, 0, strings.Repeat("a", 999) + "...[truncated]"},
+		{"body within the limit", short, 100, short},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generalSettings.ErrorBodyLogMaxLength = tt.maxLength
+			var logs bytes.Buffer
+			gin.DefaultErrorWriter = &logs
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+			resp := &http.Response{
+				StatusCode: http.StatusBadGateway,
+				Body:       io.NopCloser(strings.NewReader(tt.body)),
+				Request:    httptest.NewRequest(http.MethodPost, "https://upstream.example.com/v1/messages", nil),
+			}
+			RelayErrorHandler(c, resp, false)
+
+			var logged string
+			for _, line := range strings.Split(logs.String(), "\n") {
+				if _, after, ok := strings.Cut(line, "Upstream error response | Body:"); ok {
+					logged = strings.TrimSuffix(after, " ")
+				}
+			}
+			if !utf8.ValidString(logged) {
+				t.Errorf("logged body %q is not valid UTF-8", logged)
+			}
+			if logged != tt.wantBody {
+				t.Errorf("logged body = %q, want %q", logged, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRelayErrorHandlerAppliesChannelErrorMapping(t *testing.T) {
+	const regionDeniedBody = `{"error":{"code":403,"message":"Permission denied on resource project test-project in region us-east5.","status":"PERMISSION_DENIED"}}`
+	mappings := map[string]dto.ErrorMapping{
+		"403": {Code: "model_not_available_in_region", Message: "The model is not available in your region."},
+		"404": {Message: "Model not found on this channel."},
+	}
+	tests := []struct {
+		name        string
+		status      int
+		wantCode    types.ErrorCode
+		wantMessage string
+	}{
+		{"code and message replaced", http.StatusForbidden, "model_not_available_in_region", "The model is not available in your region."},
+		{"only message replaced", http.StatusNotFound, "", "Model not found on this channel."},
+		{"unmapped status kept", http.StatusBadRequest, "", "Permission denied on resource project test-project in region us-east5."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relayError := func(setting *dto.ChannelSettings) *types.NewAPIError {
+				gin.SetMode(gin.TestMode)
+				c, _ := gin.CreateTestContext(httptest.NewRecorder())
+				c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+				if setting != nil {
+					common.SetContextKey(c, constant.ContextKeyChannelSetting, *setting)
+				}
+				resp := &http.Response{
+					StatusCode: tt.status,
+					Body:       io.NopCloser(strings.NewReader(regionDeniedBody)),
+					Request:    httptest.NewRequest(http.MethodPost, "https://us-east5-aiplatform.googleapis.com/v1/projects/test-project/locations/us-east5/publishers/google/models/gemini-2.5-pro:generateContent", nil),
+				}
+				return RelayErrorHandler(c, resp, true)
+			}
+			apiErr := relayError(&dto.ChannelSettings{ErrorMappings: mappings})
+			// 未映射错误码时与未配置映射的渠道一致
+			original := relayError(nil)
+			wantCode, wantClientCode := tt.wantCode, any(string(tt.wantCode))
+			if wantCode == "" {
+				wantCode, wantClientCode = original.GetErrorCode(), original.ToOpenAIError().Code
+			}
+			if apiErr.GetErrorCode() != wantCode || apiErr.ToOpenAIError().Code != wantClientCode {
+				t.Errorf("error code = %s (client %v), want %s (client %v)", apiErr.GetErrorCode(), apiErr.ToOpenAIError().Code, wantCode, wantClientCode)
+			}
+			if apiErr.Error() != tt.wantMessage || apiErr.ToOpenAIError().Message != tt.wantMessage {
+				t.Errorf("error message = %q, want %q", apiErr.Error(), tt.wantMessage)
+			}
+			// 只替换错误码和信息，状态码不变
+			if apiErr.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", apiErr.StatusCode, tt.status)
+			}
+		})
+	}
+
+	settings := dto.ChannelSettings{ErrorMappings: map[string]dto.ErrorMapping{"200": {Code: "ok"}}}
+	if err := settings.Validate(); err == nil {
+		t.Error("Validate should reject a non-error status in error_mappings")
+	}
+}
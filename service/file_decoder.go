@@ -8,8 +8,93 @@ import (
 	"one-api/constant"
 	"one-api/dto"
 	"strings"
+	"sync"
+	"time"
 )
 
+// CollectMessageImageUrls 按出现顺序收集消息中需要下载的图片地址（http/https），忽略系统和工具消息
+func CollectMessageImageUrls(messages []dto.Message) []string {
+	var urls []string
+	for _, message := range messages {
+		if message.Role == "system" || message.Role == "developer" || message.Role == "tool" || message.Role == "function" || message.IsStringContent() {
+			continue
+		}
+		for _, part := range message.ParseContent() {
+			if part.Type == dto.ContentTypeText {
+				continue
+			}
+			if imageUrl := part.GetImageMedia(); imageUrl != nil && strings.HasPrefix(imageUrl.Url, "http") {
+				urls = append(urls, imageUrl.Url)
+			}
+		}
+	}
+	return urls
+}
+
+// GetFilesBase64FromUrls 按 FILE_DOWNLOAD_CONCURRENCY 并发下载文件，相同地址只下载一次
+// 任一文件下载失败或超时时返回该地址对应的错误
+func GetFilesBase64FromUrls(urls []string) (map[string]*dto.LocalFileData, error) {
+	uniqueUrls := make([]string, 0, len(urls))
+	seen := make(map[string]bool, len(urls))
+	for _, url := range urls {
+		if !seen[url] {
+			seen[url] = true
+			uniqueUrls = append(uniqueUrls, url)
+		}
+	}
+
+	results := make([]*dto.LocalFileData, len(uniqueUrls))
+	errs := make([]error, len(uniqueUrls))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(max(constant.FileDownloadConcurrency, 1), len(uniqueUrls)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i], errs[i] = getFileBase64FromUrlWithTimeout(uniqueUrls[i])
+			}
+		}()
+	}
+	for i := range uniqueUrls {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	files := make(map[string]*dto.LocalFileData, len(uniqueUrls))
+	for i, url := range uniqueUrls {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("get file base64 from url '%s' failed: %w", url, errs[i])
+		}
+		files[url] = results[i]
+	}
+	return files, nil
+}
+
+// getFileBase64FromUrlWithTimeout 超时后直接返回错误，未完成的下载在后台结束
+func getFileBase64FromUrlWithTimeout(url string) (*dto.LocalFileData, error) {
+	if constant.FileDownloadTimeoutSeconds <= 0 {
+		return GetFileBase64FromUrl(url)
+	}
+	type result struct {
+		file *dto.LocalFileData
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		file, err := GetFileBase64FromUrl(url)
+		done <- result{file, err}
+	}()
+	timeout := time.Duration(constant.FileDownloadTimeoutSeconds) * time.Second
+	select {
+	case r := <-done:
+		return r.file, r.err
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("download timed out after %v", timeout)
+	}
+}
+
 func GetFileBase64FromUrl(url string) (*dto.LocalFileData, error) {
 	var maxFileSize = constant.MaxFileDownloadMB * 1024 * 1024
 
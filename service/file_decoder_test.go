@@ -0,0 +1,91 @@
+package service
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"one-api/constant"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetFilesBase64FromUrlsDownloadsConcurrently(t *testing.T) {
+	originalConcurrency, originalTimeout := constant.FileDownloadConcurrency, constant.FileDownloadTimeoutSeconds
+	constant.MaxFileDownloadMB = 20
+	constant.FileDownloadTimeoutSeconds = 10
+	defer func() {
+		constant.FileDownloadConcurrency, constant.FileDownloadTimeoutSeconds = originalConcurrency, originalTimeout
+	}()
+	var inFlight, maxInFlight, requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		current := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			observed := atomic.LoadInt32(&maxInFlight)
+			if current <= observed || atomic.CompareAndSwapInt32(&maxInFlight, observed, current) {
+				break
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+	urls := []string{server.URL + "/a.png", server.URL + "/b.png", server.URL + "/c.png", server.URL + "/d.png", server.URL + "/a.png"}
+
+	tests := []struct {
+		concurrency int
+		wantMax     int32
+	}{
+		{4, 4},
+		{1, 1},
+	}
+	for _, tt := range tests {
+		constant.FileDownloadConcurrency = tt.concurrency
+		atomic.StoreInt32(&maxInFlight, 0)
+		atomic.StoreInt32(&requests, 0)
+		files, err := GetFilesBase64FromUrls(urls)
+		if err != nil {
+			t.Fatalf("GetFilesBase64FromUrls: %v", err)
+		}
+		// 相同地址只下载一次
+		if got := atomic.LoadInt32(&requests); got != 4 {
+			t.Errorf("concurrency %d: %d downloads, want 4", tt.concurrency, got)
+		}
+		if got := atomic.LoadInt32(&maxInFlight); got != tt.wantMax {
+			t.Errorf("concurrency %d: %d downloads in flight, want %d", tt.concurrency, got, tt.wantMax)
+		}
+		for _, url := range urls {
+			path := url[strings.LastIndex(url, "/"):]
+			if file := files[url]; file == nil || file.Base64Data != base64.StdEncoding.EncodeToString([]byte(path)) || file.MimeType != "image/png" {
+				t.Errorf("concurrency %d: file for %s = %+v", tt.concurrency, url, file)
+			}
+		}
+	}
+}
+
+func TestGetFilesBase64FromUrlsNamesFailingUrl(t *testing.T) {
+	originalTimeout := constant.FileDownloadTimeoutSeconds
+	constant.MaxFileDownloadMB = 20
+	constant.FileDownloadConcurrency = 4
+	constant.FileDownloadTimeoutSeconds = 1
+	defer func() { constant.FileDownloadTimeoutSeconds = originalTimeout }()
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow.png" {
+			<-release
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png"))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	_, err := GetFilesBase64FromUrls([]string{server.URL + "/fast.png", server.URL + "/slow.png"})
+	if err == nil || !strings.Contains(err.Error(), server.URL+"/slow.png") || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("err = %v, want a timeout naming the slow url", err)
+	}
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/types"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// googleErrorResponse Google API（Vertex AI）的错误响应格式
+type googleErrorResponse struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+		Details []struct {
+			Type     string            `json:"@type"`
+			Reason   string            `json:"reason"`
+			Metadata map[string]string `json:"metadata"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+// 未返回 details 时，配额指标只出现在错误信息中，如 "Quota exceeded for aiplatform.googleapis.com/xxx with base model: ..."
+var googleQuotaMetricRegex = regexp.MustCompile(`Quota exceeded for (?:quota metric ')?([A-Za-z0-9._/-]+)`)
+
+// classifyGoogleResourceExhausted 区分 Google RESOURCE_EXHAUSTED 错误：带配额指标的为项目配额耗尽，否则为上游容量不足导致的限流
+func classifyGoogleResourceExhausted(c *gin.Context, responseBody []byte, newApiErr *types.NewAPIError) {
+	if newApiErr.StatusCode != http.StatusTooManyRequests || !bytes.Contains(responseBody, []byte("RESOURCE_EXHAUSTED")) {
+		return
+	}
+	var errResponse googleErrorResponse
+	body := bytes.TrimSpace(responseBody)
+	if bytes.HasPrefix(body, []byte("[")) {
+		// 流式接口的错误响应是数组
+		var errResponses []googleErrorResponse
+		if err := common.Unmarshal(body, &errResponses); err != nil || len(errResponses) == 0 {
+			return
+		}
+		errResponse = errResponses[0]
+	} else if err := common.Unmarshal(body, &errResponse); err != nil {
+		return
+	}
+	if errResponse.Error.Status != "RESOURCE_EXHAUSTED" {
+		return
+	}
+
+	quotaMetric := ""
+	for _, detail := range errResponse.Error.Details {
+		if metric := detail.Metadata["quota_metric"]; metric != "" {
+			quotaMetric = metric
+			break
+		}
+	}
+	if quotaMetric == "" {
+		if match := googleQuotaMetricRegex.FindStringSubmatch(errResponse.Error.Message); match != nil {
+			quotaMetric = match[1]
+		}
+	}
+
+	errorCode := types.ErrorCodeUpstreamRateLimited
+	if quotaMetric != "" {
+		errorCode = types.ErrorCodeUpstreamQuotaExhausted
+	}
+	newApiErr.SetErrorCode(errorCode)
+	newApiErr.QuotaMetric = quotaMetric
+	if openAIError, ok := newApiErr.RelayError.(types.OpenAIError); ok {
+		openAIError.Code = errorCode
+		newApiErr.RelayError = openAIError
+	}
+	common.LogWarn(c, fmt.Sprintf("[CLAUDE] Upstream resource exhausted | Code:%s | QuotaMetric:%s", errorCode, quotaMetric))
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/types"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Vertex AI 返回的 RESOURCE_EXHAUSTED 错误响应
+const (
+	// 项目配额耗尽，配额指标只出现在错误信息中
+	vertexQuotaExceededFixture = `{
+  "error": {
+    "code": 429,
+    "message": "Quota exceeded for aiplatform.googleapis.com/generate_content_requests_per_minute_per_project_per_base_model with base model: gemini-2.5-pro. Please submit a quota increase request. https://cloud.google.com/vertex-ai/docs/generative-ai/quotas-genai.",
+    "status": "RESOURCE_EXHAUSTED"
+  }
+}`
+	// 项目配额耗尽，配额指标在 details 中
+	vertexQuotaExceededDetailsFixture = `{
+  "error": {
+    "code": 429,
+    "message": "Quota exceeded for quota metric 'Online prediction requests per base model' and limit 'Online prediction requests per minute per base model per minute per region per base_model' of service 'aiplatform.googleapis.com' for consumer 'project_number:123456789012'.",
+    "status": "RESOURCE_EXHAUSTED",
+    "details": [
+      {
+        "@type": "type.googleapis.com/google.rpc.ErrorInfo",
+        "reason": "RATE_LIMIT_EXCEEDED",
+        "domain": "googleapis.com",
+        "metadata": {
+          "service": "aiplatform.googleapis.com",
+          "consumer": "projects/123456789012",
+          "quota_metric": "aiplatform.googleapis.com/online_prediction_requests_per_base_model",
+          "quota_limit": "OnlinePredictionRequestsPerMinutePerProjectPerRegionPerBaseModel"
+        }
+      }
+    ]
+  }
+}`
+	// 共享容量不足导致的限流，流式接口返回数组
+	vertexCapacityExhaustedFixture = `[{
+  "error": {
+    "code": 429,
+    "message": "Resource exhausted. Please try again later. Please refer to https://cloud.google.com/vertex-ai/generative-ai/docs/error-code-429 for more details.",
+    "status": "RESOURCE_EXHAUSTED"
+  }
+}]`
+)
+
+func TestRelayErrorHandlerClassifiesVertexResourceExhausted(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantCode   types.ErrorCode
+		wantMetric string
+	}{
+		{"quota metric in message", vertexQuotaExceededFixture, types.ErrorCodeUpstreamQuotaExhausted, "aiplatform.googleapis.com/generate_content_requests_per_minute_per_project_per_base_model"},
+		{"quota metric in details", vertexQuotaExceededDetailsFixture, types.ErrorCodeUpstreamQuotaExhausted, "aiplatform.googleapis.com/online_prediction_requests_per_base_model"},
+		{"capacity exhausted", vertexCapacityExhaustedFixture, types.ErrorCodeUpstreamRateLimited, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+			resp := &http.Response{
+				StatusCode: http.StatusTooManyRequests,
+				Body:       io.NopCloser(strings.NewReader(tt.body)),
+				Request:    httptest.NewRequest(http.MethodPost, "https://us-east5-aiplatform.googleapis.com/v1/projects/test-project/locations/us-east5/publishers/google/models/gemini-2.5-pro:generateContent", nil),
+			}
+			apiErr := RelayErrorHandler(c, resp, true)
+			if apiErr.GetErrorCode() != tt.wantCode {
+				t.Errorf("error code = %s, want %s", apiErr.GetErrorCode(), tt.wantCode)
+			}
+			if apiErr.QuotaMetric != tt.wantMetric {
+				t.Errorf("quota metric = %q, want %q", apiErr.QuotaMetric, tt.wantMetric)
+			}
+			if apiErr.StatusCode != http.StatusTooManyRequests {
+				t.Errorf("status = %d, want 429", apiErr.StatusCode)
+			}
+		})
+	}
+}
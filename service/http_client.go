@@ -7,13 +7,33 @@ import (
 	"net/http"
 	"net/url"
 	"one-api/common"
+	"one-api/dto"
+	"sync"
 	"time"
 
+	"github.com/bytedance/gopkg/util/gopool"
 	"golang.org/x/net/proxy"
 )
 
 var httpClient *http.Client
 
+// channelHttpClient 渠道专用的客户端，fingerprint 为创建客户端时的连接池与 TLS 设置
+type channelHttpClient struct {
+	fingerprint string
+	client      *http.Client
+	lastUsed    time.Time
+}
+
+// channelHttpClientIdleTTL 渠道客户端超过该时间未使用时被清理，已删除渠道的客户端随之释放
+const channelHttpClientIdleTTL = time.Hour
+
+// channelHttpClients 按渠道缓存的客户端，保证同一渠道的请求复用连接
+var (
+	channelHttpClients        = make(map[int]*channelHttpClient)
+	channelHttpClientsMutex   sync.Mutex
+	channelHttpClientsCleanup sync.Once
+)
+
 func InitHttpClient() {
 	if common.RelayTimeout == 0 {
 		httpClient = &http.Client{}
@@ -28,24 +48,126 @@ func GetHttpClient() *http.Client {
 	return httpClient
 }
 
+// GetChannelHttpClient 根据渠道设置获取 HTTP 客户端，配置了连接池参数或 TLS 设置时返回渠道专用并复用连接的客户端
+// 渠道设置变更后替换该渠道的客户端并关闭旧客户端的空闲连接
+func GetChannelHttpClient(channelId int, setting dto.ChannelSettings) (*http.Client, error) {
+	if !setting.HasConnectionPool() && setting.TLS == nil {
+		if setting.Proxy != "" {
+			return NewProxyHttpClient(setting.Proxy)
+		}
+		return GetHttpClient(), nil
+	}
+	fingerprint := fmt.Sprintf("%s|%d|%d|%d", setting.Proxy, setting.MaxIdleConnsPerHost, setting.IdleConnTimeout, setting.KeepAlive)
+	if setting.TLS != nil {
+		fingerprint += "|" + setting.TLS.Fingerprint()
+	}
+	channelHttpClientsCleanup.Do(startChannelHttpClientCleanupTask)
+
+	channelHttpClientsMutex.Lock()
+	defer channelHttpClientsMutex.Unlock()
+	cached, ok := channelHttpClients[channelId]
+	if ok && cached.fingerprint == fingerprint {
+		cached.lastUsed = time.Now()
+		return cached.client, nil
+	}
+	client, err := newPooledHttpClient(setting)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		cached.client.CloseIdleConnections()
+	}
+	channelHttpClients[channelId] = &channelHttpClient{fingerprint: fingerprint, client: client, lastUsed: time.Now()}
+	return client, nil
+}
+
+func startChannelHttpClientCleanupTask() {
+	gopool.Go(func() {
+		for {
+			time.Sleep(time.Minute)
+			cleanupChannelHttpClients(time.Now())
+		}
+	})
+}
+
+// cleanupChannelHttpClients 清理长时间未使用的渠道客户端并关闭其空闲连接
+func cleanupChannelHttpClients(now time.Time) {
+	channelHttpClientsMutex.Lock()
+	defer channelHttpClientsMutex.Unlock()
+	for channelId, cached := range channelHttpClients {
+		if now.Sub(cached.lastUsed) > channelHttpClientIdleTTL {
+			cached.client.CloseIdleConnections()
+			delete(channelHttpClients, channelId)
+		}
+	}
+}
+
+func newPooledHttpClient(setting dto.ChannelSettings) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if setting.KeepAlive > 0 {
+		dialer.KeepAlive = time.Duration(setting.KeepAlive) * time.Second
+	}
+	transport.DialContext = dialer.DialContext
+	if setting.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = setting.MaxIdleConnsPerHost
+		if transport.MaxIdleConns < setting.MaxIdleConnsPerHost {
+			transport.MaxIdleConns = setting.MaxIdleConnsPerHost
+		}
+	}
+	if setting.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = time.Duration(setting.IdleConnTimeout) * time.Second
+	}
+	if setting.TLS != nil {
+		tlsConfig, err := setting.TLS.BuildTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	if setting.Proxy != "" {
+		if err := setupProxyTransport(transport, setting.Proxy); err != nil {
+			return nil, err
+		}
+	}
+	client := &http.Client{
+		Transport: transport,
+	}
+	if common.RelayTimeout != 0 {
+		client.Timeout = time.Duration(common.RelayTimeout) * time.Second
+	}
+	return client, nil
+}
+
 // NewProxyHttpClient 创建支持代理的 HTTP 客户端
 func NewProxyHttpClient(proxyURL string) (*http.Client, error) {
 	if proxyURL == "" {
 		return http.DefaultClient, nil
 	}
 
+	transport := &http.Transport{}
+	if err := setupProxyTransport(transport, proxyURL); err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Transport: transport,
+	}, nil
+}
+
+// setupProxyTransport 为 transport 设置代理
+func setupProxyTransport(transport *http.Transport, proxyURL string) error {
 	parsedURL, err := url.Parse(proxyURL)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	switch parsedURL.Scheme {
 	case "http", "https":
-		return &http.Client{
-			Transport: &http.Transport{
-				Proxy: http.ProxyURL(parsedURL),
-			},
-		}, nil
+		transport.Proxy = http.ProxyURL(parsedURL)
+		return nil
 
 	case "socks5", "socks5h":
 		// 获取认证信息
@@ -64,18 +186,15 @@ func NewProxyHttpClient(proxyURL string) (*http.Client, error) {
 		// proxy.SOCKS5 使用 tcp 参数，所有 TCP 连接包括 DNS 查询都将通过代理进行。行为与 socks5h 相同
 		dialer, err := proxy.SOCKS5("tcp", parsedURL.Host, auth, proxy.Direct)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		return &http.Client{
-			Transport: &http.Transport{
-				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-					return dialer.Dial(network, addr)
-				},
-			},
-		}, nil
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.Dial(network, addr)
+		}
+		return nil
 
 	default:
-		return nil, fmt.Errorf("unsupported proxy scheme: %s", parsedURL.Scheme)
+		return fmt.Errorf("unsupported proxy scheme: %s", parsedURL.Scheme)
 	}
 }
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/dto"
+	"testing"
+	"time"
+)
+
+// useTestTLSServer 将默认 Transport 替换为信任测试服务器证书的 Transport，渠道客户端基于它创建
+func useTestTLSServer(tb testing.TB) *httptest.Server {
+	tb.Helper()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	originalTransport := http.DefaultTransport
+	http.DefaultTransport = server.Client().Transport
+	tb.Cleanup(func() {
+		http.DefaultTransport = originalTransport
+		server.Close()
+	})
+	return server
+}
+
+func doBenchmarkRequest(b *testing.B, client *http.Client, url string) {
+	resp, err := client.Get(url)
+	if err != nil {
+		b.Fatalf("request: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// BenchmarkChannelHttpClient 对比复用连接的渠道客户端与每次请求都重新握手的客户端
+func BenchmarkChannelHttpClient(b *testing.B) {
+	server := useTestTLSServer(b)
+	b.Run("pooled", func(b *testing.B) {
+		client, err := GetChannelHttpClient(1, dto.ChannelSettings{MaxIdleConnsPerHost: 16, IdleConnTimeout: 90})
+		if err != nil {
+			b.Fatalf("GetChannelHttpClient: %v", err)
+		}
+		for i := 0; i < b.N; i++ {
+			doBenchmarkRequest(b, client, server.URL)
+		}
+	})
+	b.Run("no pooling", func(b *testing.B) {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.DisableKeepAlives = true
+		client := &http.Client{Transport: transport}
+		for i := 0; i < b.N; i++ {
+			doBenchmarkRequest(b, client, server.URL)
+		}
+	})
+}
+
+func TestGetChannelHttpClientKeepsOneClientPerChannel(t *testing.T) {
+	useTestTLSServer(t)
+	const channelId = 7001
+	setting := dto.ChannelSettings{MaxIdleConnsPerHost: 8}
+	channelHttpClientsMutex.Lock()
+	cachedBefore := len(channelHttpClients)
+	channelHttpClientsMutex.Unlock()
+	first, err := GetChannelHttpClient(channelId, setting)
+	if err != nil {
+		t.Fatalf("GetChannelHttpClient: %v", err)
+	}
+	if again, _ := GetChannelHttpClient(channelId, setting); again != first {
+		t.Error("the same settings should reuse the channel client")
+	}
+	setting.MaxIdleConnsPerHost = 16
+	updated, _ := GetChannelHttpClient(channelId, setting)
+	if updated == first {
+		t.Error("changed settings should replace the channel client")
+	}
+
+	channelHttpClientsMutex.Lock()
+	cached := len(channelHttpClients)
+	channelHttpClientsMutex.Unlock()
+	if cached != cachedBefore+1 {
+		t.Errorf("cached clients = %d, want %d", cached, cachedBefore+1)
+	}
+
+	cleanupChannelHttpClients(time.Now().Add(channelHttpClientIdleTTL + time.Minute))
+	channelHttpClientsMutex.Lock()
+	_, ok := channelHttpClients[channelId]
+	channelHttpClientsMutex.Unlock()
+	if ok {
+		t.Error("idle channel client should be evicted")
+	}
+}
+
+func TestGetChannelHttpClientTrustsChannelCA(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	caCert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+	tests := []struct {
+		name      string
+		channelId int
+		setting   dto.ChannelSettings
+		ok        bool
+	}{
+		{"system roots only", 7101, dto.ChannelSettings{MaxIdleConnsPerHost: 2}, false},
+		{"custom ca", 7102, dto.ChannelSettings{TLS: &dto.ChannelTLSConfig{CACert: caCert}}, true},
+		{"insecure skip verify", 7103, dto.ChannelSettings{TLS: &dto.ChannelTLSConfig{InsecureSkipVerify: true}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := GetChannelHttpClient(tt.channelId, tt.setting)
+			if err != nil {
+				t.Fatalf("GetChannelHttpClient: %v", err)
+			}
+			resp, err := client.Get(server.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err == nil) != tt.ok {
+				t.Errorf("request error = %v, want success %v", err, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"one-api/common"
+	"sync"
+	"time"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+// IdempotencyRecord 幂等键对应的请求记录，Pending 表示首个请求仍在处理中，完成后保存响应用于重复请求直接返回
+type IdempotencyRecord struct {
+	BodyHash    string    `json:"body_hash"`
+	Pending     bool      `json:"pending"`
+	ContentType string    `json:"content_type"`
+	Body        string    `json:"body"`
+	ExpireAt    time.Time `json:"expire_at"`
+}
+
+// idempotencyStore is used for in-memory storage when Redis is disabled
+var (
+	idempotencyStore       sync.Map
+	idempotencyCleanupOnce sync.Once
+)
+
+func getIdempotencyKey(userId int, key string) string {
+	return fmt.Sprintf("idempotency:%d:%s", userId, key)
+}
+
+// HashIdempotencyBody 计算请求体摘要，同一幂等键只能用于相同的请求体
+func HashIdempotencyBody(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+func startIdempotencyCleanupTask() {
+	gopool.Go(func() {
+		for {
+			time.Sleep(time.Minute)
+			now := time.Now()
+			idempotencyStore.Range(func(key, value interface{}) bool {
+				if record, ok := value.(*IdempotencyRecord); ok && now.After(record.ExpireAt) {
+					idempotencyStore.CompareAndDelete(key, value)
+				}
+				return true
+			})
+		}
+	})
+}
+
+// AcquireIdempotencyKey 原子地占用用户的幂等键，占用成功时 acquired 为 true
+// 幂等键已被占用时返回已有记录，记录可能仍在处理中（Pending）或已完成
+func AcquireIdempotencyKey(userId int, key string, bodyHash string, ttl time.Duration) (record *IdempotencyRecord, acquired bool, err error) {
+	cacheKey := getIdempotencyKey(userId, key)
+	placeholder := &IdempotencyRecord{BodyHash: bodyHash, Pending: true, ExpireAt: time.Now().Add(ttl)}
+	if common.RedisEnabled {
+		data, err := common.Marshal(placeholder)
+		if err != nil {
+			return nil, false, err
+		}
+		ok, err := common.RDB.SetNX(context.Background(), cacheKey, string(data), ttl).Result()
+		if err != nil {
+			return nil, false, err
+		}
+		if ok {
+			return nil, true, nil
+		}
+		value, err := common.RedisGet(cacheKey)
+		if err != nil {
+			return nil, false, err
+		}
+		var existing IdempotencyRecord
+		if err := common.UnmarshalJsonStr(value, &existing); err != nil {
+			return nil, false, err
+		}
+		return &existing, false, nil
+	}
+	idempotencyCleanupOnce.Do(startIdempotencyCleanupTask)
+	for {
+		value, loaded := idempotencyStore.LoadOrStore(cacheKey, placeholder)
+		if !loaded {
+			return nil, true, nil
+		}
+		existing := value.(*IdempotencyRecord)
+		if time.Now().Before(existing.ExpireAt) {
+			return existing, false, nil
+		}
+		// 已过期的记录只由一个请求删除，随后重新尝试占用
+		idempotencyStore.CompareAndDelete(cacheKey, value)
+	}
+}
+
+// CompleteIdempotencyKey 保存已完成请求的响应，重复请求在 ttl 内直接返回该响应
+func CompleteIdempotencyKey(userId int, key string, record *IdempotencyRecord, ttl time.Duration) error {
+	cacheKey := getIdempotencyKey(userId, key)
+	record.Pending = false
+	record.ExpireAt = time.Now().Add(ttl)
+	if common.RedisEnabled {
+		data, err := common.Marshal(record)
+		if err != nil {
+			return err
+		}
+		return common.RedisSet(cacheKey, string(data), ttl)
+	}
+	idempotencyStore.Store(cacheKey, record)
+	return nil
+}
+
+// ReleaseIdempotencyKey 请求失败时释放幂等键，客户端可以使用同一幂等键重试
+func ReleaseIdempotencyKey(userId int, key string) error {
+	cacheKey := getIdempotencyKey(userId, key)
+	if common.RedisEnabled {
+		return common.RedisDel(cacheKey)
+	}
+	idempotencyStore.Delete(cacheKey)
+	return nil
+}
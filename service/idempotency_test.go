@@ -0,0 +1,62 @@
+package service
+
+import (
+	"one-api/common"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAcquireIdempotencyKeyAllowsSingleInFlightRequest(t *testing.T) {
+	common.RedisEnabled = false
+	hash := HashIdempotencyBody([]byte(`{"model":"claude-sonnet-4-20250514"}`))
+	var acquiredCount int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			record, acquired, err := AcquireIdempotencyKey(1, "in-flight", hash, time.Minute)
+			if err != nil {
+				t.Errorf("AcquireIdempotencyKey: %v", err)
+				return
+			}
+			if acquired {
+				atomic.AddInt32(&acquiredCount, 1)
+			} else if !record.Pending {
+				t.Errorf("concurrent duplicate should see a pending record")
+			}
+		}()
+	}
+	wg.Wait()
+	if acquiredCount != 1 {
+		t.Fatalf("acquired %d times, want 1", acquiredCount)
+	}
+
+	if err := CompleteIdempotencyKey(1, "in-flight", &IdempotencyRecord{BodyHash: hash, Body: "done"}, time.Minute); err != nil {
+		t.Fatalf("CompleteIdempotencyKey: %v", err)
+	}
+	record, acquired, _ := AcquireIdempotencyKey(1, "in-flight", hash, time.Minute)
+	if acquired || record.Pending || record.Body != "done" {
+		t.Errorf("completed key: acquired=%v record=%+v", acquired, record)
+	}
+	// 不同用户的同名幂等键互不影响
+	if _, acquired, _ := AcquireIdempotencyKey(2, "in-flight", hash, time.Minute); !acquired {
+		t.Error("key should be scoped per user")
+	}
+}
+
+func TestReleaseIdempotencyKeyAllowsRetry(t *testing.T) {
+	common.RedisEnabled = false
+	hash := HashIdempotencyBody([]byte("body"))
+	if _, acquired, _ := AcquireIdempotencyKey(1, "released", hash, time.Minute); !acquired {
+		t.Fatal("first acquire should succeed")
+	}
+	if err := ReleaseIdempotencyKey(1, "released"); err != nil {
+		t.Fatalf("ReleaseIdempotencyKey: %v", err)
+	}
+	if _, acquired, _ := AcquireIdempotencyKey(1, "released", hash, time.Minute); !acquired {
+		t.Error("released key should be acquirable again")
+	}
+}
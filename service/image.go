@@ -34,6 +34,43 @@ func DecodeBase64ImageData(base64String string) (image.Config, string, string, e
 	return config, format, base64String, err
 }
 
+// ParseBase64DataURI 解析并校验 data:<mime>;base64,<data> 格式的 data URI，返回 MIME 类型与 base64 数据
+func ParseBase64DataURI(uri string) (string, string, error) {
+	if !strings.HasPrefix(uri, "data:") {
+		return "", "", errors.New("not a data URI, expected prefix 'data:'")
+	}
+	header, data, found := strings.Cut(strings.TrimPrefix(uri, "data:"), ",")
+	if !found {
+		return "", "", errors.New("malformed data URI, missing ',' before data")
+	}
+	params := strings.Split(header, ";")
+	mimeType := strings.ToLower(strings.TrimSpace(params[0]))
+	if mimeType == "" {
+		return "", "", errors.New("malformed data URI, missing mime type")
+	}
+	if !strings.Contains(mimeType, "/") {
+		return "", "", fmt.Errorf("malformed data URI, invalid mime type '%s'", mimeType)
+	}
+	isBase64 := false
+	for _, param := range params[1:] {
+		if strings.TrimSpace(param) == "base64" {
+			isBase64 = true
+			break
+		}
+	}
+	if !isBase64 {
+		return "", "", errors.New("malformed data URI, missing ';base64' marker")
+	}
+	data = strings.TrimSpace(data)
+	if data == "" {
+		return "", "", errors.New("malformed data URI, data is empty")
+	}
+	if _, err := base64.StdEncoding.DecodeString(data); err != nil {
+		return "", "", fmt.Errorf("malformed data URI, invalid base64 data: %s", err.Error())
+	}
+	return mimeType, data, nil
+}
+
 func DecodeBase64FileData(base64String string) (string, string, error) {
 	var mimeType string
 	var idx int
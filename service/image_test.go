@@ -0,0 +1,43 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseBase64DataURI(t *testing.T) {
+	const png = "iVBORw0KGgo="
+	tests := []struct {
+		name     string
+		uri      string
+		wantMime string
+		wantErr  string
+	}{
+		{"valid", "data:image/png;base64," + png, "image/png", ""},
+		{"uppercase mime with extra params", "data:IMAGE/PNG;charset=binary;base64," + png, "image/png", ""},
+		{"not a data uri", "image/png;base64," + png, "", "expected prefix 'data:'"},
+		{"missing comma", "data:image/png;base64" + png, "", "missing ','"},
+		{"missing mime", "data:;base64," + png, "", "missing mime type"},
+		{"invalid mime", "data:png;base64," + png, "", "invalid mime type 'png'"},
+		{"missing base64 marker", "data:image/png," + png, "", "missing ';base64' marker"},
+		{"empty data", "data:image/png;base64,", "", "data is empty"},
+		{"invalid base64", "data:image/png;base64,not*base64", "", "invalid base64 data"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mimeType, data, err := ParseBase64DataURI(tt.uri)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseBase64DataURI: %v", err)
+			}
+			if mimeType != tt.wantMime || data != png {
+				t.Errorf("got %q %q, want %q %q", mimeType, data, tt.wantMime, png)
+			}
+		})
+	}
+}
@@ -24,10 +24,19 @@ func GenerateTextOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, m
 	if relayInfo.ReasoningEffort != "" {
 		other["reasoning_effort"] = relayInfo.ReasoningEffort
 	}
+	if alias := common.GetContextKeyString(ctx, constant.ContextKeyModelAlias); alias != "" {
+		other["model_alias"] = alias
+	}
 	if relayInfo.IsModelMapped {
 		other["is_model_mapped"] = true
 		other["upstream_model_name"] = relayInfo.UpstreamModelName
 	}
+	if routedFrom := common.GetContextKeyString(ctx, constant.ContextKeyModelRoute); routedFrom != "" {
+		other["model_routed_from"] = routedFrom
+	}
+	if modelVersion := common.GetContextKeyString(ctx, constant.ContextKeyModelVersion); modelVersion != "" {
+		other["model_version"] = modelVersion
+	}
 	adminInfo := make(map[string]interface{})
 	adminInfo["use_channel"] = ctx.GetStringSlice("use_channel")
 	isMultiKey := common.GetContextKeyBool(ctx, constant.ContextKeyChannelIsMultiKey)
@@ -69,6 +78,16 @@ func GenerateClaudeOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	info["claude"] = true
 	info["cache_creation_tokens"] = cacheCreationTokens
 	info["cache_creation_ratio"] = cacheCreationRatio
+	if common.GetContextKeyBool(ctx, constant.ContextKeyClaudeMaxTokensTruncated) {
+		info["stop_reason"] = "max_tokens"
+	}
+	if common.GetContextKeyBool(ctx, constant.ContextKeySLABreached) {
+		info["sla_breached"] = true
+	}
+	if delta, ok := common.GetContextKey(ctx, constant.ContextKeyPromptTokensDelta); ok {
+		info["prompt_tokens_estimated"] = relayInfo.PromptTokens
+		info["prompt_tokens_delta"] = delta
+	}
 	return info
 }
 
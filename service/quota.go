@@ -12,6 +12,7 @@ import (
 	relaycommon "one-api/relay/common"
 	"one-api/relay/helper"
 	"one-api/setting"
+	"one-api/setting/model_setting"
 	"one-api/setting/ratio_setting"
 	"strings"
 	"time"
@@ -226,6 +227,29 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 	})
 }
 
+// ApplyEmptyResponsePolicy 按配置处理被安全策略拦截或无输出的响应，返回调整后的额度与日志说明
+// 以工具调用结束的响应即使没有文本输出也按正常响应计费
+func ApplyEmptyResponsePolicy(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, promptTokens int, completionTokens int, quota int) (int, string) {
+	claudeSettings := model_setting.GetClaudeSettings()
+	if common.GetContextKeyBool(ctx, constant.ContextKeyResponseBlocked) && claudeSettings.RefundBlockedResponse {
+		// 被安全策略拦截的响应全额退还
+		return 0, "（安全策略拦截，已退还）"
+	}
+	if promptTokens+completionTokens == 0 || completionTokens > 0 || common.GetContextKeyBool(ctx, constant.ContextKeyResponseToolUse) {
+		return quota, ""
+	}
+	// 上游无输出时默认仍按输入 token 计费，可配置为不计费，两种情况均不低于最低计费额度
+	common.LogWarn(ctx, fmt.Sprintf("Empty upstream response | Channel:%d | Model:%s | PromptTokens:%d",
+		relayInfo.ChannelId, relayInfo.OriginModelName, promptTokens))
+	if claudeSettings.ChargeInputOnEmptyResponse {
+		return max(quota, claudeSettings.EmptyResponseMinQuota), "（上游无输出）"
+	}
+	if claudeSettings.EmptyResponseMinQuota > 0 {
+		return claudeSettings.EmptyResponseMinQuota, "（上游无输出，按最低额度计费）"
+	}
+	return 0, "（上游无输出，不计费）"
+}
+
 func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	usage *dto.Usage, preConsumedQuota int, userQuota int, priceData helper.PriceData, extraContent string) {
 
@@ -275,7 +299,8 @@ func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 
 	totalTokens := promptTokens + completionTokens
 
-	var logContent string
+	quota, logContent := ApplyEmptyResponsePolicy(ctx, relayInfo, promptTokens, completionTokens, quota)
+
 	// record all the consume log even if quota is 0
 	if totalTokens == 0 {
 		// in this case, must be some error happened
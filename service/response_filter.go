@@ -0,0 +1,188 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"one-api/dto"
+	"one-api/types"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ResponseMatcher 返回文本中所有命中区间，格式同 regexp.FindAllStringIndex
+type ResponseMatcher func(text string) [][]int
+
+var (
+	responseMatchers     = map[string]ResponseMatcher{}
+	responseMatchersLock sync.RWMutex
+
+	responseFilterRegexps sync.Map
+)
+
+// RegisterResponseMatcher 注册响应过滤匹配器，渠道通过 response_filters 中的 matcher 名称引用
+func RegisterResponseMatcher(name string, matcher ResponseMatcher) {
+	responseMatchersLock.Lock()
+	defer responseMatchersLock.Unlock()
+	responseMatchers[name] = matcher
+}
+
+func getResponseMatcher(rule *dto.ResponseFilterRule) (ResponseMatcher, error) {
+	if rule.Matcher != "" {
+		responseMatchersLock.RLock()
+		defer responseMatchersLock.RUnlock()
+		matcher, ok := responseMatchers[rule.Matcher]
+		if !ok {
+			return nil, fmt.Errorf("response matcher %s is not registered", rule.Matcher)
+		}
+		return matcher, nil
+	}
+	var re *regexp.Regexp
+	if cached, ok := responseFilterRegexps.Load(rule.Pattern); ok {
+		re = cached.(*regexp.Regexp)
+	} else {
+		compiled, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		responseFilterRegexps.Store(rule.Pattern, compiled)
+		re = compiled
+	}
+	return func(text string) [][]int {
+		return re.FindAllStringIndex(text, -1)
+	}, nil
+}
+
+type responseFilterRule struct {
+	dto.ResponseFilterRule
+	match ResponseMatcher
+}
+
+// ResponseFilter 渠道配置的响应内容过滤器
+type ResponseFilter struct {
+	rules  []responseFilterRule
+	window int
+}
+
+// NewResponseFilter 根据渠道设置创建过滤器，未配置规则时返回 nil
+func NewResponseFilter(setting dto.ChannelSettings) (*ResponseFilter, error) {
+	if len(setting.ResponseFilters) == 0 {
+		return nil, nil
+	}
+	filter := &ResponseFilter{window: setting.GetResponseFilterWindow()}
+	for i := range setting.ResponseFilters {
+		rule := setting.ResponseFilters[i]
+		match, err := getResponseMatcher(&rule)
+		if err != nil {
+			return nil, err
+		}
+		filter.rules = append(filter.rules, responseFilterRule{ResponseFilterRule: rule, match: match})
+	}
+	return filter, nil
+}
+
+func newResponseFilteredError(rule *responseFilterRule) *types.NewAPIError {
+	message := rule.ErrorMessage
+	if message == "" {
+		message = "response blocked by content filter"
+	}
+	statusCode := rule.ErrorStatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusBadRequest
+	}
+	return types.NewErrorWithStatusCode(errors.New(message), types.ErrorCodeResponseFiltered, statusCode)
+}
+
+// Apply 按规则依次过滤文本，命中拦截规则时返回错误，changed 表示文本是否被替换
+func (f *ResponseFilter) Apply(text string) (result string, changed bool, err *types.NewAPIError) {
+	for i := range f.rules {
+		rule := &f.rules[i]
+		spans := rule.match(text)
+		if len(spans) == 0 {
+			continue
+		}
+		if rule.Action == dto.ResponseFilterActionBlock {
+			return "", false, newResponseFilteredError(rule)
+		}
+		replacement := rule.Replacement
+		if replacement == "" {
+			replacement = dto.DefaultResponseFilterReplacement
+		}
+		var sb strings.Builder
+		last := 0
+		for _, span := range spans {
+			sb.WriteString(text[last:span[0]])
+			sb.WriteString(replacement)
+			last = span[1]
+		}
+		sb.WriteString(text[last:])
+		text = sb.String()
+		changed = true
+	}
+	return text, changed, nil
+}
+
+// safeCut 计算可以输出的位置，末尾 window 字节暂不输出，且不切断已有的命中或多字节字符
+func (f *ResponseFilter) safeCut(text string) int {
+	cut := len(text) - f.window
+	if cut <= 0 {
+		return 0
+	}
+	for moved := true; moved && cut > 0; {
+		moved = false
+		for i := range f.rules {
+			for _, span := range f.rules[i].match(text) {
+				if span[0] < cut && span[1] > cut {
+					cut = span[0]
+					moved = true
+				}
+			}
+		}
+	}
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return cut
+}
+
+// ResponseFilterStream 单个内容块的流式过滤状态，未输出的文本缓存在 pending 中
+type ResponseFilterStream struct {
+	filter  *ResponseFilter
+	pending string
+}
+
+// NewStream 创建流式过滤状态，每个内容块使用独立的状态
+func (f *ResponseFilter) NewStream() *ResponseFilterStream {
+	return &ResponseFilterStream{filter: f}
+}
+
+// Write 追加增量文本，返回过滤后可以输出的部分
+func (s *ResponseFilterStream) Write(text string) (string, *types.NewAPIError) {
+	s.pending += text
+	// 拦截规则对缓存中的全部文本生效，不必等到输出时才发现
+	if _, _, err := s.filter.Apply(s.pending); err != nil {
+		return "", err
+	}
+	cut := s.filter.safeCut(s.pending)
+	if cut == 0 {
+		return "", nil
+	}
+	out, _, err := s.filter.Apply(s.pending[:cut])
+	if err != nil {
+		return "", err
+	}
+	s.pending = s.pending[cut:]
+	return out, nil
+}
+
+// Flush 内容块结束时输出缓存中剩余的文本
+func (s *ResponseFilterStream) Flush() (string, *types.NewAPIError) {
+	if s.pending == "" {
+		return "", nil
+	}
+	out, _, err := s.filter.Apply(s.pending)
+	s.pending = ""
+	return out, err
+}
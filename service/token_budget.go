@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"one-api/common"
+	"sync"
+	"time"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+// 每日 token 额度按周期计数，周期从每天的重置时刻开始，计数键包含周期起始日期，跨周期后自然清零
+
+// TokenBudgetReservation 一次请求预占的 token 额度，请求结束后按实际用量校正
+type TokenBudgetReservation struct {
+	key    string
+	period string
+	tokens int
+}
+
+type tokenBudgetUsage struct {
+	period string
+	end    time.Time
+	used   int64
+}
+
+var (
+	tokenBudgetStore           = make(map[string]*tokenBudgetUsage)
+	tokenBudgetMutex           sync.Mutex
+	tokenBudgetCleanupTaskOnce sync.Once
+)
+
+// getTokenBudgetPeriod 返回 now 所在周期的起止时间，resetHour 为每天重置的时刻
+func getTokenBudgetPeriod(now time.Time, resetHour int) (time.Time, time.Time) {
+	start := time.Date(now.Year(), now.Month(), now.Day(), resetHour, 0, 0, 0, now.Location())
+	if now.Before(start) {
+		start = start.AddDate(0, 0, -1)
+	}
+	return start, start.AddDate(0, 0, 1)
+}
+
+// ReserveTokenBudget 从用户当前周期的额度中预占 tokens，超过 budget 时返回 false 及已用量；budget <= 0 表示不限制
+func ReserveTokenBudget(userId int, tokens int, budget int, resetHour int) (*TokenBudgetReservation, int64, bool, error) {
+	if budget <= 0 || tokens <= 0 {
+		return nil, 0, true, nil
+	}
+	start, end := getTokenBudgetPeriod(time.Now(), resetHour)
+	period := start.Format("2006010215")
+	userKey := fmt.Sprintf("user:%d", userId)
+	reservation := &TokenBudgetReservation{period: period, tokens: tokens}
+	if common.RedisEnabled {
+		ctx := context.Background()
+		reservation.key = fmt.Sprintf("token_budget:%s:%s", userKey, period)
+		pipe := common.RDB.TxPipeline()
+		incr := pipe.IncrBy(ctx, reservation.key, int64(tokens))
+		pipe.ExpireAt(ctx, reservation.key, end.Add(time.Hour))
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, 0, false, err
+		}
+		if used := incr.Val(); used > int64(budget) {
+			common.RDB.DecrBy(ctx, reservation.key, int64(tokens))
+			return nil, used - int64(tokens), false, nil
+		}
+		return reservation, 0, true, nil
+	}
+
+	reservation.key = userKey
+	tokenBudgetCleanupTaskOnce.Do(startTokenBudgetCleanupTask)
+	tokenBudgetMutex.Lock()
+	defer tokenBudgetMutex.Unlock()
+	usage, ok := tokenBudgetStore[userKey]
+	if !ok || usage.period != period {
+		usage = &tokenBudgetUsage{period: period, end: end}
+		tokenBudgetStore[userKey] = usage
+	}
+	if usage.used+int64(tokens) > int64(budget) {
+		return nil, usage.used, false, nil
+	}
+	usage.used += int64(tokens)
+	return reservation, 0, true, nil
+}
+
+func startTokenBudgetCleanupTask() {
+	gopool.Go(func() {
+		for {
+			time.Sleep(time.Minute)
+			cleanupTokenBudgetStore(time.Now())
+		}
+	})
+}
+
+// cleanupTokenBudgetStore 清理已结束周期的计数，这些计数在下次预占时也会被重置
+func cleanupTokenBudgetStore(now time.Time) {
+	tokenBudgetMutex.Lock()
+	defer tokenBudgetMutex.Unlock()
+	for key, usage := range tokenBudgetStore {
+		if !now.Before(usage.end) {
+			delete(tokenBudgetStore, key)
+		}
+	}
+}
+
+// ReconcileTokenBudget 按实际消耗的 tokens 校正预占额度，actualTokens 为 0 时即完全释放
+func ReconcileTokenBudget(reservation *TokenBudgetReservation, actualTokens int) {
+	if reservation == nil {
+		return
+	}
+	delta := int64(actualTokens - reservation.tokens)
+	reservation.tokens = actualTokens
+	if delta == 0 {
+		return
+	}
+	if common.RedisEnabled {
+		if err := common.RDB.IncrBy(context.Background(), reservation.key, delta).Err(); err != nil {
+			common.SysError("failed to reconcile token budget reservation: " + err.Error())
+		}
+		return
+	}
+
+	tokenBudgetMutex.Lock()
+	defer tokenBudgetMutex.Unlock()
+	// 周期已切换时旧周期的计数已清零，无需校正
+	if usage, ok := tokenBudgetStore[reservation.key]; ok && usage.period == reservation.period {
+		usage.used = max(usage.used+delta, 0)
+	}
+}
@@ -0,0 +1,29 @@
+package service
+
+import (
+	"one-api/common"
+	"testing"
+	"time"
+)
+
+func TestTokenBudgetStoreEvictsEndedPeriods(t *testing.T) {
+	common.RedisEnabled = false
+	const userId = 7301
+	if _, _, ok, err := ReserveTokenBudget(userId, 100, 1000, 0); err != nil || !ok {
+		t.Fatalf("ReserveTokenBudget: ok=%v err=%v", ok, err)
+	}
+	cleanupTokenBudgetStore(time.Now())
+	tokenBudgetMutex.Lock()
+	_, ok := tokenBudgetStore["user:7301"]
+	tokenBudgetMutex.Unlock()
+	if !ok {
+		t.Fatal("the current period must be kept")
+	}
+	cleanupTokenBudgetStore(time.Now().Add(25 * time.Hour))
+	tokenBudgetMutex.Lock()
+	_, ok = tokenBudgetStore["user:7301"]
+	tokenBudgetMutex.Unlock()
+	if ok {
+		t.Error("an ended period should be evicted")
+	}
+}
@@ -180,19 +180,40 @@ func CountTokenChatRequest(info *relaycommon.RelayInfo, request dto.GeneralOpenA
 }
 
 func CountTokenClaudeRequest(request dto.ClaudeRequest, model string) (int, error) {
+	tokenEncoder := getTokenEncoder(model)
+	return countTokenClaudeRequest(request, func(text string) int {
+		return getTokenNum(tokenEncoder, text)
+	})
+}
+
+// EstimateTokenClaudeRequest 按字符数粗略估算输入 token，不调用 tokenizer，仅用于预扣费等无需精确值的场景
+func EstimateTokenClaudeRequest(request dto.ClaudeRequest) (int, error) {
+	return countTokenClaudeRequest(request, estimateTextTokens)
+}
+
+// estimateTextTokens 按平均每个 token 约 4 个字节估算
+func estimateTextTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+func countTokenClaudeRequest(request dto.ClaudeRequest, countText func(string) int) (int, error) {
 	tkm := 0
 
 	// Count tokens in messages
-	msgTokens, err := CountTokenClaudeMessages(request.Messages, model, request.Stream)
+	msgTokens, err := countTokenClaudeMessages(request.Messages, countText)
 	if err != nil {
 		return 0, err
 	}
 	tkm += msgTokens
 
 	// Count tokens in system message
-	if request.System != "" {
-		systemTokens := CountTokenInput(request.System, model)
-		tkm += systemTokens
+	if blocks, ok := request.System.([]dto.ClaudeMediaMessage); ok {
+		// 注入渠道系统提示词后为结构体数组，按文本内容计算
+		for _, block := range blocks {
+			tkm += countText(block.GetText())
+		}
+	} else if request.System != "" {
+		tkm += countText(tokenInputText(request.System))
 	}
 
 	if request.Tools != nil {
@@ -203,7 +224,7 @@ func CountTokenClaudeRequest(request dto.ClaudeRequest, model string) (int, erro
 				if err1 != nil {
 					return 0, fmt.Errorf("tools: Input should be a valid list: %v", err)
 				}
-				toolTokens, err2 := CountTokenClaudeTools(parsedTools, model)
+				toolTokens, err2 := countTokenClaudeTools(parsedTools, countText)
 				if err2 != nil {
 					return 0, fmt.Errorf("tools: %v", err)
 				}
@@ -219,13 +240,19 @@ func CountTokenClaudeRequest(request dto.ClaudeRequest, model string) (int, erro
 
 func CountTokenClaudeMessages(messages []dto.ClaudeMessage, model string, stream bool) (int, error) {
 	tokenEncoder := getTokenEncoder(model)
+	return countTokenClaudeMessages(messages, func(text string) int {
+		return getTokenNum(tokenEncoder, text)
+	})
+}
+
+func countTokenClaudeMessages(messages []dto.ClaudeMessage, countText func(string) int) (int, error) {
 	tokenNum := 0
 
 	for _, message := range messages {
 		// Count tokens for role
-		tokenNum += getTokenNum(tokenEncoder, message.Role)
+		tokenNum += countText(message.Role)
 		if message.IsStringContent() {
-			tokenNum += getTokenNum(tokenEncoder, message.GetStringContent())
+			tokenNum += countText(message.GetStringContent())
 		} else {
 			content, err := message.ParseContent()
 			if err != nil {
@@ -234,24 +261,21 @@ func CountTokenClaudeMessages(messages []dto.ClaudeMessage, model string, stream
 			for _, mediaMessage := range content {
 				switch mediaMessage.Type {
 				case "text":
-					tokenNum += getTokenNum(tokenEncoder, mediaMessage.GetText())
+					tokenNum += countText(mediaMessage.GetText())
 				case "image":
 					//imageTokenNum, err := getClaudeImageToken(mediaMsg.Source, model, stream)
 					//if err != nil {
 					//	return 0, err
 					//}
-					tokenNum += 1000
+					tokenNum += claudeImageTokens
 				case "tool_use":
 					if mediaMessage.Input != nil {
-						tokenNum += getTokenNum(tokenEncoder, mediaMessage.Name)
+						tokenNum += countText(mediaMessage.Name)
 						inputJSON, _ := json.Marshal(mediaMessage.Input)
-						tokenNum += getTokenNum(tokenEncoder, string(inputJSON))
+						tokenNum += countText(string(inputJSON))
 					}
 				case "tool_result":
-					if mediaMessage.Content != nil {
-						contentJSON, _ := json.Marshal(mediaMessage.Content)
-						tokenNum += getTokenNum(tokenEncoder, string(contentJSON))
-					}
+					tokenNum += countClaudeToolResultTokens(&mediaMessage, countText)
 				}
 			}
 		}
@@ -263,19 +287,51 @@ func CountTokenClaudeMessages(messages []dto.ClaudeMessage, model string, stream
 	return tokenNum, nil
 }
 
+// claudeImageTokens 图片按固定 token 数估算
+const claudeImageTokens = 1000
+
+// countClaudeToolResultTokens 计算 tool_result 的 token，内容中的图片（如截图）按图片估算，不计算 base64 数据
+func countClaudeToolResultTokens(toolResult *dto.ClaudeMediaMessage, countText func(string) int) int {
+	if toolResult.Content == nil {
+		return 0
+	}
+	if toolResult.IsStringContent() {
+		return countText(toolResult.GetStringContent())
+	}
+	tokenNum := 0
+	for _, block := range toolResult.ParseMediaContent() {
+		switch block.Type {
+		case "text":
+			tokenNum += countText(block.GetText())
+		case "image":
+			tokenNum += claudeImageTokens
+		default:
+			blockJSON, _ := json.Marshal(block)
+			tokenNum += countText(string(blockJSON))
+		}
+	}
+	return tokenNum
+}
+
 func CountTokenClaudeTools(tools []dto.Tool, model string) (int, error) {
 	tokenEncoder := getTokenEncoder(model)
+	return countTokenClaudeTools(tools, func(text string) int {
+		return getTokenNum(tokenEncoder, text)
+	})
+}
+
+func countTokenClaudeTools(tools []dto.Tool, countText func(string) int) (int, error) {
 	tokenNum := 0
 
 	for _, tool := range tools {
-		tokenNum += getTokenNum(tokenEncoder, tool.Name)
-		tokenNum += getTokenNum(tokenEncoder, tool.Description)
+		tokenNum += countText(tool.Name)
+		tokenNum += countText(tool.Description)
 
 		schemaJSON, err := json.Marshal(tool.InputSchema)
 		if err != nil {
 			return 0, errors.New(fmt.Sprintf("marshal_tool_schema_fail: %s", err.Error()))
 		}
-		tokenNum += getTokenNum(tokenEncoder, string(schemaJSON))
+		tokenNum += countText(string(schemaJSON))
 	}
 
 	// Add a constant for tool formatting (this may need adjustment based on Claude's exact formatting)
@@ -392,23 +448,24 @@ func CountTokenMessages(info *relaycommon.RelayInfo, messages []dto.Message, mod
 }
 
 func CountTokenInput(input any, model string) int {
+	return CountTextToken(tokenInputText(input), model)
+}
+
+// tokenInputText 将字符串、字符串数组等输入拼接为计算 token 的文本
+func tokenInputText(input any) string {
 	switch v := input.(type) {
 	case string:
-		return CountTextToken(v, model)
+		return v
 	case []string:
-		text := ""
-		for _, s := range v {
-			text += s
-		}
-		return CountTextToken(text, model)
+		return strings.Join(v, "")
 	case []interface{}:
 		text := ""
 		for _, item := range v {
 			text += fmt.Sprintf("%v", item)
 		}
-		return CountTextToken(text, model)
+		return text
 	}
-	return CountTokenInput(fmt.Sprintf("%v", input), model)
+	return fmt.Sprintf("%v", input)
 }
 
 func CountTokenStreamChoices(messages []dto.ChatCompletionsStreamResponseChoice, model string) int {
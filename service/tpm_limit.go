@@ -0,0 +1,164 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"one-api/common"
+	"sync"
+	"time"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/go-redis/redis/v8"
+)
+
+// TPM 限流采用滑动窗口计数：当前分钟的用量加上上一分钟按剩余比例折算的用量
+
+const tpmWindowSeconds = 60
+
+// TPMReservation 一次请求预占的 token 额度，请求结束后按实际用量校正
+type TPMReservation struct {
+	key    string
+	bucket int64
+	tokens int
+}
+
+type tpmBucket struct {
+	current  int64
+	previous int64
+	index    int64
+}
+
+var (
+	tpmStore           = make(map[string]*tpmBucket)
+	tpmMutex           sync.Mutex
+	tpmCleanupTaskOnce sync.Once
+)
+
+func getTPMBucketKey(key string, bucket int64) string {
+	return fmt.Sprintf("tpm:%s:%d", key, bucket)
+}
+
+func getTPMWindow(now time.Time) (bucket int64, previousWeight float64) {
+	bucket = now.Unix() / tpmWindowSeconds
+	elapsed := float64(now.Unix()%tpmWindowSeconds) / tpmWindowSeconds
+	return bucket, 1 - elapsed
+}
+
+// ReserveTPM 从 key 对应的滑动窗口中预占 tokens，超过 limit 时返回 false；limit <= 0 表示不限制
+func ReserveTPM(key string, tokens int, limit int) (*TPMReservation, bool, error) {
+	if limit <= 0 || tokens <= 0 {
+		return nil, true, nil
+	}
+	bucket, previousWeight := getTPMWindow(time.Now())
+	reservation := &TPMReservation{key: key, bucket: bucket, tokens: tokens}
+	if common.RedisEnabled {
+		ok, err := reserveTPMRedis(reservation, limit, previousWeight)
+		if err != nil || !ok {
+			return nil, ok, err
+		}
+		return reservation, true, nil
+	}
+
+	tpmCleanupTaskOnce.Do(startTPMCleanupTask)
+	tpmMutex.Lock()
+	defer tpmMutex.Unlock()
+	b, ok := tpmStore[key]
+	if !ok {
+		b = &tpmBucket{index: bucket}
+		tpmStore[key] = b
+	}
+	rotateTPMBucket(b, bucket)
+	used := float64(b.current) + float64(b.previous)*previousWeight
+	if used+float64(tokens) > float64(limit) {
+		return nil, false, nil
+	}
+	b.current += int64(tokens)
+	return reservation, true, nil
+}
+
+// ReconcileTPM 按实际消耗的 tokens 校正预占额度，actualTokens 为 0 时即完全释放
+func ReconcileTPM(reservation *TPMReservation, actualTokens int) {
+	if reservation == nil {
+		return
+	}
+	delta := int64(actualTokens - reservation.tokens)
+	reservation.tokens = actualTokens
+	if delta == 0 {
+		return
+	}
+	if common.RedisEnabled {
+		ctx := context.Background()
+		if err := common.RDB.IncrBy(ctx, getTPMBucketKey(reservation.key, reservation.bucket), delta).Err(); err != nil {
+			common.SysError("failed to reconcile tpm reservation: " + err.Error())
+		}
+		return
+	}
+
+	tpmMutex.Lock()
+	defer tpmMutex.Unlock()
+	b, ok := tpmStore[reservation.key]
+	if !ok {
+		return
+	}
+	switch reservation.bucket {
+	case b.index:
+		b.current = max(b.current+delta, 0)
+	case b.index - 1:
+		b.previous = max(b.previous+delta, 0)
+	}
+}
+
+func startTPMCleanupTask() {
+	gopool.Go(func() {
+		for {
+			time.Sleep(time.Minute)
+			cleanupTPMStore(time.Now())
+		}
+	})
+}
+
+// cleanupTPMStore 清理滑动窗口已过期的计数，这些计数在下次预占时也会被清零
+func cleanupTPMStore(now time.Time) {
+	bucket, _ := getTPMWindow(now)
+	tpmMutex.Lock()
+	defer tpmMutex.Unlock()
+	for key, b := range tpmStore {
+		if b.index < bucket-1 {
+			delete(tpmStore, key)
+		}
+	}
+}
+
+func rotateTPMBucket(b *tpmBucket, bucket int64) {
+	switch {
+	case bucket == b.index:
+	case bucket == b.index+1:
+		b.previous = b.current
+		b.current = 0
+	default:
+		b.previous = 0
+		b.current = 0
+	}
+	b.index = bucket
+}
+
+func reserveTPMRedis(reservation *TPMReservation, limit int, previousWeight float64) (bool, error) {
+	ctx := context.Background()
+	currentKey := getTPMBucketKey(reservation.key, reservation.bucket)
+	previous, err := common.RDB.Get(ctx, getTPMBucketKey(reservation.key, reservation.bucket-1)).Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return false, err
+	}
+	pipe := common.RDB.TxPipeline()
+	incr := pipe.IncrBy(ctx, currentKey, int64(reservation.tokens))
+	pipe.Expire(ctx, currentKey, 2*tpmWindowSeconds*time.Second)
+	if _, err = pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	if float64(incr.Val())+float64(previous)*previousWeight > float64(limit) {
+		common.RDB.DecrBy(ctx, currentKey, int64(reservation.tokens))
+		return false, nil
+	}
+	return true, nil
+}
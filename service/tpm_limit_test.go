@@ -0,0 +1,63 @@
+package service
+
+import (
+	"one-api/common"
+	"testing"
+	"time"
+)
+
+func TestTPMStoreEvictsExpiredWindows(t *testing.T) {
+	common.RedisEnabled = false
+	const key = "user:7201"
+	if _, ok, err := ReserveTPM(key, 100, 1000); err != nil || !ok {
+		t.Fatalf("ReserveTPM: ok=%v err=%v", ok, err)
+	}
+	cleanupTPMStore(time.Now())
+	tpmMutex.Lock()
+	_, ok := tpmStore[key]
+	tpmMutex.Unlock()
+	if !ok {
+		t.Fatal("the current window must be kept")
+	}
+	cleanupTPMStore(time.Now().Add(2 * tpmWindowSeconds * time.Second))
+	tpmMutex.Lock()
+	_, ok = tpmStore[key]
+	tpmMutex.Unlock()
+	if ok {
+		t.Error("an expired window should be evicted")
+	}
+}
+
+func TestReserveTPMDrivesWindowToLimit(t *testing.T) {
+	common.RedisEnabled = false
+	const key = "user:7202"
+	const limit = 1000
+
+	first, ok, err := ReserveTPM(key, 600, limit)
+	if err != nil || !ok {
+		t.Fatalf("first reservation: ok=%v err=%v", ok, err)
+	}
+	second, ok, err := ReserveTPM(key, 400, limit)
+	if err != nil || !ok {
+		t.Fatalf("reservation up to the limit: ok=%v err=%v", ok, err)
+	}
+	if _, ok, _ := ReserveTPM(key, 1, limit); ok {
+		t.Fatal("a reservation beyond the limit must be rejected")
+	}
+
+	// 实际用量少于预占额度时归还差额
+	ReconcileTPM(first, 100)
+	if _, ok, _ := ReserveTPM(key, 500, limit); !ok {
+		t.Error("the reconciled difference should be reservable again")
+	}
+	if _, ok, _ := ReserveTPM(key, 1, limit); ok {
+		t.Error("the window should be full again")
+	}
+	ReconcileTPM(second, 0)
+	if _, ok, _ := ReserveTPM(key, 400, limit); !ok {
+		t.Error("a released reservation should be reservable again")
+	}
+	if _, ok, _ := ReserveTPM(key, 1, 0); !ok {
+		t.Error("a zero limit must not restrict requests")
+	}
+}
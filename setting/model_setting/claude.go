@@ -2,7 +2,11 @@ package model_setting
 
 import (
 	"net/http"
+	"one-api/common"
 	"one-api/setting/config"
+	"strconv"
+	"strings"
+	"time"
 )
 
 //var claudeHeadersSettings = map[string][]string{}
@@ -17,6 +21,44 @@ type ClaudeSettings struct {
 	DefaultMaxTokens                      map[string]int                 `json:"default_max_tokens"`
 	ThinkingAdapterEnabled                bool                           `json:"thinking_adapter_enabled"`
 	ThinkingAdapterBudgetTokensPercentage float64                        `json:"thinking_adapter_budget_tokens_percentage"`
+	ThinkingBudgetTokensPercentages       map[string]float64             `json:"thinking_budget_tokens_percentages"` // 按模型配置的思考预算比例，未配置时使用全局比例
+	ThinkingMaxBudgetTokens               map[string]int                 `json:"thinking_max_budget_tokens"`         // 按模型配置的思考预算上限
+	ConcurrencyLimits                     map[string]int                 `json:"concurrency_limits"`                 // 每个渠道下各模型的最大并发数，支持 default，0 表示不限制
+	ConcurrencyWaitSeconds                int                            `json:"concurrency_wait_seconds"`           // 达到并发上限时的最长排队秒数，0 表示立即失败
+	IdempotencyEnabled                    bool                           `json:"idempotency_enabled"`
+	IdempotencyTTLSeconds                 int                            `json:"idempotency_ttl_seconds"`
+	MaxTokensTruncationLogEnabled         bool                           `json:"max_tokens_truncation_log_enabled"`
+	TPMLimitEnabled                       bool                           `json:"tpm_limit_enabled"`
+	UserTPMLimit                          int                            `json:"user_tpm_limit"`                 // 每个用户每分钟 token 上限，0 表示不限制
+	ChannelTPMLimit                       int                            `json:"channel_tpm_limit"`              // 每个渠道每分钟 token 上限，0 表示不限制
+	ChargeInputOnEmptyResponse            bool                           `json:"charge_input_on_empty_response"` // 无输出时是否仍按输入 token 计费
+	EmptyResponseMinQuota                 int                            `json:"empty_response_min_quota"`       // 无输出（非工具调用）响应的最低计费额度
+	SlowRequestThresholdMs                int                            `json:"slow_request_threshold_ms"`      // 超过该耗时的请求记录各阶段耗时明细，0 表示不区分
+	CompletedLogPercentage                float64                        `json:"completed_log_percentage"`       // 普通请求完成日志的采样比例，0-100
+	OverloadedRetryTimes                  int                            `json:"overloaded_retry_times"`         // 上游过载（529）时的重试次数，0 表示不重试
+	OverloadedRetryDelayMs                int                            `json:"overloaded_retry_delay_ms"`      // 过载重试的初始退避时间，每次翻倍
+	OverloadedMaxDelayMs                  int                            `json:"overloaded_max_delay_ms"`        // 过载重试的最长退避时间
+	RefundBlockedResponse                 bool                           `json:"refund_blocked_response"`        // 被安全策略拦截的响应是否退还全部费用
+	MetadataUserIdEnabled                 bool                           `json:"metadata_user_id_enabled"`       // 是否向上游发送哈希后的用户 id（metadata.user_id）
+	MetadataUserIdOverride                bool                           `json:"metadata_user_id_override"`      // 是否覆盖客户端自带的 metadata.user_id
+	MetadataUserIdSalt                    string                         `json:"metadata_user_id_salt"`          // 哈希密钥，为空时使用 CRYPTO_SECRET
+	ShadowEnabled                         bool                           `json:"shadow_enabled"`                 // 是否将部分请求镜像到影子渠道用于效果对比
+	ShadowPercentage                      float64                        `json:"shadow_percentage"`              // 镜像请求的采样比例，0-100
+	ShadowChannelId                       int                            `json:"shadow_channel_id"`              // 影子请求使用的渠道
+	ShadowModel                           string                         `json:"shadow_model"`                   // 影子请求使用的模型，为空时与原请求相同
+	ContextWindowTokens                   map[string]int                 `json:"context_window_tokens"`          // 各模型的上下文窗口，支持 default，未配置时不校验
+	SLAThresholdMs                        int                            `json:"sla_threshold_ms"`               // 响应时间 SLA，超过时仅标记违约，不中断请求，0 表示不检测
+	ReasoningEffortBudget                 map[string]int                 `json:"reasoning_effort_budget"`        // reasoning_effort（low/medium/high）对应的思考预算
+	RetryBudgetAttempts                   int                            `json:"retry_budget_attempts"`          // 单个请求过载重试、区域故障转移与备用模型切换的总次数上限，0 表示不限制
+	RetryBudgetDelayMs                    int                            `json:"retry_budget_delay_ms"`          // 单个请求因重试增加的总耗时上限，0 表示不限制
+	FastTokenEstimate                     bool                           `json:"fast_token_estimate"`            // 输入 token 按字符数估算，不调用 tokenizer，结算以上游返回的用量为准
+	StrictRequestFields                   bool                           `json:"strict_request_fields"`          // 拒绝包含未知字段的请求（如 max_token 拼写错误），默认忽略未知字段
+	NoThinkingModels                      []string                       `json:"no_thinking_models"`             // 不支持扩展思考的模型，按前缀匹配
+	NoThinkingReject                      bool                           `json:"no_thinking_reject"`             // 不支持思考的模型开启思考时返回错误，否则移除思考配置
+	CompletionTokenGuardEnabled           bool                           `json:"completion_token_guard_enabled"` // 上游返回的输出用量超过 max_tokens 时提前结束流，并按 max_tokens 结算
+	UserDailyTokenBudget                  int                            `json:"user_daily_token_budget"`        // 每个用户每天的 token 上限（输入加输出），0 表示不限制
+	TokenBudgetResetHour                  int                            `json:"token_budget_reset_hour"`        // 每日 token 额度重置的时刻（服务器本地时间 0-23 点）
+	ReturnStopSequence                    bool                           `json:"return_stop_sequence"`           // OpenAI 格式响应是否在 choice 中返回触发结束的 stop_sequence
 }
 
 // 默认配置
@@ -27,6 +69,58 @@ var defaultClaudeSettings = ClaudeSettings{
 		"default": 8192,
 	},
 	ThinkingAdapterBudgetTokensPercentage: 0.8,
+	IdempotencyEnabled:                    false,
+	IdempotencyTTLSeconds:                 600,
+	MaxTokensTruncationLogEnabled:         false,
+	TPMLimitEnabled:                       false,
+	UserTPMLimit:                          0,
+	ChannelTPMLimit:                       0,
+	ChargeInputOnEmptyResponse:            true,
+	RefundBlockedResponse:                 true,
+	MetadataUserIdEnabled:                 false,
+	MetadataUserIdOverride:                false,
+	MetadataUserIdSalt:                    "",
+	ThinkingBudgetTokensPercentages:       map[string]float64{},
+	ThinkingMaxBudgetTokens: map[string]int{
+		"claude-3-7-sonnet-20250219": 64000,
+		"claude-sonnet-4-20250514":   64000,
+		"claude-opus-4-20250514":     32000,
+	},
+	ConcurrencyLimits:      map[string]int{},
+	ConcurrencyWaitSeconds: 0,
+	ShadowEnabled:          false,
+	ShadowPercentage:       0,
+	ShadowChannelId:        0,
+	ShadowModel:            "",
+	EmptyResponseMinQuota:  0,
+	SlowRequestThresholdMs: 0,
+	CompletedLogPercentage: 100,
+	OverloadedRetryTimes:   2,
+	OverloadedRetryDelayMs: 500,
+	OverloadedMaxDelayMs:   4000,
+	ContextWindowTokens:    map[string]int{},
+	SLAThresholdMs:         0,
+	ReasoningEffortBudget: map[string]int{
+		"low":    1280,
+		"medium": 2048,
+		"high":   4096,
+	},
+	RetryBudgetAttempts:         0,
+	RetryBudgetDelayMs:          0,
+	FastTokenEstimate:           false,
+	StrictRequestFields:         false,
+	CompletionTokenGuardEnabled: false,
+	UserDailyTokenBudget:        0,
+	TokenBudgetResetHour:        0,
+	ReturnStopSequence:          false,
+	NoThinkingModels: []string{
+		"claude-3-haiku",
+		"claude-3-sonnet",
+		"claude-3-opus",
+		"claude-3-5-haiku",
+		"claude-3-5-sonnet",
+	},
+	NoThinkingReject: false,
 }
 
 // 全局实例
@@ -63,3 +157,71 @@ func (c *ClaudeSettings) GetDefaultMaxTokens(model string) int {
 	}
 	return c.DefaultMaxTokens["default"]
 }
+
+// GetContextWindowTokens 获取模型的上下文窗口大小
+func (c *ClaudeSettings) GetContextWindowTokens(model string) int {
+	if tokens, ok := c.ContextWindowTokens[model]; ok {
+		return tokens
+	}
+	return c.ContextWindowTokens["default"]
+}
+
+// GetThinkingBudgetTokens 按模型的思考预算比例计算预算，并限制在模型允许的范围内
+func (c *ClaudeSettings) GetThinkingBudgetTokens(model string, maxTokens int) int {
+	percentage := c.ThinkingAdapterBudgetTokensPercentage
+	if p, ok := c.ThinkingBudgetTokensPercentages[model]; ok && p > 0 && p < 1 {
+		percentage = p
+	}
+	budget := int(float64(maxTokens) * percentage)
+	if maxBudget, ok := c.ThinkingMaxBudgetTokens[model]; ok && maxBudget > 0 && budget > maxBudget {
+		budget = maxBudget
+	}
+	// BudgetTokens 必须不小于 1024
+	return max(budget, 1024)
+}
+
+// GetReasoningEffortBudgetTokens 获取 reasoning_effort 对应的思考预算，并限制在模型允许的范围内，未配置的等级返回 false
+func (c *ClaudeSettings) GetReasoningEffortBudgetTokens(model string, effort string) (int, bool) {
+	budget, ok := c.ReasoningEffortBudget[effort]
+	if !ok || budget <= 0 {
+		return 0, false
+	}
+	if maxBudget, ok := c.ThinkingMaxBudgetTokens[model]; ok && maxBudget > 0 && budget > maxBudget {
+		budget = maxBudget
+	}
+	return max(budget, 1024), true
+}
+
+// SupportsThinking 模型是否支持扩展思考
+func (c *ClaudeSettings) SupportsThinking(model string) bool {
+	for _, prefix := range c.NoThinkingModels {
+		if strings.HasPrefix(model, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// GetConcurrencyLimit 获取模型的并发上限，0 表示不限制
+func (c *ClaudeSettings) GetConcurrencyLimit(model string) int {
+	if limit, ok := c.ConcurrencyLimits[model]; ok {
+		return limit
+	}
+	return c.ConcurrencyLimits["default"]
+}
+
+// GetIdempotencyTTL 获取幂等键缓存时长
+func (c *ClaudeSettings) GetIdempotencyTTL() time.Duration {
+	if c.IdempotencyTTLSeconds <= 0 {
+		return 10 * time.Minute
+	}
+	return time.Duration(c.IdempotencyTTLSeconds) * time.Second
+}
+
+// HashMetadataUserId 获取发送给上游的哈希用户 id
+func (c *ClaudeSettings) HashMetadataUserId(userId int) string {
+	if c.MetadataUserIdSalt == "" {
+		return common.GenerateHMAC(strconv.Itoa(userId))
+	}
+	return common.GenerateHMACWithKey([]byte(c.MetadataUserIdSalt), strconv.Itoa(userId))
+}
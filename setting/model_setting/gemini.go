@@ -11,6 +11,8 @@ type GeminiSettings struct {
 	SupportedImagineModels                []string          `json:"supported_imagine_models"`
 	ThinkingAdapterEnabled                bool              `json:"thinking_adapter_enabled"`
 	ThinkingAdapterBudgetTokensPercentage float64           `json:"thinking_adapter_budget_tokens_percentage"`
+	ReasoningEffortBudget                 map[string]int    `json:"reasoning_effort_budget"`       // reasoning_effort（low/medium/high）对应的 thinkingBudget
+	MalformedFunctionCallRetry            bool              `json:"malformed_function_call_retry"` // 返回 MALFORMED_FUNCTION_CALL 时追加修复提示重试一次，否则直接返回错误
 }
 
 // 默认配置
@@ -29,6 +31,12 @@ var defaultGeminiSettings = GeminiSettings{
 	},
 	ThinkingAdapterEnabled:                false,
 	ThinkingAdapterBudgetTokensPercentage: 0.6,
+	ReasoningEffortBudget: map[string]int{
+		"low":    1024,
+		"medium": 8192,
+		"high":   24576,
+	},
+	MalformedFunctionCallRetry: false,
 }
 
 // 全局实例
@@ -60,6 +68,12 @@ func GetGeminiVersionSetting(key string) string {
 	return geminiSettings.VersionSettings["default"]
 }
 
+// GetReasoningEffortBudget 获取 reasoning_effort 对应的思考预算，未配置的等级返回 false
+func (g *GeminiSettings) GetReasoningEffortBudget(effort string) (int, bool) {
+	budget, ok := g.ReasoningEffortBudget[effort]
+	return budget, ok
+}
+
 func IsGeminiModelSupportImagine(model string) bool {
 	for _, v := range geminiSettings.SupportedImagineModels {
 		if v == model {
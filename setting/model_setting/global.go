@@ -6,11 +6,16 @@ import (
 
 type GlobalSettings struct {
 	PassThroughRequestEnabled bool `json:"pass_through_request_enabled"`
+	MaxThinkingBudgetTokens   int  `json:"max_thinking_budget_tokens"` // 思考预算上限，0 表示不限制
+	// 流式响应中途出错时，保留已输出的内容并补发错误事件，而不是整体返回错误
+	StreamPartialContentOnError bool `json:"stream_partial_content_on_error"`
 }
 
 // 默认配置
 var defaultOpenaiSettings = GlobalSettings{
-	PassThroughRequestEnabled: false,
+	PassThroughRequestEnabled:   false,
+	MaxThinkingBudgetTokens:     0,
+	StreamPartialContentOnError: false,
 }
 
 // 全局实例
@@ -0,0 +1,44 @@
+package model_setting
+
+import (
+	"one-api/setting/config"
+)
+
+// ModelAliasSettings 定义模型别名，别名在选择渠道前解析为实际模型
+type ModelAliasSettings struct {
+	// 分组 -> 别名 -> 实际模型，default 对所有分组生效
+	GroupAliases map[string]map[string]string `json:"group_aliases"`
+}
+
+// 默认配置
+var defaultModelAliasSettings = ModelAliasSettings{
+	GroupAliases: map[string]map[string]string{},
+}
+
+// 全局实例
+var modelAliasSettings = defaultModelAliasSettings
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("model_alias", &modelAliasSettings)
+}
+
+// GetModelAliasSettings 获取模型别名配置
+func GetModelAliasSettings() *ModelAliasSettings {
+	return &modelAliasSettings
+}
+
+// ResolveModelAlias 按分组解析模型别名，分组未配置时使用 default
+func (s *ModelAliasSettings) ResolveModelAlias(group string, model string) (string, bool) {
+	if aliases, ok := s.GroupAliases[group]; ok {
+		if resolved, ok := aliases[model]; ok && resolved != "" {
+			return resolved, true
+		}
+	}
+	if aliases, ok := s.GroupAliases["default"]; ok {
+		if resolved, ok := aliases[model]; ok && resolved != "" {
+			return resolved, true
+		}
+	}
+	return model, false
+}
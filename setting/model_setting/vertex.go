@@ -0,0 +1,55 @@
+package model_setting
+
+import (
+	"one-api/common"
+	"one-api/setting/config"
+)
+
+// VertexSettings 定义Vertex渠道的配置
+type VertexSettings struct {
+	UserAgent          string         `json:"user_agent"`           // 为空时使用 new-api/<version>
+	EmbeddingBatchSize map[string]int `json:"embedding_batch_size"` // 每次上游请求的最大 embedding 输入数，按模型配置
+	// temperature、top_p 超出上游接受范围时返回错误，关闭时自动限制到有效范围
+	StrictSamplingParams bool `json:"strict_sampling_params"`
+}
+
+// 默认配置
+var defaultVertexSettings = VertexSettings{
+	UserAgent: "",
+	EmbeddingBatchSize: map[string]int{
+		"default":              250,
+		"gemini-embedding-001": 1,
+	},
+}
+
+// 全局实例
+var vertexSettings = defaultVertexSettings
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("vertex", &vertexSettings)
+}
+
+// GetVertexSettings 获取Vertex配置
+func GetVertexSettings() *VertexSettings {
+	return &vertexSettings
+}
+
+// GetUserAgent 获取请求上游时使用的 User-Agent
+func (s *VertexSettings) GetUserAgent() string {
+	if s.UserAgent == "" {
+		return "new-api/" + common.Version
+	}
+	return s.UserAgent
+}
+
+// GetEmbeddingBatchSize 获取模型单次请求的最大 embedding 输入数
+func (s *VertexSettings) GetEmbeddingBatchSize(model string) int {
+	if size, ok := s.EmbeddingBatchSize[model]; ok && size > 0 {
+		return size
+	}
+	if size, ok := s.EmbeddingBatchSize["default"]; ok && size > 0 {
+		return size
+	}
+	return 250
+}
@@ -3,16 +3,24 @@ package operation_setting
 import "one-api/setting/config"
 
 type GeneralSetting struct {
-	DocsLink            string `json:"docs_link"`
-	PingIntervalEnabled bool   `json:"ping_interval_enabled"`
-	PingIntervalSeconds int    `json:"ping_interval_seconds"`
+	DocsLink              string   `json:"docs_link"`
+	PingIntervalEnabled   bool     `json:"ping_interval_enabled"`
+	PingIntervalSeconds   int      `json:"ping_interval_seconds"`
+	ErrorBodyLogMaxLength int      `json:"error_body_log_max_length"` // 日志中记录的上游错误响应体最大字节数
+	StreamPreConsumeFloor bool     `json:"stream_pre_consume_floor"`  // 流式请求的预扣额度不低于 max_tokens 按输出价格计算的额度
+	TraceHeaders          []string `json:"trace_headers"`             // 按顺序读取的追踪请求头，客户端传入合法值时作为请求 id
+	ForwardTraceHeaders   bool     `json:"forward_trace_headers"`     // 是否将客户端传入的追踪请求头转发给上游
 }
 
 // 默认配置
 var generalSetting = GeneralSetting{
-	DocsLink:            "https://docs.newapi.pro",
-	PingIntervalEnabled: false,
-	PingIntervalSeconds: 60,
+	DocsLink:              "https://docs.newapi.pro",
+	PingIntervalEnabled:   false,
+	PingIntervalSeconds:   60,
+	ErrorBodyLogMaxLength: 1000,
+	StreamPreConsumeFloor: false,
+	TraceHeaders:          []string{"X-Request-Id", "traceparent"},
+	ForwardTraceHeaders:   false,
 }
 
 func init() {
@@ -23,3 +31,11 @@ func init() {
 func GetGeneralSetting() *GeneralSetting {
 	return &generalSetting
 }
+
+// GetErrorBodyLogMaxLength 获取错误响应体日志截断长度，未配置时使用 1000
+func (s *GeneralSetting) GetErrorBodyLogMaxLength() int {
+	if s.ErrorBodyLogMaxLength <= 0 {
+		return 1000
+	}
+	return s.ErrorBodyLogMaxLength
+}
@@ -43,6 +43,8 @@ const (
 	ErrorCodeJsonMarshalFailed ErrorCode = "json_marshal_failed"
 	ErrorCodeDoRequestFailed   ErrorCode = "do_request_failed"
 	ErrorCodeGetChannelFailed  ErrorCode = "get_channel_failed"
+	ErrorCodeVertexAuthFailed  ErrorCode = "vertex_auth_failed"
+	ErrorCodeVertexKeyExpired  ErrorCode = "vertex_key_expired" // 服务账号密钥已失效，需要更换
 
 	// channel error
 	ErrorCodeChannelNoAvailableKey       ErrorCode = "channel:no_available_key"
@@ -51,17 +53,31 @@ const (
 	ErrorCodeChannelAwsClientError       ErrorCode = "channel:aws_client_error"
 	ErrorCodeChannelInvalidKey           ErrorCode = "channel:invalid_key"
 	ErrorCodeChannelResponseTimeExceeded ErrorCode = "channel:response_time_exceeded"
+	ErrorCodeChannelUnavailable          ErrorCode = "channel:unavailable"
 
 	// client request error
 	ErrorCodeReadRequestBodyFailed ErrorCode = "read_request_body_failed"
 	ErrorCodeConvertRequestFailed  ErrorCode = "convert_request_failed"
 	ErrorCodeAccessDenied          ErrorCode = "access_denied"
+	ErrorCodeRateLimitExceeded     ErrorCode = "rate_limit_exceeded"
+	ErrorCodeTokenBudgetExceeded   ErrorCode = "token_budget_exceeded"
+	ErrorCodeModelNotSupported     ErrorCode = "model_not_supported"
+	// 幂等键对应的请求仍在处理中，或被用于不同的请求体
+	ErrorCodeIdempotencyKeyInUse    ErrorCode = "idempotency_key_in_use"
+	ErrorCodeIdempotencyKeyMismatch ErrorCode = "idempotency_key_mismatch"
 
 	// response error
 	ErrorCodeReadResponseBodyFailed ErrorCode = "read_response_body_failed"
 	ErrorCodeBadResponseStatusCode  ErrorCode = "bad_response_status_code"
 	ErrorCodeBadResponse            ErrorCode = "bad_response"
 	ErrorCodeBadResponseBody        ErrorCode = "bad_response_body"
+	ErrorCodePromptBlocked          ErrorCode = "prompt_blocked"
+	ErrorCodeResponseBlocked        ErrorCode = "response_blocked"
+	ErrorCodeMalformedFunctionCall  ErrorCode = "malformed_function_call"
+	ErrorCodeResponseFiltered       ErrorCode = "response_filtered"
+	// 上游项目配额耗尽与上游临时限流（容量不足）需要区分告警与故障转移策略
+	ErrorCodeUpstreamQuotaExhausted ErrorCode = "upstream_quota_exhausted"
+	ErrorCodeUpstreamRateLimited    ErrorCode = "upstream_rate_limited"
 
 	// sql error
 	ErrorCodeQueryDataError  ErrorCode = "query_data_error"
@@ -78,6 +94,9 @@ type NewAPIError struct {
 	ErrorType  ErrorType
 	errorCode  ErrorCode
 	StatusCode int
+	RequestId  string
+	// 上游配额耗尽时对应的配额指标，如 aiplatform.googleapis.com/generate_content_requests_per_minute_per_project_per_base_model
+	QuotaMetric string
 }
 
 func (e *NewAPIError) GetErrorCode() ErrorCode {
@@ -102,6 +121,10 @@ func (e *NewAPIError) SetMessage(message string) {
 	e.Err = errors.New(message)
 }
 
+func (e *NewAPIError) SetErrorCode(errorCode ErrorCode) {
+	e.errorCode = errorCode
+}
+
 func (e *NewAPIError) ToOpenAIError() OpenAIError {
 	switch e.ErrorType {
 	case ErrorTypeOpenAIError: